@@ -0,0 +1,98 @@
+package elf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// aarch64Compiler is the backend for 64-bit ARM (A64 instruction set). All
+// instructions are 4 bytes long. Addresses of data are calculated relative
+// to the program counter with adr, so the code is position independent.
+//
+// Linux system calls take the number in x8 and the arguments in x0-x5 and
+// are invoked with svc #0.
+type aarch64Compiler struct {
+	*Compiler
+}
+
+// AArch64 system call numbers (asm-generic/unistd.h)
+const (
+	aarch64SysWrite = 64
+	aarch64SysExit  = 93
+)
+
+func (c *aarch64Compiler) emitInstruction(instruction uint32) {
+	c.buf = binary.LittleEndian.AppendUint32(c.buf, instruction)
+}
+
+// movz xd, #imm16
+func (c *aarch64Compiler) emitMovz(rd uint32, value int) {
+	if value < 0 || value > 0xffff {
+		panic(fmt.Sprintf("movz immediate %d out of range", value))
+	}
+	c.emitInstruction(0xd2800000 | uint32(value)<<5 | rd)
+}
+
+// adr xd, addr (within +-1MB of the instruction)
+func (c *aarch64Compiler) emitAdr(rd uint32, addr uint64) {
+	pc := c.startAddr + uint64(len(c.buf))
+	offset := int64(addr - pc)
+	if offset < -(1<<20) || offset >= 1<<20 {
+		panic(fmt.Sprintf("adr offset %d out of range", offset))
+	}
+	imm := uint32(offset) & 0x1fffff
+	immlo := imm & 0x3
+	immhi := imm >> 2
+	c.emitInstruction(0x10000000 | immlo<<29 | immhi<<5 | rd)
+}
+
+// ldr wt, [xn]
+func (c *aarch64Compiler) emitLdr32(rt uint32, rn uint32) {
+	c.emitInstruction(0xb9400000 | rn<<5 | rt)
+}
+
+// str wt, [xn]
+func (c *aarch64Compiler) emitStr32(rt uint32, rn uint32) {
+	c.emitInstruction(0xb9000000 | rn<<5 | rt)
+}
+
+// add wd, wn, #imm12
+func (c *aarch64Compiler) emitAddImm32(rd uint32, rn uint32, value uint16) {
+	if value > 0xfff {
+		panic(fmt.Sprintf("add immediate %d out of range", value))
+	}
+	c.emitInstruction(0x11000000 | uint32(value)<<10 | rn<<5 | rd)
+}
+
+// svc #0
+func (c *aarch64Compiler) emitSvc() {
+	c.emitInstruction(0xd4000001)
+}
+
+func (c *aarch64Compiler) emitWrite(fd int, bufAddr uint64, count int) {
+	c.emitMovz(8, aarch64SysWrite) // x8 = syscall 64 (write)
+	c.emitMovz(0, fd)              // x0 = fd
+	c.emitAdr(1, bufAddr)          // x1 = buffer address
+	c.emitMovz(2, count)           // x2 = count
+	c.emitSvc()
+}
+
+func (c *aarch64Compiler) emitIncrementCounter(addr uint64) {
+	c.emitAdr(1, addr)      // x1 = counter address
+	c.emitLdr32(0, 1)       // w0 = [x1]
+	c.emitAddImm32(0, 0, 1) // w0 = w0 + 1
+	c.emitStr32(0, 1)       // [x1] = w0
+}
+
+func (c *aarch64Compiler) emitExit(counterAddr uint64) {
+	c.emitAdr(1, counterAddr)     // x1 = counter address
+	c.emitLdr32(0, 1)             // w0 = counter value
+	c.emitMovz(8, aarch64SysExit) // x8 = syscall 93 (exit)
+	c.emitSvc()
+}
+
+func (c *aarch64Compiler) emitExitCode(code int) {
+	c.emitMovz(0, code)           // x0 = exit code
+	c.emitMovz(8, aarch64SysExit) // x8 = syscall 93 (exit)
+	c.emitSvc()
+}
@@ -0,0 +1,67 @@
+package elf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// The expected values are the encodings of the assembler, e.g.
+// aarch64-linux-gnu-as or llvm-mc -triple=aarch64 -show-encoding.
+func TestAArch64Encoding(t *testing.T) {
+	var addr uint64 = 0x401000
+	tests := []struct {
+		asm      string
+		emit     func(c *aarch64Compiler)
+		expected uint32
+	}{
+		{"movz x8, #64", func(c *aarch64Compiler) { c.emitMovz(8, 64) }, 0xd2800808},
+		{"movz x2, #0xffff", func(c *aarch64Compiler) { c.emitMovz(2, 0xffff) }, 0xd29fffe2},
+		{"adr x1, .+8", func(c *aarch64Compiler) { c.emitAdr(1, addr+8) }, 0x10000041},
+		{"adr x1, .-4", func(c *aarch64Compiler) { c.emitAdr(1, addr-4) }, 0x10ffffe1},
+		{"adr x3, .+0xffffc", func(c *aarch64Compiler) { c.emitAdr(3, addr+0xffffc) }, 0x107fffe3},
+		{"adr x3, .-0x100000", func(c *aarch64Compiler) { c.emitAdr(3, addr-0x100000) }, 0x10800003},
+		{"ldr w0, [x1]", func(c *aarch64Compiler) { c.emitLdr32(0, 1) }, 0xb9400020},
+		{"ldr w5, [x30]", func(c *aarch64Compiler) { c.emitLdr32(5, 30) }, 0xb94003c5},
+		{"str w0, [x1]", func(c *aarch64Compiler) { c.emitStr32(0, 1) }, 0xb9000020},
+		{"str w7, [x2]", func(c *aarch64Compiler) { c.emitStr32(7, 2) }, 0xb9000047},
+		{"add w0, w0, #1", func(c *aarch64Compiler) { c.emitAddImm32(0, 0, 1) }, 0x11000400},
+		{"add w3, w4, #4095", func(c *aarch64Compiler) { c.emitAddImm32(3, 4, 4095) }, 0x113ffc83},
+		{"svc #0", func(c *aarch64Compiler) { c.emitSvc() }, 0xd4000001},
+	}
+	for _, test := range tests {
+		c := &aarch64Compiler{&Compiler{startAddr: addr}}
+		test.emit(c)
+		if len(c.buf) != 4 {
+			t.Errorf("%s: expected 4 bytes got %d", test.asm, len(c.buf))
+			continue
+		}
+		if got := binary.LittleEndian.Uint32(c.buf); got != test.expected {
+			t.Errorf("%s: expected 0x%08x got 0x%08x", test.asm, test.expected, got)
+		}
+	}
+}
+
+func TestAArch64Panics(t *testing.T) {
+	var addr uint64 = 0x401000
+	tests := []struct {
+		name string
+		emit func(c *aarch64Compiler)
+		err  string
+	}{
+		{"movz", func(c *aarch64Compiler) { c.emitMovz(0, 0x10000) }, "movz immediate 65536 out of range"},
+		{"negative movz", func(c *aarch64Compiler) { c.emitMovz(0, -1) }, "movz immediate -1 out of range"},
+		{"write count", func(c *aarch64Compiler) { c.emitWrite(1, addr, 0x10000) }, "movz immediate 65536 out of range"},
+		{"adr", func(c *aarch64Compiler) { c.emitAdr(1, addr+0x100000) }, "adr offset 1048576 out of range"},
+		{"add", func(c *aarch64Compiler) { c.emitAddImm32(0, 0, 0x1000) }, "add immediate 4096 out of range"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != test.err {
+					t.Errorf("expected panic %q got %v", test.err, r)
+				}
+			}()
+			test.emit(&aarch64Compiler{&Compiler{startAddr: addr}})
+		})
+	}
+}
@@ -0,0 +1,583 @@
+package elf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go-elf/x86"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// asmSection is an output section of the assembler. The sections are placed
+// in this order into the code of the Writer.
+type asmSection int
+
+const (
+	sectionText asmSection = iota
+	sectionData
+	sectionBSS
+)
+
+// statement is a line of the source: labels followed by a directive or an
+// instruction.
+type statement struct {
+	line   int
+	labels []string
+	name   string // directive or mnemonic, empty if the line has only labels
+	args   string
+}
+
+// assembler translates the statements into machine code with the Compiler.
+type assembler struct {
+	*Compiler
+	section asmSection
+}
+
+// opAliases are mnemonics of the GNU assembler which are other names for an
+// instruction of the x86 package.
+var opAliases = map[string]x86.Op{
+	"jz":     x86.JE,
+	"jnz":    x86.JNE,
+	"jc":     x86.JB,
+	"jnc":    x86.JAE,
+	"jnae":   x86.JB,
+	"jnb":    x86.JAE,
+	"jna":    x86.JBE,
+	"jnbe":   x86.JA,
+	"jpe":    x86.JP,
+	"jpo":    x86.JNP,
+	"jnge":   x86.JL,
+	"jnl":    x86.JGE,
+	"jng":    x86.JLE,
+	"jnle":   x86.JG,
+	"sal":    x86.SHL,
+	"cqto":   x86.CQO,
+	"cltd":   x86.CDQ,
+	"movabs": x86.MOV,
+}
+
+// suffixSizes are the operand sizes of the mnemonic suffixes, e.g. movq.
+var suffixSizes = map[byte]int{
+	'b': 8,
+	'w': 16,
+	'l': 32,
+	'q': 64,
+}
+
+// Assemble translates x86-64 assembly in the AT&T syntax of the GNU
+// assembler into code which is loaded at startAddr. The sections are
+// placed into the code in the order .text, .data and .bss, so the code can
+// be written with the Writer. Like ld, the entry point is the label _start
+// or the start of .text if it is not defined.
+//
+// Supported are labels, comments (# and //) and the directives .global,
+// .text, .data, .rodata, .bss, .section, .ascii, .asciz, .string, .byte,
+// .short, .long, .quad, .zero, .lcomm and .align.
+func Assemble(startAddr uint64, source string) (entryPoint uint64, code []byte, err error) {
+	statements, err := parseAssembly(source)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	a := &assembler{
+		Compiler: &Compiler{
+			startAddr: startAddr,
+			buf:       make([]byte, 0),
+			labels:    map[string]int{},
+		},
+	}
+	for _, section := range []asmSection{sectionText, sectionData, sectionBSS} {
+		if section != sectionText {
+			a.align(16)
+		}
+		a.section = section
+		for _, s := range statements[section] {
+			err := a.assemble(s)
+			if err != nil {
+				return 0, nil, fmt.Errorf("line %d: %w", s.line, err)
+			}
+		}
+	}
+
+	err = a.resolve()
+	if err != nil {
+		return 0, nil, err
+	}
+	entryPoint = startAddr
+	if position, ok := a.labels["_start"]; ok {
+		entryPoint = startAddr + uint64(position)
+	}
+	return entryPoint, a.buf, nil
+}
+
+// parseAssembly splits the source into statements and sorts them by section.
+func parseAssembly(source string) (map[asmSection][]statement, error) {
+	statements := map[asmSection][]statement{}
+	current := sectionText
+	for i, line := range strings.Split(source, "\n") {
+		s := statement{line: i + 1}
+		line = strings.TrimSpace(stripComment(line))
+		for {
+			name, rest, ok := strings.Cut(line, ":")
+			if !ok || !isSymbol(strings.TrimSpace(name)) {
+				break
+			}
+			s.labels = append(s.labels, strings.TrimSpace(name))
+			line = strings.TrimSpace(rest)
+		}
+		s.name = line
+		if end := strings.IndexAny(line, " \t"); end >= 0 {
+			s.name, s.args = line[:end], strings.TrimSpace(line[end:])
+		}
+		if s.name == "rep" {
+			// the prefix is part of the mnemonic, e.g. rep movsb
+			s.name, s.args = "rep "+s.args, ""
+		}
+
+		switch s.name {
+		case ".text":
+			current = sectionText
+		case ".data", ".rodata":
+			current = sectionData
+		case ".bss":
+			current = sectionBSS
+		case ".section":
+			name, _, _ := strings.Cut(s.args, ",")
+			switch name = strings.TrimSpace(name); {
+			case strings.HasPrefix(name, ".text"):
+				current = sectionText
+			case strings.HasPrefix(name, ".data"), strings.HasPrefix(name, ".rodata"):
+				current = sectionData
+			case strings.HasPrefix(name, ".bss"):
+				current = sectionBSS
+			default:
+				return nil, fmt.Errorf("line %d: unknown section %s", s.line, name)
+			}
+		case ".lcomm", ".comm":
+			// .lcomm symbol, length reserves length bytes in .bss
+			fields := strings.FieldsFunc(s.args, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+			if len(fields) < 2 || !isSymbol(fields[0]) {
+				return nil, fmt.Errorf("line %d: expected symbol and length", s.line)
+			}
+			statements[current] = append(statements[current], statement{line: s.line, labels: s.labels})
+			statements[sectionBSS] = append(statements[sectionBSS], statement{
+				line:   s.line,
+				labels: []string{fields[0]},
+				name:   ".zero",
+				args:   fields[1],
+			})
+			continue
+		}
+		statements[current] = append(statements[current], s)
+	}
+	return statements, nil
+}
+
+// stripComment removes a comment starting with # or // from the line. The
+// comment characters are ignored in strings and character literals.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"', '\'':
+			i = skipLiteral(line, i)
+		case '#':
+			return line[:i]
+		case '/':
+			if i+1 < len(line) && line[i+1] == '/' {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// skipLiteral returns the index of the last character of the string or
+// character literal which starts at s[i].
+func skipLiteral(s string, i int) int {
+	if s[i] == '"' {
+		for i++; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' {
+				i++
+			}
+		}
+		return i
+	}
+	// 'c or 'c' with an optional escape sequence
+	i++
+	if i < len(s) && s[i] == '\\' {
+		i++
+	}
+	if i+1 < len(s) && s[i+1] == '\'' {
+		i++
+	}
+	return i
+}
+
+func isSymbol(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '$':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// splitArgs splits the arguments of a statement at the commas which are
+// not inside parentheses, strings or character literals.
+func splitArgs(args string) []string {
+	if args == "" {
+		return nil
+	}
+	var (
+		fields []string
+		depth  int
+		start  int
+	)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '"', '\'':
+			i = skipLiteral(args, i)
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				fields = append(fields, strings.TrimSpace(args[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, strings.TrimSpace(args[start:]))
+}
+
+func (a *assembler) assemble(s statement) error {
+	for _, label := range s.labels {
+		if _, ok := a.labels[label]; ok {
+			return fmt.Errorf("label %s already defined", label)
+		}
+		a.label(label)
+	}
+	switch {
+	case s.name == "":
+		return nil
+	case strings.HasPrefix(s.name, "."):
+		return a.directive(s.name, s.args)
+	case a.section == sectionBSS:
+		return fmt.Errorf("instruction %s in .bss", s.name)
+	default:
+		return a.instruction(s.name, splitArgs(s.args))
+	}
+}
+
+func (a *assembler) directive(name string, args string) error {
+	switch name {
+	case ".global", ".globl", ".text", ".data", ".rodata", ".bss", ".section", ".type", ".size":
+		// symbols are not exported, the sections are handled by parseAssembly
+		return nil
+
+	case ".ascii", ".asciz", ".string":
+		for _, arg := range splitArgs(args) {
+			s, err := unquote(arg)
+			if err != nil {
+				return err
+			}
+			a.buf = append(a.buf, s...)
+			if name != ".ascii" {
+				a.buf = append(a.buf, 0)
+			}
+		}
+		return nil
+
+	case ".byte", ".short", ".word", ".long", ".int", ".quad":
+		size := map[string]int{".byte": 1, ".short": 2, ".word": 2, ".long": 4, ".int": 4, ".quad": 8}[name]
+		for _, arg := range splitArgs(args) {
+			if isSymbol(arg) {
+				err := a.emitAddress(reference{label: arg}, size)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			value, err := parseNumber(arg)
+			if err != nil {
+				return err
+			}
+			if size < 8 && (value < -1<<(size*8-1) || value >= 1<<(size*8)) {
+				return fmt.Errorf("value %d does not fit into %d bytes", value, size)
+			}
+			a.buf = append(a.buf, binary.LittleEndian.AppendUint64(nil, uint64(value))[:size]...)
+		}
+		return nil
+
+	case ".zero", ".skip", ".space":
+		size, err := parseNumber(args)
+		if err != nil {
+			return err
+		}
+		if size < 0 {
+			return fmt.Errorf("negative size %d", size)
+		}
+		a.buf = append(a.buf, make([]byte, size)...)
+		return nil
+
+	case ".align", ".balign", ".p2align":
+		arg, _, _ := strings.Cut(args, ",")
+		align, err := parseNumber(strings.TrimSpace(arg))
+		if err != nil {
+			return err
+		}
+		if name == ".p2align" {
+			align = 1 << align
+		}
+		if align <= 0 || align&(align-1) != 0 {
+			return fmt.Errorf("alignment %d is not a power of 2", align)
+		}
+		if a.section != sectionText {
+			a.align(uint64(align))
+			return nil
+		}
+		a.pad(uint64(align), 0x90) // nop
+		return nil
+
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+}
+
+// lookupOp returns the instruction of the mnemonic and the operand size of
+// its suffix, which is 0 without suffix.
+func lookupOp(mnemonic string) (x86.Op, int, bool) {
+	if op, ok := x86.LookupOp(mnemonic); ok {
+		return op, 0, true
+	}
+	if op, ok := opAliases[mnemonic]; ok {
+		return op, 0, true
+	}
+	size, ok := suffixSizes[mnemonic[len(mnemonic)-1]]
+	if !ok {
+		return 0, 0, false
+	}
+	op, ok := x86.LookupOp(mnemonic[:len(mnemonic)-1])
+	return op, size, ok
+}
+
+func (a *assembler) instruction(mnemonic string, args []string) error {
+	op, size, ok := lookupOp(mnemonic)
+	if !ok {
+		return fmt.Errorf("unknown instruction %s", mnemonic)
+	}
+	if op == x86.MOVQ && !slices.ContainsFunc(args, func(arg string) bool { return strings.HasPrefix(arg, "%xmm") }) {
+		// mov with the suffix q
+		op, size = x86.MOV, 64
+	}
+
+	_, conditional := op.Condition()
+	if op == x86.JMP || op == x86.CALL || conditional {
+		if len(args) == 1 && isSymbol(args[0]) {
+			a.emitJump(op, args[0])
+			return nil
+		}
+		if len(args) == 1 && strings.HasPrefix(args[0], "*") {
+			// indirect jump or call
+			args = []string{args[0][1:]}
+		}
+	}
+
+	// the operands are in the reverse order of the x86 package, except for
+	// enter
+	var (
+		operands = make([]x86.Operand, len(args))
+		symbol   string
+		operand  int
+	)
+	for i, arg := range args {
+		index := len(args) - 1 - i
+		if op == x86.ENTER {
+			index = i
+		}
+		o, label, err := parseOperand(arg, size)
+		if err != nil {
+			return err
+		}
+		if label != "" {
+			if symbol != "" {
+				return fmt.Errorf("more than one symbol in %s", mnemonic)
+			}
+			symbol, operand = label, index
+		}
+		operands[index] = o
+	}
+	if (op == x86.SHL || op == x86.SHR || op == x86.SAR) && len(operands) == 1 {
+		operands = append(operands, x86.Imm(1))
+	}
+
+	if symbol != "" {
+		return a.addFixup(fixup{op: op, operands: operands, operand: operand, target: reference{label: symbol}})
+	}
+	code, err := x86.Encode(op, operands...)
+	if err != nil {
+		return err
+	}
+	a.buf = append(a.buf, code...)
+	return nil
+}
+
+// parseOperand parses an operand in AT&T syntax:
+//
+//	%rax                      register
+//	$42, $'a', $symbol        immediate
+//	disp(base, index, scale)  memory, every part is optional
+//	symbol(%rip)              memory relative to the instruction pointer
+//	symbol                    memory at the address of symbol
+//
+// The displacement of memory operands may be a symbol. If the operand
+// references a symbol, its name is returned, the address has to be set by
+// a fixup. The size of memory operands is set to size.
+func parseOperand(s string, size int) (x86.Operand, string, error) {
+	switch {
+	case strings.HasPrefix(s, "%"):
+		reg, ok := x86.LookupRegister(s[1:])
+		if !ok {
+			return nil, "", fmt.Errorf("unknown register %s", s)
+		}
+		return reg, "", nil
+
+	case strings.HasPrefix(s, "$"):
+		if isSymbol(s[1:]) {
+			return x86.Imm(0), s[1:], nil
+		}
+		value, err := parseNumber(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		return x86.Imm(value), "", nil
+	}
+
+	mem := x86.Mem{Size: size}
+	disp, addressing, hasAddressing := strings.Cut(s, "(")
+	disp = strings.TrimSpace(disp)
+	var symbol string
+	switch {
+	case disp == "":
+	case isSymbol(disp):
+		symbol = disp
+	default:
+		value, err := parseNumber(disp)
+		if err != nil {
+			return nil, "", err
+		}
+		if value < -1<<31 || value >= 1<<31 {
+			return nil, "", fmt.Errorf("displacement %d out of range", value)
+		}
+		mem.Disp = int32(value)
+	}
+	if !hasAddressing {
+		return mem, symbol, nil
+	}
+
+	addressing, ok := strings.CutSuffix(strings.TrimSpace(addressing), ")")
+	if !ok {
+		return nil, "", fmt.Errorf("missing ) in %s", s)
+	}
+	parts := strings.Split(addressing, ",")
+	if len(parts) > 3 {
+		return nil, "", fmt.Errorf("invalid memory operand %s", s)
+	}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if i == 2 {
+			scale, err := parseNumber(part)
+			if err != nil {
+				return nil, "", err
+			}
+			mem.Scale = int(scale)
+			continue
+		}
+		if i == 0 && part == "%rip" {
+			mem.RIP = true
+			continue
+		}
+		reg, ok := x86.LookupRegister(strings.TrimPrefix(part, "%"))
+		if !ok || !strings.HasPrefix(part, "%") {
+			return nil, "", fmt.Errorf("unknown register %s", part)
+		}
+		if i == 0 {
+			mem.Base = reg
+		} else {
+			mem.Index = reg
+		}
+	}
+	if mem.Index.Size() != 0 && mem.Scale == 0 {
+		mem.Scale = 1
+	}
+	return mem, symbol, nil
+}
+
+// parseNumber parses an integer in decimal, hexadecimal (0x), binary (0b)
+// or octal (leading 0) notation or a character literal like 'a'.
+func parseNumber(s string) (int64, error) {
+	if strings.HasPrefix(s, "'") {
+		value, _, tail, err := unquoteChar(s[1:], '\'')
+		if err != nil || (tail != "" && tail != "'") {
+			return 0, fmt.Errorf("invalid character %s", s)
+		}
+		return int64(value), nil
+	}
+	value, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %s", s)
+	}
+	return value, nil
+}
+
+// unquote returns the value of a string literal. Unlike in Go, octal escape
+// sequences have 1 to 3 digits, e.g. "\0".
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	var (
+		b    []byte
+		tail = s[1 : len(s)-1]
+	)
+	for tail != "" {
+		value, multibyte, rest, err := unquoteChar(tail, '"')
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", s)
+		}
+		if multibyte {
+			b = utf8.AppendRune(b, value)
+		} else {
+			b = append(b, byte(value))
+		}
+		tail = rest
+	}
+	return string(b), nil
+}
+
+// unquoteChar is like strconv.UnquoteChar but accepts octal escape sequences
+// with less than 3 digits.
+func unquoteChar(s string, quote byte) (value rune, multibyte bool, tail string, err error) {
+	if len(s) < 2 || s[0] != '\\' || s[1] < '0' || s[1] > '7' {
+		return strconv.UnquoteChar(s, quote)
+	}
+	n := 1
+	for ; n < 4 && n < len(s) && s[n] >= '0' && s[n] <= '7'; n++ {
+		value = value*8 + rune(s[n]-'0')
+	}
+	if value > 0xff {
+		return 0, false, "", strconv.ErrSyntax
+	}
+	return value, false, s[n:], nil
+}
@@ -0,0 +1,227 @@
+package elf
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// assembleFile assembles the file with Assemble and writes the executable
+// to outputPath.
+func assembleFile(t *testing.T, file string, outputPath string) {
+	t.Helper()
+	source, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var virtualAddress uint64 = 0x401000
+	entryPoint, code, err := Assemble(virtualAddress, string(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elfBinary, err := Write(virtualAddress, entryPoint, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(outputPath, elfBinary, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+
+	// arguments of the programs, sleep.s is only assembled as it runs for
+	// hours
+	args := map[string][]string{
+		"args.s": {"first", "second"},
+		"main.s": {"input.txt"},
+	}
+
+	files, err := filepath.Glob("../../asm/*.s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, "testdata/hello.s", "testdata/copy.s")
+	if len(files) == 2 {
+		t.Fatal("no assembly files found")
+	}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(strings.TrimPrefix(file, "../../"), func(t *testing.T) {
+			tempDir := t.TempDir()
+			outputPath := filepath.Join(tempDir, "output.elf")
+			assembleFile(t, file, outputPath)
+			if name == "sleep.s" {
+				return
+			}
+
+			gccPath := filepath.Join(tempDir, "gcc.out")
+			out, err := exec.Command("gcc", "-nostdlib", "-static", "-no-pie", "-o", gccPath, file).CombinedOutput()
+			if err != nil {
+				t.Fatalf("gcc: %s: %s", err, out)
+			}
+
+			dir := filepath.Dir(file)
+			expectedOutput, expectedExitCode := execute(t, gccPath, dir, args[name]...)
+			output, exitCode := execute(t, outputPath, dir, args[name]...)
+			if exitCode != expectedExitCode {
+				t.Errorf("expected exit code %d got %d", expectedExitCode, exitCode)
+			}
+			if !bytes.Equal(output, expectedOutput) {
+				t.Errorf("expected output %q got %q", expectedOutput, output)
+			}
+		})
+	}
+}
+
+func TestAssembleData(t *testing.T) {
+	source := `
+.global _start
+.text
+_start:
+    mov $1, %eax
+    mov %eax, %edi
+    mov pointer, %rsi       # absolute address
+    movl length(%rip), %edx
+    syscall
+
+    lea table(%rip), %rbx
+    mov $2, %rcx
+    movb (%rbx,%rcx,1), %dil
+    mov $60, %eax
+    syscall
+
+.section .rodata
+message: .string "tab\t\"quoted\" \101#\n"
+.data
+.align 8
+pointer: .quad message
+length: .long 16
+table: .byte 1, 'b, ',', 0x2a
+.bss
+.lcomm buffer, 64
+`
+	var virtualAddress uint64 = 0x401000
+	entryPoint, code, err := Assemble(virtualAddress, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elfBinary, err := Write(virtualAddress, entryPoint, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(t.TempDir(), "output.elf")
+	err = os.WriteFile(outputPath, elfBinary, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, exitCode := execute(t, outputPath, "")
+	if exitCode != ',' {
+		t.Errorf("expected exit code %d got %d", ',', exitCode)
+	}
+	expectedOutput := "tab\t\"quoted\" A#\n"
+	if string(out) != expectedOutput {
+		t.Errorf("expected output %q got %q", expectedOutput, out)
+	}
+}
+
+// TestAssembleAlign checks that .data and .align stay aligned behind jumps
+// which get shortened. The program exits with the misalignment of the
+// labels, or 99 if a value was not found at its label.
+func TestAssembleAlign(t *testing.T) {
+	source := `
+_start:
+	jmp check
+	nop
+.align 8
+code:
+	.quad 0x1122334455667788
+check:
+	lea code(%rip), %rdi
+	lea first(%rip), %rsi
+	lea value(%rip), %rdx
+	mov (%rdi), %rax
+	cmp %rax, (%rdx)
+	jne wrong
+	and $7, %rdi
+	and $15, %rsi
+	and $7, %rdx
+	add %rsi, %rdi
+	add %rdx, %rdi
+	mov $60, %eax
+	syscall
+wrong:
+	mov $99, %edi
+	mov $60, %eax
+	syscall
+
+.data
+first:
+	.byte 1
+.align 8
+value:
+	.quad 0x1122334455667788
+`
+	var virtualAddress uint64 = 0x401000
+	entryPoint, code, err := Assemble(virtualAddress, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code[0] != 0xeb {
+		t.Fatalf("expected a short jump got % x", code[:2])
+	}
+	elfBinary, err := Write(virtualAddress, entryPoint, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(t.TempDir(), "output.elf")
+	err = os.WriteFile(outputPath, elfBinary, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, exitCode := execute(t, outputPath, "")
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 got %d", exitCode)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"_start:\n  frobnicate %rax", "line 2: unknown instruction frobnicate"},
+		{"  mov %rax, %rbx\n  jmp nowhere", "undefined label nowhere"},
+		{"a:\na:", "line 2: label a already defined"},
+		{".data\n.foo 1", "line 2: unknown directive .foo"},
+		{"  mov %eax, %rbx", "line 1: mov: operand size mismatch: 64 and 32 bit"},
+		{"  mov $1, (%rax)", "line 1: mov: operand size missing"},
+		{"  mov %ymm0, %rax", "line 1: unknown register %ymm0"},
+		{"  mov %xmm0, %rax", "line 1: mov: invalid operand xmm0"},
+		{"  mov $a, b", "line 1: more than one symbol in mov"},
+		{".bss\n  ret", "line 2: instruction ret in .bss"},
+		{".ascii \"open", "line 1: invalid string \"open"},
+		{".byte 256", "line 1: value 256 does not fit into 1 bytes"},
+		{".section .foo", "line 1: unknown section .foo"},
+	}
+	for _, test := range tests {
+		_, _, err := Assemble(0x401000, test.source)
+		if err == nil {
+			t.Errorf("%q: expected error %q", test.source, test.err)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%q: expected error %q got %q", test.source, test.err, err)
+		}
+	}
+}
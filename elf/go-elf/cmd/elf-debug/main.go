@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"go-elf"
+	"io"
 	"os"
 )
 
@@ -15,6 +17,12 @@ func main() {
 }
 
 func run() error {
+	machineName := flag.String("machine", "x86-64", "instruction set of the compile action: x86-64, aarch64, riscv64")
+	syntaxName := flag.String("syntax", "att", "assembly syntax of the disasm action: att, intel")
+	outputName := flag.String("o", "output.elf", "output file of the write, compile, assemble, compile-dynamic, compile-shared, compile-functions and compile-tls actions")
+	peephole := flag.Bool("peephole", false, "optimize the x86-64 instructions of the compile action and print their size before and after")
+	level := flag.Int("O", 0, "optimization level of the compile action: 0, or 1 to fold constants, remove dead code and use -peephole")
+	args := flag.Bool("args", false, "start the x86-64 program of the compile action with the entry prologue, which writes the arguments")
 	flag.Parse()
 	if flag.NArg() < 1 {
 		return fmt.Errorf("missing action")
@@ -45,6 +53,29 @@ func run() error {
 		}
 		return elf.Print(elfReader)
 
+	case "disasm":
+		if flag.NArg() < 2 {
+			return fmt.Errorf("usage: %s disasm ELF-FILE", os.Args[0])
+		}
+		syntax, err := parseSyntax(*syntaxName)
+		if err != nil {
+			return err
+		}
+
+		fileData, err := os.ReadFile(flag.Arg(1))
+		if err != nil {
+			return err
+		}
+		elfFile, err := elf.Read(fileData)
+		if err != nil {
+			return err
+		}
+		elfReader := &elf.Reader{
+			File: elfFile,
+			Data: fileData,
+		}
+		return elf.Disassemble(elfReader, os.Stdout, syntax)
+
 	case "write":
 		var (
 			virtualAddress uint64 = 0x401000
@@ -54,19 +85,204 @@ func run() error {
 				0x0f, 0x05, // syscall
 			}
 		)
-		elfBinary := elf.Write(virtualAddress, virtualAddress, code)
-		return os.WriteFile("output.elf", elfBinary, 0755)
+		return writeFile(*outputName, &elf.Writer{
+			VirtualAddress: virtualAddress,
+			EntryPoint:     virtualAddress,
+			Code:           code,
+		})
 
 	case "compile":
 		var (
 			virtualAddress uint64 = 0x401000
+			fileName       string
 		)
-		entryPoint, code := elf.Compile(virtualAddress)
+		if flag.NArg() >= 2 {
+			// flags may follow the file name, e.g. -o
+			fileName = flag.Arg(1)
+			if err := flag.CommandLine.Parse(flag.Args()[2:]); err != nil {
+				return err
+			}
+		}
+		machine, err := parseMachine(*machineName)
+		if err != nil {
+			return err
+		}
+		if *level != 0 && *level != 1 {
+			return fmt.Errorf("invalid optimization level %d", *level)
+		}
+		opt := elf.Optimization{Fold: *level >= 1, Peephole: *peephole || *level >= 1}
+		if fileName != "" {
+			if *args {
+				return fmt.Errorf("-args is not supported for source files, which call argc() and argv()")
+			}
+			return compileSource(fileName, *outputName, machine, opt)
+		}
+		entryPoint, code, stats, err := elf.CompileWithOptions(machine, virtualAddress, elf.CompileOptions{Optimization: opt, Args: *args})
+		if err != nil {
+			return err
+		}
+		if opt.Peephole {
+			fmt.Fprintf(os.Stderr, "peephole: %s\n", stats)
+		}
 
-		elfBinary := elf.Write(virtualAddress, entryPoint, code)
-		return os.WriteFile("output.elf", elfBinary, 0755)
+		var flags uint32
+		if machine == elf.EM_RISCV {
+			// RV64GC Linux uses the lp64d ABI
+			flags = elf.EF_RISCV_FLOAT_ABI_DOUBLE
+		}
+
+		return writeFile(*outputName, &elf.Writer{
+			VirtualAddress: virtualAddress,
+			EntryPoint:     entryPoint,
+			Code:           code,
+			WritableCode:   true, // the counter is part of the code
+			Machine:        machine,
+			Flags:          flags,
+		})
+
+	case "assemble":
+		if flag.NArg() < 2 {
+			return fmt.Errorf("usage: %s assemble FILE.s", os.Args[0])
+		}
+		var (
+			virtualAddress uint64 = 0x401000
+			fileName              = flag.Arg(1)
+		)
+		// flags may follow the file name, e.g. -o
+		if err := flag.CommandLine.Parse(flag.Args()[2:]); err != nil {
+			return err
+		}
+		source, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		entryPoint, code, err := elf.Assemble(virtualAddress, string(source))
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+		return writeFile(*outputName, &elf.Writer{
+			VirtualAddress: virtualAddress,
+			EntryPoint:     entryPoint,
+			Code:           code,
+			WritableCode:   true, // .data and .bss follow the code
+		})
+
+	case "compile-dynamic":
+		var (
+			virtualAddress uint64 = 0x401000
+		)
+		d, err := elf.NewDynamicExecutable(virtualAddress, elf.Dynamic{
+			Needed:  []string{"libc.so.6"},
+			Imports: []string{"puts", "printf", "exit"},
+		})
+		if err != nil {
+			return err
+		}
+		entryPoint, code := elf.CompileDynamic(d)
+
+		elfBinary, err := d.Write(entryPoint, code)
+		if err != nil {
+			return err
+		}
+		return writeFile(*outputName, bytes.NewReader(elfBinary))
+
+	case "compile-shared":
+		s := elf.NewSharedObject("libgreeting.so", nil, []string{"answer", "increment", "greeting"})
+		code, functions := elf.CompileSharedObject(s)
+
+		sharedObject, err := s.Write(code, functions)
+		if err != nil {
+			return err
+		}
+		return writeFile(*outputName, bytes.NewReader(sharedObject))
+
+	case "compile-functions":
+		s := elf.NewSharedObject("libfunctions.so", nil, []string{"weighted", "factorial", "fold", "mix", "apply"})
+		code, functions := elf.CompileFunctions(s)
+
+		sharedObject, err := s.Write(code, functions)
+		if err != nil {
+			return err
+		}
+		return writeFile(*outputName, bytes.NewReader(sharedObject))
+
+	case "compile-tls":
+		var (
+			virtualAddress uint64 = 0x401000
+		)
+		e, entryPoint, code := elf.CompileThreadLocal(virtualAddress)
+
+		elfBinary, err := e.Write(entryPoint, code)
+		if err != nil {
+			return err
+		}
+		return writeFile(*outputName, bytes.NewReader(elfBinary))
 
 	default:
 		return fmt.Errorf("unknown action '%s'", action)
 	}
 }
+
+// compileSource compiles the source file of the language of package lang and
+// writes the executable to outputName.
+func compileSource(fileName string, outputName string, machine elf.Machine, opt elf.Optimization) error {
+	var (
+		virtualAddress uint64 = 0x401000
+	)
+	if machine != elf.EM_X86_64 {
+		return fmt.Errorf("source files can only be compiled for x86-64")
+	}
+	source, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	w, stats, err := elf.CompileSourceExecutable(virtualAddress, string(source), opt)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+	if opt.Peephole {
+		fmt.Fprintf(os.Stderr, "peephole: %s\n", stats)
+	}
+	return writeFile(outputName, w)
+}
+
+func parseMachine(name string) (elf.Machine, error) {
+	switch name {
+	case "x86-64", "amd64":
+		return elf.EM_X86_64, nil
+	case "aarch64", "arm64":
+		return elf.EM_AARCH64, nil
+	case "riscv64":
+		return elf.EM_RISCV, nil
+	default:
+		return elf.EM_NONE, fmt.Errorf("unknown machine '%s'", name)
+	}
+}
+
+func parseSyntax(name string) (elf.Syntax, error) {
+	switch name {
+	case "att":
+		return elf.SyntaxATT, nil
+	case "intel":
+		return elf.SyntaxIntel, nil
+	default:
+		return elf.SyntaxATT, fmt.Errorf("unknown syntax '%s'", name)
+	}
+}
+
+// writeFile writes the ELF file to fileName. The file is removed again if
+// writing fails.
+func writeFile(fileName string, w io.WriterTo) error {
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.WriteTo(f)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(fileName)
+		return fmt.Errorf("failed to write %s: %w", fileName, err)
+	}
+	return f.Close()
+}
@@ -2,107 +2,715 @@ package elf
 
 import (
 	"encoding/binary"
+	"fmt"
+	"go-elf/x86"
 )
 
 type Compiler struct {
 	startAddr uint64
 	buf       []byte
+	dynamic   *DynamicExecutable
+	functions []Function
+	sections  []CodeSection
+	peephole  *peephole // nil if the instructions are not optimized
+
+	// data which follows the code, nil if there is none. Its labels are
+	// referenced like those of the code.
+	data *DataBuilder
+
+	// labels of the floating point constants in data by their bits
+	constants map[uint64]string
+
+	// positions of the labels in buf
+	labels     map[string]int
+	labelCount int
+	fixups     []fixup
+	alignments []alignment
 }
 
 // Compile generates machine code that:
-// - Writes "Hello\n" to stdout
-// - Increments a counter variable
-// - Exits with the counter value as exit code
+//   - Writes "Hello\n" to stdout
+//   - Increments a counter variable
+//   - Exits with the counter value as exit code
 func Compile(startAddr uint64) (entryPoint uint64, code []byte) {
+	entryPoint, code, err := CompileMachine(EM_X86_64, startAddr)
+	if err != nil {
+		panic(err)
+	}
+	return entryPoint, code
+}
+
+// backend emits the operations of the Compile program for an instruction
+// set. The Compiler itself is the x86-64 backend.
+type backend interface {
+	emitWrite(fd int, bufAddr uint64, count int)
+	emitIncrementCounter(addr uint64)
+	emitExit(counterAddr uint64)
+	emitExitCode(code int)
+}
+
+// CompileMachine generates the program of Compile for the instruction set
+// of machine.
+func CompileMachine(machine Machine, startAddr uint64) (entryPoint uint64, code []byte, err error) {
+	entryPoint, code, _, err = CompileOptimized(machine, startAddr, Optimization{})
+	return entryPoint, code, err
+}
+
+// Optimization selects the optimizations of the compile functions.
+type Optimization struct {
+	// Peephole rewrites the x86-64 instructions while they are emitted,
+	// see peephole.
+	Peephole bool
+
+	// Fold propagates and folds constants and removes dead stores and
+	// unreachable code before the instructions are generated, see
+	// optimizeOperations and ir.Func.Optimize.
+	Fold bool
+}
+
+// CompileOptions selects the optimizations and optional parts of the
+// Compile program.
+type CompileOptions struct {
+	Optimization
+
+	// Args starts the program with the entry prologue, which stores argc,
+	// argv, envp and the auxiliary vector of the initial stack, see
+	// emitEntryPrologue. The program writes each argument on its own line
+	// in front of "Hello World!\n". Only x86-64 is supported.
+	Args bool
+}
+
+// CompileOptimized is like CompileMachine with the optimizations of opt. It
+// returns the size of the instructions before and after the peephole
+// optimizer.
+func CompileOptimized(machine Machine, startAddr uint64, opt Optimization) (entryPoint uint64, code []byte, stats PeepholeStats, err error) {
+	return CompileWithOptions(machine, startAddr, CompileOptions{Optimization: opt})
+}
+
+// CompileWithOptions is like CompileOptimized with the options of opt.
+func CompileWithOptions(machine Machine, startAddr uint64, opt CompileOptions) (entryPoint uint64, code []byte, stats PeepholeStats, err error) {
+	if opt.Args && machine != EM_X86_64 {
+		return 0, nil, PeepholeStats{}, fmt.Errorf("the entry prologue is not supported for machine %s", machine)
+	}
 	c := &Compiler{
 		startAddr: startAddr,
 		buf:       make([]byte, 0),
 	}
+	if opt.Peephole && machine == EM_X86_64 {
+		c.enablePeephole()
+	}
 
-	// Data section
+	var (
+		b         backend
+		codeAlign uint64 = 1
+	)
+	switch machine {
+	case EM_X86_64:
+		b = c
+	case EM_AARCH64:
+		b = &aarch64Compiler{c}
+		codeAlign = 4
+	case EM_RISCV:
+		b = &riscvCompiler{c}
+		codeAlign = 4
+	default:
+		return 0, nil, PeepholeStats{}, fmt.Errorf("machine %s is not supported by the compiler", machine)
+	}
+
+	// Data section, the variables of the entry prologue come first to be
+	// aligned
+	var (
+		p           processStart
+		newlineAddr uint64
+	)
+	if opt.Args {
+		p = c.addProcessStart()
+		newlineAddr = c.addString("\n")
+	}
 	str := "Hello World!\n"
 	helloAddr := c.addString(str)
 	counterAddr := c.addInt32(33)
 
 	// Mark where code starts
+	c.align(codeAlign)
 	entryPoint = startAddr + uint64(len(c.buf))
 
 	// Code section
-	c.emitWrite(1, helloAddr, len(str))
-	c.emitIncrementCounter(counterAddr)
-	c.emitExit(counterAddr)
+	if opt.Args {
+		c.emitEntryPrologue(p)
+		c.emitWriteArguments(p, newlineAddr)
+	}
+	ops := []operation{
+		{kind: opWrite, fd: 1, addr: helloAddr, count: len(str)},
+		{kind: opIncrement, addr: counterAddr},
+		{kind: opExit, addr: counterAddr},
+	}
+	if opt.Fold {
+		ops = optimizeOperations(ops, map[uint64]int32{counterAddr: 33})
+	}
+	emitOperations(b, ops)
+
+	err = c.resolve()
+	if err != nil {
+		return 0, nil, PeepholeStats{}, err
+	}
+	return entryPoint, c.buf, c.peepholeStats(), nil
+}
+
+// operationKind is the kind of an operation of the Compile program.
+type operationKind int
+
+const (
+	opWrite     operationKind = iota // write count bytes at addr to fd
+	opIncrement                      // increment the 32 bit counter at addr
+	opExit                           // exit with the counter at addr
+	opExitCode                       // exit with code
+)
+
+// operation is a step of the Compile program, which is emitted by a
+// backend.
+type operation struct {
+	kind  operationKind
+	fd    int
+	addr  uint64
+	count int
+	code  int
+}
+
+// emitOperations emits ops with b.
+func emitOperations(b backend, ops []operation) {
+	for _, op := range ops {
+		switch op.kind {
+		case opWrite:
+			b.emitWrite(op.fd, op.addr, op.count)
+		case opIncrement:
+			b.emitIncrementCounter(op.addr)
+		case opExit:
+			b.emitExit(op.addr)
+		case opExitCode:
+			b.emitExitCode(op.code)
+		}
+	}
+}
+
+// optimizeOperations returns ops with the values of the counters
+// propagated, whose initial values are known from counters:
+//   - an exit with a counter whose value is known exits with the constant
+//     instead, the counter is not read anymore
+//   - the operations after an exit are unreachable and removed
+//   - increments of a counter which is not read afterwards are dead stores
+//     and removed
+func optimizeOperations(ops []operation, counters map[uint64]int32) []operation {
+	values := map[uint64]int32{}
+	for addr, value := range counters {
+		values[addr] = value
+	}
+	reachable := []operation{}
+	for _, op := range ops {
+		value, known := values[op.addr]
+		switch op.kind {
+		case opIncrement:
+			if known {
+				values[op.addr] = value + 1
+			}
+		case opExit:
+			if known {
+				op = operation{kind: opExitCode, code: int(value)}
+			}
+		}
+		reachable = append(reachable, op)
+		if op.kind == opExit || op.kind == opExitCode {
+			break
+		}
+	}
+
+	// read reports whether one of the operations reads the counter at addr
+	read := func(addr uint64, later []operation) bool {
+		for _, op := range later {
+			switch op.kind {
+			case opExit:
+				if op.addr == addr {
+					return true
+				}
+			case opWrite:
+				if addr+4 > op.addr && addr < op.addr+uint64(op.count) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	live := []operation{}
+	for i, op := range reachable {
+		if op.kind == opIncrement && !read(op.addr, reachable[i+1:]) {
+			continue
+		}
+		live = append(live, op)
+	}
+	return live
+}
+
+// CompileDynamic generates machine code for a dynamically linked executable
+// that:
+//   - Prints "Hello World!" with puts
+//   - Increments a counter variable and prints it with printf
+//   - Exits with the counter value as exit code using exit from libc, which
+//     flushes the buffered output
+func CompileDynamic(d *DynamicExecutable) (entryPoint uint64, code []byte) {
+	c := &Compiler{
+		startAddr: d.CodeAddress(),
+		buf:       make([]byte, 0),
+		dynamic:   d,
+	}
+
+	// Data section, the code is read only, so the counter is in .data
+	helloAddr := c.addString("Hello World!")
+	formatAddr := c.addString("counter: %d\n")
+	counter := c.addCounter(33)
+
+	// Mark where code starts
+	entryPoint = c.startAddr + uint64(len(c.buf))
+
+	// Code section
+	// The stack pointer is 16 byte aligned at the entry point, which is
+	// what the System V ABI requires before a call instruction.
+	c.emitMovRegImm64(7, helloAddr) // rdi = string
+	c.emitCallImport("puts")
+
+	c.emitLabelRIP(x86.ADD, counter, x86.Mem{RIP: true, Size: 32}, x86.Imm(1))
+
+	c.emitMovRegImm64(7, formatAddr)                              // rdi = format
+	c.emitLabelRIP(x86.MOV, counter, x86.ESI, x86.Mem{RIP: true}) // esi = counter value
+	c.emitMovRegImm32(0, 0)                                       // al = number of vector registers used by variadic call
+	c.emitCallImport("printf")
 
+	c.emitLabelRIP(x86.MOV, counter, x86.EDI, x86.Mem{RIP: true}) // edi = counter value
+	c.emitCallImport("exit")
+
+	err := c.resolve()
+	if err != nil {
+		panic(err)
+	}
+	d.Data = c.data
 	return entryPoint, c.buf
 }
 
+// CompileSharedObject generates position independent machine code for a
+// shared object that exports:
+// - int answer(void): returns 42
+// - int increment(void): increments a counter and returns the new value
+// - const char *greeting(void): returns "Hello World!"
+func CompileSharedObject(s *SharedObject) (code []byte, functions []Function) {
+	c := &Compiler{
+		startAddr: s.CodeAddress(),
+		buf:       make([]byte, 0),
+	}
+
+	// Data section, the code is read only, so the counter is in .data
+	helloAddr := c.addString("Hello World!")
+	counter := c.addCounter(33)
+
+	// Code section
+	c.beginFunction("answer")
+	c.emitMovRegImm32(0, 42) // rax = 42
+	c.emitRet()
+	c.endFunction()
+
+	c.beginFunction("increment")
+	c.emitLabelRIP(x86.MOV, counter, x86.EAX, x86.Mem{RIP: true}) // eax = counter
+	c.emitAddRegImm8(0, 1)                                        // eax += 1
+	c.emitLabelRIP(x86.MOV, counter, x86.Mem{RIP: true}, x86.EAX) // counter = eax
+	c.emitRet()
+	c.endFunction()
+
+	c.beginFunction("greeting")
+	c.emitLeaRIP(0, helloAddr) // rax = string
+	c.emitRet()
+	c.endFunction()
+
+	err := c.resolve()
+	if err != nil {
+		panic(err)
+	}
+	s.Data = c.data
+	return c.buf, c.functions
+}
+
+// CompileFunctions generates position independent machine code for a
+// shared object whose functions follow the System V AMD64 calling
+// convention, so they can be called from C and call C functions:
+//   - long weighted(long a, long b, long c, long d, long e, long f): returns
+//     a + 2*b + 3*c + 4*d + 5*e + 6*f
+//   - long factorial(long n): computes n! recursively
+//   - long fold(long (*f)(long, long), long n): calls acc = f(acc, i) for i
+//     from 1 to n, starting with acc = 0, and returns acc
+//   - double mix(long n, double x, long m, double y): returns n*x + m*y
+//   - double apply(double (*f)(double, long, double), long n, double x):
+//     returns f(2*x, n, x)
+func CompileFunctions(s *SharedObject) (code []byte, functions []Function) {
+	c := &Compiler{
+		startAddr: s.CodeAddress(),
+		buf:       make([]byte, 0),
+	}
+
+	f := c.beginFrame("weighted", 0)
+	c.emitMovRegImm32(0, 0) // rax = 0
+	for i := range 6 {
+		c.emit(x86.IMUL, x86.R10, f.param(i), x86.Imm(i+1))
+		c.emit(x86.ADD, x86.RAX, x86.R10)
+	}
+	c.emitReturn(f)
+	c.endFrame()
+
+	// n is kept in rbx, which is preserved by the recursive call
+	f = c.beginFrame("factorial", 0, x86.RBX)
+	done := c.newLabel()
+	c.emit(x86.MOV, x86.RBX, f.param(0))
+	c.emitMovRegImm32(0, 1) // rax = 1
+	c.emit(x86.CMP, x86.RBX, x86.Imm(1))
+	c.emitJump(x86.JLE, done)
+	c.emit(x86.LEA, x86.RDI, x86.Mem{Base: x86.RBX, Disp: -1})
+	c.emitCallFunction("factorial", x86.RDI)
+	c.emit(x86.IMUL, x86.RAX, x86.RBX)
+	c.label(done)
+	c.emitReturn(f)
+	c.endFrame()
+
+	// f, i and n are kept in callee saved registers, acc in a stack slot
+	f = c.beginFrame("fold", 1, x86.RBX, x86.R12, x86.R13)
+	acc := f.local(0)
+	loop := c.newLabel()
+	done = c.newLabel()
+	c.emit(x86.MOV, x86.RBX, f.param(0))
+	c.emit(x86.MOV, x86.R13, f.param(1))
+	c.emitMove(acc, x86.Imm(0))
+	c.emitMovRegImm32(12, 1) // r12 = 1
+	c.label(loop)
+	c.emit(x86.CMP, x86.R12, x86.R13)
+	c.emitJump(x86.JG, done)
+	c.emitCallIndirect(x86.RBX, acc, x86.R12)
+	c.emitMove(acc, x86.RAX)
+	c.emit(x86.INC, x86.R12)
+	c.emitJump(x86.JMP, loop)
+	c.label(done)
+	c.emitMove(x86.RAX, acc)
+	c.emitReturn(f)
+	c.endFrame()
+
+	// the integer and floating point parameters are counted separately
+	f = c.beginFrame("mix", 0)
+	c.emit(x86.CVTSI2SD, x86.XMM2, f.param(0))
+	c.emit(x86.MULSD, f.floatParam(0), x86.XMM2)
+	c.emit(x86.CVTSI2SD, x86.XMM2, f.param(1))
+	c.emit(x86.MULSD, f.floatParam(1), x86.XMM2)
+	c.emit(x86.ADDSD, x86.XMM0, x86.XMM1)
+	c.emitReturn(f)
+	c.endFrame()
+
+	// f and n are kept in callee saved registers, x and 2*x in stack slots
+	// which are passed as floating point arguments
+	f = c.beginFrame("apply", 2, x86.RBX, x86.R12)
+	c.emit(x86.MOV, x86.RBX, f.param(0))
+	c.emit(x86.MOV, x86.R12, f.param(1))
+	c.emitMove(f.local(0), f.floatParam(0))
+	c.emit(x86.ADDSD, x86.XMM0, x86.XMM0)
+	c.emitMove(f.local(1), x86.XMM0)
+	c.emitCallIndirect(x86.RBX, floatArg{f.local(1)}, x86.R12, floatArg{f.local(0)})
+	c.emitReturn(f)
+	c.endFrame()
+
+	err := c.resolve()
+	if err != nil {
+		panic(err)
+	}
+	return c.buf, c.functions
+}
+
+// CompileThreadLocal generates machine code for a statically linked
+// executable which uses thread local variables:
+//   - Sets up the TLS of the main thread and increments its variables
+//   - Starts a second thread with clone(), which increments its own copy of
+//     the variables and writes them to stdout: "thread: 8 1"
+//   - Waits until the second thread is done, writes its own copy of the
+//     variables "main: 6 2" and exits with exit code 0
+func CompileThreadLocal(virtualAddress uint64) (e *StaticExecutable, entryPoint uint64, code []byte) {
+	tls := ThreadLocalStorage{}
+	counterOffset := tls.AddInt32(5)
+	callsOffset := tls.AddBSS(4, 4)
+
+	e = NewStaticExecutable(virtualAddress, tls)
+	counter := e.TLS().ThreadPointerOffset(counterOffset)
+	calls := e.TLS().ThreadPointerOffset(callsOffset)
+
+	c := &Compiler{
+		startAddr: e.CodeAddress(),
+		buf:       make([]byte, 0),
+	}
+
+	// Data section, the code is read only, so the variables are in .data
+	threadStr := "thread: "
+	threadAddr := c.addString(threadStr)
+	mainStr := "main: "
+	mainAddr := c.addString(mainStr)
+	spaceAddr := c.addString(" ")
+	newlineAddr := c.addString("\n")
+	digit := c.addVariable("digit", 0)
+	done := c.addVariable("done", 0)
+
+	// Mark where code starts
+	entryPoint = c.startAddr + uint64(len(c.buf))
+
+	// Code section
+	c.emitMmap(threadAreaSize)
+	c.emitInitThreadArea(e) // rbx = thread pointer
+	c.emitArchPrctl(archSetFS, x86.RBX)
+
+	c.emitIncrementThreadLocal(counter, 1)
+	c.emitIncrementThreadLocal(calls, 2)
+
+	c.emitMmap(threadAreaSize)
+	c.emitInitThreadArea(e)     // rbx = thread pointer of the new thread
+	c.emitClone(threadAreaSize) // stack at the end of the thread area
+	c.emitJump(x86.JNE, "parent")
+
+	// new thread, rax = 0
+	c.emitIncrementThreadLocal(counter, 3)
+	c.emitIncrementThreadLocal(calls, 1)
+	c.emitWrite(1, threadAddr, len(threadStr))
+	c.emitWriteDigit(counter, digit)
+	c.emitWrite(1, spaceAddr, 1)
+	c.emitWriteDigit(calls, digit)
+	c.emitWrite(1, newlineAddr, 1)
+	c.emitLabelRIP(x86.MOV, done, x86.Mem{RIP: true, Size: 32}, x86.Imm(1))
+	c.emitSys(SYS_EXIT, x86.Imm(0)) // terminates only this thread
+
+	// main thread, rax = thread ID
+	c.label("parent")
+	c.emitWaitNotZero(done)
+	c.emitWrite(1, mainAddr, len(mainStr))
+	c.emitWriteDigit(counter, digit)
+	c.emitWrite(1, spaceAddr, 1)
+	c.emitWriteDigit(calls, digit)
+	c.emitWrite(1, newlineAddr, 1)
+	c.emitSys(SYS_EXIT_GROUP, x86.Imm(0))
+
+	err := c.resolve()
+	if err != nil {
+		panic(err)
+	}
+	e.Data = c.data
+	return e, entryPoint, c.buf
+}
+
+// beginFunction marks the current position as the start of a function.
+func (c *Compiler) beginFunction(name string) {
+	c.peepholeBarrier()
+	c.functions = append(c.functions, Function{
+		Name:    name,
+		Address: c.startAddr + uint64(len(c.buf)),
+	})
+}
+
+// endFunction sets the size of the function started last.
+func (c *Compiler) endFunction() {
+	c.peepholeBarrier()
+	f := &c.functions[len(c.functions)-1]
+	f.Size = c.startAddr + uint64(len(c.buf)) - f.Address
+}
+
+// beginSection starts the section name at the current position, see
+// Writer.Sections.
+func (c *Compiler) beginSection(name string) {
+	c.peepholeBarrier()
+	c.sections = append(c.sections, CodeSection{
+		Name:    name,
+		Address: c.startAddr + uint64(len(c.buf)),
+	})
+}
+
+// align pads the buffer with zeros to a multiple of align. The padding is
+// adjusted by resolve when the code in front of it moves.
+func (c *Compiler) align(align uint64) {
+	c.pad(align, 0)
+}
+
+// dataBuilder returns the data of the Compiler, which is created on first
+// use.
+func (c *Compiler) dataBuilder() *DataBuilder {
+	if c.data == nil {
+		c.data = NewDataBuilder()
+	}
+	return c.data
+}
+
+// dataAddress returns the address of data appended to the buffer now. The
+// address would change if a jump in front of it was shortened by resolve,
+// so data which follows a relaxable jump has to be added to the
+// DataBuilder and referenced by a label.
+func (c *Compiler) dataAddress() uint64 {
+	for _, f := range c.fixups {
+		if f.relaxable {
+			panic("data after a relaxable jump moves, use the DataBuilder")
+		}
+	}
+	return c.startAddr + uint64(len(c.buf))
+}
+
 func (c *Compiler) addString(s string) uint64 {
-	addr := c.startAddr + uint64(len(c.buf))
+	addr := c.dataAddress()
 	c.buf = append(c.buf, []byte(s)...)
 	c.buf = append(c.buf, 0) // null terminator
 	return addr
 }
 
 func (c *Compiler) addInt32(value int32) uint64 {
-	addr := c.startAddr + uint64(len(c.buf))
+	addr := c.dataAddress()
 	b := make([]byte, 4)
 	binary.LittleEndian.PutUint32(b, uint32(value))
 	c.buf = append(c.buf, b...)
 	return addr
 }
 
+func (c *Compiler) addInt64(value int64) uint64 {
+	addr := c.dataAddress()
+	c.buf = binary.LittleEndian.AppendUint64(c.buf, uint64(value))
+	return addr
+}
+
+// addCounter adds an int32 to the writable .data section of the
+// DataBuilder, because the code is mapped read only, and returns its label.
+func (c *Compiler) addCounter(value int32) string {
+	return c.addVariable("counter", value)
+}
+
+// addVariable adds an int32 with the label name to the writable .data
+// section of the DataBuilder and returns the label.
+func (c *Compiler) addVariable(name string, value int32) string {
+	d := c.dataBuilder().Data
+	d.Align(4)
+	d.Label(name)
+	d.Int32(value)
+	return name
+}
+
+// emit appends an instruction encoded by the x86 package.
+func (c *Compiler) emit(op x86.Op, operands ...x86.Operand) {
+	if c.peephole != nil {
+		c.emitPeephole(op, operands)
+		return
+	}
+	code, err := x86.Encode(op, operands...)
+	if err != nil {
+		panic(err)
+	}
+	c.buf = append(c.buf, code...)
+}
+
+// emitRIP appends an instruction with a RIP relative memory operand which
+// addresses addr. The displacement is relative to the end of the
+// instruction, so it is set by resolve.
+func (c *Compiler) emitRIP(op x86.Op, addr uint64, operands ...x86.Operand) {
+	c.emitRIPReference(reference{addr: addr}, op, operands...)
+}
+
+// emitLabelRIP appends an instruction with a RIP relative memory operand
+// which addresses label, like emitRIP.
+func (c *Compiler) emitLabelRIP(op x86.Op, label string, operands ...x86.Operand) {
+	c.emitRIPReference(reference{label: label}, op, operands...)
+}
+
+func (c *Compiler) emitRIPReference(target reference, op x86.Op, operands ...x86.Operand) {
+	for i, operand := range operands {
+		if mem, ok := operand.(x86.Mem); ok && mem.RIP {
+			c.emitFixup(target, op, i, operands...)
+			return
+		}
+	}
+	panic("no RIP relative operand")
+}
+
+func reg64(reg byte) x86.Register {
+	return x86.Reg(int(reg), 64)
+}
+
+func reg32(reg byte) x86.Register {
+	return x86.Reg(int(reg), 32)
+}
+
 // mov r64, imm32 (sign-extended to 64-bit)
 func (c *Compiler) emitMovRegImm32(reg byte, value uint32) {
-	c.buf = append(c.buf, 0x48, 0xc7, 0xc0+reg)
-	b := make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, value)
-	c.buf = append(c.buf, b...)
+	c.emit(x86.MOV, reg64(reg), x86.Imm(int32(value)))
 }
 
 // mov r64, imm64
 func (c *Compiler) emitMovRegImm64(reg byte, value uint64) {
-	c.buf = append(c.buf, 0x48, 0xb8+reg)
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, value)
-	c.buf = append(c.buf, b...)
+	c.emit(x86.MOV, reg64(reg), x86.Imm(value))
 }
 
 // mov r32, [abs32]
 func (c *Compiler) emitMovRegMem32(reg byte, addr uint64) {
-	c.buf = append(c.buf, 0x8b, (reg<<3)|0x04, 0x25)
-	b := make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, uint32(addr))
-	c.buf = append(c.buf, b...)
+	c.emit(x86.MOV, reg32(reg), x86.Mem{Disp: int32(addr)})
 }
 
 // mov [abs32], r32
 func (c *Compiler) emitMovMemReg32(addr uint64, reg byte) {
-	c.buf = append(c.buf, 0x89, (reg<<3)|0x04, 0x25)
-	b := make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, uint32(addr))
-	c.buf = append(c.buf, b...)
+	c.emit(x86.MOV, x86.Mem{Disp: int32(addr)}, reg32(reg))
+}
+
+// lea r64, [rip+disp32]
+func (c *Compiler) emitLeaRIP(reg byte, addr uint64) {
+	c.emitRIP(x86.LEA, addr, reg64(reg), x86.Mem{RIP: true})
 }
 
 // add r32, imm8
 func (c *Compiler) emitAddRegImm8(reg byte, value uint8) {
-	c.buf = append(c.buf, 0x83, 0xc0+reg, value)
+	c.emit(x86.ADD, reg32(reg), x86.Imm(int8(value)))
+}
+
+// call rel32
+func (c *Compiler) emitCall(addr uint64) {
+	c.emitFixup(reference{addr: addr}, x86.CALL, 0, x86.Rel(0))
+}
+
+// call a function of a shared library through its PLT entry
+func (c *Compiler) emitCallImport(name string) {
+	if c.dynamic == nil {
+		panic("call of " + name + " requires a dynamic executable")
+	}
+	addr, ok := c.dynamic.PLTAddress(name)
+	if !ok {
+		panic("function " + name + " is not imported")
+	}
+	c.emitCall(addr)
+}
+
+// mov r64, r64
+func (c *Compiler) emitMovRegReg(dst byte, src byte) {
+	c.emit(x86.MOV, reg64(dst), reg64(src))
+}
+
+// mov r32, fs:[disp32]
+func (c *Compiler) emitMovRegFS32(reg byte, offset int32) {
+	c.emit(x86.MOV, reg32(reg), x86.Mem{Disp: offset, Segment: x86.FS})
+}
+
+// mov fs:[disp32], r32
+func (c *Compiler) emitMovFSReg32(offset int32, reg byte) {
+	c.emit(x86.MOV, x86.Mem{Disp: offset, Segment: x86.FS}, reg32(reg))
+}
+
+// ret
+func (c *Compiler) emitRet() {
+	c.emit(x86.RET)
 }
 
 // syscall
 func (c *Compiler) emitSyscall() {
-	c.buf = append(c.buf, 0x0f, 0x05)
+	c.emit(x86.SYSCALL)
 }
 
 func (c *Compiler) emitWrite(fd int, bufAddr uint64, count int) {
-	// mov rax, 1
-	c.emitMovRegImm32(0, 1) // rax = syscall 1 (write)
-	// mov rdi, fd
-	c.emitMovRegImm32(7, uint32(fd)) // rdi = fd
-	// mov rsi, bufAddr
-	c.emitMovRegImm64(6, bufAddr) // rsi = buffer address
-	// mov rdx, count
-	c.emitMovRegImm32(2, uint32(count)) // rdx = count
-	// syscall
-	c.emitSyscall()
+	c.emitSys(SYS_WRITE, x86.Imm(fd), x86.Imm(bufAddr), x86.Imm(count))
 }
 
 func (c *Compiler) emitIncrementCounter(addr uint64) {
@@ -115,10 +723,104 @@ func (c *Compiler) emitIncrementCounter(addr uint64) {
 }
 
 func (c *Compiler) emitExit(counterAddr uint64) {
-	// mov rax, 60
-	c.emitMovRegImm32(0, 60) // rax = syscall 60 (exit)
-	// mov edi, [counterAddr]
 	c.emitMovRegMem32(7, counterAddr) // edi = counter value
-	// syscall
-	c.emitSyscall()
+	c.emitSys(SYS_EXIT, x86.RDI)
+}
+
+func (c *Compiler) emitExitCode(code int) {
+	c.emitSys(SYS_EXIT, x86.Imm(code))
+}
+
+const (
+	// threadAreaSize is the size of the memory allocated for each thread.
+	// It contains the TLS block and the thread control block at the
+	// beginning and the stack, which grows down, at the end.
+	threadAreaSize = 0x10000
+
+	archSetFS = 0x1002
+
+	// clone flags to create a thread which shares everything with its
+	// parent but gets its own thread pointer
+	cloneThreadFlags = 0x100 | // CLONE_VM
+		0x200 | // CLONE_FS
+		0x400 | // CLONE_FILES
+		0x800 | // CLONE_SIGHAND
+		0x10000 | // CLONE_THREAD
+		0x40000 | // CLONE_SYSVSEM
+		0x80000 // CLONE_SETTLS
+)
+
+func (c *Compiler) emitMmap(size uint32) {
+	c.emitSys(SYS_MMAP, x86.Imm(0), x86.Imm(size), x86.Imm(PROT_READ|PROT_WRITE), x86.Imm(MAP_PRIVATE|MAP_ANONYMOUS), x86.Imm(-1), x86.Imm(0))
+}
+
+// emitInitThreadArea sets up the TLS block and the thread control block at
+// the beginning of the zero initialized thread area in rax. The address of
+// the thread pointer is returned in rbx.
+func (c *Compiler) emitInitThreadArea(e *StaticExecutable) {
+	tls := e.TLS()
+
+	c.emit(x86.LEA, x86.RBX, x86.Mem{Base: x86.RAX, Disp: int32(tls.BlockSize())}) // lea rbx, [rax+blockSize]
+	c.emit(x86.MOV, x86.Mem{Base: x86.RBX}, x86.RBX)                               // mov [rbx], rbx
+
+	// copy .tdata to the beginning of the block, .tbss is already zero
+	c.emitMovRegReg(7, 0)                       // rdi = block
+	c.emitMovRegImm64(6, e.TLSAddress())        // rsi = template
+	c.emitMovRegImm32(1, uint32(len(tls.Data))) // rcx = size
+	c.emit(x86.REP_MOVSB)
+}
+
+func (c *Compiler) emitArchPrctl(code uint32, addr x86.Operand) {
+	c.emitSys(SYS_ARCH_PRCTL, x86.Imm(code), addr)
+}
+
+// emitClone starts a new thread with the thread pointer in rbx and the stack
+// at offset stackTop of the thread area in rax. Afterwards rax is 0 in the
+// new thread and the thread ID in the calling thread.
+func (c *Compiler) emitClone(stackTop uint32) {
+	c.emit(x86.LEA, x86.RSI, x86.Mem{Base: x86.RAX, Disp: int32(stackTop)}) // lea rsi, [rax+stackTop]
+	c.emitSys(SYS_CLONE, x86.Imm(cloneThreadFlags), x86.RSI, x86.Imm(0), x86.Imm(0), x86.RBX)
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+}
+
+func (c *Compiler) emitIncrementThreadLocal(offset int32, value uint8) {
+	// mov eax, fs:[offset]
+	c.emitMovRegFS32(0, offset)
+	// add eax, value
+	c.emitAddRegImm8(0, value)
+	// mov fs:[offset], eax
+	c.emitMovFSReg32(offset, 0)
+}
+
+// emitWriteDigit writes the thread local variable at offset as a single
+// decimal digit to stdout using the 4 byte buffer with the label buf.
+func (c *Compiler) emitWriteDigit(offset int32, buf string) {
+	c.emitMovRegFS32(0, offset)
+	c.emitAddRegImm8(0, '0')
+	c.emitLabelRIP(x86.MOV, buf, x86.Mem{RIP: true}, x86.EAX)
+	c.emitLabelRIP(x86.LEA, buf, x86.RSI, x86.Mem{RIP: true})
+	c.emitSys(SYS_WRITE, x86.Imm(1), x86.RSI, x86.Imm(1))
+}
+
+// emitStrlen sets rax to the length of the null terminated string at rsi.
+func (c *Compiler) emitStrlen() {
+	loop := c.newLabel()
+	done := c.newLabel()
+	c.emit(x86.XOR, x86.EAX, x86.EAX)
+	c.label(loop)
+	c.emit(x86.CMP, x86.Mem{Base: x86.RSI, Index: x86.RAX, Scale: 1, Size: 8}, x86.Imm(0))
+	c.emitJump(x86.JE, done)
+	c.emit(x86.INC, x86.RAX)
+	c.emitJump(x86.JMP, loop)
+	c.label(done)
+}
+
+// emitWaitNotZero spins until the 32 bit value with the label is not zero.
+func (c *Compiler) emitWaitNotZero(label string) {
+	loop := c.newLabel()
+	c.label(loop)
+	c.emit(x86.PAUSE)
+	c.emitLabelRIP(x86.MOV, label, x86.EAX, x86.Mem{RIP: true})
+	c.emit(x86.TEST, x86.EAX, x86.EAX)
+	c.emitJump(x86.JE, loop)
 }
@@ -3,6 +3,7 @@ package elf
 import (
 	"bytes"
 	"errors"
+	"go-elf/x86"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,12 +14,59 @@ func TestCompile(t *testing.T) {
 	var virtualAddress uint64 = 0x401000
 	entryPoint, code := Compile(virtualAddress)
 
-	elfBinary := Write(virtualAddress, entryPoint, code)
+	elfBinary, err := Write(virtualAddress, entryPoint, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir := t.TempDir()
+	outputPath := filepath.Join(tempDir, "output.elf")
+
+	err = os.WriteFile(outputPath, elfBinary, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(outputPath)
+
+	out, err := cmd.Output()
+	exitErr := &exec.ExitError{}
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitCode() != 34 {
+			t.Fatalf("expected exit code 34 got %d: %s", exitErr.ExitCode(), err)
+		}
+	} else if err == nil {
+		t.Fatal("expected error code 34")
+	} else {
+		t.Fatalf("other error returned: %s", err)
+	}
+
+	expectedOutput := "Hello World!\n"
+	if !bytes.Equal(out, []byte(expectedOutput)) {
+		t.Fatalf("expected output %q, got %q", expectedOutput, out)
+	}
+}
+
+func TestCompileDynamic(t *testing.T) {
+	d, err := NewDynamicExecutable(0x401000, Dynamic{
+		Needed:  []string{"libc.so.6"},
+		Imports: []string{"puts", "printf", "exit"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryPoint, code := CompileDynamic(d)
+
+	elfBinary, err := d.Write(entryPoint, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSegments(t, elfBinary, ".dynamic", ".got.plt", ".plt", ".text", ".data")
 
 	tempDir := t.TempDir()
 	outputPath := filepath.Join(tempDir, "output.elf")
 
-	err := os.WriteFile(outputPath, elfBinary, 0755)
+	err = os.WriteFile(outputPath, elfBinary, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -37,8 +85,444 @@ func TestCompile(t *testing.T) {
 		t.Fatalf("other error returned: %s", err)
 	}
 
+	expectedOutput := "Hello World!\ncounter: 34\n"
+	if !bytes.Equal(out, []byte(expectedOutput)) {
+		t.Fatalf("expected output %q, got %q", expectedOutput, out)
+	}
+}
+
+// checkSegments checks that no PT_LOAD is writable and executable, and
+// that the named sections are mapped with their own permissions.
+func checkSegments(t *testing.T, data []byte, names ...string) {
+	t.Helper()
+	file, err := Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reader{File: file, Data: data}
+	for i, load := range file.ProgramHeaders {
+		if load.Type == PT_LOAD && load.Flags&(PF_W|PF_X) == PF_W|PF_X {
+			t.Errorf("PT_LOAD %d at 0x%x is writable and executable", i, load.VirtualAddress)
+		}
+	}
+	for _, name := range names {
+		index, ok := r.sectionIndexByName(name)
+		if !ok {
+			t.Fatalf("section %s not found", name)
+		}
+		header := r.SectionHeaders[index]
+		expected := (&section{header: header}).permissions()
+		found := false
+		for _, load := range file.ProgramHeaders {
+			if load.Type == PT_LOAD && header.Address >= load.VirtualAddress && header.Address+header.Size <= load.VirtualAddress+load.MemorySize {
+				found = true
+				if load.Flags != expected {
+					t.Errorf("expected section %s in a PT_LOAD with %s got %s", name, programHeaderFlags(expected), programHeaderFlags(load.Flags))
+				}
+			}
+		}
+		if !found {
+			t.Errorf("section %s is not in a PT_LOAD", name)
+		}
+	}
+}
+
+func TestCompileArgs(t *testing.T) {
+	var virtualAddress uint64 = 0x401000
+	tempDir := t.TempDir()
+	for _, opt := range []Optimization{{}, {Fold: true, Peephole: true}} {
+		entryPoint, code, _, err := CompileWithOptions(EM_X86_64, virtualAddress, CompileOptions{Optimization: opt, Args: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		elfBinary, err := Write(virtualAddress, entryPoint, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputPath := filepath.Join(tempDir, "output.elf")
+		err = os.WriteFile(outputPath, elfBinary, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(outputPath, "first", "with space", "", "last")
+		cmd.Args[0] = "program"
+		out, err := cmd.Output()
+		exitErr := &exec.ExitError{}
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("expected exit code 34 got %v", err)
+		}
+		if exitErr.ExitCode() != 34 {
+			t.Errorf("%+v: expected exit code 34 got %d", opt, exitErr.ExitCode())
+		}
+		expectedOutput := "program\nfirst\nwith space\n\nlast\nHello World!\n"
+		if !bytes.Equal(out, []byte(expectedOutput)) {
+			t.Errorf("%+v: expected output %q, got %q", opt, expectedOutput, out)
+		}
+	}
+
+	_, _, _, err := CompileWithOptions(EM_AARCH64, virtualAddress, CompileOptions{Args: true})
+	if err == nil || err.Error() != "the entry prologue is not supported for machine EM_AARCH64" {
+		t.Errorf("expected error for aarch64 got %v", err)
+	}
+}
+
+func TestCompileSharedObject(t *testing.T) {
+	s := NewSharedObject("libgreeting.so", nil, []string{"answer", "increment", "greeting"})
+	code, functions := CompileSharedObject(s)
+
+	sharedObject, err := s.Write(code, functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSegments(t, sharedObject, ".dynamic", ".text", ".data")
+
+	tempDir := t.TempDir()
+	sharedObjectPath := filepath.Join(tempDir, "libgreeting.so")
+	err = os.WriteFile(sharedObjectPath, sharedObject, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cCode, err := os.ReadFile("testdata/dlopen.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	programPath := filepath.Join(tempDir, "dlopen")
+	err = compile(cCode, programPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(programPath, sharedObjectPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	expectedOutput := "Hello World!\nanswer: 42\nincrement: 34\nincrement: 35\n"
+	if !bytes.Equal(out, []byte(expectedOutput)) {
+		t.Fatalf("expected output %q, got %q", expectedOutput, out)
+	}
+}
+
+func TestCompileFunctions(t *testing.T) {
+	s := NewSharedObject("libfunctions.so", nil, []string{"weighted", "factorial", "fold", "mix", "apply"})
+	code, functions := CompileFunctions(s)
+
+	sharedObject, err := s.Write(code, functions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir := t.TempDir()
+	sharedObjectPath := filepath.Join(tempDir, "libfunctions.so")
+	err = os.WriteFile(sharedObjectPath, sharedObject, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cCode, err := os.ReadFile("testdata/sysv.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	programPath := filepath.Join(tempDir, "sysv")
+	err = compile(cCode, programPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(programPath, sharedObjectPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	expectedOutput := "weighted: 91\n" +
+		"factorial(10): 3628800\n" +
+		"factorial(20): 2432902008176640000\n" +
+		"fold: 26 calls: 4 aligned: 1\n" +
+		"fold: 57 preserved: 1\n" +
+		"factorial(5): 120 preserved: 1\n" +
+		"mix: 5\n" +
+		"apply: 8.75\n"
+	if !bytes.Equal(out, []byte(expectedOutput)) {
+		t.Fatalf("expected output %q, got %q", expectedOutput, out)
+	}
+}
+
+func TestCompileThreadLocal(t *testing.T) {
+	e, entryPoint, code := CompileThreadLocal(0x401000)
+
+	elfBinary, err := e.Write(entryPoint, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSegments(t, elfBinary, ".tdata", ".text", ".data")
+
+	tempDir := t.TempDir()
+	outputPath := filepath.Join(tempDir, "output.elf")
+
+	err = os.WriteFile(outputPath, elfBinary, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(outputPath).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each thread has its own copy of the initialized counter (5) and the
+	// zero initialized calls variable
+	expectedOutput := "thread: 8 1\nmain: 6 2\n"
+	if !bytes.Equal(out, []byte(expectedOutput)) {
+		t.Fatalf("expected output %q, got %q", expectedOutput, out)
+	}
+}
+
+func TestCompileAArch64(t *testing.T) {
+	var virtualAddress uint64 = 0x401000
+	entryPoint, code, err := CompileMachine(EM_AARCH64, virtualAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entryPoint%4 != 0 {
+		t.Fatalf("entry point 0x%x is not aligned to 4 bytes", entryPoint)
+	}
+
+	w := &Writer{
+		VirtualAddress: virtualAddress,
+		EntryPoint:     entryPoint,
+		Code:           code,
+		WritableCode:   true,
+		Machine:        EM_AARCH64,
+	}
+	buf := &bytes.Buffer{}
+	_, err = w.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Read(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.Machine != EM_AARCH64 {
+		t.Fatalf("expected machine %s got %s", EM_AARCH64, f.Header.Machine)
+	}
+
+	out, exitCode := run(t, EM_AARCH64, buf.Bytes())
+	if exitCode != 34 {
+		t.Fatalf("expected exit code 34 got %d", exitCode)
+	}
+	expectedOutput := "Hello World!\n"
+	if !bytes.Equal(out, []byte(expectedOutput)) {
+		t.Fatalf("expected output %q, got %q", expectedOutput, out)
+	}
+}
+
+func TestCompileRISCV(t *testing.T) {
+	var virtualAddress uint64 = 0x401000
+	entryPoint, code, err := CompileMachine(EM_RISCV, virtualAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &Writer{
+		VirtualAddress: virtualAddress,
+		EntryPoint:     entryPoint,
+		Code:           code,
+		WritableCode:   true,
+		Machine:        EM_RISCV,
+		Flags:          EF_RISCV_FLOAT_ABI_DOUBLE,
+	}
+	buf := &bytes.Buffer{}
+	_, err = w.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Read(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.Machine != EM_RISCV {
+		t.Fatalf("expected machine %s got %s", EM_RISCV, f.Header.Machine)
+	}
+	if f.Header.Flags&EF_RISCV_FLOAT_ABI != EF_RISCV_FLOAT_ABI_DOUBLE {
+		t.Fatalf("expected double float ABI got flags 0x%x", f.Header.Flags)
+	}
+
+	out, exitCode := run(t, EM_RISCV, buf.Bytes())
+	if exitCode != 34 {
+		t.Fatalf("expected exit code 34 got %d", exitCode)
+	}
 	expectedOutput := "Hello World!\n"
 	if !bytes.Equal(out, []byte(expectedOutput)) {
 		t.Fatalf("expected output %q, got %q", expectedOutput, out)
 	}
 }
+
+func TestCompileLabels(t *testing.T) {
+	var virtualAddress uint64 = 0x401000
+	c := &Compiler{startAddr: virtualAddress}
+
+	str := "Hello Labels!\n"
+	strAddr := c.addString(str)
+	entryPoint := c.startAddr + uint64(len(c.buf))
+
+	c.emitMovRegImm64(7, strAddr) // rdi = string
+	c.emitJump(x86.CALL, "get_len")
+	c.emit(x86.MOV, x86.RDX, x86.RAX) // rdx = length
+	c.emitMovRegImm32(0, 1)           // rax = syscall 1 (write)
+	c.emitMovRegImm32(7, 1)           // rdi = stdout
+	c.emitMovRegImm64(6, strAddr)     // rsi = string
+	c.emitSyscall()
+	c.emit(x86.MOV, x86.RDI, x86.RDX) // rdi = length
+	c.emitMovRegImm32(0, 60)          // rax = syscall 60 (exit)
+	c.emitSyscall()
+
+	// get_len returns the length of the zero terminated string in rdi
+	c.label("get_len")
+	c.emit(x86.XOR, x86.EAX, x86.EAX)
+	c.label("loop")
+	c.emit(x86.CMP, x86.Mem{Base: x86.RDI, Index: x86.RAX, Scale: 1, Size: 8}, x86.Imm(0))
+	c.emitJump(x86.JE, "end")
+	c.emit(x86.ADD, x86.RAX, x86.Imm(1))
+	c.emitJump(x86.JMP, "loop")
+	c.label("end")
+	c.emitRet()
+
+	err := c.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elfBinary, err := Write(virtualAddress, entryPoint, c.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(t.TempDir(), "output.elf")
+	err = os.WriteFile(outputPath, elfBinary, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(outputPath).Output()
+	exitErr := &exec.ExitError{}
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("expected exit code %d: %v", len(str), err)
+	}
+	if exitErr.ExitCode() != len(str) {
+		t.Fatalf("expected exit code %d got %d", len(str), exitErr.ExitCode())
+	}
+	if string(out) != str {
+		t.Fatalf("expected output %q, got %q", str, out)
+	}
+}
+
+func TestResolveRelaxation(t *testing.T) {
+	nops := func(c *Compiler, n int) {
+		for range n {
+			c.emit(x86.NOP)
+		}
+	}
+	tests := []struct {
+		name     string
+		generate func(c *Compiler)
+		expected []byte
+	}{
+		{
+			name: "short forward",
+			generate: func(c *Compiler) {
+				c.emitJump(x86.JMP, "target")
+				nops(c, 3)
+				c.label("target")
+			},
+			expected: []byte{0xeb, 0x03, 0x90, 0x90, 0x90},
+		},
+		{
+			name: "short backward",
+			generate: func(c *Compiler) {
+				c.label("target")
+				nops(c, 1)
+				c.emitJump(x86.JNE, "target")
+			},
+			expected: []byte{0x90, 0x75, 0xfd},
+		},
+		{
+			name: "long forward",
+			generate: func(c *Compiler) {
+				c.emitJump(x86.JE, "target")
+				nops(c, 128)
+				c.label("target")
+			},
+			expected: append([]byte{0x0f, 0x84, 0x80, 0x00, 0x00, 0x00}, bytes.Repeat([]byte{0x90}, 128)...),
+		},
+		{
+			// the second jump is only short enough after the first one
+			// got shortened
+			name: "chain",
+			generate: func(c *Compiler) {
+				c.emitJump(x86.JMP, "first")
+				c.emitJump(x86.JMP, "second")
+				nops(c, 124)
+				c.label("first")
+				c.label("second")
+			},
+			expected: append([]byte{0xeb, 0x7e, 0xeb, 0x7c}, bytes.Repeat([]byte{0x90}, 124)...),
+		},
+		{
+			name: "call",
+			generate: func(c *Compiler) {
+				c.emitJump(x86.CALL, "target")
+				c.label("target")
+			},
+			expected: []byte{0xe8, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			// the padding grows from 2 to 5 bytes when the jump gets
+			// shortened, so the target stays aligned
+			name: "align",
+			generate: func(c *Compiler) {
+				c.emitJump(x86.JMP, "target")
+				nops(c, 1)
+				c.align(8)
+				c.label("target")
+				nops(c, 1)
+				c.align(4)
+				nops(c, 1)
+			},
+			expected: []byte{0xeb, 0x06, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x90},
+		},
+	}
+	for _, test := range tests {
+		c := &Compiler{startAddr: 0x401000}
+		test.generate(c)
+		err := c.resolve()
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !bytes.Equal(c.buf, test.expected) {
+			t.Errorf("%s: expected % x got % x", test.name, test.expected, c.buf)
+		}
+	}
+
+	c := &Compiler{startAddr: 0x401000}
+	c.emitJump(x86.JMP, "missing")
+	err := c.resolve()
+	if err == nil || err.Error() != "undefined label missing" {
+		t.Fatalf("expected undefined label error got %v", err)
+	}
+
+	// the address of data behind a relaxable jump would move
+	defer func() {
+		if r := recover(); r != "data after a relaxable jump moves, use the DataBuilder" {
+			t.Errorf("expected panic for data after a jump got %v", r)
+		}
+	}()
+	c = &Compiler{startAddr: 0x401000}
+	c.emitJump(x86.JMP, "target")
+	c.addInt64(0)
+}
@@ -0,0 +1,216 @@
+package elf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DataBuilder collects the data of a program, which the Writer places after
+// the code into the sections .rodata, .data and .bss, see layout:
+//
+//	b := NewDataBuilder()
+//	b.Rodata.Align(8)
+//	b.Rodata.Label("pi")
+//	b.Rodata.Float64(math.Pi)
+//	b.BSS.Label("buffer")
+//	b.BSS.Reserve(4096)
+//
+// The labels become symbols of the executable, except the local labels
+// starting with ".L" like those of the Compiler. The methods panic on
+// invalid input, e.g. a label which is already defined.
+type DataBuilder struct {
+	Rodata *DataSection // read only data
+	Data   *DataSection // initialized data
+	BSS    *DataSection // zero filled data, which takes no space in the file
+
+	labels map[string]dataReference
+}
+
+// dataReference is the position of a label in its section.
+type dataReference struct {
+	section *DataSection
+	offset  uint64
+}
+
+// DataSection is a section of a DataBuilder.
+type DataSection struct {
+	name    string
+	builder *DataBuilder
+	buf     []byte // not used by .bss
+	size    uint64
+	align   uint64
+	labels  []dataLabel
+}
+
+// dataLabel is a label at offset in its section. The size is the number of
+// bytes which were added after it, without alignment padding in front of
+// the next label.
+type dataLabel struct {
+	name   string
+	offset uint64
+	size   uint64
+}
+
+// NewDataBuilder returns a builder with empty sections.
+func NewDataBuilder() *DataBuilder {
+	b := &DataBuilder{labels: map[string]dataReference{}}
+	b.Rodata = &DataSection{name: ".rodata", builder: b, align: 1}
+	b.Data = &DataSection{name: ".data", builder: b, align: 1}
+	b.BSS = &DataSection{name: ".bss", builder: b, align: 1}
+	return b
+}
+
+// sections returns the sections in the order in which they follow the code.
+func (b *DataBuilder) sections() []*DataSection {
+	return []*DataSection{b.Rodata, b.Data, b.BSS}
+}
+
+// layout returns the addresses of the sections if they follow code which
+// ends at codeEnd, like the Writer places them. A used section starts on a
+// new page unless it follows a used section with the same permissions,
+// i.e. .bss after .data, then it starts at the next multiple of its
+// alignment.
+func (b *DataBuilder) layout(codeEnd uint64) []uint64 {
+	addresses := []uint64{}
+	address := codeEnd
+	var previous *DataSection // the last used section
+	for _, s := range b.sections() {
+		if s.used() {
+			if previous == b.Data && s == b.BSS {
+				address = alignUp(address, s.align)
+			} else {
+				address = alignUp(address, pageSize)
+			}
+			previous = s
+		}
+		addresses = append(addresses, address)
+		address += s.size
+	}
+	return addresses
+}
+
+// Address returns the address of label if the data follows code which ends
+// at codeEnd.
+func (b *DataBuilder) Address(label string, codeEnd uint64) (uint64, error) {
+	ref, ok := b.labels[label]
+	if !ok {
+		return 0, fmt.Errorf("undefined label %s", label)
+	}
+	addresses := b.layout(codeEnd)
+	for i, s := range b.sections() {
+		if s == ref.section {
+			return addresses[i] + ref.offset, nil
+		}
+	}
+	panic("section " + ref.section.name + " is not part of the builder")
+}
+
+// newDataSection returns the section of the image for the section s of b.
+func newDataSection(b *DataBuilder, s *DataSection) *section {
+	switch s {
+	case b.Rodata:
+		return newSection(s.name, SHT_PROGBITS, SHF_ALLOC, s.align, s.buf)
+	case b.Data:
+		return newSection(s.name, SHT_PROGBITS, SHF_ALLOC|SHF_WRITE, s.align, s.buf)
+	}
+	bss := newSection(s.name, SHT_NOBITS, SHF_ALLOC|SHF_WRITE, s.align, nil)
+	bss.header.Size = s.size
+	return bss
+}
+
+// used reports whether the section contains data or labels. Unused
+// sections are not written.
+func (s *DataSection) used() bool {
+	return s.size > 0 || len(s.labels) > 0
+}
+
+// Label defines the label name at the current position.
+func (s *DataSection) Label(name string) {
+	if _, ok := s.builder.labels[name]; ok {
+		panic("label " + name + " already defined")
+	}
+	s.builder.labels[name] = dataReference{section: s, offset: s.size}
+	s.labels = append(s.labels, dataLabel{name: name, offset: s.size})
+}
+
+// Align pads the section with zeros to a multiple of align, which has to be
+// a power of two up to the page size. The section itself is aligned to the
+// largest alignment.
+func (s *DataSection) Align(align uint64) {
+	if align == 0 || align&(align-1) != 0 || align > pageSize {
+		panic(fmt.Sprintf("invalid alignment %d", align))
+	}
+	s.align = max(s.align, align)
+	s.grow(alignUp(s.size, align) - s.size)
+}
+
+// Reserve appends size zero bytes. It is the only way to add to .bss.
+func (s *DataSection) Reserve(size uint64) {
+	s.grow(size)
+	s.extendLabel()
+}
+
+// grow appends size zero bytes without extending the last label.
+func (s *DataSection) grow(size uint64) {
+	if s != s.builder.BSS {
+		s.buf = append(s.buf, make([]byte, size)...)
+	}
+	s.size += size
+}
+
+// extendLabel makes the last label cover the bytes up to the current
+// position.
+func (s *DataSection) extendLabel() {
+	if len(s.labels) > 0 {
+		l := &s.labels[len(s.labels)-1]
+		l.size = s.size - l.offset
+	}
+}
+
+// Bytes appends data.
+func (s *DataSection) Bytes(data []byte) {
+	if s == s.builder.BSS {
+		panic("initialized data in .bss")
+	}
+	s.buf = append(s.buf, data...)
+	s.size += uint64(len(data))
+	s.extendLabel()
+}
+
+// Int8 appends a byte.
+func (s *DataSection) Int8(value int8) {
+	s.Bytes([]byte{byte(value)})
+}
+
+// Int16 appends value in little endian.
+func (s *DataSection) Int16(value int16) {
+	s.Bytes(binary.LittleEndian.AppendUint16(nil, uint16(value)))
+}
+
+// Int32 appends value in little endian.
+func (s *DataSection) Int32(value int32) {
+	s.Bytes(binary.LittleEndian.AppendUint32(nil, uint32(value)))
+}
+
+// Int64 appends value in little endian.
+func (s *DataSection) Int64(value int64) {
+	s.Bytes(binary.LittleEndian.AppendUint64(nil, uint64(value)))
+}
+
+// Float32 appends value in the single precision format of IEEE 754.
+func (s *DataSection) Float32(value float32) {
+	s.Bytes(binary.LittleEndian.AppendUint32(nil, math.Float32bits(value)))
+}
+
+// Float64 appends value in the double precision format of IEEE 754.
+func (s *DataSection) Float64(value float64) {
+	s.Bytes(binary.LittleEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+// isLocalLabel reports whether the label is not written to the symbol
+// table, like the local labels of the GNU assembler.
+func isLocalLabel(name string) bool {
+	return strings.HasPrefix(name, ".L")
+}
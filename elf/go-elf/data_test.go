@@ -0,0 +1,206 @@
+package elf
+
+import (
+	"encoding/binary"
+	"go-elf/x86"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDataBuilder runs a program which reads and writes data in the
+// sections .rodata, .data and .bss and checks how the Writer places them.
+func TestDataBuilder(t *testing.T) {
+	c := &Compiler{
+		startAddr: 0x401000,
+		buf:       make([]byte, 0),
+	}
+	d := c.dataBuilder()
+	d.Rodata.Label("message")
+	d.Rodata.Bytes([]byte("data\n"))
+	d.Rodata.Align(8)
+	d.Rodata.Label("factor")
+	d.Rodata.Int64(3)
+	d.Rodata.Label("values")
+	d.Rodata.Int8(-1)
+	d.Rodata.Int16(0x1234)
+	d.Rodata.Float32(0.5)
+	d.Data.Label("increment")
+	d.Data.Int8(1)
+	d.Data.Align(4)
+	d.Data.Label("counter")
+	d.Data.Int32(10)
+	d.BSS.Align(64)
+	d.BSS.Label("buffer")
+	d.BSS.Reserve(1 << 20)
+
+	entryPoint := c.startAddr + uint64(len(c.buf))
+	c.emitLabelRIP(x86.LEA, "message", x86.RSI, x86.Mem{RIP: true})
+	c.emitSys(SYS_WRITE, x86.Imm(1), x86.RSI, x86.Imm(5))
+
+	// counter = counter * factor + increment = 31 in the writable .data
+	c.emitLabelRIP(x86.MOVSXD, "counter", x86.RAX, x86.Mem{RIP: true, Size: 32})
+	c.emitLabelRIP(x86.IMUL, "factor", x86.RAX, x86.Mem{RIP: true, Size: 64})
+	c.emitLabelRIP(x86.MOVSX, "increment", x86.RCX, x86.Mem{RIP: true, Size: 8})
+	c.emit(x86.ADD, x86.RAX, x86.RCX)
+	c.emitLabelRIP(x86.MOV, "counter", x86.Mem{RIP: true}, x86.EAX)
+
+	// the end of the buffer is zero, add counter twice through it
+	c.emitLabelRIP(x86.LEA, "buffer", x86.RDI, x86.Mem{RIP: true})
+	c.emit(x86.ADD, x86.Mem{Base: x86.RDI, Disp: 1<<20 - 8}, x86.RAX)
+	c.emitLabelRIP(x86.ADD, "counter", x86.Mem{RIP: true}, x86.EAX)
+	c.emitLabelRIP(x86.MOVSXD, "counter", x86.RAX, x86.Mem{RIP: true, Size: 32})
+	c.emit(x86.ADD, x86.RAX, x86.Mem{Base: x86.RDI, Disp: 1<<20 - 8})
+	c.emitSys(SYS_EXIT, x86.RAX)
+
+	outputPath := filepath.Join(t.TempDir(), "data.elf")
+	writeProgram(t, c, entryPoint, outputPath)
+
+	output, exitCode := execute(t, outputPath, t.TempDir())
+	if exitCode != 93 {
+		t.Errorf("expected exit code 93 got %d", exitCode)
+	}
+	if string(output) != "data\n" {
+		t.Errorf("expected output %q got %q", "data\n", output)
+	}
+
+	// the .bss takes no space in the file
+	elfBinary, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elfBinary) > 1<<16 {
+		t.Errorf("expected a small file got %d bytes", len(elfBinary))
+	}
+	file, err := Read(elfBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reader{File: file, Data: elfBinary}
+	sections := []struct {
+		name        string
+		sectionType SectionHeaderType
+		flags       SectionHeaderFlag
+		align       uint64
+		size        uint64
+	}{
+		{".rodata", SHT_PROGBITS, SHF_ALLOC, 8, 8 + 8 + 1 + 2 + 4},
+		{".data", SHT_PROGBITS, SHF_ALLOC | SHF_WRITE, 4, 8},
+		{".bss", SHT_NOBITS, SHF_ALLOC | SHF_WRITE, 64, 1 << 20},
+	}
+	for _, s := range sections {
+		index, ok := r.sectionIndexByName(s.name)
+		if !ok {
+			t.Fatalf("section %s not found", s.name)
+		}
+		header := r.SectionHeaders[index]
+		if header.Type != s.sectionType || header.Flags != s.flags || header.AddressAlign != s.align || header.Size != s.size {
+			t.Errorf("expected section %s %s %s align %d size %d got %s %s align %d size %d", s.name, s.sectionType, s.flags, s.align, s.size, header.Type, header.Flags, header.AddressAlign, header.Size)
+		}
+		if header.Address%s.align != 0 {
+			t.Errorf("section %s at 0x%x is not aligned to %d bytes", s.name, header.Address, s.align)
+		}
+	}
+
+	// the code, .rodata and .data with .bss are mapped by their own PT_LOAD
+	// with the permissions of their content, after the headers and notes
+	segments := []struct {
+		flags    ProgramHeaderFlag
+		sections []string
+	}{
+		{PF_R, []string{".note.gnu.build-id"}},
+		{PF_R | PF_X, []string{".text"}},
+		{PF_R, []string{".rodata"}},
+		{PF_R | PF_W, []string{".data", ".bss"}},
+	}
+	loads := []ProgramHeader64{}
+	for _, p := range r.ProgramHeaders {
+		if p.Type == PT_LOAD {
+			loads = append(loads, p)
+		}
+	}
+	if len(loads) != len(segments) {
+		t.Fatalf("expected %d PT_LOAD got %d", len(segments), len(loads))
+	}
+	for i, segment := range segments {
+		load := loads[i]
+		if load.Flags != segment.flags || load.VirtualAddress%pageSize != 0 {
+			t.Errorf("expected PT_LOAD %d with %s on a new page got %s at 0x%x", i, programHeaderFlags(segment.flags), programHeaderFlags(load.Flags), load.VirtualAddress)
+		}
+		for _, name := range segment.sections {
+			index, _ := r.sectionIndexByName(name)
+			header := r.SectionHeaders[index]
+			if header.Address < load.VirtualAddress || header.Address+header.Size > load.VirtualAddress+load.MemorySize {
+				t.Errorf("section %s at 0x%x is outside of PT_LOAD %d at 0x%x-0x%x", name, header.Address, i, load.VirtualAddress, load.VirtualAddress+load.MemorySize)
+			}
+		}
+	}
+
+	index, _ := r.sectionIndexByName(".rodata")
+	rodata := r.SectionHeaders[index]
+	values := elfBinary[rodata.Offset+16 : rodata.Offset+rodata.Size]
+	if values[0] != 0xff || binary.LittleEndian.Uint16(values[1:]) != 0x1234 || math.Float32frombits(binary.LittleEndian.Uint32(values[3:])) != 0.5 {
+		t.Errorf("unexpected values % x", values)
+	}
+
+	// each label is a symbol with the size of the data following it
+	symtab, ok := r.sectionIndexByName(".symtab")
+	if !ok {
+		t.Fatal("section .symtab not found")
+	}
+	symbols, err := r.readSymbolTable(symtab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]uint64{}
+	for _, symbol := range symbols[1:] {
+		name, err := r.readString(int(r.SectionHeaders[symtab].Link), int(symbol.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if symbol.SymbolType() != STT_OBJECT {
+			t.Errorf("expected symbol %s of type STT_OBJECT got %s", name, symbol.SymbolType())
+		}
+		address, err := d.Address(name, c.startAddr+uint64(len(c.buf)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if symbol.Value != address {
+			t.Errorf("expected symbol %s at 0x%x got 0x%x", name, address, symbol.Value)
+		}
+		sizes[name] = symbol.Size
+	}
+	expectedSizes := map[string]uint64{"message": 5, "factor": 8, "values": 7, "increment": 1, "counter": 4, "buffer": 1 << 20}
+	if len(sizes) != len(expectedSizes) {
+		t.Errorf("expected symbols %v got %v", expectedSizes, sizes)
+	}
+	for name, size := range expectedSizes {
+		if sizes[name] != size {
+			t.Errorf("expected symbol %s of size %d got %d", name, size, sizes[name])
+		}
+	}
+}
+
+func TestDataBuilderPanics(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(d *DataBuilder)
+		err   string
+	}{
+		{"bss", func(d *DataBuilder) { d.BSS.Int32(1) }, "initialized data in .bss"},
+		{"alignment", func(d *DataBuilder) { d.Data.Align(3) }, "invalid alignment 3"},
+		{"page", func(d *DataBuilder) { d.Data.Align(2 * pageSize) }, "invalid alignment 8192"},
+		{"label", func(d *DataBuilder) { d.Data.Label("a"); d.BSS.Label("a") }, "label a already defined"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != test.err {
+					t.Errorf("expected panic %q got %v", test.err, r)
+				}
+			}()
+			test.build(NewDataBuilder())
+		})
+	}
+}
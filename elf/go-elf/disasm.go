@@ -0,0 +1,250 @@
+package elf
+
+import (
+	"fmt"
+	"go-elf/x86"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Syntax is the assembly syntax of Disassemble.
+type Syntax int
+
+const (
+	SyntaxATT Syntax = iota
+	SyntaxIntel
+)
+
+// codeRegion is executable code of a file at a virtual address.
+type codeRegion struct {
+	name    string
+	address uint64
+	code    []byte
+}
+
+// symbol is a named address used to annotate the disassembly.
+type symbol struct {
+	name    string
+	address uint64
+}
+
+// Disassemble writes the x86-64 code of the file to w in the format of
+// objdump -d -w. The code are the sections with the flag SHF_EXECINSTR or,
+// if the file has none, the executable PT_LOAD segments. Functions and the
+// targets of jumps and calls are annotated with the names of the symbols in
+// .symtab and .dynsym and of the PLT entries, e.g. puts@plt.
+func Disassemble(r *Reader, w io.Writer, syntax Syntax) error {
+	if r.Header.Machine != EM_X86_64 {
+		return fmt.Errorf("disassembly of machine %s is not supported", r.Header.Machine)
+	}
+	format := x86.ATTSyntax
+	if syntax == SyntaxIntel {
+		format = x86.IntelSyntax
+	}
+
+	regions, err := r.codeRegions()
+	if err != nil {
+		return err
+	}
+	if len(regions) == 0 {
+		return fmt.Errorf("no executable code found")
+	}
+	symbols, err := r.codeSymbols()
+	if err != nil {
+		return err
+	}
+	lookup := func(addr uint64) (string, uint64) {
+		// last symbol at or before addr
+		i := sort.Search(len(symbols), func(i int) bool { return symbols[i].address > addr })
+		if i == 0 {
+			return "", 0
+		}
+		return symbols[i-1].name, symbols[i-1].address
+	}
+
+	for _, region := range regions {
+		fmt.Fprintf(w, "\nDisassembly of %s:\n", region.name)
+		next := sort.Search(len(symbols), func(i int) bool { return symbols[i].address >= region.address })
+		for offset := 0; offset < len(region.code); {
+			pc := region.address + uint64(offset)
+			for ; next < len(symbols) && symbols[next].address <= pc; next++ {
+				if symbols[next].address == pc {
+					fmt.Fprintf(w, "\n%016x <%s>:\n", pc, symbols[next].name)
+				}
+			}
+
+			inst, err := x86.Decode(region.code[offset:])
+			text := "(bad)"
+			length := 1
+			if err == nil {
+				text = format(inst, pc, lookup)
+				length = inst.Len
+			}
+			machineCode := &strings.Builder{}
+			for _, b := range region.code[offset : offset+length] {
+				fmt.Fprintf(machineCode, "%02x ", b)
+			}
+			_, err = fmt.Fprintf(w, "%8x:\t%-21s\t%s\n", pc, machineCode, text)
+			if err != nil {
+				return err
+			}
+			offset += length
+		}
+	}
+	return nil
+}
+
+// codeRegions returns the executable sections or, if there are none, the
+// executable PT_LOAD segments.
+func (r *Reader) codeRegions() ([]codeRegion, error) {
+	regions := []codeRegion{}
+	for i, s := range r.SectionHeaders {
+		if s.Flags&SHF_EXECINSTR == 0 || s.Type == SHT_NOBITS {
+			continue
+		}
+		name, err := r.readSectionName(i)
+		if err != nil {
+			return nil, err
+		}
+		if s.Offset+s.Size > uint64(len(r.Data)) {
+			return nil, fmt.Errorf("section %s is outside of the file", name)
+		}
+		regions = append(regions, codeRegion{
+			name:    "section " + name,
+			address: s.Address,
+			code:    r.Data[s.Offset : s.Offset+s.Size],
+		})
+	}
+	if len(regions) > 0 {
+		return regions, nil
+	}
+
+	for i, p := range r.ProgramHeaders {
+		if p.Type != PT_LOAD || p.Flags&PF_X == 0 {
+			continue
+		}
+		if p.Offset+p.FileSize > uint64(len(r.Data)) {
+			return nil, fmt.Errorf("segment %d is outside of the file", i)
+		}
+		regions = append(regions, codeRegion{
+			name:    fmt.Sprintf("segment %d", i),
+			address: p.VirtualAddress,
+			code:    r.Data[p.Offset : p.Offset+p.FileSize],
+		})
+	}
+	return regions, nil
+}
+
+// codeSymbols returns the named symbols of .symtab and .dynsym which are
+// defined in a section and the symbols of the PLT entries, sorted by
+// address.
+func (r *Reader) codeSymbols() ([]symbol, error) {
+	symbols := []symbol{}
+	seen := map[symbol]bool{}
+	for i, s := range r.SectionHeaders {
+		if s.Type != SHT_SYMTAB && s.Type != SHT_DYNSYM {
+			continue
+		}
+		entries, err := r.readSymbolTable(i)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch entry.SymbolType() {
+			case STT_NOTYPE, STT_FUNC, STT_OBJECT:
+			default:
+				continue
+			}
+			if entry.Name == 0 || entry.SectionHeaderIndex == 0 || entry.SectionHeaderIndex >= uint16(SHN_LORESERVE) {
+				continue
+			}
+			name, err := r.readString(int(s.Link), int(entry.Name))
+			if err != nil {
+				return nil, err
+			}
+			sym := symbol{name: name, address: entry.Value}
+			if !seen[sym] {
+				seen[sym] = true
+				symbols = append(symbols, sym)
+			}
+		}
+	}
+	plt, err := r.pltSymbols()
+	if err != nil {
+		return nil, err
+	}
+	symbols = append(symbols, plt...)
+	sort.SliceStable(symbols, func(i, j int) bool { return symbols[i].address < symbols[j].address })
+	return symbols, nil
+}
+
+// pltSymbols returns a symbol name@plt for each entry of the PLT sections
+// which jumps through a GOT entry with a relocation of the dynamic symbol
+// name. The imported functions are undefined in .dynsym, so objdump
+// synthesizes the same symbols.
+func (r *Reader) pltSymbols() ([]symbol, error) {
+	// names of the imported symbols by the address of their GOT entry
+	imports := map[uint64]string{}
+	for i, s := range r.SectionHeaders {
+		if s.Type != SHT_RELA || int(s.Link) >= len(r.SectionHeaders) || r.SectionHeaders[s.Link].Type != SHT_DYNSYM {
+			continue
+		}
+		relocations, err := r.readRelocations(i)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := r.readSymbolTable(int(s.Link))
+		if err != nil {
+			return nil, err
+		}
+		for _, relocation := range relocations {
+			index := relocation.SymbolIndex()
+			if index == 0 || int(index) >= len(entries) || entries[index].Name == 0 {
+				continue
+			}
+			name, err := r.readString(int(r.SectionHeaders[s.Link].Link), int(entries[index].Name))
+			if err != nil {
+				return nil, err
+			}
+			imports[relocation.Offset] = name
+		}
+	}
+
+	symbols := []symbol{}
+	for i, s := range r.SectionHeaders {
+		if s.Flags&SHF_EXECINSTR == 0 || s.Type == SHT_NOBITS || s.Offset+s.Size > uint64(len(r.Data)) {
+			continue
+		}
+		name, err := r.readSectionName(i)
+		if err != nil {
+			return nil, err
+		}
+		if name != ".plt" && !strings.HasPrefix(name, ".plt.") {
+			continue
+		}
+		entrySize := max(s.EntSize, 1)
+		code := r.Data[s.Offset : s.Offset+s.Size]
+		for offset := uint64(0); offset < s.Size; {
+			inst, err := x86.Decode(code[offset:])
+			if err != nil {
+				offset++
+				continue
+			}
+			start := offset
+			offset += uint64(inst.Len)
+			// jmp *got(%rip)
+			if inst.Op != x86.JMP || len(inst.Operands) != 1 {
+				continue
+			}
+			mem, ok := inst.Operands[0].(x86.Mem)
+			if !ok || !mem.RIP {
+				continue
+			}
+			if name, ok := imports[s.Address+offset+uint64(int64(mem.Disp))]; ok {
+				symbols = append(symbols, symbol{name: name + "@plt", address: s.Address + start/entrySize*entrySize})
+			}
+		}
+	}
+	return symbols, nil
+}
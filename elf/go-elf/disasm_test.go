@@ -0,0 +1,195 @@
+package elf
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// functionLines returns the lines of the function in the output of objdump
+// -d -w or Disassemble.
+func functionLines(output string, name string) []string {
+	lines := []string{}
+	inFunction := false
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.HasSuffix(line, " <"+name+">:"):
+			inFunction = true
+		case inFunction && line == "":
+			return lines
+		case inFunction:
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestDisassemble(t *testing.T) {
+	if _, err := exec.LookPath("objdump"); err != nil {
+		t.Skip("objdump not found")
+	}
+
+	cCode := []byte(`
+int add(int a, int b) { return a + b; }
+
+long sum(long *p, int n) {
+	long s = 0;
+	for (int i = 0; i < n; i++) {
+		s += p[i];
+	}
+	return s;
+}
+
+unsigned char shift(unsigned char c, long x) { return (c << 3) ^ (x >> 7) ^ (x < 0); }
+
+int puts(const char *s);
+
+int main(int argc, char **argv) {
+	long a[3] = {1, 2, 3};
+	puts(argv[0]);
+	return add(argc, 2) + sum(a, 3) + shift(argc, -argc);
+}
+`)
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "main")
+	if err := compile(cCode, path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reader{File: file, Data: data}
+
+	for _, syntax := range []struct {
+		name    string
+		syntax  Syntax
+		options []string
+	}{
+		{"att", SyntaxATT, nil},
+		{"intel", SyntaxIntel, []string{"-M", "intel"}},
+	} {
+		t.Run(syntax.name, func(t *testing.T) {
+			out, err := exec.Command("objdump", append([]string{"-d", "-w"}, append(syntax.options, path)...)...).CombinedOutput()
+			if err != nil {
+				t.Fatalf("objdump: %s: %s", err, out)
+			}
+			output := &bytes.Buffer{}
+			if err := Disassemble(r, output, syntax.syntax); err != nil {
+				t.Fatal(err)
+			}
+
+			// main calls puts@plt, which is synthesized from the relocation
+			// of its GOT entry
+			for _, function := range []string{"add", "sum", "shift", "main"} {
+				expected := functionLines(string(out), function)
+				got := functionLines(output.String(), function)
+				if len(expected) == 0 {
+					t.Fatalf("%s not found in objdump output", function)
+				}
+				if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+					t.Errorf("%s: expected\n%s\ngot\n%s", function, strings.Join(expected, "\n"), strings.Join(got, "\n"))
+				}
+			}
+			if len(functionLines(output.String(), "puts@plt")) == 0 {
+				t.Error("puts@plt not found")
+			}
+		})
+	}
+}
+
+func TestDisassembleIBTPLT(t *testing.T) {
+	if _, err := exec.LookPath("objdump"); err != nil {
+		t.Skip("objdump not found")
+	}
+
+	cCode := []byte(`
+int puts(const char *s);
+
+int main(int argc, char **argv) {
+	puts(argv[0]);
+	return 0;
+}
+`)
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "main")
+	// every PLT entry starts with endbr64 and jumps from .plt.sec
+	if err := compile(cCode, path, "-fcf-protection=full", "-Wl,-z,ibtplt"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reader{File: file, Data: data}
+
+	out, err := exec.Command("objdump", "-d", "-w", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("objdump: %s: %s", err, out)
+	}
+	output := &bytes.Buffer{}
+	if err := Disassemble(r, output, SyntaxATT); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := functionLines(string(out), "main")
+	got := functionLines(output.String(), "main")
+	if len(expected) == 0 {
+		t.Fatal("main not found in objdump output")
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("main: expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	if len(functionLines(output.String(), "puts@plt")) == 0 {
+		t.Error("puts@plt not found")
+	}
+}
+
+func TestDisassembleSegments(t *testing.T) {
+	var virtualAddress uint64 = 0x401000
+	entryPoint, code, err := Assemble(virtualAddress, "_start:\n  mov $60, %eax\n  xor %edi, %edi\n  jmp _start\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Write(virtualAddress, entryPoint, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reader{File: file, Data: data}
+	// without section headers only the executable segment is left
+	r.SectionHeaders = nil
+
+	output := &bytes.Buffer{}
+	if err := Disassemble(r, output, SyntaxATT); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"  401000:\tb8 3c 00 00 00       \tmov    $0x3c,%eax",
+		"  401005:\t31 ff                \txor    %edi,%edi",
+		"  401007:\teb f7                \tjmp    0x401000",
+	}
+	if got := output.String(); !strings.Contains(got, strings.Join(expected, "\n")) {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), got)
+	}
+
+	r.Header.Machine = EM_AARCH64
+	err = Disassemble(r, output, SyntaxATT)
+	if err == nil || err.Error() != "disassembly of machine EM_AARCH64 is not supported" {
+		t.Errorf("expected unsupported machine error got %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+package elf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DefaultInterpreter is the path of the dynamic linker on x86-64 Linux.
+const DefaultInterpreter = "/lib64/ld-linux-x86-64.so.2"
+
+const (
+	pltHeaderSize = 16
+	pltEntrySize  = 16
+)
+
+// Dynamic describes what the dynamic linker has to do before a dynamically
+// linked executable can run.
+type Dynamic struct {
+	// Interpreter is the path of the dynamic linker (PT_INTERP).
+	Interpreter string
+
+	// Needed are the shared libraries to load (DT_NEEDED), e.g. libc.so.6.
+	Needed []string
+
+	// Imports are the functions from the needed libraries which are
+	// called through the procedure linkage table (PLT).
+	Imports []string
+}
+
+// DynamicExecutable is the layout of a dynamically linked executable. All
+// structures required for dynamic linking are placed in front of the code.
+// Their size only depends on the Dynamic description, so the addresses of
+// the PLT entries are known before the code gets generated.
+//
+// The headers and the tables of the dynamic linker are read only. The
+// writable segment starts on a new page with the dynamic section, which is
+// only written by the dynamic linker and read only after relocation
+// (PT_GNU_RELRO), and the GOT on the next page, which has to stay writable
+// for lazy binding. The PLT and the code are readable and executable:
+//
+//	ELF header, program headers
+//	.interp    path of the dynamic linker
+//	.note.gnu.build-id
+//	.hash      symbol hash table
+//	.dynsym    imported symbols
+//	.dynstr    names of imported symbols and needed libraries
+//	.rela.plt  R_X86_64_JUMP_SLOT relocation per import
+//	.dynamic   information for the dynamic linker (PT_DYNAMIC)
+//	.got.plt   global offset table, filled by the dynamic linker
+//	.plt       procedure linkage table, code calls these entries
+//	.text      code, starts at virtualAddress
+//	.rodata    Data, like that of the Writer
+//	.data      Data
+//	.bss       Data
+type DynamicExecutable struct {
+	// Data is placed after the code if set, see Writer.Data.
+	Data *DataBuilder
+
+	virtualAddress uint64
+	imports        []string
+
+	img      *image
+	rela     *section
+	dynamic  *section
+	gotPLT   *section
+	plt      *section
+	text     *section
+	dynamics []Dyn64
+
+	// program headers in front of and after the PT_LOADs
+	firstProgramHeaders []ProgramHeader64
+	lastProgramHeaders  []ProgramHeader64
+}
+
+// NewDynamicExecutable returns the layout of an executable whose code starts
+// at virtualAddress. The virtual address has to be page aligned and big
+// enough to leave room for the dynamic linking structures in front of it and
+// each import has to be named once, otherwise an error is returned.
+func NewDynamicExecutable(virtualAddress uint64, dynamic Dynamic) (*DynamicExecutable, error) {
+	if dynamic.Interpreter == "" {
+		dynamic.Interpreter = DefaultInterpreter
+	}
+	imported := map[string]bool{}
+	for _, name := range dynamic.Imports {
+		if name == "" {
+			return nil, fmt.Errorf("imported function without a name")
+		}
+		if imported[name] {
+			return nil, fmt.Errorf("function %s is imported more than once", name)
+		}
+		imported[name] = true
+	}
+
+	d := &DynamicExecutable{
+		virtualAddress: virtualAddress,
+		imports:        dynamic.Imports,
+		img:            newImage(ET_EXEC, EM_X86_64),
+	}
+
+	dynstr := newStringTable()
+	for _, lib := range dynamic.Needed {
+		d.dynamics = append(d.dynamics, Dyn64{Tag: DT_NEEDED, Value: uint64(dynstr.add(lib))})
+	}
+
+	symbols := NewSymbolTable64()
+	for _, name := range dynamic.Imports {
+		symbols = append(symbols, Symbol64{
+			Name:               dynstr.add(name),
+			Info:               NewSymbolInfo(STB_GLOBAL, STT_FUNC),
+			SectionHeaderIndex: uint16(SHN_UNDEF),
+		})
+	}
+
+	img := d.img
+	interp := img.addSection(".interp", SHT_PROGBITS, SHF_ALLOC, 1, append([]byte(dynamic.Interpreter), 0))
+	img.addBuildIDNote()
+	hash := img.addSection(".hash", SHT_HASH, SHF_ALLOC, 8, sysvHashTable(symbols, dynstr))
+	hash.header.EntSize = 4
+	dynsym := img.addSection(".dynsym", SHT_DYNSYM, SHF_ALLOC, 8, encode(symbols))
+	dynsym.header.EntSize = symbolSize
+	dynsym.header.Info = 1 // index of the first non-local symbol
+	dynstrSection := img.addSection(".dynstr", SHT_STRTAB, SHF_ALLOC, 1, dynstr.bytes())
+
+	importCount := uint64(len(dynamic.Imports))
+	d.rela = img.addSection(".rela.plt", SHT_RELA, SHF_ALLOC|SHF_INFO_LINK, 8, make([]byte, importCount*relaSize))
+	d.rela.header.EntSize = relaSize
+
+	// the dynamic entries with addresses are added after the layout but
+	// the number of entries has to be known now
+	dynamicCount := len(d.dynamics) + 10
+	d.dynamic = img.addSection(".dynamic", SHT_DYNAMIC, SHF_ALLOC|SHF_WRITE, 8, make([]byte, uint64(dynamicCount)*dynSize))
+	d.dynamic.header.EntSize = dynSize
+
+	// the first three entries are reserved: address of .dynamic, and two
+	// entries which are used by the dynamic linker for lazy binding
+	d.gotPLT = img.addSection(".got.plt", SHT_PROGBITS, SHF_ALLOC|SHF_WRITE, 8, make([]byte, (3+importCount)*8))
+	d.gotPLT.header.EntSize = 8
+	d.gotPLT.alignment = 0x1000
+	d.plt = img.addSection(".plt", SHT_PROGBITS, SHF_ALLOC|SHF_EXECINSTR, 16, make([]byte, pltHeaderSize+importCount*pltEntrySize))
+	d.plt.header.EntSize = pltEntrySize
+
+	d.text = img.addSection(".text", SHT_PROGBITS, SHF_ALLOC|SHF_EXECINSTR, 16, nil)
+	d.text.alignment = 0x1000
+
+	hash.header.Link = img.sectionIndex(dynsym)
+	dynsym.header.Link = img.sectionIndex(dynstrSection)
+	d.rela.header.Link = img.sectionIndex(dynsym)
+	d.rela.header.Info = img.sectionIndex(d.gotPLT)
+	d.dynamic.header.Link = img.sectionIndex(dynstrSection)
+
+	// PT_PHDR, PT_INTERP, PT_LOAD..., PT_DYNAMIC, PT_GNU_RELRO, PT_NOTE,
+	// PT_GNU_STACK
+	img.alignSegments()
+	programHeaderCount := 4 + len(img.segments()) + maxDataSegments + len(img.gnuProgramHeaders())
+	img.layout(programHeaderCount)
+	if virtualAddress%pageSize != d.text.header.Offset%pageSize {
+		return nil, fmt.Errorf("virtual address 0x%x is not congruent to the file offset 0x%x of the code modulo the page size 0x%x", virtualAddress, d.text.header.Offset, pageSize)
+	}
+	if virtualAddress < d.text.header.Offset {
+		return nil, fmt.Errorf("virtual address 0x%x is too low, the headers require 0x%x bytes in front of the code", virtualAddress, d.text.header.Offset)
+	}
+	base := virtualAddress - d.text.header.Offset
+	img.setBase(base)
+
+	d.dynamics = append(d.dynamics,
+		Dyn64{Tag: DT_HASH, Value: hash.header.Address},
+		Dyn64{Tag: DT_STRTAB, Value: dynstrSection.header.Address},
+		Dyn64{Tag: DT_SYMTAB, Value: dynsym.header.Address},
+		Dyn64{Tag: DT_STRSZ, Value: dynstrSection.header.Size},
+		Dyn64{Tag: DT_SYMENT, Value: symbolSize},
+		Dyn64{Tag: DT_PLTGOT, Value: d.gotPLT.header.Address},
+		Dyn64{Tag: DT_PLTRELSZ, Value: d.rela.header.Size},
+		Dyn64{Tag: DT_PLTREL, Value: uint64(DT_RELA)},
+		Dyn64{Tag: DT_JMPREL, Value: d.rela.header.Address},
+		Dyn64{Tag: DT_NULL},
+	)
+	d.dynamic.setData(encode(d.dynamics))
+
+	relocations := []Rela64{}
+	for i := range dynamic.Imports {
+		relocations = append(relocations, Rela64{
+			Offset: d.gotEntryAddress(i),
+			Info:   NewRelocationInfo(uint32(i+1), R_X86_64_JUMP_SLOT),
+		})
+	}
+	d.rela.setData(encode(relocations))
+
+	d.plt.setData(d.pltCode())
+	d.gotPLT.setData(d.gotContent())
+
+	d.firstProgramHeaders = []ProgramHeader64{
+		{
+			Type:           PT_PHDR,
+			Flags:          PF_R,
+			Offset:         img.header.ProgramHeaderOffset,
+			VirtualAddress: base + img.header.ProgramHeaderOffset,
+			FileSize:       uint64(programHeaderCount) * uint64(img.header.ProgramHeaderSize),
+			MemorySize:     uint64(programHeaderCount) * uint64(img.header.ProgramHeaderSize),
+			Align:          8,
+		},
+		{
+			Type:           PT_INTERP,
+			Flags:          PF_R,
+			Offset:         interp.header.Offset,
+			VirtualAddress: interp.header.Address,
+			FileSize:       interp.header.Size,
+			MemorySize:     interp.header.Size,
+			Align:          1,
+		},
+	}
+	// the PT_LOADs depend on the code and the data and are set in Write
+	d.lastProgramHeaders = []ProgramHeader64{
+		{
+			Type:           PT_DYNAMIC,
+			Flags:          PF_R | PF_W,
+			Offset:         d.dynamic.header.Offset,
+			VirtualAddress: d.dynamic.header.Address,
+			FileSize:       d.dynamic.header.Size,
+			MemorySize:     d.dynamic.header.Size,
+			Align:          8,
+		},
+		{
+			Type:           PT_GNU_RELRO,
+			Flags:          PF_R,
+			Offset:         0,
+			VirtualAddress: base,
+			FileSize:       d.gotPLT.header.Offset,
+			MemorySize:     d.gotPLT.header.Offset,
+			Align:          1,
+		},
+	}
+	d.lastProgramHeaders = append(d.lastProgramHeaders, img.gnuProgramHeaders()...)
+	for _, programHeaders := range [][]ProgramHeader64{d.firstProgramHeaders, d.lastProgramHeaders} {
+		for i := range programHeaders {
+			programHeaders[i].PhysicalAddress = programHeaders[i].VirtualAddress
+		}
+	}
+
+	return d, nil
+}
+
+// PLTAddress returns the address of the PLT entry of an imported function.
+// Code calls this address to call the function.
+func (d *DynamicExecutable) PLTAddress(name string) (uint64, bool) {
+	for i, imported := range d.imports {
+		if imported == name {
+			return d.plt.header.Address + pltHeaderSize + uint64(i)*pltEntrySize, true
+		}
+	}
+	return 0, false
+}
+
+// CodeAddress returns the address where the code starts.
+func (d *DynamicExecutable) CodeAddress() uint64 {
+	return d.virtualAddress
+}
+
+// Write returns the ELF file with the code placed at the code address.
+func (d *DynamicExecutable) Write(entryPoint uint64, code []byte) ([]byte, error) {
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no code to write")
+	}
+	img := d.img
+
+	img.setLastData(d.text, code)
+	if d.Data != nil {
+		img.addDataAfter(d.text, d.Data)
+	}
+
+	img.setProgramHeaders(d.firstProgramHeaders, d.lastProgramHeaders)
+	img.header.Entry = entryPoint
+
+	return img.bytes()
+}
+
+func (d *DynamicExecutable) gotEntryAddress(importIndex int) uint64 {
+	return d.gotPLT.header.Address + uint64(3+importIndex)*8
+}
+
+func (d *DynamicExecutable) gotContent() []byte {
+	got := make([]byte, d.gotPLT.header.Size)
+	binary.LittleEndian.PutUint64(got[0:], d.dynamic.header.Address)
+	for i := range d.imports {
+		// until the function is resolved, the GOT entry points back to
+		// the push instruction in the PLT entry, which calls the
+		// resolver of the dynamic linker (lazy binding)
+		pltEntry := d.plt.header.Address + pltHeaderSize + uint64(i)*pltEntrySize
+		binary.LittleEndian.PutUint64(got[(3+i)*8:], pltEntry+6)
+	}
+	return got
+}
+
+// pltCode generates the procedure linkage table:
+//
+//	PLT0: push GOT[1](%rip)      # link map of this object
+//	      jmp *GOT[2](%rip)      # resolver of the dynamic linker
+//	      nop
+//	PLTn: jmp *GOT[3+n](%rip)    # function address once resolved
+//	      push $n                # index of the relocation
+//	      jmp PLT0
+func (d *DynamicExecutable) pltCode() []byte {
+	pltAddr := d.plt.header.Address
+	gotAddr := d.gotPLT.header.Address
+
+	// displacement relative to the end of the instruction
+	rel32 := func(code []byte, target uint64) []byte {
+		next := pltAddr + uint64(len(code)) + 4
+		return binary.LittleEndian.AppendUint32(code, uint32(int32(target-next)))
+	}
+
+	code := []byte{0xff, 0x35}
+	code = rel32(code, gotAddr+8)
+	code = append(code, 0xff, 0x25)
+	code = rel32(code, gotAddr+16)
+	code = append(code, 0x0f, 0x1f, 0x40, 0x00)
+
+	for i := range d.imports {
+		code = append(code, 0xff, 0x25)
+		code = rel32(code, d.gotEntryAddress(i))
+		code = append(code, 0x68)
+		code = binary.LittleEndian.AppendUint32(code, uint32(i))
+		code = append(code, 0xe9)
+		code = rel32(code, pltAddr)
+	}
+
+	if uint64(len(code)) != d.plt.header.Size {
+		panic(fmt.Sprintf("invalid PLT size %d", len(code)))
+	}
+	return code
+}
+
+// sysvHashTable generates the content of a .hash section. See chapter
+// "Hash Table" of the System V ABI. A single bucket is enough as the
+// executable exports no symbols.
+func sysvHashTable(symbols []Symbol64, strtab *stringTable) []byte {
+	nbucket := uint32(1)
+	nchain := uint32(len(symbols))
+
+	buckets := make([]uint32, nbucket)
+	chains := make([]uint32, nchain)
+	for i := len(symbols) - 1; i > 0; i-- {
+		name := strtab.data[symbols[i].Name:]
+		for j, b := range name {
+			if b == 0 {
+				name = name[:j]
+				break
+			}
+		}
+		bucket := sysvHash(name) % nbucket
+		chains[i] = buckets[bucket]
+		buckets[bucket] = uint32(i)
+	}
+
+	table := []uint32{nbucket, nchain}
+	table = append(table, buckets...)
+	table = append(table, chains...)
+	return encode(table)
+}
+
+func sysvHash(name []byte) uint32 {
+	var h uint32
+	for _, c := range name {
+		h = (h << 4) + uint32(c)
+		g := h & 0xf0000000
+		if g != 0 {
+			h ^= g >> 24
+		}
+		h &= ^g
+	}
+	return h
+}
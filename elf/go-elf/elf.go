@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"unsafe"
+	"strings"
 )
 
 func Read(data []byte) (*File, error) {
@@ -64,79 +64,116 @@ func Read(data []byte) (*File, error) {
 	return file, nil
 }
 
-func Write(virtualAddress uint64, entryPoint uint64, code []byte) []byte {
-	programHeaders := []ProgramHeader64{
-		{
-			Type:           PT_LOAD,
-			Flags:          PF_R | PF_X | PF_W,
-			Offset:         0, // will be set below
-			VirtualAddress: uint64(virtualAddress),
-			FileSize:       uint64(len(code)),
-			MemorySize:     uint64(len(code)),
-			Align:          0x1000,
-		},
+// Write returns an executable which loads code at virtualAddress and starts
+// at entryPoint. The code is writable, as it may contain variables. See
+// Writer.
+func Write(virtualAddress uint64, entryPoint uint64, code []byte) ([]byte, error) {
+	w := &Writer{
+		VirtualAddress: virtualAddress,
+		EntryPoint:     entryPoint,
+		Code:           code,
+		WritableCode:   true,
 	}
-
-	headerSize := uint64(unsafe.Sizeof(Header64{}) + uintptr(len(programHeaders))*unsafe.Sizeof(ProgramHeader64{}))
-	padding := (4096 - headerSize%4096) % 4096
-	codeStart := headerSize + padding
-
-	programHeaders[0].Offset = codeStart
-
-	f := &File{
-		Header: &Header64{
-			ELFIdentifier: ELFIdentifier{
-				Magic:      MagicBytes,
-				Class:      ELFCLASS64,
-				Data:       ELFDATA2LSB,
-				Version:    1,
-				OSABI:      0x03, // Linux
-				ABIVersion: 0,
-				Padding:    [7]byte{},
-			},
-			Type:                     ET_EXEC,
-			Machine:                  0x3e, // AMD x86-64
-			Version:                  1,
-			Entry:                    uint64(entryPoint),
-			ProgramHeaderOffset:      uint64(unsafe.Sizeof(Header64{})),
-			SectionHeaderOffset:      0,
-			Flags:                    0,
-			EhSize:                   uint16(unsafe.Sizeof(Header64{})),
-			ProgramHeaderSize:        uint16(unsafe.Sizeof(ProgramHeader64{})),
-			ProgramHeaderCount:       1,
-			SectionHeaderSize:        0,
-			SectionHeaderCount:       0,
-			SectionHeaderStringIndex: 0,
-		},
-		ProgramHeaders: programHeaders,
-		SectionHeaders: []SectionHeader64{},
-	}
-
-	byteOrder := binary.LittleEndian
-
 	buf := &bytes.Buffer{}
-	err := binary.Write(buf, byteOrder, f.Header)
+	_, err := w.WriteTo(buf)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	for _, programHeader := range f.ProgramHeaders {
-		err = binary.Write(buf, byteOrder, programHeader)
-		if err != nil {
-			panic(err)
+// programHeaderFlags returns the names of the set flags, e.g. PF_R|PF_W.
+func programHeaderFlags(flags ProgramHeaderFlag) string {
+	names := []string{}
+	for _, flag := range []ProgramHeaderFlag{PF_R, PF_W, PF_X} {
+		if flags&flag != 0 {
+			names = append(names, flag.String())
+			flags &^= flag
 		}
 	}
-
-	for i := 0; i < int(padding); i++ {
-		buf.WriteByte(0)
+	if flags != 0 || len(names) == 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(flags)))
 	}
+	return strings.Join(names, "|")
+}
 
-	_, err = buf.Write(code)
-	if err != nil {
-		panic(err)
-	}
+// headerFlag is the name of a value in the processor specific flags of the
+// ELF header. Flags without a mask are single bits.
+type headerFlag struct {
+	name  string
+	mask  uint32
+	value uint32
+}
+
+// headerFlags lists the known e_flags of each machine. Enumerated fields like
+// the float ABI come before the single bits.
+var headerFlags = map[Machine][]headerFlag{
+	EM_ARM: {
+		{"EF_ARM_EABI_UNKNOWN", EF_ARM_EABIMASK, EF_ARM_EABI_UNKNOWN},
+		{"EF_ARM_EABI_VER1", EF_ARM_EABIMASK, EF_ARM_EABI_VER1},
+		{"EF_ARM_EABI_VER2", EF_ARM_EABIMASK, EF_ARM_EABI_VER2},
+		{"EF_ARM_EABI_VER3", EF_ARM_EABIMASK, EF_ARM_EABI_VER3},
+		{"EF_ARM_EABI_VER4", EF_ARM_EABIMASK, EF_ARM_EABI_VER4},
+		{"EF_ARM_EABI_VER5", EF_ARM_EABIMASK, EF_ARM_EABI_VER5},
+		{"EF_ARM_BE8", 0, EF_ARM_BE8},
+		{"EF_ARM_ABI_FLOAT_HARD", 0, EF_ARM_ABI_FLOAT_HARD},
+		{"EF_ARM_ABI_FLOAT_SOFT", 0, EF_ARM_ABI_FLOAT_SOFT},
+	},
+	EM_RISCV: {
+		{"EF_RISCV_FLOAT_ABI_SOFT", EF_RISCV_FLOAT_ABI, EF_RISCV_FLOAT_ABI_SOFT},
+		{"EF_RISCV_FLOAT_ABI_SINGLE", EF_RISCV_FLOAT_ABI, EF_RISCV_FLOAT_ABI_SINGLE},
+		{"EF_RISCV_FLOAT_ABI_DOUBLE", EF_RISCV_FLOAT_ABI, EF_RISCV_FLOAT_ABI_DOUBLE},
+		{"EF_RISCV_FLOAT_ABI_QUAD", EF_RISCV_FLOAT_ABI, EF_RISCV_FLOAT_ABI_QUAD},
+		{"EF_RISCV_RVC", 0, EF_RISCV_RVC},
+		{"EF_RISCV_RVE", 0, EF_RISCV_RVE},
+		{"EF_RISCV_TSO", 0, EF_RISCV_TSO},
+	},
+	EM_MIPS: {
+		{"EF_MIPS_ARCH_1", EF_MIPS_ARCH, EF_MIPS_ARCH_1},
+		{"EF_MIPS_ARCH_2", EF_MIPS_ARCH, EF_MIPS_ARCH_2},
+		{"EF_MIPS_ARCH_3", EF_MIPS_ARCH, EF_MIPS_ARCH_3},
+		{"EF_MIPS_ARCH_4", EF_MIPS_ARCH, EF_MIPS_ARCH_4},
+		{"EF_MIPS_ARCH_5", EF_MIPS_ARCH, EF_MIPS_ARCH_5},
+		{"EF_MIPS_ARCH_32", EF_MIPS_ARCH, EF_MIPS_ARCH_32},
+		{"EF_MIPS_ARCH_64", EF_MIPS_ARCH, EF_MIPS_ARCH_64},
+		{"EF_MIPS_ARCH_32R2", EF_MIPS_ARCH, EF_MIPS_ARCH_32R2},
+		{"EF_MIPS_ARCH_64R2", EF_MIPS_ARCH, EF_MIPS_ARCH_64R2},
+		{"EF_MIPS_ARCH_32R6", EF_MIPS_ARCH, EF_MIPS_ARCH_32R6},
+		{"EF_MIPS_ARCH_64R6", EF_MIPS_ARCH, EF_MIPS_ARCH_64R6},
+		{"EF_MIPS_NOREORDER", 0, EF_MIPS_NOREORDER},
+		{"EF_MIPS_PIC", 0, EF_MIPS_PIC},
+		{"EF_MIPS_CPIC", 0, EF_MIPS_CPIC},
+		{"EF_MIPS_ABI2", 0, EF_MIPS_ABI2},
+		{"EF_MIPS_32BITMODE", 0, EF_MIPS_32BITMODE},
+		{"EF_MIPS_NAN2008", 0, EF_MIPS_NAN2008},
+	},
+}
 
-	return buf.Bytes()
+// headerFlagNames returns the names of the processor specific flags of the
+// ELF header, e.g. EF_RISCV_FLOAT_ABI_DOUBLE|EF_RISCV_RVC. Unknown bits are
+// shown as number.
+func headerFlagNames(machine Machine, flags uint32) string {
+	known, ok := headerFlags[machine]
+	if !ok {
+		return fmt.Sprintf("0x%x", flags)
+	}
+	names := []string{}
+	rest := flags
+	for _, flag := range known {
+		if flag.mask != 0 {
+			if flags&flag.mask == flag.value {
+				names = append(names, flag.name)
+				rest &^= flag.mask
+			}
+		} else if flags&flag.value != 0 {
+			names = append(names, flag.name)
+			rest &^= flag.value
+		}
+	}
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("0x%x", rest))
+	}
+	return strings.Join(names, "|")
 }
 
 func Print(f *Reader) error {
@@ -144,13 +181,14 @@ func Print(f *Reader) error {
 		fmt.Printf("class: %s\n", h.Class)
 		fmt.Printf("data: %s\n", h.Data)
 		fmt.Printf("version: %d\n", h.ELFIdentifier.Version)
-		fmt.Printf("os abi: %d\n", h.OSABI)
+		fmt.Printf("os abi: %s\n", h.OSABI)
 		fmt.Printf("abi version: %d\n", h.ABIVersion)
 
 		fmt.Printf("type: %s\n", h.Type)
-		fmt.Printf("machine: 0x%x\n", h.Machine)
+		fmt.Printf("machine: %s\n", h.Machine)
 		fmt.Printf("version: %d\n", h.Version)
 		fmt.Printf("entry: %x\n", h.Entry)
+		fmt.Printf("flags: %s\n", headerFlagNames(h.Machine, h.Flags))
 
 		fmt.Printf("header size: %x\n", h.EhSize)
 
@@ -167,7 +205,7 @@ func Print(f *Reader) error {
 	}
 	printProgram := func(p ProgramHeader64) {
 		fmt.Printf("type: %s\n", p.Type)
-		fmt.Printf("flags: 0x%x\n", p.Flags)
+		fmt.Printf("flags: %s\n", programHeaderFlags(p.Flags))
 		fmt.Printf("offset: 0x%x\n", p.Offset)
 		fmt.Printf("virtual addr: 0x%x\n", p.VirtualAddress)
 		fmt.Printf("physical addr: 0x%x\n", p.PhysicalAddress)
@@ -225,6 +263,50 @@ func Print(f *Reader) error {
 				fmt.Printf("    binding: %s\n", symbol.SymbolBinding())
 				fmt.Printf("    section header index: %d\n", symbol.SectionHeaderIndex)
 			}
+		case SHT_DYNAMIC:
+			entries, err := f.readDynamic(index)
+			if err != nil {
+				return err
+			}
+			fmt.Println("entries:")
+			for _, entry := range entries {
+				switch entry.Tag {
+				case DT_NEEDED, DT_SONAME, DT_RPATH, DT_RUNPATH:
+					name, err := f.readString(int(s.Link), int(entry.Value))
+					if err != nil {
+						return err
+					}
+					fmt.Printf("  - %s: %s\n", entry.Tag, name)
+				default:
+					fmt.Printf("  - %s: 0x%x\n", entry.Tag, entry.Value)
+				}
+				if entry.Tag == DT_NULL {
+					break
+				}
+			}
+		case SHT_NOTE:
+			notes, err := f.readNotes(index)
+			if err != nil {
+				return err
+			}
+			fmt.Println("notes:")
+			for _, note := range notes {
+				fmt.Printf("  - name: %s\n", note.Name)
+				fmt.Printf("    type: %s\n", note.Type)
+				fmt.Printf("    desc: %x\n", note.Desc)
+			}
+		case SHT_RELA:
+			relocations, err := f.readRelocations(index)
+			if err != nil {
+				return err
+			}
+			fmt.Println("relocations:")
+			for _, relocation := range relocations {
+				fmt.Printf("  - offset: 0x%x\n", relocation.Offset)
+				fmt.Printf("    type: %s\n", relocation.RelocationType())
+				fmt.Printf("    symbol index: %d\n", relocation.SymbolIndex())
+				fmt.Printf("    addend: %d\n", relocation.Addend)
+			}
 		}
 		fmt.Println()
 		return nil
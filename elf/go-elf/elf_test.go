@@ -1,10 +1,13 @@
 package elf
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -17,12 +20,15 @@ func TestWrite(t *testing.T) {
 			0x0f, 0x05, // syscall
 		}
 	)
-	elfBinary := Write(virtualAddress, virtualAddress, code)
+	elfBinary, err := Write(virtualAddress, virtualAddress, code)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	tempDir := t.TempDir()
 	outputPath := filepath.Join(tempDir, "output.elf")
 
-	err := os.WriteFile(outputPath, elfBinary, 0755)
+	err = os.WriteFile(outputPath, elfBinary, 0755)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -41,3 +47,347 @@ func TestWrite(t *testing.T) {
 		t.Fatalf("other error returned: %s", err)
 	}
 }
+
+func TestWriteBuildID(t *testing.T) {
+	var (
+		virtualAddress uint64 = 0x401000
+		code                  = []byte{
+			0x48, 0xc7, 0xc0, 0x3c, 0x00, 0x00, 0x00, // mov $60, %rax
+			0x48, 0xc7, 0xc7, 0x21, 0x00, 0x00, 0x00, // mov $33, %rdi
+			0x0f, 0x05, // syscall
+		}
+	)
+
+	write := func() []byte {
+		t.Helper()
+		elfBinary, err := Write(virtualAddress, virtualAddress, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return elfBinary
+	}
+
+	buildID := func(elfBinary []byte) []byte {
+		t.Helper()
+		elfFile, err := Read(elfBinary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := &Reader{elfFile, elfBinary}
+		index, ok := reader.sectionIndexByName(".note.gnu.build-id")
+		if !ok {
+			t.Fatal("no .note.gnu.build-id section found")
+		}
+		notes, err := reader.readNotes(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) != 1 || notes[0].Name != "GNU" || notes[0].Type != NT_GNU_BUILD_ID {
+			t.Fatalf("unexpected notes: %v", notes)
+		}
+		return notes[0].Desc
+	}
+
+	first := write()
+	second := write()
+	if !bytes.Equal(first, second) {
+		t.Fatal("output is not deterministic")
+	}
+
+	id := buildID(first)
+	zeroed := bytes.Replace(first, id, make([]byte, len(id)), 1)
+	sum := sha1.Sum(zeroed)
+	if !bytes.Equal(id, sum[:]) {
+		t.Fatalf("build ID %x is not the SHA-1 %x of the file", id, sum)
+	}
+
+	code[10] = 0x22 // exit code 34
+	other := buildID(write())
+	if bytes.Equal(id, other) {
+		t.Fatal("different code has the same build ID")
+	}
+}
+
+func TestWriterFeatures(t *testing.T) {
+	var (
+		virtualAddress uint64 = 0x401000
+		code                  = []byte{
+			0x48, 0xc7, 0xc0, 0x3c, 0x00, 0x00, 0x00, // mov $60, %rax
+			0x48, 0xc7, 0xc7, 0x21, 0x00, 0x00, 0x00, // mov $33, %rdi
+			0x0f, 0x05, // syscall
+		}
+	)
+	w := &Writer{
+		VirtualAddress: virtualAddress,
+		EntryPoint:     virtualAddress,
+		Code:           code,
+		Features:       X86_FEATURE_1_IBT | X86_FEATURE_1_SHSTK,
+	}
+	buf := &bytes.Buffer{}
+	_, err := w.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elfBinary := buf.Bytes()
+
+	elfFile, err := Read(elfBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &Reader{elfFile, elfBinary}
+	index, ok := reader.sectionIndexByName(".note.gnu.property")
+	if !ok {
+		t.Fatal("no .note.gnu.property section found")
+	}
+	notes, err := reader.readNotes(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedDesc := []byte{
+		0x02, 0x00, 0x00, 0xc0, // GNU_PROPERTY_X86_FEATURE_1_AND
+		0x04, 0x00, 0x00, 0x00, // data size
+		0x03, 0x00, 0x00, 0x00, // IBT | SHSTK
+		0x00, 0x00, 0x00, 0x00, // padding
+	}
+	if len(notes) != 1 || notes[0].Type != NT_GNU_PROPERTY_TYPE_0 || !bytes.Equal(notes[0].Desc, expectedDesc) {
+		t.Fatalf("unexpected notes: %v", notes)
+	}
+
+	noteSegments := 0
+	for _, programHeader := range elfFile.ProgramHeaders {
+		if programHeader.Type == PT_NOTE {
+			noteSegments++
+		}
+	}
+	if noteSegments != 2 {
+		t.Fatalf("expected a PT_NOTE for the build ID and the property note, got %d", noteSegments)
+	}
+}
+
+func TestWriteGNUStack(t *testing.T) {
+	var (
+		virtualAddress uint64 = 0x401000
+		code                  = []byte{
+			0x48, 0xc7, 0xc0, 0x3c, 0x00, 0x00, 0x00, // mov $60, %rax
+			0x48, 0xc7, 0xc7, 0x21, 0x00, 0x00, 0x00, // mov $33, %rdi
+			0x0f, 0x05, // syscall
+		}
+	)
+
+	static, err := Write(virtualAddress, virtualAddress, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDynamicExecutable(virtualAddress, Dynamic{Needed: []string{"libc.so.6"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamic, err := d.Write(virtualAddress, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSharedObject("libempty.so", nil, nil)
+	sharedObject, err := s.Write(code, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		elfBinary []byte
+		relro     bool
+	}{
+		"static":  {elfBinary: static},
+		"dynamic": {elfBinary: dynamic, relro: true},
+		"shared":  {elfBinary: sharedObject, relro: true},
+	} {
+		elfFile, err := Read(tc.elfBinary)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		types := map[ProgramHeaderType]ProgramHeader64{}
+		for _, programHeader := range elfFile.ProgramHeaders {
+			types[programHeader.Type] = programHeader
+		}
+
+		stack, ok := types[PT_GNU_STACK]
+		if !ok {
+			t.Errorf("%s: no %s program header", name, PT_GNU_STACK)
+		} else if stack.Flags&PF_X != 0 {
+			t.Errorf("%s: stack is executable: %s", name, programHeaderFlags(stack.Flags))
+		}
+
+		_, ok = types[PT_GNU_RELRO]
+		if ok != tc.relro {
+			t.Errorf("%s: expected %s program header: %t", name, PT_GNU_RELRO, tc.relro)
+		}
+	}
+}
+
+func TestWriterValidation(t *testing.T) {
+	code := []byte{
+		0x48, 0xc7, 0xc0, 0x3c, 0x00, 0x00, 0x00, // mov $60, %rax
+		0x48, 0xc7, 0xc7, 0x21, 0x00, 0x00, 0x00, // mov $33, %rdi
+		0x0f, 0x05, // syscall
+	}
+
+	for name, tc := range map[string]struct {
+		writer        Writer
+		expectedError string
+	}{
+		"empty code": {
+			writer:        Writer{VirtualAddress: 0x401000, EntryPoint: 0x401000},
+			expectedError: "no code to write",
+		},
+		"entry point before code": {
+			writer:        Writer{VirtualAddress: 0x401000, EntryPoint: 0x400000, Code: code},
+			expectedError: "entry point 0x400000 is outside of the code at 0x401000-0x401010",
+		},
+		"entry point after code": {
+			writer:        Writer{VirtualAddress: 0x401000, EntryPoint: 0x401010, Code: code},
+			expectedError: "entry point 0x401010 is outside of the code at 0x401000-0x401010",
+		},
+		"misaligned virtual address": {
+			writer:        Writer{VirtualAddress: 0x401010, EntryPoint: 0x401010, Code: code},
+			expectedError: "virtual address 0x401010 is not congruent to the file offset 0x1000 of the code modulo the page size 0x1000",
+		},
+		"virtual address too low": {
+			writer:        Writer{VirtualAddress: 0, EntryPoint: 0, Code: code},
+			expectedError: "virtual address 0x0 is too low, the headers require 0x1000 bytes in front of the code",
+		},
+	} {
+		buf := &bytes.Buffer{}
+		_, err := tc.writer.WriteTo(buf)
+		if err == nil {
+			t.Errorf("%s: expected error %q", name, tc.expectedError)
+			continue
+		}
+		if err.Error() != tc.expectedError {
+			t.Errorf("%s: expected error %q, got %q", name, tc.expectedError, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: %d bytes written despite invalid input", name, buf.Len())
+		}
+	}
+}
+
+func TestDynamicExecutableValidation(t *testing.T) {
+	for name, tc := range map[string]struct {
+		virtualAddress uint64
+		imports        []string
+		expectedError  string
+	}{
+		"misaligned virtual address": {
+			virtualAddress: 0x401010,
+			imports:        []string{"exit"},
+			expectedError:  "virtual address 0x401010 is not congruent to the file offset 0x4000 of the code modulo the page size 0x1000",
+		},
+		"virtual address too low": {
+			virtualAddress: 0x1000,
+			imports:        []string{"exit"},
+			expectedError:  "virtual address 0x1000 is too low, the headers require 0x4000 bytes in front of the code",
+		},
+		"duplicate import": {
+			virtualAddress: 0x401000,
+			imports:        []string{"puts", "exit", "puts"},
+			expectedError:  "function puts is imported more than once",
+		},
+		"empty import": {
+			virtualAddress: 0x401000,
+			imports:        []string{"exit", ""},
+			expectedError:  "imported function without a name",
+		},
+	} {
+		_, err := NewDynamicExecutable(tc.virtualAddress, Dynamic{Needed: []string{"libc.so.6"}, Imports: tc.imports})
+		if err == nil {
+			t.Errorf("%s: expected error %q", name, tc.expectedError)
+			continue
+		}
+		if err.Error() != tc.expectedError {
+			t.Errorf("%s: expected error %q, got %q", name, tc.expectedError, err)
+		}
+	}
+}
+
+func TestSharedObjectValidation(t *testing.T) {
+	code := []byte{0xc3} // ret
+	functions := []Function{{Name: "f", Size: 1}}
+	for name, tc := range map[string]struct {
+		exports       []string
+		expectedError string
+	}{
+		"undefined export": {
+			exports:       []string{"g"},
+			expectedError: "exported function g is not defined",
+		},
+		"duplicate export": {
+			exports:       []string{"f", "f"},
+			expectedError: "function f is exported more than once",
+		},
+	} {
+		s := NewSharedObject("libf.so", nil, tc.exports)
+		_, err := s.Write(code, functions)
+		if err == nil {
+			t.Errorf("%s: expected error %q", name, tc.expectedError)
+			continue
+		}
+		if err.Error() != tc.expectedError {
+			t.Errorf("%s: expected error %q, got %q", name, tc.expectedError, err)
+		}
+	}
+}
+
+type failingWriter struct {
+	limit int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.limit {
+		n := fw.limit
+		fw.limit = 0
+		return n, errors.New("disk full")
+	}
+	fw.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriterWriteError(t *testing.T) {
+	w := &Writer{
+		VirtualAddress: 0x401000,
+		EntryPoint:     0x401000,
+		Code:           []byte{0x0f, 0x05},
+	}
+	n, err := w.WriteTo(&failingWriter{limit: 100})
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected write error, got %v", err)
+	}
+	if n != 100 {
+		t.Fatalf("expected 100 bytes written, got %d", n)
+	}
+}
+
+func TestHeaderFlagNames(t *testing.T) {
+	tests := []struct {
+		machine  Machine
+		flags    uint32
+		expected string
+	}{
+		{EM_X86_64, 0, "0x0"},
+		{EM_RISCV, EF_RISCV_RVC | EF_RISCV_FLOAT_ABI_DOUBLE, "EF_RISCV_FLOAT_ABI_DOUBLE|EF_RISCV_RVC"},
+		{EM_RISCV, 0, "EF_RISCV_FLOAT_ABI_SOFT"},
+		{EM_ARM, EF_ARM_EABI_VER5 | EF_ARM_ABI_FLOAT_HARD, "EF_ARM_EABI_VER5|EF_ARM_ABI_FLOAT_HARD"},
+		{EM_MIPS, EF_MIPS_ARCH_32R2 | EF_MIPS_PIC | EF_MIPS_CPIC | EF_MIPS_NOREORDER, "EF_MIPS_ARCH_32R2|EF_MIPS_NOREORDER|EF_MIPS_PIC|EF_MIPS_CPIC"},
+		{EM_MIPS, EF_MIPS_ARCH_64 | 0x1000, "EF_MIPS_ARCH_64|0x1000"},
+	}
+	for _, test := range tests {
+		names := headerFlagNames(test.machine, test.flags)
+		if names != test.expected {
+			t.Errorf("%s 0x%x: expected %s got %s", test.machine, test.flags, test.expected, names)
+		}
+	}
+
+	if EM_AARCH64.String() != "EM_AARCH64" || ELFOSABI_LINUX.String() != "ELFOSABI_LINUX" {
+		t.Fatalf("unexpected names %s, %s", EM_AARCH64, ELFOSABI_LINUX)
+	}
+}
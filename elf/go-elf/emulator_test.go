@@ -0,0 +1,215 @@
+package elf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// emulator executes the small subset of instructions generated by the
+// Compiler for instruction sets which can't be run natively. It loads the
+// PT_LOAD segments of an executable, runs it from the entry point and
+// records what gets written to stdout and the exit code.
+type emulator struct {
+	segments []emulatorSegment
+	pc       uint64
+	x        [32]uint64
+	stdout   bytes.Buffer
+	exited   bool
+	exitCode int
+}
+
+type emulatorSegment struct {
+	address uint64
+	data    []byte
+}
+
+// emulatorSteps decodes and executes one instruction for each supported
+// machine.
+var emulatorSteps = map[Machine]func(e *emulator) error{
+	EM_AARCH64: (*emulator).stepAArch64,
+	EM_RISCV:   (*emulator).stepRISCV,
+}
+
+const emulatorMaxSteps = 10000
+
+// emulate runs the executable and returns its output and exit code.
+func emulate(elfBinary []byte) (stdout []byte, exitCode int, err error) {
+	f, err := Read(elfBinary)
+	if err != nil {
+		return nil, 0, err
+	}
+	step, ok := emulatorSteps[f.Header.Machine]
+	if !ok {
+		return nil, 0, fmt.Errorf("no emulator for machine %s", f.Header.Machine)
+	}
+
+	e := &emulator{pc: f.Header.Entry}
+	for _, programHeader := range f.ProgramHeaders {
+		if programHeader.Type != PT_LOAD {
+			continue
+		}
+		data := make([]byte, programHeader.MemorySize)
+		copy(data, elfBinary[programHeader.Offset:programHeader.Offset+programHeader.FileSize])
+		e.segments = append(e.segments, emulatorSegment{address: programHeader.VirtualAddress, data: data})
+	}
+
+	for i := 0; i < emulatorMaxSteps && !e.exited; i++ {
+		err = step(e)
+		if err != nil {
+			return e.stdout.Bytes(), 0, fmt.Errorf("pc 0x%x: %w", e.pc, err)
+		}
+	}
+	if !e.exited {
+		return e.stdout.Bytes(), 0, fmt.Errorf("program did not exit after %d instructions", emulatorMaxSteps)
+	}
+	return e.stdout.Bytes(), e.exitCode, nil
+}
+
+func (e *emulator) memory(addr uint64, size uint64) ([]byte, error) {
+	for _, s := range e.segments {
+		if addr >= s.address && addr+size <= s.address+uint64(len(s.data)) {
+			return s.data[addr-s.address : addr-s.address+size], nil
+		}
+	}
+	return nil, fmt.Errorf("segmentation fault accessing 0x%x", addr)
+}
+
+func (e *emulator) load32(addr uint64) (uint32, error) {
+	mem, err := e.memory(addr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(mem), nil
+}
+
+func (e *emulator) store32(addr uint64, value uint32) error {
+	mem, err := e.memory(addr, 4)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(mem, value)
+	return nil
+}
+
+// syscall executes the Linux system calls write and exit. The numbers differ
+// between the instruction sets.
+func (e *emulator) syscall(number, write, exit uint64, args ...uint64) error {
+	switch number {
+	case write:
+		if args[0] != 1 {
+			return fmt.Errorf("write to unsupported file descriptor %d", args[0])
+		}
+		buf, err := e.memory(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		e.stdout.Write(buf)
+		return nil
+	case exit:
+		e.exited = true
+		e.exitCode = int(args[0] & 0xff)
+		return nil
+	default:
+		return fmt.Errorf("unsupported system call %d", number)
+	}
+}
+
+// stepAArch64 executes an A64 instruction. Register 31 is treated as the
+// zero register.
+func (e *emulator) stepAArch64() error {
+	instruction, err := e.load32(e.pc)
+	if err != nil {
+		return err
+	}
+	rd := instruction & 0x1f
+	rn := (instruction >> 5) & 0x1f
+	set := func(r uint32, value uint64) {
+		if r != 31 {
+			e.x[r] = value
+		}
+	}
+
+	switch {
+	case instruction&0xff800000 == 0xd2800000: // movz xd, #imm16
+		set(rd, uint64((instruction>>5)&0xffff))
+	case instruction&0x9f000000 == 0x10000000: // adr xd, label
+		imm := (instruction>>5)&0x7ffff<<2 | (instruction>>29)&0x3
+		offset := int64(int32(imm<<11) >> 11) // sign extend 21 bits
+		set(rd, e.pc+uint64(offset))
+	case instruction&0xffc00000 == 0xb9400000: // ldr wt, [xn, #imm]
+		value, err := e.load32(e.x[rn] + uint64((instruction>>10)&0xfff)*4)
+		if err != nil {
+			return err
+		}
+		set(rd, uint64(value))
+	case instruction&0xffc00000 == 0xb9000000: // str wt, [xn, #imm]
+		err := e.store32(e.x[rn]+uint64((instruction>>10)&0xfff)*4, uint32(e.x[rd]))
+		if err != nil {
+			return err
+		}
+	case instruction&0xffc00000 == 0x11000000: // add wd, wn, #imm12
+		set(rd, uint64(uint32(e.x[rn])+(instruction>>10)&0xfff))
+	case instruction == 0xd4000001: // svc #0
+		err := e.syscall(e.x[8], aarch64SysWrite, aarch64SysExit, e.x[0], e.x[1], e.x[2])
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported instruction 0x%08x", instruction)
+	}
+	e.pc += 4
+	return nil
+}
+
+// stepRISCV executes a RV64I instruction. Register 0 is hardwired to zero.
+func (e *emulator) stepRISCV() error {
+	instruction, err := e.load32(e.pc)
+	if err != nil {
+		return err
+	}
+	opcode := instruction & 0x7f
+	rd := (instruction >> 7) & 0x1f
+	funct3 := (instruction >> 12) & 0x7
+	rs1 := (instruction >> 15) & 0x1f
+	rs2 := (instruction >> 20) & 0x1f
+	immI := uint64(int64(int32(instruction) >> 20))
+	immS := uint64(int64(int32(instruction)>>25<<5 | int32(rd)))
+	immU := uint64(int64(int32(instruction & 0xfffff000)))
+	set := func(r uint32, value uint64) {
+		if r != 0 {
+			e.x[r] = value
+		}
+	}
+
+	switch {
+	case opcode == riscvOpLUI:
+		set(rd, immU)
+	case opcode == riscvOpAUIPC:
+		set(rd, e.pc+immU)
+	case opcode == riscvOpImm && funct3 == 0: // addi
+		set(rd, e.x[rs1]+immI)
+	case opcode == riscvOpImm32 && funct3 == 0: // addiw
+		set(rd, uint64(int64(int32(e.x[rs1]+immI))))
+	case opcode == riscvOpLoad && funct3 == 2: // lw
+		value, err := e.load32(e.x[rs1] + immI)
+		if err != nil {
+			return err
+		}
+		set(rd, uint64(int64(int32(value))))
+	case opcode == riscvOpStore && funct3 == 2: // sw
+		err := e.store32(e.x[rs1]+immS, uint32(e.x[rs2]))
+		if err != nil {
+			return err
+		}
+	case instruction == 0x00000073: // ecall
+		err := e.syscall(e.x[riscvA7], riscvSysWrite, riscvSysExit, e.x[riscvA0], e.x[riscvA1], e.x[riscvA2])
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported instruction 0x%08x", instruction)
+	}
+	e.pc += 4
+	return nil
+}
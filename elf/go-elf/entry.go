@@ -0,0 +1,108 @@
+package elf
+
+import "go-elf/x86"
+
+// Types of the entries of the auxiliary vector, see getauxval(3).
+const (
+	AT_NULL   = 0  // end of the vector
+	AT_PHDR   = 3  // address of the program headers
+	AT_PHENT  = 4  // size of a program header
+	AT_PHNUM  = 5  // number of program headers
+	AT_PAGESZ = 6  // page size
+	AT_ENTRY  = 9  // entry point
+	AT_UID    = 11 // real user ID
+	AT_RANDOM = 25 // address of 16 random bytes
+	AT_EXECFN = 31 // path name of the executable
+)
+
+// processStart are the labels of the variables in which the entry
+// prologue stores the arguments, the environment and the auxiliary vector
+// of the process. The kernel passes them on the initial stack:
+//
+//	rsp        argc
+//	rsp + 8    argv[0], ..., argv[argc-1], NULL
+//	           envp[0], ..., NULL
+//	           auxv: pairs of type and value, ending with AT_NULL
+//	           strings of the arguments and the environment
+//
+// The variables are 64 bit values which generated code can load with
+// emitLabelRIP.
+type processStart struct {
+	argc string // number of arguments
+	argv string // address of the argument pointers
+	envp string // address of the environment pointers
+	auxv string // address of the auxiliary vector
+}
+
+// processStartLabels are the labels of the variables of the entry
+// prologue.
+var processStartLabels = processStart{
+	argc: "runtime.argc",
+	argv: "runtime.argv",
+	envp: "runtime.envp",
+	auxv: "runtime.auxv",
+}
+
+// addProcessStart adds the variables of the entry prologue to the data in
+// front of the code.
+func (c *Compiler) addProcessStart() processStart {
+	p := processStartLabels
+	for _, name := range []string{p.argc, p.argv, p.envp, p.auxv} {
+		c.label(name)
+		c.addInt64(0)
+	}
+	return p
+}
+
+// reserveProcessStart adds the variables of the entry prologue to .bss.
+func (c *Compiler) reserveProcessStart() processStart {
+	p := processStartLabels
+	bss := c.dataBuilder().BSS
+	bss.Align(8)
+	for _, name := range []string{p.argc, p.argv, p.envp, p.auxv} {
+		bss.Label(name)
+		bss.Reserve(8)
+	}
+	return p
+}
+
+// emitEntryPrologue stores the values of the initial stack in the variables
+// of p. It has to be the first code at the entry point, as it reads rsp.
+// Only rax and rcx are overwritten, rsp is unchanged.
+func (c *Compiler) emitEntryPrologue(p processStart) {
+	c.emit(x86.MOV, x86.RAX, x86.Mem{Base: x86.RSP}) // rax = argc
+	c.emitLabelRIP(x86.MOV, p.argc, x86.Mem{RIP: true}, x86.RAX)
+	c.emit(x86.LEA, x86.RCX, x86.Mem{Base: x86.RSP, Disp: 8})
+	c.emitLabelRIP(x86.MOV, p.argv, x86.Mem{RIP: true}, x86.RCX)
+
+	// the environment follows the NULL after the arguments
+	c.emit(x86.LEA, x86.RCX, x86.Mem{Base: x86.RCX, Index: x86.RAX, Scale: 8, Disp: 8})
+	c.emitLabelRIP(x86.MOV, p.envp, x86.Mem{RIP: true}, x86.RCX)
+
+	// the auxiliary vector follows the NULL after the environment
+	loop := c.newLabel()
+	c.label(loop)
+	c.emit(x86.MOV, x86.RAX, x86.Mem{Base: x86.RCX})
+	c.emit(x86.ADD, x86.RCX, x86.Imm(8))
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JNE, loop)
+	c.emitLabelRIP(x86.MOV, p.auxv, x86.Mem{RIP: true}, x86.RCX)
+}
+
+// emitWriteArguments writes each argument of p, including the program
+// name, on its own line. The newline is at newlineAddr. It overwrites rbx.
+func (c *Compiler) emitWriteArguments(p processStart, newlineAddr uint64) {
+	loop := c.newLabel()
+	done := c.newLabel()
+	c.emitLabelRIP(x86.MOV, p.argv, x86.RBX, x86.Mem{RIP: true})
+	c.label(loop)
+	c.emit(x86.MOV, x86.RSI, x86.Mem{Base: x86.RBX})
+	c.emit(x86.TEST, x86.RSI, x86.RSI)
+	c.emitJump(x86.JE, done)
+	c.emitStrlen()
+	c.emitSys(SYS_WRITE, x86.Imm(1), x86.RSI, x86.RAX)
+	c.emitWrite(1, newlineAddr, 1)
+	c.emit(x86.ADD, x86.RBX, x86.Imm(8))
+	c.emitJump(x86.JMP, loop)
+	c.label(done)
+}
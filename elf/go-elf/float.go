@@ -0,0 +1,55 @@
+package elf
+
+import (
+	"fmt"
+	"go-elf/x86"
+	"math"
+)
+
+// floatArgumentRegisters are the registers of the first eight floating
+// point arguments of a call in the System V ABI. They are assigned
+// independently of the integer arguments, e.g. f(double a, long n, double b)
+// gets a in xmm0, n in rdi and b in xmm1. A floating point result is
+// returned in xmm0. All of them are caller saved.
+var floatArgumentRegisters = []x86.Register{x86.XMM0, x86.XMM1, x86.XMM2, x86.XMM3, x86.XMM4, x86.XMM5, x86.XMM6, x86.XMM7}
+
+// isXMM reports whether the operand is an SSE register.
+func isXMM(operand x86.Operand) bool {
+	reg, ok := operand.(x86.Register)
+	return ok && reg.IsXMM()
+}
+
+// floatParam returns the register of the floating point parameter i, which
+// counts the floating point parameters only.
+func (f *frame) floatParam(i int) x86.Register {
+	if i >= len(floatArgumentRegisters) {
+		panic(fmt.Sprintf("floating point parameter %d is not passed in a register", i))
+	}
+	return floatArgumentRegisters[i]
+}
+
+// floatConstant returns the label of the double precision constant value.
+// Each value is only stored once, aligned in the section .rodata of the
+// data.
+func (c *Compiler) floatConstant(value float64) string {
+	bits := math.Float64bits(value)
+	if label, ok := c.constants[bits]; ok {
+		return label
+	}
+	if c.constants == nil {
+		c.constants = map[uint64]string{}
+	}
+	label := c.newLabel()
+	c.constants[bits] = label
+	rodata := c.dataBuilder().Rodata
+	rodata.Align(8)
+	rodata.Label(label)
+	rodata.Float64(value)
+	return label
+}
+
+// emitFloat appends op with the SSE register dst and the double precision
+// constant value as source, e.g. movsd to load the value or addsd.
+func (c *Compiler) emitFloat(op x86.Op, dst x86.Register, value float64) {
+	c.emitLabelRIP(op, c.floatConstant(value), dst, x86.Mem{RIP: true})
+}
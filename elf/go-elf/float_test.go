@@ -0,0 +1,121 @@
+package elf
+
+import (
+	"encoding/binary"
+	"go-elf/x86"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFloat runs a program which passes double precision values to
+// functions in SSE registers, converts between integers and doubles and
+// compares them. The constants are in the section .rodata.
+func TestFloat(t *testing.T) {
+	c := &Compiler{
+		startAddr: 0x401000,
+		buf:       make([]byte, 0),
+	}
+	newline := c.addString("\n")
+	entryPoint := c.startAddr + uint64(len(c.buf))
+
+	// writes the integer part of 10000 * xmm0
+	printFloat := func() {
+		c.emitFloat(x86.MULSD, x86.XMM0, 10000)
+		c.emit(x86.CVTTSD2SI, x86.RAX, x86.XMM0)
+		c.emitJump(x86.CALL, printIntLabel)
+		c.emitWrite(1, newline, 1)
+	}
+	small := c.newLabel()
+
+	// xmm8 = mix(1.5, 3, 2.25) = 1.6875, which is kept in xmm8 as the
+	// functions don't use it
+	c.emitFloat(x86.MOVSD, x86.XMM8, 1.5)
+	c.emitFloat(x86.MOVSD, x86.XMM9, 2.25)
+	c.emitCallFunction("mix", x86.XMM8, x86.Imm(3), x86.XMM9)
+	c.emitMove(x86.XMM8, x86.XMM0)
+	printFloat()
+
+	// the arguments are swapped through xmm15: sub(2, 1.6875) = 0.3125
+	c.emitMove(x86.XMM0, x86.XMM8)
+	c.emitFloat(x86.MOVSD, x86.XMM1, 2)
+	c.emitCallFunction("sub", x86.XMM1, x86.XMM0)
+	printFloat()
+
+	// exit code = 100 * xmm8 if xmm8 > 1.5 else 1
+	c.emitFloat(x86.COMISD, x86.XMM8, 1.5)
+	c.emitJump(x86.JBE, small)
+	c.emitFloat(x86.MULSD, x86.XMM8, 100)
+	c.emit(x86.CVTTSD2SI, x86.EDI, x86.XMM8)
+	c.emitSys(SYS_EXIT, x86.RDI)
+	c.label(small)
+	c.emitSys(SYS_EXIT, x86.Imm(1))
+
+	// double mix(double a, long n, double b): returns (a*n + b) / (n+1),
+	// the result is stored in a stack slot and loaded again
+	f := c.beginFrame("mix", 1)
+	c.emit(x86.CVTSI2SD, x86.XMM2, f.param(0))
+	c.emit(x86.MULSD, f.floatParam(0), x86.XMM2)
+	c.emit(x86.ADDSD, f.floatParam(0), f.floatParam(1))
+	c.emit(x86.LEA, x86.RAX, x86.Mem{Base: f.param(0), Disp: 1})
+	c.emit(x86.CVTSI2SD, x86.XMM2, x86.RAX)
+	c.emit(x86.DIVSD, x86.XMM0, x86.XMM2)
+	c.emitMove(f.local(0), x86.XMM0)
+	c.emitMove(x86.XMM0, f.local(0))
+	c.emitReturn(f)
+	c.endFrame()
+
+	// double sub(double a, double b): returns a - b
+	f = c.beginFrame("sub", 0)
+	c.emit(x86.SUBSD, f.floatParam(0), f.floatParam(1))
+	c.emitReturn(f)
+	c.endFrame()
+
+	c.emitPrintInt()
+
+	outputPath := filepath.Join(t.TempDir(), "float.elf")
+	writeProgram(t, c, entryPoint, outputPath)
+
+	output, exitCode := execute(t, outputPath, t.TempDir())
+	if exitCode != 168 {
+		t.Errorf("expected exit code 168 got %d", exitCode)
+	}
+	expected := "16875\n3125\n"
+	if string(output) != expected {
+		t.Errorf("expected output %q got %q", expected, output)
+	}
+
+	// each constant is stored once and aligned
+	elfBinary, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Read(elfBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reader{File: file, Data: elfBinary}
+	index, ok := r.sectionIndexByName(".rodata")
+	if !ok {
+		t.Fatal("section .rodata not found")
+	}
+	rodata := r.SectionHeaders[index]
+	if rodata.Address%8 != 0 || rodata.AddressAlign != 8 {
+		t.Errorf("expected .rodata aligned to 8 bytes at 0x%x with alignment %d", rodata.Address, rodata.AddressAlign)
+	}
+	constants := []float64{}
+	for offset := rodata.Offset; offset < rodata.Offset+rodata.Size; offset += 8 {
+		constants = append(constants, math.Float64frombits(binary.LittleEndian.Uint64(elfBinary[offset:])))
+	}
+	expectedConstants := []float64{1.5, 2.25, 10000, 2, 100}
+	if len(constants) != len(expectedConstants) {
+		t.Fatalf("expected constants %v got %v", expectedConstants, constants)
+	}
+	for i := range constants {
+		if constants[i] != expectedConstants[i] {
+			t.Errorf("expected constants %v got %v", expectedConstants, constants)
+			break
+		}
+	}
+}
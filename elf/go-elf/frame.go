@@ -0,0 +1,239 @@
+package elf
+
+import (
+	"fmt"
+	"go-elf/x86"
+)
+
+// argumentRegisters are the registers of the first six integer arguments of
+// a call in the System V ABI.
+var argumentRegisters = []x86.Register{x86.RDI, x86.RSI, x86.RDX, x86.RCX, x86.R8, x86.R9}
+
+// calleeSaved reports whether a function has to preserve the register in the
+// System V ABI.
+func calleeSaved(reg int) bool {
+	switch reg {
+	case 3, 5, 12, 13, 14, 15: // rbx, rbp, r12-r15
+		return true
+	}
+	return false
+}
+
+// frame is the stack frame of a function which follows the System V AMD64
+// calling convention. The integer parameters are passed in the argument
+// registers and the result is returned in rax. The frame looks like this:
+//
+//	rbp + 8        return address
+//	rbp            rbp of the caller
+//	rbp - 8        callee saved registers used by the function
+//	...
+//	rsp            local stack slots of 8 bytes
+//
+// rsp is 16 byte aligned after the prologue, which is required before a
+// call instruction, so the function can call other functions including
+// those of C.
+type frame struct {
+	saved  []x86.Register
+	locals int
+}
+
+// beginFrame starts the function name with a prologue which saves rbp and
+// the callee saved registers and reserves locals stack slots. The function
+// is defined as label name and added to the functions of the Compiler.
+func (c *Compiler) beginFrame(name string, locals int, saved ...x86.Register) *frame {
+	for _, reg := range saved {
+		if reg.Size() != 64 || !calleeSaved(reg.Num()) || reg == x86.RBP {
+			panic(fmt.Sprintf("%s is not a callee saved register", reg))
+		}
+	}
+	f := &frame{saved: saved, locals: locals}
+
+	c.beginFunction(name)
+	c.label(name)
+	c.emit(x86.PUSH, x86.RBP)
+	c.emit(x86.MOV, x86.RBP, x86.RSP)
+	for _, reg := range saved {
+		c.emit(x86.PUSH, reg)
+	}
+	// the return address and rbp are 16 bytes
+	size := 8 * locals
+	if (8*len(saved)+size)%16 != 0 {
+		size += 8
+	}
+	if size > 0 {
+		c.emit(x86.SUB, x86.RSP, x86.Imm(size))
+	}
+	return f
+}
+
+// endFrame ends the function started by beginFrame.
+func (c *Compiler) endFrame() {
+	c.endFunction()
+}
+
+// param returns the register of parameter i.
+func (f *frame) param(i int) x86.Register {
+	if i >= len(argumentRegisters) {
+		panic(fmt.Sprintf("parameter %d is not passed in a register", i))
+	}
+	return argumentRegisters[i]
+}
+
+// local returns the stack slot i.
+func (f *frame) local(i int) x86.Mem {
+	if i < 0 || i >= f.locals {
+		panic(fmt.Sprintf("stack slot %d out of range", i))
+	}
+	return x86.Mem{Base: x86.RBP, Disp: int32(-8*len(f.saved) - 8*(i+1)), Size: 64}
+}
+
+// emitReturn appends the epilogue, which restores the callee saved registers
+// and the stack of the caller, and returns the value in rax. A function can
+// return at several places.
+func (c *Compiler) emitReturn(f *frame) {
+	if len(f.saved) == 0 {
+		c.emit(x86.LEAVE)
+		c.emitRet()
+		return
+	}
+	c.emit(x86.LEA, x86.RSP, x86.Mem{Base: x86.RBP, Disp: int32(-8 * len(f.saved))})
+	for i := len(f.saved) - 1; i >= 0; i-- {
+		c.emit(x86.POP, f.saved[i])
+	}
+	c.emit(x86.POP, x86.RBP)
+	c.emitRet()
+}
+
+// floatArg marks an argument of emitCallFunction and emitCallIndirect as
+// double precision value, e.g. one held in a stack slot. Arguments in SSE
+// registers are floating point arguments without it, all other arguments
+// are integers.
+type floatArg struct {
+	x86.Operand
+}
+
+// emitCallFunction calls the function at label with the arguments, which
+// are moved to the argument registers. Arguments in SSE registers and those
+// marked by floatArg are floating point arguments, see
+// floatArgumentRegisters. al is not set to their number, so variadic
+// functions can't get floating point arguments. The result is in rax or
+// xmm0. The caller saved registers are overwritten.
+func (c *Compiler) emitCallFunction(label string, args ...x86.Operand) {
+	c.emitArguments(args)
+	c.emitJump(x86.CALL, label)
+}
+
+// emitCallIndirect calls the function whose address is in target, e.g. a
+// function pointer of C, like emitCallFunction. The arguments must not be
+// in rax, which holds the address during the call.
+func (c *Compiler) emitCallIndirect(target x86.Operand, args ...x86.Operand) {
+	// the argument registers may be overwritten by the arguments
+	c.emitMove(x86.RAX, target)
+	c.emitArguments(args)
+	c.emit(x86.CALL, x86.RAX)
+}
+
+func (c *Compiler) emitArguments(args []x86.Operand) {
+	moves, floatMoves := []move{}, []move{}
+	for _, arg := range args {
+		float := isXMM(arg)
+		if f, ok := arg.(floatArg); ok {
+			arg, float = f.Operand, true
+		}
+		if float {
+			if len(floatMoves) == len(floatArgumentRegisters) {
+				panic(fmt.Sprintf("more than %d floating point arguments", len(floatArgumentRegisters)))
+			}
+			floatMoves = append(floatMoves, move{dst: floatArgumentRegisters[len(floatMoves)], src: arg})
+			continue
+		}
+		if len(moves) == len(argumentRegisters) {
+			panic(fmt.Sprintf("more than %d integer arguments", len(argumentRegisters)))
+		}
+		moves = append(moves, move{dst: argumentRegisters[len(moves)], src: arg})
+	}
+	c.emitParallelMove(moves)
+	c.emitParallelMove(floatMoves)
+}
+
+// emitMove copies src to dst, through rax if both are in memory. A move
+// from or to an SSE register copies the double precision value, also from
+// or to a general purpose register.
+func (c *Compiler) emitMove(dst x86.Operand, src x86.Operand) {
+	if dst == src {
+		return
+	}
+	if isXMM(dst) || isXMM(src) {
+		_, dstReg := dst.(x86.Register)
+		_, srcReg := src.(x86.Register)
+		if dstReg && srcReg && isXMM(dst) != isXMM(src) {
+			c.emit(x86.MOVQ, dst, src)
+			return
+		}
+		c.emit(x86.MOVSD, dst, src)
+		return
+	}
+	_, dstMem := dst.(x86.Mem)
+	_, srcMem := src.(x86.Mem)
+	imm, srcImm := src.(x86.Imm)
+	if dstMem && (srcMem || srcImm && !fitsInt32(int64(imm))) {
+		c.emit(x86.MOV, x86.RAX, src)
+		src = x86.RAX
+	}
+	c.emit(x86.MOV, dst, src)
+}
+
+// fitsInt32 reports whether the value can be encoded as sign extended 32 bit
+// immediate.
+func fitsInt32(value int64) bool {
+	return value >= -1<<31 && value < 1<<31
+}
+
+// move is a move of a parallel move.
+type move struct {
+	dst, src x86.Operand
+}
+
+// emitParallelMove emits the moves as if all sources were read before the
+// destinations are written. A move is emitted once no other move reads its
+// destination. If the remaining moves form a cycle, the destination of one
+// of them is saved in r11 first, or in xmm15 if it is an SSE register.
+func (c *Compiler) emitParallelMove(moves []move) {
+	pending := []move{}
+	for _, m := range moves {
+		if m.dst != m.src {
+			pending = append(pending, m)
+		}
+	}
+	for len(pending) > 0 {
+		done := false
+		for i, m := range pending {
+			read := false
+			for j, other := range pending {
+				if j != i && other.src == m.dst {
+					read = true
+				}
+			}
+			if !read {
+				c.emitMove(m.dst, m.src)
+				pending = append(pending[:i], pending[i+1:]...)
+				done = true
+				break
+			}
+		}
+		if done {
+			continue
+		}
+		saved := pending[0].dst
+		scratch := x86.R11
+		if isXMM(saved) {
+			scratch = x86.XMM15
+		}
+		c.emitMove(scratch, saved)
+		for i := range pending {
+			if pending[i].src == saved {
+				pending[i].src = scratch
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package elf
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// execute runs the executable in dir and returns its output and exit code.
+// The program name in argv[0] is always "program", so it does not depend on
+// the path.
+func execute(t *testing.T, path string, dir string, args ...string) (stdout []byte, exitCode int) {
+	t.Helper()
+	cmd := exec.Command(path, args...)
+	cmd.Args[0] = "program"
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	exitErr := &exec.ExitError{}
+	if errors.As(err, &exitErr) {
+		return out, exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+	return out, 0
+}
+
+// run executes the binary with the user mode emulator of qemu if it is
+// installed and with the built-in emulator otherwise.
+func run(t *testing.T, machine Machine, elfBinary []byte) (stdout []byte, exitCode int) {
+	t.Helper()
+	qemu := map[Machine]string{
+		EM_AARCH64: "qemu-aarch64",
+		EM_RISCV:   "qemu-riscv64",
+	}[machine]
+	qemuPath, err := exec.LookPath(qemu)
+	if err != nil {
+		stdout, exitCode, err := emulate(elfBinary)
+		if err != nil {
+			t.Fatalf("emulator: %s", err)
+		}
+		return stdout, exitCode
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "output.elf")
+	writeExecutable(t, elfBinary, outputPath)
+	out, err := exec.Command(qemuPath, outputPath).Output()
+	exitErr := &exec.ExitError{}
+	if errors.As(err, &exitErr) {
+		return out, exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("%s: %s", qemu, err)
+	}
+	return out, 0
+}
+
+// writeExecutable writes the ELF file to outputPath with the permissions of
+// an executable.
+func writeExecutable(t *testing.T, elfBinary []byte, outputPath string) {
+	t.Helper()
+	err := os.WriteFile(outputPath, elfBinary, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeProgram resolves the code of c and writes it as executable with the
+// entry point to outputPath. The code sections, functions and data of c are
+// written with it.
+func writeProgram(t *testing.T, c *Compiler, entryPoint uint64, outputPath string) {
+	t.Helper()
+	err := c.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Writer{
+		VirtualAddress: c.startAddr,
+		EntryPoint:     entryPoint,
+		Code:           c.buf,
+		Sections:       c.sections,
+		Symbols:        c.functions,
+		Data:           c.data,
+	}
+	buf := &bytes.Buffer{}
+	_, err = w.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeExecutable(t, buf.Bytes(), outputPath)
+}
+
+// compileSourceFile compiles the program with CompileSource and writes the
+// executable to outputPath.
+func compileSourceFile(t *testing.T, source string, outputPath string) {
+	t.Helper()
+	var virtualAddress uint64 = 0x401000
+	entryPoint, code, err := CompileSource(virtualAddress, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elfBinary, err := Write(virtualAddress, entryPoint, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeExecutable(t, elfBinary, outputPath)
+}
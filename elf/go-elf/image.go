@@ -0,0 +1,483 @@
+package elf
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+const (
+	symbolSize = uint64(unsafe.Sizeof(Symbol64{}))
+	relaSize   = uint64(unsafe.Sizeof(Rela64{}))
+	dynSize    = uint64(unsafe.Sizeof(Dyn64{}))
+)
+
+// image is an ELF file under construction which consists of sections. The
+// sections with SHF_ALLOC are placed directly after the ELF header and the
+// program headers, so that PT_LOADs starting at offset 0 can map all of
+// them, see loadProgramHeaders. All other sections (e.g. .shstrtab) and the
+// section header table follow at the end of the file.
+type image struct {
+	header         Header64
+	programHeaders []ProgramHeader64
+	sections       []*section
+}
+
+type section struct {
+	name   string
+	header SectionHeader64
+	data   []byte
+
+	// alignment of the section start in the file and in memory. It is
+	// usually the same as AddressAlign but can be higher e.g. to start
+	// the code on a new page.
+	alignment uint64
+}
+
+func newImage(fileType FileType, machine Machine) *image {
+	return &image{
+		header: Header64{
+			ELFIdentifier: ELFIdentifier{
+				Magic:      MagicBytes,
+				Class:      ELFCLASS64,
+				Data:       ELFDATA2LSB,
+				Version:    1,
+				OSABI:      ELFOSABI_LINUX,
+				ABIVersion: 0,
+				Padding:    [7]byte{},
+			},
+			Type:              fileType,
+			Machine:           machine,
+			Version:           1,
+			EhSize:            uint16(unsafe.Sizeof(Header64{})),
+			ProgramHeaderSize: uint16(unsafe.Sizeof(ProgramHeader64{})),
+			SectionHeaderSize: uint16(unsafe.Sizeof(SectionHeader64{})),
+		},
+	}
+}
+
+// addSection appends a section. The section header index of the section is
+// its position in img.sections plus one, because of the null section.
+func (img *image) addSection(name string, sectionType SectionHeaderType, flags SectionHeaderFlag, align uint64, data []byte) *section {
+	s := newSection(name, sectionType, flags, align, data)
+	img.sections = append(img.sections, s)
+	return s
+}
+
+// newSection returns a section which is not yet part of an image.
+func newSection(name string, sectionType SectionHeaderType, flags SectionHeaderFlag, align uint64, data []byte) *section {
+	return &section{
+		name: name,
+		header: SectionHeader64{
+			Type:         sectionType,
+			Flags:        flags,
+			Size:         uint64(len(data)),
+			AddressAlign: align,
+		},
+		data:      data,
+		alignment: align,
+	}
+}
+
+func (img *image) sectionIndex(s *section) uint32 {
+	for i, current := range img.sections {
+		if current == s {
+			return uint32(i + 1)
+		}
+	}
+	panic("section " + s.name + " is not part of the image")
+}
+
+// setData replaces the content of a section after the layout has been
+// calculated. The size must not change.
+func (s *section) setData(data []byte) {
+	if uint64(len(data)) != s.header.Size {
+		panic("size of section " + s.name + " changed after layout")
+	}
+	s.data = data
+}
+
+func alignUp(value, align uint64) uint64 {
+	if align <= 1 {
+		return value
+	}
+	return (value + align - 1) / align * align
+}
+
+// layout assigns the file offsets of all sections and of the section header
+// table. The number of program headers has to be known in advance as they
+// are placed in front of the sections.
+func (img *image) layout(programHeaderCount int) {
+	img.header.ProgramHeaderOffset = uint64(img.header.EhSize)
+	img.header.ProgramHeaderCount = uint16(programHeaderCount)
+
+	offset := uint64(img.header.EhSize) + uint64(programHeaderCount)*uint64(img.header.ProgramHeaderSize)
+	place := func(s *section) {
+		offset = alignUp(offset, s.alignment)
+		s.header.Offset = offset
+		if s.header.Type != SHT_NOBITS {
+			offset += s.header.Size
+		}
+	}
+	for _, s := range img.sections {
+		if s.header.Flags&SHF_ALLOC != 0 {
+			place(s)
+		}
+	}
+
+	img.setSectionNames(img.addSection(".shstrtab", SHT_STRTAB, 0, 1, nil))
+
+	for _, s := range img.sections {
+		if s.header.Flags&SHF_ALLOC == 0 {
+			place(s)
+		}
+	}
+
+	img.header.SectionHeaderOffset = alignUp(offset, 8)
+	img.header.SectionHeaderCount = uint16(len(img.sections) + 1)
+}
+
+// setSectionNames writes the names of all sections to shstrtab.
+func (img *image) setSectionNames(shstrtab *section) {
+	names := newStringTable()
+	for _, s := range img.sections {
+		s.header.Name = names.add(s.name)
+	}
+	shstrtab.data = names.bytes()
+	shstrtab.header.Size = uint64(len(shstrtab.data))
+	img.header.SectionHeaderStringIndex = uint16(img.sectionIndex(shstrtab))
+}
+
+// setBase sets the virtual addresses of all allocated sections relative to
+// the address where the beginning of the file gets mapped.
+func (img *image) setBase(base uint64) {
+	for _, s := range img.sections {
+		if s.header.Flags&SHF_ALLOC != 0 {
+			s.header.Address = base + s.header.Offset
+		}
+	}
+}
+
+// setLastData replaces the content of the last allocated section (usually
+// the code) after the layout has been calculated. Only the non allocated
+// sections which follow have to be moved.
+func (img *image) setLastData(last *section, data []byte) {
+	last.data = data
+	last.header.Size = uint64(len(data))
+	img.placeUnallocated(last.header.Offset + last.header.Size)
+}
+
+// placeUnallocated moves the non allocated sections and the section header
+// table behind offset, the end of the allocated sections.
+func (img *image) placeUnallocated(offset uint64) {
+	for _, s := range img.sections {
+		if s.header.Flags&SHF_ALLOC == 0 {
+			s.header.Offset = alignUp(offset, s.alignment)
+			offset = s.header.Offset + s.header.Size
+		}
+	}
+	img.header.SectionHeaderOffset = alignUp(offset, 8)
+}
+
+// addDataAfter adds the used sections of data behind the last allocated
+// section last after the layout has been calculated, e.g. once the code is
+// known. They are placed at the addresses of DataBuilder.layout, so each
+// segment starts on a new page. It returns the added sections.
+func (img *image) addDataAfter(last *section, data *DataBuilder) []*section {
+	// the data of a previous call
+	index := int(img.sectionIndex(last))
+	for index < len(img.sections) && img.sections[index].header.Flags&SHF_ALLOC != 0 {
+		img.sections = append(img.sections[:index], img.sections[index+1:]...)
+	}
+
+	base := last.header.Address - last.header.Offset
+	addresses := data.layout(last.header.Address + last.header.Size)
+	added := []*section{}
+	end := last.header.Offset + last.header.Size
+	for i, s := range data.sections() {
+		if !s.used() {
+			continue
+		}
+		d := newDataSection(data, s)
+		d.header.Address = addresses[i]
+		d.header.Offset = addresses[i] - base
+		if d.header.Type != SHT_NOBITS {
+			end = d.header.Offset + d.header.Size
+		}
+		added = append(added, d)
+	}
+
+	img.sections = append(img.sections[:index], append(added, img.sections[index:]...)...)
+	img.header.SectionHeaderCount = uint16(len(img.sections) + 1)
+	for _, s := range img.sections {
+		if s.name == ".shstrtab" {
+			img.setSectionNames(s)
+		}
+	}
+	img.placeUnallocated(end)
+	return added
+}
+
+// permissions returns the flags of a segment which maps the section.
+func (s *section) permissions() ProgramHeaderFlag {
+	flags := PF_R
+	if s.header.Flags&SHF_WRITE != 0 {
+		flags |= PF_W
+	}
+	if s.header.Flags&SHF_EXECINSTR != 0 {
+		flags |= PF_X
+	}
+	return flags
+}
+
+// segments groups the allocated sections into the sections of a PT_LOAD
+// each. A segment is a run of sections with the same permissions.
+func (img *image) segments() [][]*section {
+	segments := [][]*section{}
+	for _, s := range img.sections {
+		if s.header.Flags&SHF_ALLOC == 0 {
+			continue
+		}
+		if n := len(segments); n > 0 && segments[n-1][0].permissions() == s.permissions() {
+			segments[n-1] = append(segments[n-1], s)
+			continue
+		}
+		segments = append(segments, []*section{s})
+	}
+	return segments
+}
+
+// alignSegments starts each segment on a new page, so the segments can be
+// mapped with their own permissions. The first segment shares its page with
+// the headers. It has to be called before layout.
+func (img *image) alignSegments() {
+	for i, segment := range img.segments() {
+		if i > 0 {
+			segment[0].alignment = max(segment[0].alignment, pageSize)
+		}
+	}
+}
+
+// loadProgramHeaders returns a PT_LOAD for each segment after the layout
+// has been calculated. The first one starts at offset 0, so it also maps
+// the headers.
+func (img *image) loadProgramHeaders() []ProgramHeader64 {
+	programHeaders := []ProgramHeader64{}
+	for i, segment := range img.segments() {
+		first := segment[0]
+		offset, address := first.header.Offset, first.header.Address
+		if i == 0 {
+			offset, address = 0, first.header.Address-first.header.Offset
+		}
+		fileEnd, memoryEnd := offset, offset
+		for _, s := range segment {
+			end := s.header.Offset + s.header.Size
+			if s.header.Type != SHT_NOBITS {
+				fileEnd = end
+			}
+			// .tbss only occupies memory in the TLS block of each thread
+			if s.header.Type != SHT_NOBITS || s.header.Flags&SHF_TLS == 0 {
+				memoryEnd = end
+			}
+		}
+		programHeaders = append(programHeaders, ProgramHeader64{
+			Type:            PT_LOAD,
+			Flags:           first.permissions(),
+			Offset:          offset,
+			VirtualAddress:  address,
+			PhysicalAddress: address,
+			FileSize:        fileEnd - offset,
+			MemorySize:      memoryEnd - offset,
+			Align:           pageSize,
+		})
+	}
+	return programHeaders
+}
+
+// maxDataSegments is the number of PT_LOADs which are reserved for the data
+// added by addDataAfter: .rodata, and .data with .bss.
+const maxDataSegments = 2
+
+// setProgramHeaders sets the program headers to first, a PT_LOAD for each
+// segment and rest. The remaining entries of the number of program headers
+// passed to layout are PT_NULL, which is ignored.
+func (img *image) setProgramHeaders(first []ProgramHeader64, rest []ProgramHeader64) {
+	programHeaders := append(append(append([]ProgramHeader64{}, first...), img.loadProgramHeaders()...), rest...)
+	for len(programHeaders) < int(img.header.ProgramHeaderCount) {
+		programHeaders = append(programHeaders, ProgramHeader64{Type: PT_NULL})
+	}
+	img.programHeaders = programHeaders
+}
+
+// validate checks the program headers before anything gets written.
+func (img *image) validate() error {
+	executable := false
+	for i, programHeader := range img.programHeaders {
+		if programHeader.Type != PT_LOAD {
+			continue
+		}
+		if programHeader.VirtualAddress+programHeader.MemorySize < programHeader.VirtualAddress {
+			return fmt.Errorf("segment %d at virtual address 0x%x with size 0x%x exceeds the address space", i, programHeader.VirtualAddress, programHeader.MemorySize)
+		}
+		if programHeader.Align > 1 && programHeader.VirtualAddress%programHeader.Align != programHeader.Offset%programHeader.Align {
+			return fmt.Errorf("virtual address 0x%x of segment %d is not congruent to its file offset 0x%x modulo the page size 0x%x", programHeader.VirtualAddress, i, programHeader.Offset, programHeader.Align)
+		}
+		if programHeader.Flags&PF_X != 0 &&
+			img.header.Entry >= programHeader.VirtualAddress &&
+			img.header.Entry < programHeader.VirtualAddress+programHeader.MemorySize {
+			executable = true
+		}
+	}
+	if img.header.Type == ET_EXEC && !executable {
+		return fmt.Errorf("entry point 0x%x is outside of the executable segments", img.header.Entry)
+	}
+	return nil
+}
+
+// writeTo validates the image and writes it to w. If the image contains a
+// build ID note, the file is serialized twice. First with the build ID set
+// to zero to calculate the hash and then with the hash as build ID.
+func (img *image) writeTo(w io.Writer) (int64, error) {
+	err := img.validate()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, s := range img.sections {
+		if s.name != ".note.gnu.build-id" {
+			continue
+		}
+		hash := sha1.New()
+		_, err := img.serialize(hash)
+		if err != nil {
+			return 0, err
+		}
+
+		zeroBuildID := s.data
+		s.data = append([]byte{}, zeroBuildID...)
+		// header and padded name "GNU\0"
+		copy(s.data[16:], hash.Sum(nil))
+		defer func() {
+			s.data = zeroBuildID
+		}()
+	}
+
+	return img.serialize(w)
+}
+
+func (img *image) serialize(w io.Writer) (int64, error) {
+	byteOrder := binary.LittleEndian
+	out := &countingWriter{w: w}
+
+	pad := func(offset uint64) error {
+		if out.n > int64(offset) {
+			return fmt.Errorf("invalid layout: offset 0x%x already written", offset)
+		}
+		_, err := out.Write(make([]byte, int64(offset)-out.n))
+		return err
+	}
+
+	err := binary.Write(out, byteOrder, img.header)
+	if err != nil {
+		return out.n, fmt.Errorf("failed to write ELF header: %w", err)
+	}
+
+	for i, programHeader := range img.programHeaders {
+		err = binary.Write(out, byteOrder, programHeader)
+		if err != nil {
+			return out.n, fmt.Errorf("failed to write program header %d: %w", i, err)
+		}
+	}
+
+	for _, s := range img.sections {
+		if s.header.Type == SHT_NOBITS {
+			continue
+		}
+		err = pad(s.header.Offset)
+		if err != nil {
+			return out.n, err
+		}
+		_, err = out.Write(s.data)
+		if err != nil {
+			return out.n, fmt.Errorf("failed to write section %s: %w", s.name, err)
+		}
+	}
+
+	err = pad(img.header.SectionHeaderOffset)
+	if err != nil {
+		return out.n, err
+	}
+	sectionHeaders := NewSectionHeaderTable64()
+	for _, s := range img.sections {
+		sectionHeaders = append(sectionHeaders, s.header)
+	}
+	err = binary.Write(out, byteOrder, sectionHeaders)
+	if err != nil {
+		return out.n, fmt.Errorf("failed to write section headers: %w", err)
+	}
+
+	return out.n, nil
+}
+
+func (img *image) bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	_, err := img.writeTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// stringTable builds the content of a string table section. Index 0 is
+// always the empty string.
+type stringTable struct {
+	data    []byte
+	indexes map[string]uint32
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{
+		data:    []byte{0},
+		indexes: map[string]uint32{"": 0},
+	}
+}
+
+func (st *stringTable) add(s string) uint32 {
+	if index, ok := st.indexes[s]; ok {
+		return index
+	}
+	index := uint32(len(st.data))
+	st.data = append(st.data, []byte(s)...)
+	st.data = append(st.data, 0)
+	st.indexes[s] = index
+	return index
+}
+
+func (st *stringTable) bytes() []byte {
+	return st.data
+}
+
+// encode serializes fixed size ELF structures like symbols or relocations.
+func encode(data any) []byte {
+	buf := &bytes.Buffer{}
+	err := binary.Write(buf, binary.LittleEndian, data)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,558 @@
+// Package ir is an intermediate representation in static single assignment
+// form (SSA) between the front ends and the code generators.
+//
+// A function consists of basic blocks. Each block is a list of values,
+// which are computed from other values, and ends with a control flow
+// operation: a jump to another block, a conditional branch, a return or an
+// exit of the program. Every value is assigned exactly once. Where control
+// flow joins, phi values at the start of a block select the value of the
+// predecessor which was executed.
+//
+// All values are 64 bit integers.
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is the operation of a value.
+type Op int
+
+const (
+	OpConst Op = iota // AuxInt
+	OpParam           // parameter number AuxInt
+	OpPhi             // Args[i] if the block was entered from Preds[i]
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv // signed, rounds towards zero
+	OpRem // signed, has the sign of the dividend
+	OpNeg
+	OpEq // comparisons are signed, the result is 0 or 1
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+	OpCall        // calls the function Aux with Args
+	OpPrintInt    // writes Args[0] as decimal number to stdout
+	OpPrintString // writes Aux to stdout
+	OpLoad        // the 64 bit integer at the address Args[0]
+	OpStore       // writes Args[1] to the address Args[0]
+	OpAlloc       // address of a new block of Args[0] bytes, 0 if out of memory
+	OpFree        // releases the block at the address Args[0]
+	OpArgc        // number of arguments of the process
+	OpArgv        // address of the argument pointers, ending with 0
+	OpEnvp        // address of the environment pointers, ending with 0
+	OpAuxv        // address of the auxiliary vector of type and value pairs
+)
+
+var opNames = [...]string{
+	OpConst:       "Const",
+	OpParam:       "Param",
+	OpPhi:         "Phi",
+	OpAdd:         "Add",
+	OpSub:         "Sub",
+	OpMul:         "Mul",
+	OpDiv:         "Div",
+	OpRem:         "Rem",
+	OpNeg:         "Neg",
+	OpEq:          "Eq",
+	OpNe:          "Ne",
+	OpLt:          "Lt",
+	OpLe:          "Le",
+	OpGt:          "Gt",
+	OpGe:          "Ge",
+	OpCall:        "Call",
+	OpPrintInt:    "PrintInt",
+	OpPrintString: "PrintString",
+	OpLoad:        "Load",
+	OpStore:       "Store",
+	OpAlloc:       "Alloc",
+	OpFree:        "Free",
+	OpArgc:        "Argc",
+	OpArgv:        "Argv",
+	OpEnvp:        "Envp",
+	OpAuxv:        "Auxv",
+}
+
+func (op Op) String() string {
+	if op < 0 || int(op) >= len(opNames) {
+		return fmt.Sprintf("Op(%d)", int(op))
+	}
+	return opNames[op]
+}
+
+// HasResult reports whether values of the operation have a result, which
+// needs a register or stack slot.
+func (op Op) HasResult() bool {
+	return op != OpPrintInt && op != OpPrintString && op != OpStore && op != OpFree
+}
+
+// IsCall reports whether the operation calls a function or the kernel, which
+// may overwrite all caller saved registers.
+func (op Op) IsCall() bool {
+	return op == OpCall || op == OpPrintInt || op == OpPrintString || op == OpAlloc || op == OpFree
+}
+
+// argCounts are the numbers of arguments of the operations with a fixed
+// number.
+var argCounts = map[Op]int{
+	OpConst:       0,
+	OpParam:       0,
+	OpAdd:         2,
+	OpSub:         2,
+	OpMul:         2,
+	OpDiv:         2,
+	OpRem:         2,
+	OpNeg:         1,
+	OpEq:          2,
+	OpNe:          2,
+	OpLt:          2,
+	OpLe:          2,
+	OpGt:          2,
+	OpGe:          2,
+	OpPrintInt:    1,
+	OpPrintString: 0,
+	OpLoad:        1,
+	OpStore:       2,
+	OpAlloc:       1,
+	OpFree:        1,
+	OpArgc:        0,
+	OpArgv:        0,
+	OpEnvp:        0,
+	OpAuxv:        0,
+}
+
+// Value is the result of an operation.
+type Value struct {
+	ID     int
+	Op     Op
+	Args   []*Value
+	AuxInt int64
+	Aux    string
+	Block  *Block
+}
+
+func (v *Value) String() string {
+	return fmt.Sprintf("v%d", v.ID)
+}
+
+// LongString returns the value with its operation and arguments, e.g.
+// "v3 = Add v1 v2".
+func (v *Value) LongString() string {
+	s := &strings.Builder{}
+	if v.Op.HasResult() {
+		fmt.Fprintf(s, "%s = ", v)
+	}
+	s.WriteString(v.Op.String())
+	switch v.Op {
+	case OpConst, OpParam:
+		fmt.Fprintf(s, " %d", v.AuxInt)
+	case OpCall:
+		fmt.Fprintf(s, " %s", v.Aux)
+	case OpPrintString:
+		fmt.Fprintf(s, " %q", v.Aux)
+	}
+	for _, arg := range v.Args {
+		fmt.Fprintf(s, " %s", arg)
+	}
+	return s.String()
+}
+
+// BlockKind is the control flow operation at the end of a block.
+type BlockKind int
+
+const (
+	BlockInvalid BlockKind = iota // not terminated yet
+	BlockPlain                    // jumps to Succs[0]
+	BlockIf                       // jumps to Succs[0] if Control is not 0, otherwise to Succs[1]
+	BlockReturn                   // returns Control
+	BlockExit                     // terminates the program with exit code Control
+)
+
+var blockKindNames = [...]string{
+	BlockInvalid: "Invalid",
+	BlockPlain:   "Plain",
+	BlockIf:      "If",
+	BlockReturn:  "Return",
+	BlockExit:    "Exit",
+}
+
+func (k BlockKind) String() string {
+	if k < 0 || int(k) >= len(blockKindNames) {
+		return fmt.Sprintf("BlockKind(%d)", int(k))
+	}
+	return blockKindNames[k]
+}
+
+// Block is a basic block. The phi values are at the beginning of Values.
+type Block struct {
+	ID      int
+	Kind    BlockKind
+	Values  []*Value
+	Control *Value
+	Preds   []*Block
+	Succs   []*Block
+	Func    *Func
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+// Func is a function. Blocks[0] is the entry block.
+type Func struct {
+	Name      string
+	NumParams int
+	Blocks    []*Block
+
+	nextValueID int
+	nextBlockID int
+}
+
+// Program is a list of functions.
+type Program struct {
+	Funcs []*Func
+}
+
+// NewFunc returns a function with an empty entry block.
+func NewFunc(name string, numParams int) *Func {
+	f := &Func{Name: name, NumParams: numParams}
+	f.NewBlock()
+	return f
+}
+
+// Entry returns the entry block.
+func (f *Func) Entry() *Block {
+	return f.Blocks[0]
+}
+
+// NumValues returns an upper bound of the value IDs, which can be used as
+// the size of slices indexed by ID.
+func (f *Func) NumValues() int {
+	return f.nextValueID
+}
+
+// NewBlock appends a new block to the function.
+func (f *Func) NewBlock() *Block {
+	b := &Block{ID: f.nextBlockID, Func: f}
+	f.nextBlockID++
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+func (b *Block) newValue(op Op, args []*Value) *Value {
+	v := &Value{ID: b.Func.nextValueID, Op: op, Args: args, Block: b}
+	b.Func.nextValueID++
+	return v
+}
+
+// NewValue appends a value to the block.
+func (b *Block) NewValue(op Op, args ...*Value) *Value {
+	if op == OpPhi {
+		return b.NewPhi(args...)
+	}
+	v := b.newValue(op, args)
+	b.Values = append(b.Values, v)
+	return v
+}
+
+// NewConst appends a constant to the block.
+func (b *Block) NewConst(c int64) *Value {
+	v := b.NewValue(OpConst)
+	v.AuxInt = c
+	return v
+}
+
+// NewPhi inserts a phi value after the existing phi values of the block.
+// The arguments may be added later, once all predecessors are known.
+func (b *Block) NewPhi(args ...*Value) *Value {
+	v := b.newValue(OpPhi, args)
+	i := 0
+	for i < len(b.Values) && b.Values[i].Op == OpPhi {
+		i++
+	}
+	b.Values = append(b.Values[:i], append([]*Value{v}, b.Values[i:]...)...)
+	return v
+}
+
+// AddEdge makes to a successor of b.
+func (b *Block) AddEdge(to *Block) {
+	b.Succs = append(b.Succs, to)
+	to.Preds = append(to.Preds, b)
+}
+
+// Jump ends the block with a jump to to.
+func (b *Block) Jump(to *Block) {
+	b.Kind = BlockPlain
+	b.AddEdge(to)
+}
+
+// If ends the block with a branch to then if cond is not zero, otherwise to
+// els.
+func (b *Block) If(cond *Value, then *Block, els *Block) {
+	b.Kind = BlockIf
+	b.Control = cond
+	b.AddEdge(then)
+	b.AddEdge(els)
+}
+
+// Return ends the block with a return of v.
+func (b *Block) Return(v *Value) {
+	b.Kind = BlockReturn
+	b.Control = v
+}
+
+// Exit ends the block with the termination of the program with exit code v.
+func (b *Block) Exit(v *Value) {
+	b.Kind = BlockExit
+	b.Control = v
+}
+
+// removePred removes the predecessor i and the corresponding arguments of
+// the phi values.
+func (b *Block) removePred(i int) {
+	b.Preds = append(b.Preds[:i], b.Preds[i+1:]...)
+	for _, v := range b.Values {
+		if v.Op == OpPhi {
+			v.Args = append(v.Args[:i], v.Args[i+1:]...)
+		}
+	}
+}
+
+func (f *Func) String() string {
+	s := &strings.Builder{}
+	fmt.Fprintf(s, "func %s(%d):\n", f.Name, f.NumParams)
+	for _, b := range f.Blocks {
+		fmt.Fprintf(s, "%s:", b)
+		if len(b.Preds) > 0 {
+			s.WriteString(" <-")
+			for _, p := range b.Preds {
+				fmt.Fprintf(s, " %s", p)
+			}
+		}
+		s.WriteString("\n")
+		for _, v := range b.Values {
+			fmt.Fprintf(s, "  %s\n", v.LongString())
+		}
+		fmt.Fprintf(s, "  %s", b.Kind)
+		if b.Control != nil {
+			fmt.Fprintf(s, " %s", b.Control)
+		}
+		if len(b.Succs) > 0 {
+			s.WriteString(" ->")
+			for _, succ := range b.Succs {
+				fmt.Fprintf(s, " %s", succ)
+			}
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+func (p *Program) String() string {
+	s := &strings.Builder{}
+	for i, f := range p.Funcs {
+		if i > 0 {
+			s.WriteString("\n")
+		}
+		s.WriteString(f.String())
+	}
+	return s.String()
+}
+
+// Verify checks that the function is well formed: all blocks are
+// terminated, predecessors and successors match, phi values are at the
+// beginning of their blocks with one argument per predecessor, parameters
+// are at the beginning of the entry block and values are defined before they
+// are used in the same block.
+func (f *Func) Verify() error {
+	blocks := map[*Block]bool{}
+	for _, b := range f.Blocks {
+		blocks[b] = true
+	}
+	defined := map[*Value]bool{}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			defined[v] = true
+		}
+	}
+
+	for _, b := range f.Blocks {
+		if b.Func != f {
+			return fmt.Errorf("%s: %s belongs to another function", f.Name, b)
+		}
+		succs := map[BlockKind]int{BlockPlain: 1, BlockIf: 2, BlockReturn: 0, BlockExit: 0}
+		n, ok := succs[b.Kind]
+		if !ok {
+			return fmt.Errorf("%s: %s is not terminated", f.Name, b)
+		}
+		if len(b.Succs) != n {
+			return fmt.Errorf("%s: %s block %s has %d successors", f.Name, b.Kind, b, len(b.Succs))
+		}
+		if (b.Control != nil) != (b.Kind == BlockIf || b.Kind == BlockReturn || b.Kind == BlockExit) {
+			return fmt.Errorf("%s: invalid control value of %s block %s", f.Name, b.Kind, b)
+		}
+		if b.Control != nil && (!defined[b.Control] || !b.Control.Op.HasResult()) {
+			return fmt.Errorf("%s: control value %s of %s is not defined", f.Name, b.Control, b)
+		}
+		for _, s := range b.Succs {
+			if !blocks[s] || count(s.Preds, b) != count(b.Succs, s) {
+				return fmt.Errorf("%s: edge from %s to %s not in predecessors", f.Name, b, s)
+			}
+		}
+		for _, p := range b.Preds {
+			if !blocks[p] || count(p.Succs, b) != count(b.Preds, p) {
+				return fmt.Errorf("%s: edge from %s to %s not in successors", f.Name, p, b)
+			}
+		}
+
+		seen := map[*Value]bool{}
+		phis := true
+		for _, v := range b.Values {
+			if v.Block != b {
+				return fmt.Errorf("%s: %s is in %s but belongs to %s", f.Name, v, b, v.Block)
+			}
+			if v.Op == OpPhi {
+				if !phis {
+					return fmt.Errorf("%s: phi %s after other values in %s", f.Name, v, b)
+				}
+				if len(v.Args) != len(b.Preds) {
+					return fmt.Errorf("%s: phi %s has %d arguments but %s has %d predecessors", f.Name, v, len(v.Args), b, len(b.Preds))
+				}
+			} else if v.Op == OpParam {
+				if b != f.Entry() || !phis {
+					return fmt.Errorf("%s: parameter %s is not at the start of the entry block", f.Name, v)
+				}
+				if v.AuxInt < 0 || v.AuxInt >= int64(f.NumParams) {
+					return fmt.Errorf("%s: invalid parameter number %d", f.Name, v.AuxInt)
+				}
+			} else {
+				phis = false
+				if n, ok := argCounts[v.Op]; ok && len(v.Args) != n {
+					return fmt.Errorf("%s: %s has %d arguments, expected %d", f.Name, v.LongString(), len(v.Args), n)
+				}
+			}
+			for _, arg := range v.Args {
+				if !defined[arg] || !arg.Op.HasResult() {
+					return fmt.Errorf("%s: argument %s of %s is not defined", f.Name, arg, v)
+				}
+				if v.Op != OpPhi && arg.Block == b && !seen[arg] {
+					return fmt.Errorf("%s: %s is used by %s before its definition", f.Name, arg, v)
+				}
+			}
+			seen[v] = true
+		}
+	}
+	return nil
+}
+
+func count[T comparable](list []T, x T) int {
+	n := 0
+	for _, y := range list {
+		if y == x {
+			n++
+		}
+	}
+	return n
+}
+
+// ReplaceUses replaces all uses of old as argument or control value with
+// new.
+func (f *Func) ReplaceUses(old *Value, new *Value) {
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			for i, arg := range v.Args {
+				if arg == old {
+					v.Args[i] = new
+				}
+			}
+		}
+		if b.Control == old {
+			b.Control = new
+		}
+	}
+}
+
+// RemoveValue removes the unused value from its block.
+func (f *Func) RemoveValue(v *Value) {
+	b := v.Block
+	for i, w := range b.Values {
+		if w == v {
+			b.Values = append(b.Values[:i], b.Values[i+1:]...)
+			return
+		}
+	}
+}
+
+// RemoveUnreachable removes the blocks which can't be reached from the
+// entry block.
+func (f *Func) RemoveUnreachable() {
+	reachable := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		if reachable[b] {
+			return
+		}
+		reachable[b] = true
+		for _, s := range b.Succs {
+			visit(s)
+		}
+	}
+	visit(f.Entry())
+
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if reachable[b] {
+			blocks = append(blocks, b)
+			continue
+		}
+		for _, s := range b.Succs {
+			for i := len(s.Preds) - 1; i >= 0; i-- {
+				if s.Preds[i] == b {
+					s.removePred(i)
+				}
+			}
+		}
+	}
+	f.Blocks = blocks
+}
+
+// RemoveTrivialPhis replaces the phi values whose arguments are all the
+// same value or the phi itself by that value. The front ends create such
+// phis when a variable is read in a loop before it is known whether it is
+// assigned in the loop.
+func (f *Func) RemoveTrivialPhis() {
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			for i := 0; i < len(b.Values); i++ {
+				v := b.Values[i]
+				if v.Op != OpPhi {
+					break
+				}
+				var same *Value
+				trivial := true
+				for _, arg := range v.Args {
+					if arg == v || arg == same {
+						continue
+					}
+					if same != nil {
+						trivial = false
+						break
+					}
+					same = arg
+				}
+				if !trivial || same == nil {
+					continue
+				}
+				f.ReplaceUses(v, same)
+				f.RemoveValue(v)
+				i--
+				changed = true
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+)
+
+// loop builds a function which returns the sum of 1 to its parameter.
+func loop() *Func {
+	f := NewFunc("sum", 1)
+	entry := f.Entry()
+	header := f.NewBlock()
+	body := f.NewBlock()
+	exit := f.NewBlock()
+
+	n := entry.NewValue(OpParam)
+	zero := entry.NewConst(0)
+	entry.Jump(header)
+
+	i := header.NewPhi(n)
+	s := header.NewPhi(zero)
+	header.If(header.NewValue(OpGt, i, zero), body, exit)
+
+	s1 := body.NewValue(OpAdd, s, i)
+	i1 := body.NewValue(OpSub, i, body.NewConst(1))
+	body.Jump(header)
+	i.Args = append(i.Args, i1)
+	s.Args = append(s.Args, s1)
+
+	exit.Return(s)
+	return f
+}
+
+func TestFunc(t *testing.T) {
+	f := loop()
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `func sum(1):
+b0:
+  v0 = Param 0
+  v1 = Const 0
+  Plain -> b1
+b1: <- b0 b2
+  v2 = Phi v0 v7
+  v3 = Phi v1 v5
+  v4 = Gt v2 v1
+  If v4 -> b2 b3
+b2: <- b1
+  v5 = Add v3 v2
+  v6 = Const 1
+  v7 = Sub v2 v6
+  Plain -> b1
+b3: <- b1
+  Return v3
+`
+	if s := f.String(); s != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, s)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(f *Func)
+		err    string
+	}{
+		{"unterminated", func(f *Func) { f.NewBlock() }, "sum: b4 is not terminated"},
+		{"successors", func(f *Func) { f.Blocks[3].Succs = []*Block{f.Blocks[1]} }, "sum: Return block b3 has 1 successors"},
+		{"control", func(f *Func) { f.Blocks[0].Control = f.Blocks[0].Values[0] }, "sum: invalid control value of Plain block b0"},
+		{"predecessors", func(f *Func) { f.Blocks[3].Preds = nil }, "sum: edge from b1 to b3 not in predecessors"},
+		{"phi arguments", func(f *Func) { f.Blocks[1].Values[0].Args = f.Blocks[1].Values[0].Args[:1] }, "sum: phi v2 has 1 arguments but b1 has 2 predecessors"},
+		{"phi position", func(f *Func) {
+			b := f.Blocks[2]
+			phi := b.NewPhi(f.Entry().Values[0])
+			b.Values = append(b.Values[1:], phi)
+		}, "sum: phi v8 after other values in b2"},
+		{"parameter position", func(f *Func) { f.Blocks[2].NewValue(OpParam) }, "sum: parameter v8 is not at the start of the entry block"},
+		{"parameter number", func(f *Func) { f.Blocks[0].Values[0].AuxInt = 1 }, "sum: invalid parameter number 1"},
+		{"argument count", func(f *Func) { f.Blocks[2].Values[0].Args = f.Blocks[2].Values[0].Args[:1] }, "sum: v5 = Add v3 has 1 arguments, expected 2"},
+		{"use before definition", func(f *Func) {
+			b := f.Blocks[2]
+			b.Values[1], b.Values[2] = b.Values[2], b.Values[1]
+		}, "sum: v6 is used by v7 before its definition"},
+		{"undefined argument", func(f *Func) {
+			v := f.Blocks[2].Values[2]
+			f.RemoveValue(v)
+		}, "sum: argument v7 of v2 is not defined"},
+	}
+	for _, test := range tests {
+		f := loop()
+		test.modify(f)
+		err := f.Verify()
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestRemoveUnreachable(t *testing.T) {
+	f := NewFunc("f", 0)
+	entry := f.Entry()
+	dead := f.NewBlock()
+	join := f.NewBlock()
+
+	c := entry.NewConst(1)
+	entry.Jump(join)
+	d := dead.NewConst(2)
+	dead.Jump(join)
+	join.Return(join.NewPhi(c, d))
+	// the unreachable block is the second predecessor of join
+	join.Preds = []*Block{entry, dead}
+
+	f.RemoveUnreachable()
+	f.RemoveTrivialPhis()
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `func f(0):
+b0:
+  v0 = Const 1
+  Plain -> b2
+b2: <- b0
+  Return v0
+`
+	if s := f.String(); s != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, s)
+	}
+}
+
+func TestRemoveTrivialPhis(t *testing.T) {
+	// a variable which is not assigned in the loop gets a phi of itself
+	f := loop()
+	header := f.Blocks[1]
+	c := header.NewPhi(f.Entry().Values[1])
+	c.Args = append(c.Args, c)
+	f.Blocks[3].Control = c
+
+	f.RemoveTrivialPhis()
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if s := f.String(); !strings.Contains(s, "Return v1\n") || strings.Contains(s, c.String()+" = ") {
+		t.Errorf("trivial phi %s was not removed:\n%s", c, s)
+	}
+}
@@ -0,0 +1,282 @@
+package ir
+
+import "math"
+
+// Optimize simplifies the functions of the program, see Func.Optimize.
+func (p *Program) Optimize() {
+	for _, f := range p.Funcs {
+		f.Optimize()
+	}
+}
+
+// Optimize simplifies the function until none of the following changes it
+// anymore, since each of them can enable the others:
+//
+//   - constant folding and propagation: values whose arguments are
+//     constants become constants, see FoldConstants
+//   - conditional branches on constants become jumps and the blocks which
+//     are no longer reachable are removed, see FoldBranches
+//   - values which are not used and have no side effects are removed. As a
+//     variable is assigned by defining a new value, this removes the
+//     assignments which are never read as well, see RemoveDeadValues
+//   - a block which is the only successor of its only predecessor is
+//     merged into it, see MergeBlocks
+func (f *Func) Optimize() {
+	for changed := true; changed; {
+		changed = f.FoldConstants()
+		if f.FoldBranches() {
+			f.RemoveUnreachable()
+			f.RemoveTrivialPhis()
+			changed = true
+		}
+		if f.RemoveDeadValues() {
+			changed = true
+		}
+		if f.MergeBlocks() {
+			changed = true
+		}
+	}
+}
+
+// FoldConstants replaces the values whose arguments are constants by the
+// constant result, and phi values whose arguments are all the same
+// constant. Divisions which terminate the program at run time, by zero or
+// of the smallest integer by -1, are kept. It reports whether a value was
+// replaced.
+func (f *Func) FoldConstants() bool {
+	changed := false
+	for _, b := range f.Blocks {
+		phis := false
+		for _, v := range b.Values {
+			c, ok := fold(v)
+			if !ok {
+				continue
+			}
+			phis = phis || v.Op == OpPhi
+			v.Op = OpConst
+			v.AuxInt = c
+			v.Args = nil
+			changed = true
+		}
+		if phis {
+			// the new constants must follow the remaining phi values
+			values := []*Value{}
+			for _, v := range b.Values {
+				if v.Op == OpPhi {
+					values = append(values, v)
+				}
+			}
+			for _, v := range b.Values {
+				if v.Op != OpPhi {
+					values = append(values, v)
+				}
+			}
+			b.Values = values
+		}
+	}
+	return changed
+}
+
+// fold returns the constant result of v if its arguments are constants.
+func fold(v *Value) (int64, bool) {
+	if v.Op == OpConst || len(v.Args) == 0 {
+		return 0, false
+	}
+	for _, arg := range v.Args {
+		if arg.Op != OpConst && arg != v {
+			return 0, false
+		}
+	}
+	if v.Op == OpPhi {
+		c := v.Args[0].AuxInt
+		for _, arg := range v.Args {
+			if arg == v || arg.AuxInt != c {
+				return 0, false
+			}
+		}
+		return c, true
+	}
+
+	x := v.Args[0].AuxInt
+	if v.Op == OpNeg {
+		return -x, true
+	}
+	if len(v.Args) != 2 {
+		return 0, false
+	}
+	y := v.Args[1].AuxInt
+	switch v.Op {
+	case OpAdd:
+		return x + y, true
+	case OpSub:
+		return x - y, true
+	case OpMul:
+		return x * y, true
+	case OpDiv, OpRem:
+		if y == 0 || x == math.MinInt64 && y == -1 {
+			return 0, false
+		}
+		if v.Op == OpDiv {
+			return x / y, true
+		}
+		return x % y, true
+	case OpEq:
+		return boolInt(x == y), true
+	case OpNe:
+		return boolInt(x != y), true
+	case OpLt:
+		return boolInt(x < y), true
+	case OpLe:
+		return boolInt(x <= y), true
+	case OpGt:
+		return boolInt(x > y), true
+	case OpGe:
+		return boolInt(x >= y), true
+	}
+	return 0, false
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// FoldBranches replaces the conditional branches on constants by jumps to
+// the successor which is taken. The other successor may become unreachable
+// afterwards. It reports whether a branch was replaced.
+func (f *Func) FoldBranches() bool {
+	changed := false
+	for _, b := range f.Blocks {
+		if b.Kind != BlockIf || b.Control.Op != OpConst {
+			continue
+		}
+		taken, skipped := 0, 1
+		if b.Control.AuxInt == 0 {
+			taken, skipped = 1, 0
+		}
+		// the skipped edge is the n-th edge from b to its successor
+		succ := b.Succs[skipped]
+		n := count(b.Succs[:skipped], succ)
+		for i, pred := range succ.Preds {
+			if pred != b {
+				continue
+			}
+			if n == 0 {
+				succ.removePred(i)
+				break
+			}
+			n--
+		}
+		b.Kind = BlockPlain
+		b.Control = nil
+		b.Succs = []*Block{b.Succs[taken]}
+		changed = true
+	}
+	return changed
+}
+
+// RemoveDeadValues removes the values which neither have side effects nor
+// are used by such a value or a control value, directly or through other
+// values. Calls, output and divisions which may terminate the program have
+// side effects. It reports whether a value was removed.
+func (f *Func) RemoveDeadValues() bool {
+	live := make([]bool, f.NumValues())
+	work := []*Value{}
+	mark := func(v *Value) {
+		if !live[v.ID] {
+			live[v.ID] = true
+			work = append(work, v)
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, v := range b.Values {
+			if hasSideEffects(v) {
+				mark(v)
+			}
+		}
+		if b.Control != nil {
+			mark(b.Control)
+		}
+	}
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, arg := range v.Args {
+			mark(arg)
+		}
+	}
+
+	changed := false
+	for _, b := range f.Blocks {
+		values := b.Values[:0]
+		for _, v := range b.Values {
+			if live[v.ID] {
+				values = append(values, v)
+			} else {
+				changed = true
+			}
+		}
+		b.Values = values
+	}
+	return changed
+}
+
+// hasSideEffects reports whether v must be kept even if its result is not
+// used.
+func hasSideEffects(v *Value) bool {
+	switch v.Op {
+	case OpCall, OpPrintInt, OpPrintString, OpStore, OpAlloc, OpFree:
+		return true
+	case OpDiv, OpRem:
+		y := v.Args[1]
+		return y.Op != OpConst || y.AuxInt == 0 || y.AuxInt == -1
+	}
+	return false
+}
+
+// MergeBlocks appends a block, which is only entered with a jump from its
+// predecessor, to the predecessor. It reports whether a block was merged.
+func (f *Func) MergeBlocks() bool {
+	changed := false
+	for i := 0; i < len(f.Blocks); i++ {
+		b := f.Blocks[i]
+		if b.Kind != BlockPlain {
+			continue
+		}
+		succ := b.Succs[0]
+		if succ == b || succ == f.Entry() || len(succ.Preds) != 1 {
+			continue
+		}
+		for _, v := range succ.Values {
+			if v.Op == OpPhi {
+				// the only predecessor determines the value
+				f.ReplaceUses(v, v.Args[0])
+				continue
+			}
+			v.Block = b
+			b.Values = append(b.Values, v)
+		}
+		b.Kind = succ.Kind
+		b.Control = succ.Control
+		b.Succs = succ.Succs
+		for _, s := range succ.Succs {
+			for j, pred := range s.Preds {
+				if pred == succ {
+					s.Preds[j] = b
+				}
+			}
+		}
+		for j, other := range f.Blocks {
+			if other == succ {
+				f.Blocks = append(f.Blocks[:j], f.Blocks[j+1:]...)
+				break
+			}
+		}
+		// b may be merged with its new successor as well
+		i = -1
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,227 @@
+package ir
+
+import "testing"
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func() *Func
+		expected string
+	}{
+		{
+			name: "fold constants",
+			build: func() *Func {
+				f := NewFunc("f", 0)
+				b := f.Entry()
+				x := b.NewValue(OpAdd, b.NewConst(2), b.NewConst(3))
+				y := b.NewValue(OpMul, x, b.NewValue(OpNeg, x))
+				z := b.NewValue(OpSub, y, b.NewValue(OpRem, b.NewConst(7), b.NewConst(-2)))
+				b.NewValue(OpPrintInt, b.NewValue(OpLe, z, b.NewConst(-26)))
+				b.Return(z)
+				return f
+			},
+			expected: `func f(0):
+b0:
+  v8 = Const -26
+  v10 = Const 1
+  PrintInt v10
+  Return v8
+`,
+		},
+		{
+			name: "division which may fault",
+			build: func() *Func {
+				f := NewFunc("f", 1)
+				b := f.Entry()
+				n := b.NewValue(OpParam)
+				zero := b.NewConst(0)
+				b.NewValue(OpDiv, b.NewConst(1), zero)                // by zero
+				b.NewValue(OpRem, n, b.NewConst(-1))                  // of the smallest integer by -1
+				b.NewValue(OpDiv, b.NewConst(-1<<63), b.NewConst(-1)) // as well
+				b.NewValue(OpDiv, n, b.NewConst(2))                   // never
+				b.Return(zero)
+				return f
+			},
+			expected: `func f(1):
+b0:
+  v0 = Param 0
+  v1 = Const 0
+  v2 = Const 1
+  v3 = Div v2 v1
+  v4 = Const -1
+  v5 = Rem v0 v4
+  v6 = Const -9223372036854775808
+  v7 = Const -1
+  v8 = Div v6 v7
+  Return v1
+`,
+		},
+		{
+			name: "fold branch",
+			build: func() *Func {
+				f := NewFunc("f", 1)
+				entry := f.Entry()
+				then := f.NewBlock()
+				els := f.NewBlock()
+				join := f.NewBlock()
+
+				n := entry.NewValue(OpParam)
+				entry.If(entry.NewValue(OpLt, entry.NewConst(1), entry.NewConst(2)), then, els)
+				one := then.NewConst(1)
+				then.Jump(join)
+				els.Jump(join)
+				join.Return(join.NewValue(OpAdd, n, join.NewPhi(one, n)))
+				return f
+			},
+			expected: `func f(1):
+b0:
+  v0 = Param 0
+  v4 = Const 1
+  v6 = Add v0 v4
+  Return v6
+`,
+		},
+		{
+			name: "fold branch to the same block",
+			build: func() *Func {
+				f := NewFunc("f", 0)
+				entry := f.Entry()
+				join := f.NewBlock()
+
+				one := entry.NewConst(1)
+				two := entry.NewConst(2)
+				entry.If(entry.NewConst(0), join, join)
+				join.Return(join.NewPhi(one, two))
+				return f
+			},
+			expected: `func f(0):
+b0:
+  v1 = Const 2
+  Return v1
+`,
+		},
+		{
+			name: "phi of the same constant",
+			build: func() *Func {
+				f := NewFunc("f", 1)
+				entry := f.Entry()
+				then := f.NewBlock()
+				els := f.NewBlock()
+				join := f.NewBlock()
+
+				n := entry.NewValue(OpParam)
+				entry.If(n, then, els)
+				a := then.NewConst(7)
+				then.Jump(join)
+				b := els.NewConst(7)
+				els.Jump(join)
+				join.Return(join.NewValue(OpMul, join.NewPhi(a, b), join.NewConst(6)))
+				return f
+			},
+			expected: `func f(1):
+b0:
+  v0 = Param 0
+  If v0 -> b1 b2
+b1: <- b0
+  Plain -> b3
+b2: <- b0
+  Plain -> b3
+b3: <- b1 b2
+  v5 = Const 42
+  Return v5
+`,
+		},
+		{
+			name: "dead loop variable",
+			build: func() *Func {
+				// the sum of the loop is not returned
+				f := loop()
+				f.Blocks[3].Control = f.Entry().Values[1]
+				return f
+			},
+			expected: `func sum(1):
+b0:
+  v0 = Param 0
+  v1 = Const 0
+  Plain -> b1
+b1: <- b0 b2
+  v2 = Phi v0 v7
+  v4 = Gt v2 v1
+  If v4 -> b2 b3
+b2: <- b1
+  v6 = Const 1
+  v7 = Sub v2 v6
+  Plain -> b1
+b3: <- b1
+  Return v1
+`,
+		},
+		{
+			name: "unused loop",
+			build: func() *Func {
+				f := loop()
+				f.Blocks[3].Control = f.Blocks[3].NewConst(3)
+				return f
+			},
+			// the loop may not terminate, so it is kept
+			expected: `func sum(1):
+b0:
+  v0 = Param 0
+  v1 = Const 0
+  Plain -> b1
+b1: <- b0 b2
+  v2 = Phi v0 v7
+  v4 = Gt v2 v1
+  If v4 -> b2 b3
+b2: <- b1
+  v6 = Const 1
+  v7 = Sub v2 v6
+  Plain -> b1
+b3: <- b1
+  v8 = Const 3
+  Return v8
+`,
+		},
+		{
+			name: "merge blocks",
+			build: func() *Func {
+				f := NewFunc("f", 1)
+				entry := f.Entry()
+				a := f.NewBlock()
+				b := f.NewBlock()
+				c := f.NewBlock()
+
+				n := entry.NewValue(OpParam)
+				entry.Jump(a)
+				x := a.NewValue(OpAdd, a.NewPhi(n), n)
+				a.Jump(b)
+				b.Jump(c)
+				c.Return(c.NewValue(OpCall, x))
+				c.Values[0].Aux = "g"
+				return f
+			},
+			expected: `func f(1):
+b0:
+  v0 = Param 0
+  v2 = Add v0 v0
+  v3 = Call g v2
+  Return v3
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := test.build()
+			if err := f.Verify(); err != nil {
+				t.Fatal(err)
+			}
+			f.Optimize()
+			if err := f.Verify(); err != nil {
+				t.Fatal(err)
+			}
+			if s := f.String(); s != test.expected {
+				t.Errorf("expected\n%s\ngot\n%s", test.expected, s)
+			}
+		})
+	}
+}
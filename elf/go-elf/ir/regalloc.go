@@ -0,0 +1,353 @@
+package ir
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RegisterConfig describes the registers of a machine for Allocate.
+// Registers are identified by the numbers the code generator uses.
+type RegisterConfig struct {
+	// Registers which can be allocated, in the order of preference.
+	Registers []int
+
+	// CallerSaved reports whether the register is overwritten by calls.
+	// Values which are live across a call get only the other registers.
+	CallerSaved func(reg int) bool
+}
+
+// Location is the register or stack slot of a value.
+type Location struct {
+	Spilled bool
+	Index   int // register number or stack slot
+}
+
+func (l Location) String() string {
+	if l.Spilled {
+		return fmt.Sprintf("slot%d", l.Index)
+	}
+	return fmt.Sprintf("r%d", l.Index)
+}
+
+// Allocation is the result of Allocate.
+type Allocation struct {
+	// Order is the order of the blocks in the generated code.
+	Order []*Block
+
+	// Locations of the values with a result, indexed by value ID.
+	Locations []Location
+
+	// Slots is the number of stack slots used by spilled values.
+	Slots int
+
+	// Used are the registers which were allocated.
+	Used []int
+}
+
+// Location returns the location of v.
+func (a *Allocation) Location(v *Value) Location {
+	return a.Locations[v.ID]
+}
+
+// interval is the range of positions in which a value is live. Lifetime
+// holes are not tracked, so the interval covers everything from the
+// definition to the last use.
+type interval struct {
+	value      *Value
+	start, end int
+	crossCall  bool
+}
+
+// Allocate assigns registers to the values of f with linear scan register
+// allocation. Each value gets a single register or stack slot for its whole
+// lifetime. If more values are live than registers are available, the one
+// whose interval ends last is spilled to a stack slot.
+//
+// Critical edges of f are split first, so the moves for the phi values can
+// be placed at the end of the predecessors.
+func Allocate(f *Func, config RegisterConfig) *Allocation {
+	SplitCriticalEdges(f)
+
+	a := &Allocation{
+		Order:     Order(f),
+		Locations: make([]Location, f.NumValues()),
+	}
+	intervals := buildIntervals(a.Order, f.NumValues())
+
+	sort.SliceStable(intervals, func(i, j int) bool { return intervals[i].start < intervals[j].start })
+
+	used := map[int]bool{}
+	free := map[int]bool{}
+	for _, reg := range config.Registers {
+		free[reg] = true
+	}
+	allowed := func(it *interval, reg int) bool {
+		return !it.crossCall || config.CallerSaved == nil || !config.CallerSaved(reg)
+	}
+	spill := func(it *interval) {
+		a.Locations[it.value.ID] = Location{Spilled: true, Index: a.Slots}
+		a.Slots++
+	}
+
+	active := []*interval{}
+	for _, it := range intervals {
+		// expire the intervals which end before this one starts, their
+		// registers can be reused
+		remaining := active[:0]
+		for _, other := range active {
+			if other.end <= it.start {
+				free[a.Locations[other.value.ID].Index] = true
+			} else {
+				remaining = append(remaining, other)
+			}
+		}
+		active = remaining
+
+		reg := -1
+		for _, r := range config.Registers {
+			if free[r] && allowed(it, r) {
+				reg = r
+				break
+			}
+		}
+		if reg < 0 {
+			// spill the interval which ends last, either an active one
+			// whose register can be used or this one
+			victim := -1
+			for i, other := range active {
+				r := a.Locations[other.value.ID].Index
+				if allowed(it, r) && (victim < 0 || other.end > active[victim].end) {
+					victim = i
+				}
+			}
+			if victim < 0 || active[victim].end <= it.end {
+				spill(it)
+				continue
+			}
+			reg = a.Locations[active[victim].value.ID].Index
+			spill(active[victim])
+			active = append(active[:victim], active[victim+1:]...)
+		}
+
+		free[reg] = false
+		used[reg] = true
+		a.Locations[it.value.ID] = Location{Index: reg}
+		active = append(active, it)
+	}
+
+	for _, reg := range config.Registers {
+		if used[reg] {
+			a.Used = append(a.Used, reg)
+		}
+	}
+	return a
+}
+
+// SplitCriticalEdges inserts an empty block on every edge from a block with
+// several successors to a block with several predecessors.
+func SplitCriticalEdges(f *Func) {
+	for _, b := range f.Blocks {
+		if len(b.Succs) < 2 {
+			continue
+		}
+		for i, s := range b.Succs {
+			if len(s.Preds) < 2 {
+				continue
+			}
+			n := f.NewBlock()
+			n.Kind = BlockPlain
+			n.Preds = []*Block{b}
+			n.Succs = []*Block{s}
+			b.Succs[i] = n
+			for j, p := range s.Preds {
+				// an If with the same block as both successors has it
+				// twice as predecessor
+				if p == b {
+					s.Preds[j] = n
+					break
+				}
+			}
+		}
+	}
+}
+
+// Order returns the blocks in reverse postorder, so a block comes after its
+// predecessors except for loops. The first successor of a block is placed
+// directly after it if possible.
+func Order(f *Func) []*Block {
+	visited := map[*Block]bool{}
+	postorder := []*Block{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		visited[b] = true
+		for i := len(b.Succs) - 1; i >= 0; i-- {
+			if !visited[b.Succs[i]] {
+				visit(b.Succs[i])
+			}
+		}
+		postorder = append(postorder, b)
+	}
+	visit(f.Entry())
+
+	order := make([]*Block, len(postorder))
+	for i, b := range postorder {
+		order[len(postorder)-1-i] = b
+	}
+	return order
+}
+
+// definedAtStart reports whether v is defined at the start of its block: phi
+// values and the parameters in the entry block.
+func definedAtStart(v *Value) bool {
+	return v.Op == OpPhi || v.Op == OpParam
+}
+
+// buildIntervals computes the live intervals of the values. The blocks are
+// numbered in order: the phi values and parameters of a block are defined at
+// its start, the other values at the following positions and the control
+// flow operation is at the end of the block.
+func buildIntervals(order []*Block, numValues int) []*interval {
+	type blockRange struct{ start, end int }
+	ranges := map[*Block]blockRange{}
+	position := map[*Value]int{}
+	calls := []int{}
+	pos := 0
+	for _, b := range order {
+		r := blockRange{start: pos}
+		for _, v := range b.Values {
+			if !definedAtStart(v) {
+				pos++
+			}
+			position[v] = pos
+			if v.Op.IsCall() {
+				calls = append(calls, pos)
+			}
+		}
+		pos++
+		r.end = pos
+		ranges[b] = r
+		pos++
+	}
+
+	liveIn, liveOut := liveness(order)
+
+	intervals := make([]*interval, numValues)
+	extend := func(v *Value, from, to int) {
+		it := intervals[v.ID]
+		if it == nil {
+			it = &interval{value: v, start: from, end: to}
+			intervals[v.ID] = it
+		}
+		it.start = min(it.start, from)
+		it.end = max(it.end, to)
+	}
+	for _, b := range order {
+		r := ranges[b]
+		for v := range liveIn[b] {
+			extend(v, r.start, r.start)
+		}
+		for v := range liveOut[b] {
+			extend(v, r.start, r.end)
+		}
+		for _, v := range b.Values {
+			switch {
+			case definedAtStart(v):
+				// the values defined at the start of a block are set
+				// together by parallel moves, so they must not share
+				// registers even if they are not used
+				extend(v, position[v], position[v]+1)
+			case v.Op.HasResult():
+				extend(v, position[v], position[v])
+			}
+			if v.Op == OpPhi {
+				continue
+			}
+			for _, arg := range v.Args {
+				extend(arg, position[v], position[v])
+			}
+		}
+		if b.Control != nil {
+			extend(b.Control, r.end, r.end)
+		}
+	}
+	// a value which is live in a block is live from the start of the block
+	// or its definition, whichever is later
+	for _, it := range intervals {
+		if it != nil {
+			it.start = max(it.start, position[it.value])
+		}
+	}
+
+	result := []*interval{}
+	for _, it := range intervals {
+		if it == nil {
+			continue
+		}
+		for _, call := range calls {
+			if it.start < call && call < it.end {
+				it.crossCall = true
+			}
+		}
+		result = append(result, it)
+	}
+	return result
+}
+
+// liveness computes the values which are live at the start and at the end
+// of the blocks. The arguments of a phi value are live at the end of the
+// corresponding predecessor, but not at the start of the phi's block.
+func liveness(order []*Block) (liveIn map[*Block]map[*Value]bool, liveOut map[*Block]map[*Value]bool) {
+	liveIn = map[*Block]map[*Value]bool{}
+	liveOut = map[*Block]map[*Value]bool{}
+	for _, b := range order {
+		liveIn[b] = map[*Value]bool{}
+		liveOut[b] = map[*Value]bool{}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 1; i >= 0; i-- {
+			b := order[i]
+			out := liveOut[b]
+			for _, s := range b.Succs {
+				for v := range liveIn[s] {
+					out[v] = true
+				}
+				for _, v := range s.Values {
+					if v.Op != OpPhi {
+						break
+					}
+					for j, p := range s.Preds {
+						if p == b {
+							out[v.Args[j]] = true
+						}
+					}
+				}
+			}
+
+			live := map[*Value]bool{}
+			for v := range out {
+				live[v] = true
+			}
+			if b.Control != nil {
+				live[b.Control] = true
+			}
+			for j := len(b.Values) - 1; j >= 0; j-- {
+				v := b.Values[j]
+				delete(live, v)
+				if v.Op != OpPhi {
+					for _, arg := range v.Args {
+						live[arg] = true
+					}
+				}
+			}
+			for v := range live {
+				if !liveIn[b][v] {
+					liveIn[b][v] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return liveIn, liveOut
+}
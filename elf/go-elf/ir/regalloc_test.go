@@ -0,0 +1,114 @@
+package ir
+
+import (
+	"fmt"
+	"testing"
+)
+
+// calls builds a function which keeps values live across calls.
+func calls() *Func {
+	f := NewFunc("calls", 2)
+	b := f.Entry()
+	a := b.NewValue(OpParam)
+	a.AuxInt = 0
+	c := b.NewValue(OpParam)
+	c.AuxInt = 1
+	x := b.NewValue(OpCall, a)
+	x.Aux = "f"
+	b.NewValue(OpPrintInt, c)
+	y := b.NewValue(OpCall, x, c)
+	y.Aux = "g"
+	b.Return(b.NewValue(OpAdd, b.NewValue(OpAdd, a, x), y))
+	return f
+}
+
+// checkAllocation checks that values whose intervals overlap are in
+// different registers and that values which are live across calls are not
+// in caller saved registers.
+func checkAllocation(f *Func, a *Allocation, config RegisterConfig) error {
+	intervals := buildIntervals(a.Order, f.NumValues())
+	for i, it := range intervals {
+		loc := a.Location(it.value)
+		if loc.Spilled {
+			if loc.Index >= a.Slots {
+				return fmt.Errorf("%s in %s, but there are %d slots", it.value, loc, a.Slots)
+			}
+			continue
+		}
+		if it.crossCall && config.CallerSaved(loc.Index) {
+			return fmt.Errorf("%s is live across a call in caller saved %s", it.value, loc)
+		}
+		for _, other := range intervals[i+1:] {
+			if a.Location(other.value) == loc && it.start < other.end && other.start < it.end {
+				return fmt.Errorf("%s [%d, %d] and %s [%d, %d] are both in %s", it.value, it.start, it.end, other.value, other.start, other.end, loc)
+			}
+		}
+	}
+	return nil
+}
+
+func TestAllocate(t *testing.T) {
+	callerSaved := func(reg int) bool { return reg < 2 }
+	tests := []struct {
+		name      string
+		f         func() *Func
+		registers []int
+		slots     int
+	}{
+		{"loop", loop, []int{0, 1, 2, 3, 4}, 0},
+		{"loop with 2 registers", loop, []int{0, 1}, 3},
+		{"loop with 1 register", loop, []int{0}, 4},
+		{"calls", calls, []int{0, 1, 2, 3, 4}, 0},
+		{"calls with 2 callee saved registers", calls, []int{0, 1, 2, 3}, 1},
+		{"calls with caller saved registers", calls, []int{0, 1}, 3},
+	}
+	for _, test := range tests {
+		f := test.f()
+		config := RegisterConfig{Registers: test.registers, CallerSaved: callerSaved}
+		a := Allocate(f, config)
+		if err := f.Verify(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if err := checkAllocation(f, a, config); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if a.Slots != test.slots {
+			t.Errorf("%s: expected %d slots got %d", test.name, test.slots, a.Slots)
+		}
+	}
+}
+
+func TestSplitCriticalEdges(t *testing.T) {
+	f := NewFunc("f", 1)
+	entry := f.Entry()
+	join := f.NewBlock()
+	c := entry.NewValue(OpParam)
+	entry.If(c, join, join)
+	join.Return(join.NewPhi(c, entry.NewConst(1)))
+
+	SplitCriticalEdges(f)
+	if err := f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `func f(1):
+b0:
+  v0 = Param 0
+  v1 = Const 1
+  If v0 -> b2 b3
+b1: <- b2 b3
+  v2 = Phi v0 v1
+  Return v2
+b2: <- b0
+  Plain -> b1
+b3: <- b0
+  Plain -> b1
+`
+	if s := f.String(); s != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, s)
+	}
+
+	order := Order(f)
+	if fmt.Sprint(order) != "[b0 b2 b3 b1]" {
+		t.Errorf("expected order [b0 b2 b3 b1] got %v", order)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+#include "textflag.h"
+
+// func call(fn, stack uintptr, a0, a1, a2, a3, a4, a5 uint64) uint64
+//
+// call calls fn with the arguments in rdi, rsi, rdx, rcx, r8 and r9 as in
+// the System V ABI and returns rax. fn runs on the stack whose top is at
+// stack, which has to be aligned to 16 bytes. The old stack pointer is kept
+// in r12, which fn has to preserve.
+TEXT ·call(SB), NOSPLIT, $0-72
+	MOVQ fn+0(FP), AX
+	MOVQ stack+8(FP), BX
+	MOVQ a0+16(FP), DI
+	MOVQ a1+24(FP), SI
+	MOVQ a2+32(FP), DX
+	MOVQ a3+40(FP), CX
+	MOVQ a4+48(FP), R8
+	MOVQ a5+56(FP), R9
+
+	MOVQ SP, R12
+	MOVQ BX, SP
+	CALL AX
+	MOVQ R12, SP
+
+	MOVQ AX, ret+64(FP)
+	RET
@@ -0,0 +1,179 @@
+//go:build linux && amd64
+
+// Package jit executes generated x86-64 code in the memory of the process.
+//
+// The memory is allocated with mmap as readable and writable. Once the code
+// has been written, mprotect makes it readable and executable, so it is
+// never writable and executable at the same time (W^X):
+//
+//	m, err := jit.Alloc(4096)
+//	entryPoint, code, err := elf.Assemble(m.Address(), source)
+//	err = m.Write(code)
+//	err = m.Protect()
+//	result, err := m.Call(entryPoint, 1, 2)
+//	err = m.Free()
+//
+// Call passes up to six integer arguments in rdi, rsi, rdx, rcx, r8 and r9
+// and returns rax, following the System V ABI. It calls the code with an
+// assembly trampoline, which switches to a stack of StackSize bytes mapped
+// for the call, so the code can return normally to Go instead of exiting
+// the process. The code has to preserve the callee saved registers rbx, rbp
+// and r12 to r15.
+package jit
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Memory is a mapping for code. It is writable until Protect is called and
+// executable afterwards.
+type Memory struct {
+	mem        []byte
+	size       int // of the code written so far
+	executable bool
+}
+
+// maxArguments is the number of integer arguments which are passed in
+// registers by the System V ABI.
+const maxArguments = 6
+
+// StackSize is the size of the stack of the code run by Call. It is
+// followed by an inaccessible guard page, so code which uses more stack
+// crashes the process with SIGSEGV instead of overwriting other memory.
+const StackSize = 1 << 20
+
+// call calls the code at fn with the arguments on the stack whose top is at
+// stack and returns rax, see call_amd64.s.
+func call(fn, stack uintptr, a0, a1, a2, a3, a4, a5 uint64) uint64
+
+// Alloc maps at least size bytes of readable and writable memory. The size
+// is rounded up to a multiple of the page size.
+func Alloc(size int) (*Memory, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+	pageSize := os.Getpagesize()
+	size = (size + pageSize - 1) / pageSize * pageSize
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	return &Memory{mem: mem}, nil
+}
+
+// Load allocates memory for code, which has to be position independent, and
+// makes it executable.
+func Load(code []byte) (*Memory, error) {
+	m, err := Alloc(len(code))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Write(code); err != nil {
+		m.Free()
+		return nil, err
+	}
+	if err := m.Protect(); err != nil {
+		m.Free()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Address returns the address of the memory, where the code has to be
+// generated for, e.g. the startAddr of the Compiler.
+func (m *Memory) Address() uint64 {
+	if m.mem == nil {
+		return 0
+	}
+	return uint64(uintptr(unsafe.Pointer(&m.mem[0])))
+}
+
+// Size returns the size of the memory.
+func (m *Memory) Size() int {
+	return len(m.mem)
+}
+
+// Write copies code to the start of the memory.
+func (m *Memory) Write(code []byte) error {
+	switch {
+	case m.mem == nil:
+		return fmt.Errorf("memory has been freed")
+	case m.executable:
+		return fmt.Errorf("memory is executable and can't be written")
+	case len(code) > len(m.mem):
+		return fmt.Errorf("code of %d bytes doesn't fit into %d bytes", len(code), len(m.mem))
+	}
+	copy(m.mem, code)
+	m.size = len(code)
+	return nil
+}
+
+// Protect makes the memory readable and executable instead of writable.
+func (m *Memory) Protect() error {
+	if m.mem == nil {
+		return fmt.Errorf("memory has been freed")
+	}
+	if err := syscall.Mprotect(m.mem, syscall.PROT_READ|syscall.PROT_EXEC); err != nil {
+		return fmt.Errorf("mprotect: %w", err)
+	}
+	m.executable = true
+	return nil
+}
+
+// Call calls the code at entryPoint with up to six integer arguments and
+// returns the value of rax. The code gets a new stack of StackSize bytes
+// for each call, so the same code can be called by several goroutines at
+// once.
+func (m *Memory) Call(entryPoint uint64, args ...uint64) (uint64, error) {
+	switch {
+	case m.mem == nil:
+		return 0, fmt.Errorf("memory has been freed")
+	case !m.executable:
+		return 0, fmt.Errorf("memory is not executable")
+	case entryPoint < m.Address() || entryPoint >= m.Address()+uint64(m.size):
+		return 0, fmt.Errorf("entry point 0x%x is outside of the code at 0x%x-0x%x", entryPoint, m.Address(), m.Address()+uint64(m.size))
+	case len(args) > maxArguments:
+		return 0, fmt.Errorf("%d arguments, only %d can be passed in registers", len(args), maxArguments)
+	}
+	stack, err := allocStack()
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.Munmap(stack)
+	top := uintptr(unsafe.Pointer(&stack[0])) + uintptr(len(stack))
+
+	a := [maxArguments]uint64{}
+	copy(a[:], args)
+	return call(uintptr(entryPoint), top, a[0], a[1], a[2], a[3], a[4], a[5]), nil
+}
+
+// allocStack maps a stack of StackSize bytes with the guard page below it.
+func allocStack() ([]byte, error) {
+	pageSize := os.Getpagesize()
+	stack, err := syscall.Mmap(-1, 0, pageSize+StackSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS|syscall.MAP_STACK)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	if err := syscall.Mprotect(stack[:pageSize], syscall.PROT_NONE); err != nil {
+		syscall.Munmap(stack)
+		return nil, fmt.Errorf("mprotect: %w", err)
+	}
+	return stack, nil
+}
+
+// Free unmaps the memory. The Memory can't be used afterwards.
+func (m *Memory) Free() error {
+	if m.mem == nil {
+		return fmt.Errorf("memory has been freed")
+	}
+	if err := syscall.Munmap(m.mem); err != nil {
+		return fmt.Errorf("munmap: %w", err)
+	}
+	m.mem = nil
+	m.size = 0
+	m.executable = false
+	return nil
+}
@@ -0,0 +1,356 @@
+//go:build linux && amd64
+
+package jit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-elf"
+	"go-elf/x86"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+type instruction struct {
+	op       x86.Op
+	operands []x86.Operand
+}
+
+// encode encodes the instructions of a test.
+func encode(t *testing.T, instructions ...instruction) []byte {
+	t.Helper()
+	code := []byte{}
+	for _, i := range instructions {
+		b, err := x86.Encode(i.op, i.operands...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code = append(code, b...)
+	}
+	return code
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name         string
+		instructions []instruction
+		expected     uint64
+	}{
+		{"constant", []instruction{
+			{x86.MOV, []x86.Operand{x86.EAX, x86.Imm(42)}},
+			{x86.RET, nil},
+		}, 42},
+		{"64 bit", []instruction{
+			{x86.MOV, []x86.Operand{x86.RAX, x86.Imm(-2)}},
+			{x86.RET, nil},
+		}, 0xfffffffffffffffe},
+		{"callee saved", []instruction{
+			{x86.PUSH, []x86.Operand{x86.RBX}},
+			{x86.MOV, []x86.Operand{x86.EBX, x86.Imm(6)}},
+			{x86.MOV, []x86.Operand{x86.EAX, x86.Imm(7)}},
+			{x86.IMUL, []x86.Operand{x86.RAX, x86.RBX}},
+			{x86.POP, []x86.Operand{x86.RBX}},
+			{x86.RET, nil},
+		}, 42},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := Load(encode(t, test.instructions...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Free()
+			result, err := m.Call(m.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != test.expected {
+				t.Errorf("expected %d got %d", test.expected, result)
+			}
+		})
+	}
+}
+
+// TestAssemble generates code for the address of the memory, which loads
+// data relative to rip and calls a function.
+func TestAssemble(t *testing.T) {
+	m, err := Alloc(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Free()
+	if m.Size() != os.Getpagesize() {
+		t.Errorf("expected the size of a page got %d", m.Size())
+	}
+
+	source := `
+_start:
+	mov n(%rip), %rdi
+	call sum
+	ret
+
+# sum returns 1 + 2 + ... + rdi
+sum:
+	xor %eax, %eax
+loop:
+	add %rdi, %rax
+	dec %rdi
+	jnz loop
+	ret
+
+.data
+n:
+	.quad 100
+`
+	entryPoint, code, err := elf.Assemble(m.Address(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(code); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Protect(); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		result, err := m.Call(entryPoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != 5050 {
+			t.Errorf("expected 5050 got %d", result)
+		}
+	}
+}
+
+// assemble loads the function _start of source.
+func assemble(t *testing.T, source string) (*Memory, uint64) {
+	t.Helper()
+	m, err := Alloc(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryPoint, code, err := elf.Assemble(m.Address(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(code); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Protect(); err != nil {
+		t.Fatal(err)
+	}
+	return m, entryPoint
+}
+
+// TestCall passes arguments to generated code which uses the stack and
+// returns normally.
+func TestCall(t *testing.T) {
+	// returns rdi + 2*rsi + 3*rdx + 4*rcx + 5*r8 + 6*r9
+	weighted := `
+_start:
+	lea (%rdi,%rsi,2), %rax
+	imul $3, %rdx
+	add %rdx, %rax
+	lea (%rax,%rcx,4), %rax
+	imul $5, %r8
+	add %r8, %rax
+	imul $6, %r9
+	add %r9, %rax
+	ret
+`
+	// returns rsp modulo 16 in front of the call
+	alignment := `
+_start:
+	lea 8(%rsp), %rax
+	and $15, %rax
+	ret
+`
+	// writes rdi to 32 KiB of the stack and returns their sum
+	fill := `
+_start:
+	push %rbx
+	sub $32768, %rsp
+	mov $4096, %ecx
+store:
+	mov %rdi, -8(%rsp,%rcx,8)
+	dec %rcx
+	jnz store
+	xor %eax, %eax
+	mov $4096, %ecx
+load:
+	add -8(%rsp,%rcx,8), %rax
+	dec %rcx
+	jnz load
+	add $32768, %rsp
+	pop %rbx
+	ret
+`
+	tests := []struct {
+		name     string
+		source   string
+		args     []uint64
+		expected uint64
+	}{
+		{"weighted", weighted, []uint64{1, 2, 3, 4, 5, 6}, 1 + 4 + 9 + 16 + 25 + 36},
+		{"weighted", weighted, []uint64{1, 1}, 3},
+		{"weighted", weighted, nil, 0},
+		{"weighted", weighted, []uint64{0, 0, 0, 0, 0, 1 << 60}, 6 << 60},
+		{"alignment", alignment, nil, 0},
+		{"fill", fill, []uint64{3}, 3 * 4096},
+	}
+	for _, test := range tests {
+		m, entryPoint := assemble(t, test.source)
+		result, err := m.Call(entryPoint, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != test.expected {
+			t.Errorf("expected %s%v = %d got %d", test.name, test.args, test.expected, result)
+		}
+		if _, err := m.Call(entryPoint, 1, 2, 3, 4, 5, 6, 7); err == nil {
+			t.Error("expected error for 7 arguments")
+		}
+		m.Free()
+	}
+}
+
+// touch writes to rsp - rdi, the return address is at rsp.
+const touch = `
+_start:
+	mov %rsp, %rax
+	sub %rdi, %rax
+	mov %rdi, (%rax)
+	xor %eax, %eax
+	ret
+`
+
+// TestStack uses the last bytes of the stack.
+func TestStack(t *testing.T) {
+	m, entryPoint := assemble(t, touch)
+	defer m.Free()
+	for _, n := range []uint64{8, StackSize / 2, StackSize - 8} {
+		if _, err := m.Call(entryPoint, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestStackOverflow writes to the guard page below the stack in a child
+// process, which has to crash.
+func TestStackOverflow(t *testing.T) {
+	if os.Getenv("JIT_STACK_OVERFLOW") == "1" {
+		m, entryPoint := assemble(t, touch)
+		m.Call(entryPoint, StackSize)
+		t.Fatal("the guard page was written")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestStackOverflow$")
+	cmd.Env = append(os.Environ(), "JIT_STACK_OVERFLOW=1")
+	output, err := cmd.CombinedOutput()
+	exitErr := &exec.ExitError{}
+	if !errors.As(err, &exitErr) || !strings.Contains(string(output), "SIGSEGV") {
+		t.Errorf("expected a crash with SIGSEGV got %v: %s", err, output)
+	}
+}
+
+// TestClobber sets xmm15, which is zero in Go code and used to zero memory,
+// but caller saved in the System V ABI.
+func TestClobber(t *testing.T) {
+	m, entryPoint := assemble(t, "_start:\n  mov $2, %eax\n  cvtsi2sd %rax, %xmm15\n  ret\n")
+	defer m.Free()
+	if _, err := m.Call(entryPoint); err != nil {
+		t.Fatal(err)
+	}
+	var zero [4]uint64
+	if sum := sum(&zero); sum != 0 {
+		t.Errorf("expected zero got %d", sum)
+	}
+}
+
+//go:noinline
+func sum(values *[4]uint64) uint64 {
+	return values[0] + values[1] + values[2] + values[3]
+}
+
+// TestProtect checks the permissions of the mapping in /proc/self/maps.
+func TestProtect(t *testing.T) {
+	m, err := Alloc(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Free()
+	if permissions := mapping(t, m.Address()); permissions != "rw-p" {
+		t.Errorf("expected rw-p before Protect got %s", permissions)
+	}
+	if err := m.Write(encode(t, instruction{op: x86.RET})); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Protect(); err != nil {
+		t.Fatal(err)
+	}
+	if permissions := mapping(t, m.Address()); permissions != "r-xp" {
+		t.Errorf("expected r-xp after Protect got %s", permissions)
+	}
+}
+
+// mapping returns the permissions of the mapping which starts at address.
+func mapping(t *testing.T, address uint64) string {
+	t.Helper()
+	f, err := os.Open("/proc/self/maps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	prefix := fmt.Sprintf("%x-", address)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if strings.HasPrefix(fields[0], prefix) {
+			return fields[1]
+		}
+	}
+	t.Fatalf("mapping at 0x%x not found", address)
+	return ""
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := Alloc(0); err == nil || err.Error() != "invalid size 0" {
+		t.Errorf("expected error for size 0 got %v", err)
+	}
+
+	m, err := Alloc(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := encode(t, instruction{op: x86.RET})
+	if err := m.Write(make([]byte, m.Size()+1)); err == nil {
+		t.Error("expected error for code larger than the memory")
+	}
+	if err := m.Write(ret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Call(m.Address()); err == nil || err.Error() != "memory is not executable" {
+		t.Errorf("expected error for writable memory got %v", err)
+	}
+	if err := m.Protect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(ret); err == nil || err.Error() != "memory is executable and can't be written" {
+		t.Errorf("expected error for executable memory got %v", err)
+	}
+	if _, err := m.Call(m.Address() + 1); err == nil {
+		t.Error("expected error for entry point after the code")
+	}
+	if err := m.Free(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Call(m.Address()); err == nil || err.Error() != "memory has been freed" {
+		t.Errorf("expected error for freed memory got %v", err)
+	}
+	if err := m.Free(); err == nil {
+		t.Error("expected error for freeing twice")
+	}
+}
@@ -0,0 +1,325 @@
+package elf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"go-elf/x86"
+	"math"
+)
+
+// reference is the target of an instruction, either a label or an absolute
+// address outside of the code (e.g. a PLT entry).
+type reference struct {
+	label string
+	addr  uint64
+}
+
+// fixup is an instruction whose operand depends on the address of a
+// reference. The instruction is emitted with a preliminary address and
+// encoded again by resolve, once all labels are defined and the sizes of the
+// jumps are known. A fixup without operands is not an instruction but the
+// address itself as data of length bytes.
+type fixup struct {
+	position int // in buf
+	length   int
+	op       x86.Op
+	operands []x86.Operand
+	operand  int // index of the operand which gets the address
+	target   reference
+
+	// relaxable jumps are emitted with a 32 bit displacement and are
+	// shortened to 8 bit if the target is close enough
+	relaxable bool
+	short     bool
+}
+
+// shortJumpLength is the length of jmp rel8 and jcc rel8.
+const shortJumpLength = 2
+
+// alignment is padding in buf up to a multiple of align. The padding is
+// recomputed by resolve, as shortening a jump in front of it moves the code.
+// Whatever is emitted after the padding at the same position stays after it.
+type alignment struct {
+	position int // in buf
+	length   int // of the padding in buf
+	align    uint64
+	fill     byte
+
+	// padding is the length of the padding after shortening the jumps
+	padding int
+}
+
+// pad appends padding with fill up to a multiple of align, see alignment.
+func (c *Compiler) pad(align uint64, fill byte) {
+	c.peepholeBarrier()
+	a := alignment{position: len(c.buf), align: align, fill: fill}
+	for uint64(len(c.buf))%align != 0 {
+		c.buf = append(c.buf, fill)
+	}
+	a.length = len(c.buf) - a.position
+	a.padding = a.length
+	c.alignments = append(c.alignments, a)
+}
+
+// label defines the label name at the current position.
+func (c *Compiler) label(name string) {
+	if c.labels == nil {
+		c.labels = map[string]int{}
+	}
+	if _, ok := c.labels[name]; ok {
+		panic("label " + name + " already defined")
+	}
+	c.peepholeBarrier()
+	c.labels[name] = len(c.buf)
+}
+
+// newLabel returns a new unique label name for local jumps in helpers.
+func (c *Compiler) newLabel() string {
+	c.labelCount++
+	return fmt.Sprintf(".L%d", c.labelCount)
+}
+
+// emitJump appends a jmp, jcc or call to a label which may be defined later.
+func (c *Compiler) emitJump(op x86.Op, label string) {
+	_, conditional := op.Condition()
+	c.emitFixup(reference{label: label}, op, 0, x86.Rel(0))
+	c.fixups[len(c.fixups)-1].relaxable = op == x86.JMP || conditional
+}
+
+// emitFixup appends the instruction op whose operand at index operand
+// references target:
+//   - x86.Rel: displacement relative to the end of the instruction
+//   - x86.Mem with RIP set: displacement relative to the end of the instruction
+//   - x86.Mem: absolute address as displacement
+//   - x86.Imm: absolute address as immediate
+func (c *Compiler) emitFixup(target reference, op x86.Op, operand int, operands ...x86.Operand) {
+	err := c.addFixup(fixup{op: op, operands: operands, operand: operand, target: target})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// emitAddress appends the absolute address of target as data of size bytes
+// (4 or 8).
+func (c *Compiler) emitAddress(target reference, size int) error {
+	if size != 4 && size != 8 {
+		return fmt.Errorf("invalid address size %d", size)
+	}
+	return c.addFixup(fixup{length: size, target: target})
+}
+
+// addFixup appends the instruction or data of f.
+func (c *Compiler) addFixup(f fixup) error {
+	f.position = len(c.buf)
+	// encode with the address the target would have if it was defined at
+	// the current position, so the size of the instruction is the same as
+	// with the final address in most cases
+	code, err := f.encode(c.startAddr+uint64(len(c.buf)), c.startAddr+uint64(len(c.buf)))
+	if err != nil {
+		return err
+	}
+	f.length = len(code)
+	c.buf = append(c.buf, code...)
+	c.fixups = append(c.fixups, f)
+	return nil
+}
+
+// encode encodes the instruction at address addr with the target address.
+func (f *fixup) encode(addr uint64, target uint64) ([]byte, error) {
+	if f.operands == nil {
+		if f.length == 4 && target > math.MaxUint32 {
+			return nil, fmt.Errorf("address 0x%x does not fit into 32 bits", target)
+		}
+		return binary.LittleEndian.AppendUint64(nil, target)[:f.length], nil
+	}
+
+	length := uint64(f.length)
+	if f.short {
+		length = shortJumpLength
+	}
+	end := addr + length
+
+	operands := append([]x86.Operand{}, f.operands...)
+	switch operand := operands[f.operand].(type) {
+	case x86.Rel:
+		displacement := int64(target - end)
+		if f.short {
+			operands[f.operand] = x86.Rel8(displacement)
+		} else {
+			operands[f.operand] = x86.Rel(displacement)
+		}
+	case x86.Mem:
+		if operand.RIP {
+			operand.Disp = int32(target - end)
+		} else {
+			operand.Disp = int32(target)
+		}
+		operands[f.operand] = operand
+	case x86.Imm:
+		operands[f.operand] = x86.Imm(target)
+	default:
+		return nil, fmt.Errorf("operand %d of %s can't reference an address", f.operand, f.op)
+	}
+	return x86.Encode(f.op, operands...)
+}
+
+// resolve encodes all fixups with the final addresses of their targets. The
+// labels of the data are placed after the code as by the Writer.
+// Jumps whose target is within -128 to 127 bytes are shortened, which moves
+// the code following them and changes the padding of the alignments behind
+// them. As a padding can grow, a short jump can get out of range again; it
+// is then emitted with a 32 bit displacement for good, so the shortening
+// terminates.
+func (c *Compiler) resolve() error {
+	for _, f := range c.fixups {
+		if f.target.label == "" {
+			continue
+		}
+		if _, ok := c.labels[f.target.label]; ok {
+			continue
+		}
+		if c.data == nil {
+			return fmt.Errorf("undefined label %s", f.target.label)
+		}
+		if _, err := c.data.Address(f.target.label, 0); err != nil {
+			return err
+		}
+	}
+	if c.data != nil {
+		for name := range c.data.labels {
+			if _, ok := c.labels[name]; ok {
+				return fmt.Errorf("label %s defined in the code and in the data", name)
+			}
+		}
+	}
+
+	// moved returns the position in buf after shortening the jumps and
+	// recomputing the padding of the first n alignments. A position at an
+	// alignment is behind its padding, unless it is the end of something
+	// emitted in front of it.
+	moved := func(position int, n int, end bool) int {
+		moved := position
+		for _, f := range c.fixups {
+			if f.short && f.position < position {
+				moved -= f.length - shortJumpLength
+			}
+		}
+		for _, a := range c.alignments[:n] {
+			if a.position < position || (a.position == position && !end) {
+				moved += a.padding - a.length
+			}
+		}
+		return moved
+	}
+	newPosition := func(position int) int {
+		return moved(position, len(c.alignments), false)
+	}
+	updatePadding := func() {
+		for i := range c.alignments {
+			a := &c.alignments[i]
+			start := uint64(moved(a.position, i, false))
+			a.padding = int(alignUp(start, a.align) - start)
+		}
+	}
+	targetAddress := func(target reference) uint64 {
+		if target.label == "" {
+			return target.addr
+		}
+		position, ok := c.labels[target.label]
+		if !ok {
+			// the data follows the code with the jumps shortened so far
+			addr, _ := c.data.Address(target.label, c.startAddr+uint64(newPosition(len(c.buf))))
+			return addr
+		}
+		return c.startAddr + uint64(newPosition(position))
+	}
+	fits := func(f *fixup) bool {
+		end := c.startAddr + uint64(newPosition(f.position)+shortJumpLength)
+		displacement := int64(targetAddress(f.target) - end)
+		return displacement >= -128 && displacement <= 127
+	}
+
+	long := make([]bool, len(c.fixups)) // jumps which got out of range
+	for changed := true; changed; {
+		changed = false
+		for i := range c.fixups {
+			f := &c.fixups[i]
+			if !f.relaxable || f.short || long[i] {
+				continue
+			}
+			f.short = true
+			updatePadding()
+			if fits(f) {
+				changed = true
+			} else {
+				f.short = false
+				updatePadding()
+			}
+		}
+		for i := range c.fixups {
+			f := &c.fixups[i]
+			if f.short && !fits(f) {
+				f.short = false
+				long[i] = true
+				updatePadding()
+				changed = true
+			}
+		}
+	}
+
+	buf := make([]byte, 0, len(c.buf))
+	last := 0
+	next := 0 // alignment
+	for i := range c.fixups {
+		f := &c.fixups[i]
+		for ; next < len(c.alignments) && c.alignments[next].position <= f.position; next++ {
+			buf = c.appendPadding(buf, &c.alignments[next], last)
+			last = c.alignments[next].position + c.alignments[next].length
+		}
+		buf = append(buf, c.buf[last:f.position]...)
+		code, err := f.encode(c.startAddr+uint64(len(buf)), targetAddress(f.target))
+		if err != nil {
+			return err
+		}
+		if !f.short && len(code) != f.length {
+			return fmt.Errorf("size of %s at 0x%x changed from %d to %d bytes", f.op, c.startAddr+uint64(len(buf)), f.length, len(code))
+		}
+		buf = append(buf, code...)
+		last = f.position + f.length
+	}
+	for ; next < len(c.alignments); next++ {
+		buf = c.appendPadding(buf, &c.alignments[next], last)
+		last = c.alignments[next].position + c.alignments[next].length
+	}
+	buf = append(buf, c.buf[last:]...)
+
+	for i := range c.functions {
+		fn := &c.functions[i]
+		start := newPosition(int(fn.Address - c.startAddr))
+		end := moved(int(fn.Address+fn.Size-c.startAddr), len(c.alignments), true)
+		fn.Address = c.startAddr + uint64(start)
+		fn.Size = uint64(end - start)
+	}
+	for i := range c.sections {
+		s := &c.sections[i]
+		s.Address = c.startAddr + uint64(newPosition(int(s.Address-c.startAddr)))
+	}
+	for name, position := range c.labels {
+		c.labels[name] = newPosition(position)
+	}
+
+	c.buf = buf
+	c.fixups = nil
+	c.alignments = nil
+	return nil
+}
+
+// appendPadding appends the code in front of the alignment a, which starts
+// at last, and its recomputed padding.
+func (c *Compiler) appendPadding(buf []byte, a *alignment, last int) []byte {
+	buf = append(buf, c.buf[last:a.position]...)
+	for range a.padding {
+		buf = append(buf, a.fill)
+	}
+	return buf
+}
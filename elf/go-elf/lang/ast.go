@@ -0,0 +1,169 @@
+package lang
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is a node of the syntax tree.
+type Node interface {
+	Position() Pos
+}
+
+// Stmt is a statement: *VarStmt, *AssignStmt, *IfStmt, *WhileStmt,
+// *ReturnStmt, *ExprStmt or a nested *Block.
+type Stmt interface {
+	Node
+	stmt()
+}
+
+// Expr is an expression: *NumberLit, *StringLit, *Ident, *Unary, *Binary
+// or *Call.
+type Expr interface {
+	Node
+	expr()
+}
+
+// Program is a parsed source file.
+type Program struct {
+	Functions []*Function
+}
+
+// Function is a function definition.
+type Function struct {
+	Pos
+	Name   string
+	Params []string
+	Body   *Block
+}
+
+// Block is a list of statements in braces, which is a scope for variables.
+type Block struct {
+	Pos
+	Stmts []Stmt
+}
+
+// VarStmt declares the variable Name with an initial value:
+// var Name = Value;
+type VarStmt struct {
+	Pos
+	Name  string
+	Value Expr
+}
+
+// AssignStmt assigns a new value to a variable: Name = Value;
+type AssignStmt struct {
+	Pos
+	Name  string
+	Value Expr
+}
+
+// IfStmt executes Then if Cond is not zero, otherwise Else. Else is nil, a
+// *Block or an *IfStmt for else if.
+type IfStmt struct {
+	Pos
+	Cond Expr
+	Then *Block
+	Else Stmt
+}
+
+// WhileStmt executes Body as long as Cond is not zero.
+type WhileStmt struct {
+	Pos
+	Cond Expr
+	Body *Block
+}
+
+// ReturnStmt returns from the function. Value is nil for return without a
+// value, which returns 0.
+type ReturnStmt struct {
+	Pos
+	Value Expr
+}
+
+// ExprStmt is an expression whose value is not used, usually a call.
+type ExprStmt struct {
+	Pos
+	X Expr
+}
+
+// NumberLit is an integer constant.
+type NumberLit struct {
+	Pos
+	Value int64
+}
+
+// StringLit is a string constant, which is only allowed as argument of
+// print.
+type StringLit struct {
+	Pos
+	Value string
+}
+
+// Ident is the value of a variable.
+type Ident struct {
+	Pos
+	Name string
+}
+
+// Unary is an operation with one operand: -X or !X.
+type Unary struct {
+	Pos
+	Op TokenKind
+	X  Expr
+}
+
+// Binary is an operation with two operands. && and || only evaluate Y if
+// the result is not known from X.
+type Binary struct {
+	Pos
+	Op TokenKind
+	X  Expr
+	Y  Expr
+}
+
+// Call calls a function or a builtin.
+type Call struct {
+	Pos
+	Name string
+	Args []Expr
+}
+
+func (*VarStmt) stmt()    {}
+func (*AssignStmt) stmt() {}
+func (*IfStmt) stmt()     {}
+func (*WhileStmt) stmt()  {}
+func (*ReturnStmt) stmt() {}
+func (*ExprStmt) stmt()   {}
+func (*Block) stmt()      {}
+
+func (*NumberLit) expr() {}
+func (*StringLit) expr() {}
+func (*Ident) expr()     {}
+func (*Unary) expr()     {}
+func (*Binary) expr()    {}
+func (*Call) expr()      {}
+
+// Format returns the expression in source syntax with parentheses around
+// every operation, e.g. (1 + (2 * x)).
+func Format(x Expr) string {
+	switch x := x.(type) {
+	case *NumberLit:
+		return fmt.Sprint(x.Value)
+	case *StringLit:
+		return fmt.Sprintf("%q", x.Value)
+	case *Ident:
+		return x.Name
+	case *Unary:
+		return fmt.Sprintf("(%s%s)", x.Op, Format(x.X))
+	case *Binary:
+		return fmt.Sprintf("(%s %s %s)", Format(x.X), x.Op, Format(x.Y))
+	case *Call:
+		args := make([]string, len(x.Args))
+		for i, arg := range x.Args {
+			args[i] = Format(arg)
+		}
+		return fmt.Sprintf("%s(%s)", x.Name, strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("%T", x)
+}
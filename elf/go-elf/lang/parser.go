@@ -0,0 +1,312 @@
+package lang
+
+import (
+	"strconv"
+)
+
+// Parse parses the source of a program.
+func Parse(source string) (*Program, error) {
+	tokens, err := Lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	program := &Program{}
+	for p.peek().Kind != EOF {
+		f, err := p.function()
+		if err != nil {
+			return nil, err
+		}
+		program.Functions = append(program.Functions, f)
+	}
+	return program, nil
+}
+
+type parser struct {
+	tokens []Token
+	pos    int // index of the next token
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	t := p.tokens[p.pos]
+	if t.Kind != EOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is of kind.
+func (p *parser) accept(kind TokenKind) bool {
+	if p.peek().Kind == kind {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// expect consumes the next token, which must be of kind.
+func (p *parser) expect(kind TokenKind) (Token, error) {
+	t := p.next()
+	if t.Kind != kind {
+		return t, Errorf(t.Pos, "expected %s, found %s", kind, t)
+	}
+	return t, nil
+}
+
+// function parses: func name(param, ...) { ... }
+func (p *parser) function() (*Function, error) {
+	start, err := p.expect(FUNC)
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.expect(IDENT)
+	if err != nil {
+		return nil, err
+	}
+	f := &Function{Pos: start.Pos, Name: name.Text}
+	if _, err := p.expect(LPAREN); err != nil {
+		return nil, err
+	}
+	for !p.accept(RPAREN) {
+		if len(f.Params) > 0 {
+			if _, err := p.expect(COMMA); err != nil {
+				return nil, err
+			}
+		}
+		param, err := p.expect(IDENT)
+		if err != nil {
+			return nil, err
+		}
+		f.Params = append(f.Params, param.Text)
+	}
+	f.Body, err = p.block()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) block() (*Block, error) {
+	start, err := p.expect(LBRACE)
+	if err != nil {
+		return nil, err
+	}
+	b := &Block{Pos: start.Pos}
+	for !p.accept(RBRACE) {
+		if p.peek().Kind == EOF {
+			return nil, Errorf(p.peek().Pos, "expected }, found %s", p.peek())
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		b.Stmts = append(b.Stmts, s)
+	}
+	return b, nil
+}
+
+func (p *parser) statement() (Stmt, error) {
+	t := p.peek()
+	switch t.Kind {
+	case LBRACE:
+		return p.block()
+
+	case VAR:
+		p.next()
+		name, err := p.expect(IDENT)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(ASSIGN); err != nil {
+			return nil, err
+		}
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return &VarStmt{Pos: t.Pos, Name: name.Text, Value: value}, p.semicolon()
+
+	case IF:
+		return p.ifStatement()
+
+	case WHILE:
+		p.next()
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &WhileStmt{Pos: t.Pos, Cond: cond, Body: body}, nil
+
+	case RETURN:
+		p.next()
+		s := &ReturnStmt{Pos: t.Pos}
+		if p.peek().Kind != SEMICOLON {
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			s.Value = value
+		}
+		return s, p.semicolon()
+
+	case IDENT:
+		if p.tokens[p.pos+1].Kind == ASSIGN {
+			p.next()
+			p.next()
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return &AssignStmt{Pos: t.Pos, Name: t.Text, Value: value}, p.semicolon()
+		}
+	}
+
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &ExprStmt{Pos: t.Pos, X: x}, p.semicolon()
+}
+
+func (p *parser) semicolon() error {
+	_, err := p.expect(SEMICOLON)
+	return err
+}
+
+// ifStatement parses: if cond { ... } else if cond { ... } else { ... }
+func (p *parser) ifStatement() (*IfStmt, error) {
+	start, err := p.expect(IF)
+	if err != nil {
+		return nil, err
+	}
+	cond, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	s := &IfStmt{Pos: start.Pos, Cond: cond, Then: then}
+	if !p.accept(ELSE) {
+		return s, nil
+	}
+	if p.peek().Kind == IF {
+		s.Else, err = p.ifStatement()
+	} else {
+		s.Else, err = p.block()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// precedence of the binary operators, higher binds stronger
+var precedence = map[TokenKind]int{
+	LOR:  1,
+	LAND: 2,
+	EQL:  3,
+	NEQ:  3,
+	LSS:  3,
+	LEQ:  3,
+	GTR:  3,
+	GEQ:  3,
+	ADD:  4,
+	SUB:  4,
+	MUL:  5,
+	QUO:  5,
+	REM:  5,
+}
+
+func (p *parser) expression() (Expr, error) {
+	return p.binary(1)
+}
+
+// binary parses an expression whose operators have at least the precedence
+// minPrecedence. Operators of the same precedence are left associative.
+func (p *parser) binary(minPrecedence int) (Expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		prec, ok := precedence[op.Kind]
+		if !ok || prec < minPrecedence {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{Pos: op.Pos, Op: op.Kind, X: x, Y: y}
+	}
+}
+
+func (p *parser) unary() (Expr, error) {
+	t := p.peek()
+	if t.Kind == SUB || t.Kind == NOT {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{Pos: t.Pos, Op: t.Kind, X: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.next()
+	switch t.Kind {
+	case NUMBER:
+		value, err := strconv.ParseInt(t.Text, 0, 64)
+		if err != nil {
+			return nil, Errorf(t.Pos, "invalid number %s", t.Text)
+		}
+		return &NumberLit{Pos: t.Pos, Value: value}, nil
+
+	case STRING:
+		return &StringLit{Pos: t.Pos, Value: t.Text}, nil
+
+	case IDENT:
+		if !p.accept(LPAREN) {
+			return &Ident{Pos: t.Pos, Name: t.Text}, nil
+		}
+		call := &Call{Pos: t.Pos, Name: t.Text}
+		for !p.accept(RPAREN) {
+			if len(call.Args) > 0 {
+				if _, err := p.expect(COMMA); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+		}
+		return call, nil
+
+	case LPAREN:
+		x, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(RPAREN); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+	return nil, Errorf(t.Pos, "expected expression, found %s", t)
+}
@@ -0,0 +1,157 @@
+package lang
+
+import (
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tokens, err := Lex("func f(a) { // comment\n\treturn a<=0x1f && !b; \"x\\n\" }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		kind TokenKind
+		text string
+		pos  Pos
+	}{
+		{FUNC, "func", Pos{1, 1}},
+		{IDENT, "f", Pos{1, 6}},
+		{LPAREN, "", Pos{1, 7}},
+		{IDENT, "a", Pos{1, 8}},
+		{RPAREN, "", Pos{1, 9}},
+		{LBRACE, "", Pos{1, 11}},
+		{RETURN, "return", Pos{2, 2}},
+		{IDENT, "a", Pos{2, 9}},
+		{LEQ, "", Pos{2, 10}},
+		{NUMBER, "0x1f", Pos{2, 12}},
+		{LAND, "", Pos{2, 17}},
+		{NOT, "", Pos{2, 20}},
+		{IDENT, "b", Pos{2, 21}},
+		{SEMICOLON, "", Pos{2, 22}},
+		{STRING, "x\n", Pos{2, 24}},
+		{RBRACE, "", Pos{2, 30}},
+		{EOF, "", Pos{2, 31}},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens got %d: %v", len(expected), len(tokens), tokens)
+	}
+	for i, e := range expected {
+		if tokens[i].Kind != e.kind || tokens[i].Text != e.text || tokens[i].Pos != e.pos {
+			t.Errorf("token %d: expected %s %q at %s got %s %q at %s", i, e.kind, e.text, e.pos, tokens[i].Kind, tokens[i].Text, tokens[i].Pos)
+		}
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"a - b - c", "((a - b) - c)"},
+		{"a / b % c", "((a / b) % c)"},
+		{"-a * -b", "((-a) * (-b))"},
+		{"!a == b", "((!a) == b)"},
+		{"a < b && c >= d || e != f", "(((a < b) && (c >= d)) || (e != f))"},
+		{"a || b && c", "(a || (b && c))"},
+		{"f(1, g(x) + 2, \"s\")", "f(1, (g(x) + 2), \"s\")"},
+		{"0x10 + 010", "(16 + 8)"},
+	}
+	for _, test := range tests {
+		program, err := Parse("func main() { " + test.source + "; }")
+		if err != nil {
+			t.Errorf("%s: %s", test.source, err)
+			continue
+		}
+		s := program.Functions[0].Body.Stmts[0].(*ExprStmt)
+		if got := Format(s.X); got != test.expected {
+			t.Errorf("%s: expected %s got %s", test.source, test.expected, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	source := `
+func add(a, b) {
+	return a + b;
+}
+
+func main() {
+	var x = add(1, 2);
+	if x > 2 {
+		x = 0;
+	} else if x < 0 {
+		return;
+	} else {
+		print(x);
+	}
+	while x {
+		x = x - 1;
+	}
+}
+`
+	program, err := Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(program.Functions) != 2 {
+		t.Fatalf("expected 2 functions got %d", len(program.Functions))
+	}
+	add := program.Functions[0]
+	if add.Name != "add" || len(add.Params) != 2 || add.Params[1] != "b" || add.Pos != (Pos{2, 1}) {
+		t.Errorf("unexpected function %s(%v) at %s", add.Name, add.Params, add.Pos)
+	}
+
+	stmts := program.Functions[1].Body.Stmts
+	if len(stmts) != 3 {
+		t.Fatalf("expected 3 statements got %d", len(stmts))
+	}
+	v, ok := stmts[0].(*VarStmt)
+	if !ok || v.Name != "x" || Format(v.Value) != "add(1, 2)" {
+		t.Errorf("unexpected var statement %#v", stmts[0])
+	}
+	ifStmt, ok := stmts[1].(*IfStmt)
+	if !ok {
+		t.Fatalf("expected if statement got %T", stmts[1])
+	}
+	elseIf, ok := ifStmt.Else.(*IfStmt)
+	if !ok {
+		t.Fatalf("expected else if got %T", ifStmt.Else)
+	}
+	if r, ok := elseIf.Then.Stmts[0].(*ReturnStmt); !ok || r.Value != nil {
+		t.Errorf("expected return without value got %#v", elseIf.Then.Stmts[0])
+	}
+	if _, ok := elseIf.Else.(*Block); !ok {
+		t.Errorf("expected else block got %T", elseIf.Else)
+	}
+	if w, ok := stmts[2].(*WhileStmt); !ok || Format(w.Cond) != "x" {
+		t.Errorf("unexpected while statement %#v", stmts[2])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"var x = 1;", "1:1: expected func, found var"},
+		{"func main() {\n  x = ;\n}", "2:7: expected expression, found ;"},
+		{"func main() {\n  x = 1\n}", "3:1: expected ;, found }"},
+		{"func main() { return 0x; }", "1:22: invalid number 0x"},
+		{"func main() { print(\"abc); }", "1:21: string not terminated"},
+		{"func main() { x = 1 $ 2; }", "1:21: unexpected character '$'"},
+		{"func main(a b) {}", "1:13: expected ,, found b"},
+		{"func main() { if x { }", "1:23: expected }, found end of file"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.source)
+		if err == nil {
+			t.Errorf("%q: expected error %q", test.source, test.err)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%q: expected error %q got %q", test.source, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package lang
+
+import (
+	"fmt"
+	"go-elf/ir"
+	"maps"
+	"slices"
+)
+
+// Builtins are the functions provided by the language.
+var Builtins = map[string]bool{
+	"print": true,
+	"exit":  true,
+	"alloc": true,
+	"free":  true,
+	"load":  true,
+	"store": true,
+	"argc":  true,
+	"argv":  true,
+	"envp":  true,
+	"auxv":  true,
+}
+
+// opBuiltins are the builtin functions which are a single operation of the
+// IR, with the operation and their number of arguments:
+//
+//	alloc(size)     address of a new block of size bytes, 0 if out of memory
+//	free(p)         releases the block p returned by alloc
+//	load(p)         the 64 bit integer at the address p
+//	store(p, value) writes value to the address p
+//	argc()          number of arguments including the program name
+//	argv()          address of the argument pointers, ending with 0
+//	envp()          address of the environment pointers, ending with 0
+//	auxv()          address of the auxiliary vector of type and value pairs
+var opBuiltins = map[string]struct {
+	op   ir.Op
+	args int
+}{
+	"alloc": {ir.OpAlloc, 1},
+	"free":  {ir.OpFree, 1},
+	"load":  {ir.OpLoad, 1},
+	"store": {ir.OpStore, 2},
+	"argc":  {ir.OpArgc, 0},
+	"argv":  {ir.OpArgv, 0},
+	"envp":  {ir.OpEnvp, 0},
+	"auxv":  {ir.OpAuxv, 0},
+}
+
+// MaxParams is the maximum number of parameters of a function, which are
+// passed in registers.
+const MaxParams = 6
+
+// BuildIR checks the program and translates it to the intermediate
+// representation in SSA form.
+//
+// The SSA form is constructed directly from the syntax tree with the
+// algorithm of Braun et al., "Simple and Efficient Construction of Static
+// Single Assignment Form": an assignment records the value of a variable in
+// the current block and a read looks the variable up recursively in the
+// predecessors, creating phi values where control flow joins. Blocks whose
+// predecessors are not all known yet, e.g. loop headers, get phi values
+// without arguments, which are completed once the block is sealed.
+func BuildIR(program *Program) (*ir.Program, error) {
+	functions := map[string]*Function{}
+	for _, f := range program.Functions {
+		if _, ok := functions[f.Name]; ok {
+			return nil, Errorf(f.Pos, "function %s already defined", f.Name)
+		}
+		if Builtins[f.Name] {
+			return nil, Errorf(f.Pos, "%s is a builtin function", f.Name)
+		}
+		if len(f.Params) > MaxParams {
+			return nil, Errorf(f.Pos, "function %s has more than %d parameters", f.Name, MaxParams)
+		}
+		functions[f.Name] = f
+	}
+	main, ok := functions["main"]
+	if !ok {
+		return nil, fmt.Errorf("function main is not defined")
+	}
+	if len(main.Params) != 0 {
+		return nil, Errorf(main.Pos, "function main must not have parameters")
+	}
+
+	p := &ir.Program{}
+	for _, f := range program.Functions {
+		b := &builder{
+			functions:  functions,
+			f:          ir.NewFunc(f.Name, len(f.Params)),
+			definition: map[*ir.Block]map[int]*ir.Value{},
+			sealed:     map[*ir.Block]bool{},
+			incomplete: map[*ir.Block]map[int]*ir.Value{},
+		}
+		if err := b.function(f); err != nil {
+			return nil, err
+		}
+		p.Funcs = append(p.Funcs, b.f)
+	}
+	return p, nil
+}
+
+// builder translates a function to SSA form. Every declaration of a variable
+// is a separate variable number, so shadowed variables are distinct.
+type builder struct {
+	functions map[string]*Function
+	f         *ir.Func
+	block     *ir.Block // current block
+
+	scopes    []map[string]int // variable numbers by name
+	variables int
+
+	// values of the variables at the end of the blocks
+	definition map[*ir.Block]map[int]*ir.Value
+	// blocks whose predecessors are all known
+	sealed map[*ir.Block]bool
+	// phi values of unsealed blocks which get their arguments once the
+	// block is sealed
+	incomplete map[*ir.Block]map[int]*ir.Value
+}
+
+func (b *builder) function(f *Function) error {
+	entry := b.f.Entry()
+	b.seal(entry)
+	b.block = entry
+	b.scopes = []map[string]int{{}}
+	for i, name := range f.Params {
+		v, err := b.declare(f.Pos, name)
+		if err != nil {
+			return err
+		}
+		param := entry.NewValue(ir.OpParam)
+		param.AuxInt = int64(i)
+		b.write(v, entry, param)
+	}
+
+	if err := b.blockStmt(f.Body); err != nil {
+		return err
+	}
+	// functions without return statement at the end return 0
+	b.block.Return(b.block.NewConst(0))
+
+	b.f.RemoveUnreachable()
+	b.f.RemoveTrivialPhis()
+	return b.f.Verify()
+}
+
+// declare creates a new variable in the innermost scope.
+func (b *builder) declare(pos Pos, name string) (int, error) {
+	scope := b.scopes[len(b.scopes)-1]
+	if _, ok := scope[name]; ok {
+		return 0, Errorf(pos, "%s already declared", name)
+	}
+	if _, ok := b.functions[name]; ok || Builtins[name] {
+		return 0, Errorf(pos, "%s is a function", name)
+	}
+	scope[name] = b.newVariable()
+	return scope[name], nil
+}
+
+// newVariable returns a new variable number, which is also used for
+// temporary values of && and ||.
+func (b *builder) newVariable() int {
+	b.variables++
+	return b.variables
+}
+
+// lookup returns the number of the variable declared in the innermost scope.
+func (b *builder) lookup(pos Pos, name string) (int, error) {
+	for i := len(b.scopes) - 1; i >= 0; i-- {
+		if v, ok := b.scopes[i][name]; ok {
+			return v, nil
+		}
+	}
+	return 0, Errorf(pos, "undefined variable %s", name)
+}
+
+func (b *builder) write(variable int, block *ir.Block, value *ir.Value) {
+	if b.definition[block] == nil {
+		b.definition[block] = map[int]*ir.Value{}
+	}
+	b.definition[block][variable] = value
+}
+
+func (b *builder) read(variable int, block *ir.Block) *ir.Value {
+	if v, ok := b.definition[block][variable]; ok {
+		return v
+	}
+
+	var v *ir.Value
+	switch {
+	case !b.sealed[block]:
+		v = block.NewPhi()
+		if b.incomplete[block] == nil {
+			b.incomplete[block] = map[int]*ir.Value{}
+		}
+		b.incomplete[block][variable] = v
+	case len(block.Preds) == 1:
+		v = b.read(variable, block.Preds[0])
+	case len(block.Preds) == 0:
+		// unreachable code, e.g. after a return
+		v = block.NewConst(0)
+	default:
+		// the phi is recorded first to break cycles in loops
+		v = block.NewPhi()
+		b.write(variable, block, v)
+		b.addPhiArgs(variable, v)
+	}
+	b.write(variable, block, v)
+	return v
+}
+
+func (b *builder) addPhiArgs(variable int, phi *ir.Value) {
+	for _, pred := range phi.Block.Preds {
+		phi.Args = append(phi.Args, b.read(variable, pred))
+	}
+}
+
+// seal marks the block as having all its predecessors and completes its phi
+// values.
+func (b *builder) seal(block *ir.Block) {
+	// in the order of the variables, so the IDs of the values don't depend
+	// on the order of the map
+	variables := slices.Sorted(maps.Keys(b.incomplete[block]))
+	for _, variable := range variables {
+		b.addPhiArgs(variable, b.incomplete[block][variable])
+	}
+	delete(b.incomplete, block)
+	b.sealed[block] = true
+}
+
+// startUnreachable continues the code in a new block without predecessors,
+// which is unreachable unless a jump to it is added.
+func (b *builder) startUnreachable() {
+	b.block = b.f.NewBlock()
+	b.seal(b.block)
+}
+
+func (b *builder) blockStmt(block *Block) error {
+	b.scopes = append(b.scopes, map[string]int{})
+	defer func() {
+		b.scopes = b.scopes[:len(b.scopes)-1]
+	}()
+	for _, s := range block.Stmts {
+		if err := b.statement(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *builder) statement(s Stmt) error {
+	switch s := s.(type) {
+	case *Block:
+		return b.blockStmt(s)
+
+	case *VarStmt:
+		// the variable is not visible in its initial value
+		value, err := b.value(s.Value)
+		if err != nil {
+			return err
+		}
+		v, err := b.declare(s.Pos, s.Name)
+		if err != nil {
+			return err
+		}
+		b.write(v, b.block, value)
+
+	case *AssignStmt:
+		v, err := b.lookup(s.Pos, s.Name)
+		if err != nil {
+			return err
+		}
+		value, err := b.value(s.Value)
+		if err != nil {
+			return err
+		}
+		b.write(v, b.block, value)
+
+	case *IfStmt:
+		cond, err := b.value(s.Cond)
+		if err != nil {
+			return err
+		}
+		then := b.f.NewBlock()
+		els := b.f.NewBlock()
+		b.block.If(cond, then, els)
+		b.seal(then)
+		b.seal(els)
+
+		b.block = then
+		if err := b.blockStmt(s.Then); err != nil {
+			return err
+		}
+		thenEnd := b.block
+
+		b.block = els
+		if s.Else != nil {
+			if err := b.statement(s.Else); err != nil {
+				return err
+			}
+		}
+		join := b.f.NewBlock()
+		thenEnd.Jump(join)
+		b.block.Jump(join)
+		b.seal(join)
+		b.block = join
+
+	case *WhileStmt:
+		header := b.f.NewBlock()
+		b.block.Jump(header)
+		b.block = header
+		cond, err := b.value(s.Cond)
+		if err != nil {
+			return err
+		}
+		body := b.f.NewBlock()
+		exit := b.f.NewBlock()
+		b.block.If(cond, body, exit)
+		b.seal(body)
+		b.seal(exit)
+
+		b.block = body
+		if err := b.blockStmt(s.Body); err != nil {
+			return err
+		}
+		b.block.Jump(header)
+		// the back edge is the last predecessor of the header
+		b.seal(header)
+		b.block = exit
+
+	case *ReturnStmt:
+		var value *ir.Value
+		if s.Value == nil {
+			value = b.block.NewConst(0)
+		} else {
+			var err error
+			value, err = b.value(s.Value)
+			if err != nil {
+				return err
+			}
+		}
+		b.block.Return(value)
+		b.startUnreachable()
+
+	case *ExprStmt:
+		_, err := b.expression(s.X)
+		return err
+	}
+	return nil
+}
+
+// binaryOps are the operations of the binary operators except && and ||.
+var binaryOps = map[TokenKind]ir.Op{
+	ADD: ir.OpAdd,
+	SUB: ir.OpSub,
+	MUL: ir.OpMul,
+	QUO: ir.OpDiv,
+	REM: ir.OpRem,
+	EQL: ir.OpEq,
+	NEQ: ir.OpNe,
+	LSS: ir.OpLt,
+	LEQ: ir.OpLe,
+	GTR: ir.OpGt,
+	GEQ: ir.OpGe,
+}
+
+// expression returns the value of x. Calls of print, exit, free and store
+// have no value, nil is returned for them.
+func (b *builder) expression(x Expr) (*ir.Value, error) {
+	switch x := x.(type) {
+	case *NumberLit:
+		return b.block.NewConst(x.Value), nil
+
+	case *StringLit:
+		return nil, Errorf(x.Pos, "string %q is only allowed as argument of print", x.Value)
+
+	case *Ident:
+		v, err := b.lookup(x.Pos, x.Name)
+		if err != nil {
+			return nil, err
+		}
+		return b.read(v, b.block), nil
+
+	case *Unary:
+		operand, err := b.value(x.X)
+		if err != nil {
+			return nil, err
+		}
+		if x.Op == SUB {
+			return b.block.NewValue(ir.OpNeg, operand), nil
+		}
+		return b.block.NewValue(ir.OpEq, operand, b.block.NewConst(0)), nil
+
+	case *Binary:
+		if x.Op == LAND || x.Op == LOR {
+			return b.logical(x)
+		}
+		left, err := b.value(x.X)
+		if err != nil {
+			return nil, err
+		}
+		right, err := b.value(x.Y)
+		if err != nil {
+			return nil, err
+		}
+		return b.block.NewValue(binaryOps[x.Op], left, right), nil
+
+	case *Call:
+		return b.call(x)
+	}
+	return nil, Errorf(x.Position(), "unexpected expression %T", x)
+}
+
+// value is like expression but returns an error for calls without value.
+func (b *builder) value(x Expr) (*ir.Value, error) {
+	v, err := b.expression(x)
+	if err == nil && v == nil {
+		return nil, Errorf(x.Position(), "%s has no value", Format(x))
+	}
+	return v, err
+}
+
+// logical evaluates && and || with a temporary variable, which is 0 or 1.
+func (b *builder) logical(x *Binary) (*ir.Value, error) {
+	result := b.newVariable()
+	left, err := b.value(x.X)
+	if err != nil {
+		return nil, err
+	}
+	// the result is known if X is zero for && or not zero for ||
+	known := int64(0)
+	if x.Op == LOR {
+		known = 1
+	}
+	b.write(result, b.block, b.block.NewConst(known))
+
+	right := b.f.NewBlock()
+	join := b.f.NewBlock()
+	if x.Op == LAND {
+		b.block.If(left, right, join)
+	} else {
+		b.block.If(left, join, right)
+	}
+	b.seal(right)
+
+	b.block = right
+	y, err := b.value(x.Y)
+	if err != nil {
+		return nil, err
+	}
+	b.write(result, b.block, b.block.NewValue(ir.OpNe, y, b.block.NewConst(0)))
+	b.block.Jump(join)
+	b.seal(join)
+
+	b.block = join
+	return b.read(result, join), nil
+}
+
+func (b *builder) call(x *Call) (*ir.Value, error) {
+	args := make([]*ir.Value, 0, len(x.Args))
+	switch x.Name {
+	case "print":
+		for _, arg := range x.Args {
+			if s, ok := arg.(*StringLit); ok {
+				if s.Value != "" {
+					b.block.NewValue(ir.OpPrintString).Aux = s.Value
+				}
+				continue
+			}
+			v, err := b.value(arg)
+			if err != nil {
+				return nil, err
+			}
+			b.block.NewValue(ir.OpPrintInt, v)
+		}
+		return nil, nil
+
+	case "exit":
+		if len(x.Args) != 1 {
+			return nil, Errorf(x.Pos, "exit expects 1 argument, got %d", len(x.Args))
+		}
+		v, err := b.value(x.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		b.block.Exit(v)
+		b.startUnreachable()
+		return nil, nil
+
+	case "alloc", "free", "load", "store", "argc", "argv", "envp", "auxv":
+		m := opBuiltins[x.Name]
+		if len(x.Args) != m.args {
+			arguments := "arguments"
+			if m.args == 1 {
+				arguments = "argument"
+			}
+			return nil, Errorf(x.Pos, "%s expects %d %s, got %d", x.Name, m.args, arguments, len(x.Args))
+		}
+		for _, arg := range x.Args {
+			v, err := b.value(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+		v := b.block.NewValue(m.op, args...)
+		if !m.op.HasResult() {
+			return nil, nil
+		}
+		return v, nil
+	}
+
+	f, ok := b.functions[x.Name]
+	if !ok {
+		return nil, Errorf(x.Pos, "undefined function %s", x.Name)
+	}
+	if len(x.Args) != len(f.Params) {
+		return nil, Errorf(x.Pos, "%s expects %d arguments, got %d", x.Name, len(f.Params), len(x.Args))
+	}
+	for _, arg := range x.Args {
+		v, err := b.value(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	v := b.block.NewValue(ir.OpCall, args...)
+	v.Aux = x.Name
+	return v, nil
+}
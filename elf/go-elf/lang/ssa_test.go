@@ -0,0 +1,77 @@
+package lang
+
+import (
+	"testing"
+)
+
+func TestBuildIR(t *testing.T) {
+	program, err := Parse(`func sum(n) {
+	var s = 0;
+	while n > 0 {
+		s = s + n;
+		n = n - 1;
+	}
+	return s;
+}
+
+func main() {
+	if sum(3) == 6 && 1 {
+		exit(1);
+	}
+	return 0;
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := BuildIR(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `func sum(1):
+b0:
+  v0 = Param 0
+  v1 = Const 0
+  Plain -> b1
+b1: <- b0 b2
+  v2 = Phi v0 v8
+  v5 = Phi v1 v6
+  v3 = Const 0
+  v4 = Gt v2 v3
+  If v4 -> b2 b3
+b2: <- b1
+  v6 = Add v5 v2
+  v7 = Const 1
+  v8 = Sub v2 v7
+  Plain -> b1
+b3: <- b1
+  Return v5
+
+func main(0):
+b0:
+  v0 = Const 3
+  v1 = Call sum v0
+  v2 = Const 6
+  v3 = Eq v1 v2
+  v4 = Const 0
+  If v3 -> b1 b2
+b1: <- b0
+  v5 = Const 1
+  v6 = Const 0
+  v7 = Ne v5 v6
+  Plain -> b2
+b2: <- b0 b1
+  v8 = Phi v4 v7
+  If v8 -> b3 b4
+b3: <- b2
+  v9 = Const 1
+  Exit v9
+b4: <- b2
+  Plain -> b6
+b6: <- b4
+  v10 = Const 0
+  Return v10
+`
+	if s := p.String(); s != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, s)
+	}
+}
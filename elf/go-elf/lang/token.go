@@ -0,0 +1,297 @@
+// Package lang parses a small programming language which is compiled to
+// machine code by the compiler of package elf.
+//
+// A program consists of functions. Values are 64 bit signed integers,
+// statements end with a semicolon:
+//
+//	// prints the first Fibonacci numbers
+//	func fib(n) {
+//		if n < 2 {
+//			return n;
+//		}
+//		return fib(n - 1) + fib(n - 2);
+//	}
+//
+//	func main() {
+//		var i = 0;
+//		while i < 10 {
+//			print("fib(", i, ") = ", fib(i), "\n");
+//			i = i + 1;
+//		}
+//		return fib(10) % 256; // exit code
+//	}
+//
+// The builtin function print writes strings and integers to stdout and exit
+// terminates the program with an exit code. The builtin functions alloc and
+// free manage blocks of memory on the heap, which are read and written with
+// load(p) and store(p, value) in units of 64 bits. argc, argv, envp and
+// auxv return the number of arguments and the addresses of the arguments,
+// the environment and the auxiliary vector of the process. Execution starts
+// with main, whose return value is the exit code of the program.
+package lang
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TokenKind is the kind of a token.
+type TokenKind int
+
+const (
+	EOF TokenKind = iota
+	IDENT
+	NUMBER
+	STRING
+
+	// keywords
+	FUNC
+	VAR
+	IF
+	ELSE
+	WHILE
+	RETURN
+
+	// operators and punctuation
+	ADD       // +
+	SUB       // -
+	MUL       // *
+	QUO       // /
+	REM       // %
+	ASSIGN    // =
+	EQL       // ==
+	NEQ       // !=
+	LSS       // <
+	LEQ       // <=
+	GTR       // >
+	GEQ       // >=
+	NOT       // !
+	LAND      // &&
+	LOR       // ||
+	LPAREN    // (
+	RPAREN    // )
+	LBRACE    // {
+	RBRACE    // }
+	COMMA     // ,
+	SEMICOLON // ;
+)
+
+var tokenNames = [...]string{
+	EOF:       "end of file",
+	IDENT:     "identifier",
+	NUMBER:    "number",
+	STRING:    "string",
+	FUNC:      "func",
+	VAR:       "var",
+	IF:        "if",
+	ELSE:      "else",
+	WHILE:     "while",
+	RETURN:    "return",
+	ADD:       "+",
+	SUB:       "-",
+	MUL:       "*",
+	QUO:       "/",
+	REM:       "%",
+	ASSIGN:    "=",
+	EQL:       "==",
+	NEQ:       "!=",
+	LSS:       "<",
+	LEQ:       "<=",
+	GTR:       ">",
+	GEQ:       ">=",
+	NOT:       "!",
+	LAND:      "&&",
+	LOR:       "||",
+	LPAREN:    "(",
+	RPAREN:    ")",
+	LBRACE:    "{",
+	RBRACE:    "}",
+	COMMA:     ",",
+	SEMICOLON: ";",
+}
+
+func (k TokenKind) String() string {
+	if k < 0 || int(k) >= len(tokenNames) {
+		return fmt.Sprintf("TokenKind(%d)", int(k))
+	}
+	return tokenNames[k]
+}
+
+var keywords = map[string]TokenKind{
+	"func":   FUNC,
+	"var":    VAR,
+	"if":     IF,
+	"else":   ELSE,
+	"while":  WHILE,
+	"return": RETURN,
+}
+
+// Pos is a position in the source, both line and column start at 1.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// Position returns the position, it makes every node which embeds Pos a
+// Node.
+func (p Pos) Position() Pos {
+	return p
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Error is an error in the source at Pos.
+type Error struct {
+	Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// Errorf returns an Error at pos.
+func Errorf(pos Pos, format string, args ...any) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Token is a token of the source. Text is the identifier, the digits of a
+// number or the unquoted value of a string.
+type Token struct {
+	Kind TokenKind
+	Text string
+	Pos
+}
+
+func (t Token) String() string {
+	switch t.Kind {
+	case IDENT, NUMBER:
+		return t.Text
+	case STRING:
+		return strconv.Quote(t.Text)
+	}
+	return t.Kind.String()
+}
+
+// Lex splits the source into tokens, the last token is EOF. Comments start
+// with // and end at the end of the line.
+func Lex(source string) ([]Token, error) {
+	l := &lexer{source: source, line: 1, lineStart: 0}
+	tokens := []Token{}
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.Kind == EOF {
+			return tokens, nil
+		}
+	}
+}
+
+type lexer struct {
+	source    string
+	pos       int // offset in source
+	line      int
+	lineStart int // offset of the current line
+}
+
+// operators are sorted so that the longer ones are matched first.
+var operators = []TokenKind{
+	EQL, NEQ, LEQ, GEQ, LAND, LOR,
+	ADD, SUB, MUL, QUO, REM, ASSIGN, LSS, GTR, NOT,
+	LPAREN, RPAREN, LBRACE, RBRACE, COMMA, SEMICOLON,
+}
+
+func (l *lexer) next() (Token, error) {
+	l.skipSpace()
+	pos := Pos{Line: l.line, Column: l.pos - l.lineStart + 1}
+	if l.pos >= len(l.source) {
+		return Token{Kind: EOF, Pos: pos}, nil
+	}
+
+	rest := l.source[l.pos:]
+	c := rest[0]
+	switch {
+	case isLetter(c):
+		n := 1
+		for n < len(rest) && (isLetter(rest[n]) || isDigit(rest[n])) {
+			n++
+		}
+		l.pos += n
+		word := rest[:n]
+		if kind, ok := keywords[word]; ok {
+			return Token{Kind: kind, Text: word, Pos: pos}, nil
+		}
+		return Token{Kind: IDENT, Text: word, Pos: pos}, nil
+
+	case isDigit(c):
+		n := 1
+		// letters for hexadecimal numbers, invalid ones are rejected by
+		// the parser
+		for n < len(rest) && (isLetter(rest[n]) || isDigit(rest[n])) {
+			n++
+		}
+		l.pos += n
+		return Token{Kind: NUMBER, Text: rest[:n], Pos: pos}, nil
+
+	case c == '"':
+		n := 1
+		for n < len(rest) && rest[n] != '"' && rest[n] != '\n' {
+			if rest[n] == '\\' {
+				n++
+			}
+			n++
+		}
+		if n >= len(rest) || rest[n] != '"' {
+			return Token{}, Errorf(pos, "string not terminated")
+		}
+		n++
+		value, err := strconv.Unquote(rest[:n])
+		if err != nil {
+			return Token{}, Errorf(pos, "invalid string %s", rest[:n])
+		}
+		l.pos += n
+		return Token{Kind: STRING, Text: value, Pos: pos}, nil
+	}
+
+	for _, kind := range operators {
+		if strings.HasPrefix(rest, tokenNames[kind]) {
+			l.pos += len(tokenNames[kind])
+			return Token{Kind: kind, Pos: pos}, nil
+		}
+	}
+	return Token{}, Errorf(pos, "unexpected character %q", c)
+}
+
+// skipSpace skips white space and comments.
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.source) {
+		switch c := l.source[l.pos]; {
+		case c == '\n':
+			l.pos++
+			l.line++
+			l.lineStart = l.pos
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case strings.HasPrefix(l.source[l.pos:], "//"):
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
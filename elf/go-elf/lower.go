@@ -0,0 +1,338 @@
+package elf
+
+import (
+	"fmt"
+	"go-elf/ir"
+	"go-elf/x86"
+)
+
+// irRegisters are the registers which are allocated to the values of the
+// IR. The caller saved ones are preferred, so the callee saved registers
+// only have to be saved by functions which keep values across calls. rax,
+// rcx, rdx and r11 are not allocated as they are used as scratch registers
+// by the generated instructions.
+var irRegisters = ir.RegisterConfig{
+	Registers:   []int{6, 7, 8, 9, 10, 3, 12, 13, 14, 15}, // rsi, rdi, r8-r10, rbx, r12-r15
+	CallerSaved: func(reg int) bool { return !calleeSaved(reg) },
+}
+
+// compileIR generates a statically linked x86-64 executable from the
+// program. The code starts at startAddr with the entry point, which calls
+// main and exits with its return value. The string constants follow the
+// code. If the program uses the heap, the allocator follows in the section
+// .runtime and its variables are in the Data of the Writer. If the program
+// reads argc, argv, envp or auxv, the entry prologue stores them in .bss
+// first. With opt.Fold the program is optimized in place first.
+func compileIR(startAddr uint64, program *ir.Program, registers ir.RegisterConfig, opt Optimization) (w *Writer, stats PeepholeStats, err error) {
+	if opt.Fold {
+		program.Optimize()
+	}
+	g := &irGenerator{
+		Compiler: &Compiler{
+			startAddr: startAddr,
+			buf:       make([]byte, 0),
+		},
+		registers: registers,
+		strings:   map[string]string{},
+	}
+	if opt.Peephole {
+		g.enablePeephole()
+	}
+
+	if usesProcessStart(program) {
+		g.processStart = g.reserveProcessStart()
+		g.emitEntryPrologue(g.processStart)
+	}
+	g.emitCallFunction("main")
+	g.emitSys(SYS_EXIT, x86.RAX) // exit code = return value of main
+
+	for _, f := range program.Funcs {
+		if err := g.function(f); err != nil {
+			return nil, PeepholeStats{}, err
+		}
+	}
+	if g.printIntUsed {
+		g.emitPrintInt()
+	}
+	if g.runtimeUsed {
+		g.emitRuntime()
+	}
+
+	// string constants
+	for _, s := range g.stringOrder {
+		g.label(g.strings[s])
+		g.buf = append(g.buf, s...)
+	}
+
+	err = g.resolve()
+	if err != nil {
+		return nil, PeepholeStats{}, err
+	}
+	return &Writer{
+		VirtualAddress: startAddr,
+		EntryPoint:     startAddr,
+		Code:           g.buf,
+		Sections:       g.sections,
+		Symbols:        g.functions,
+		Data:           g.data,
+	}, g.peepholeStats(), nil
+}
+
+// irGenerator lowers the functions of the IR to x86-64 instructions.
+type irGenerator struct {
+	*Compiler
+	registers ir.RegisterConfig
+
+	// labels of the string constants in the order of their first use
+	strings     map[string]string
+	stringOrder []string
+
+	printIntUsed bool
+	runtimeUsed  bool // alloc or free is called
+
+	// variables of the entry prologue if argc, argv, envp or auxv is used
+	processStart processStart
+
+	// the function which is generated
+	alloc  *ir.Allocation
+	frame  *frame
+	labels map[*ir.Block]string
+}
+
+func (g *irGenerator) function(f *ir.Func) error {
+	if err := f.Verify(); err != nil {
+		return err
+	}
+	g.alloc = ir.Allocate(f, g.registers)
+	g.labels = map[*ir.Block]string{}
+	for _, b := range g.alloc.Order {
+		g.labels[b] = g.newLabel()
+	}
+
+	// the spilled values are in the local stack slots
+	saved := []x86.Register{}
+	for _, reg := range g.alloc.Used {
+		if calleeSaved(reg) {
+			saved = append(saved, x86.Reg(reg, 64))
+		}
+	}
+	g.frame = g.beginFrame(f.Name, g.alloc.Slots, saved...)
+	defer g.endFrame()
+
+	// the parameters are moved from the argument registers to their
+	// locations
+	moves := []move{}
+	for _, v := range f.Entry().Values {
+		if v.Op == ir.OpParam {
+			moves = append(moves, move{dst: g.location(v), src: g.frame.param(int(v.AuxInt))})
+		}
+	}
+	g.emitParallelMove(moves)
+
+	for i, b := range g.alloc.Order {
+		var next *ir.Block
+		if i+1 < len(g.alloc.Order) {
+			next = g.alloc.Order[i+1]
+		}
+		if err := g.block(b, next); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// location returns the register or the stack slot of v.
+func (g *irGenerator) location(v *ir.Value) x86.Operand {
+	loc := g.alloc.Location(v)
+	if loc.Spilled {
+		return g.frame.local(loc.Index)
+	}
+	return x86.Reg(loc.Index, 64)
+}
+
+// irConditions are the conditions of the comparisons of the IR.
+var irConditions = map[ir.Op]x86.Condition{
+	ir.OpEq: x86.CondE,
+	ir.OpNe: x86.CondNE,
+	ir.OpLt: x86.CondL,
+	ir.OpLe: x86.CondLE,
+	ir.OpGt: x86.CondG,
+	ir.OpGe: x86.CondGE,
+}
+
+// irArithmetic are the operations which compute rax = rax op operand.
+var irArithmetic = map[ir.Op]x86.Op{
+	ir.OpAdd: x86.ADD,
+	ir.OpSub: x86.SUB,
+	ir.OpMul: x86.IMUL,
+}
+
+// block emits the values of b and its control flow operation. next is the
+// block which follows in the code, a jump to it is omitted.
+func (g *irGenerator) block(b *ir.Block, next *ir.Block) error {
+	g.label(g.labels[b])
+	for _, v := range b.Values {
+		g.value(v)
+	}
+
+	switch b.Kind {
+	case ir.BlockPlain:
+		succ := b.Succs[0]
+		moves := []move{}
+		for _, v := range succ.Values {
+			if v.Op != ir.OpPhi {
+				break
+			}
+			for i, pred := range succ.Preds {
+				if pred == b {
+					moves = append(moves, move{dst: g.location(v), src: g.location(v.Args[i])})
+				}
+			}
+		}
+		g.emitParallelMove(moves)
+		if succ != next {
+			g.emitJump(x86.JMP, g.labels[succ])
+		}
+
+	case ir.BlockIf:
+		for _, succ := range b.Succs {
+			if len(succ.Values) > 0 && succ.Values[0].Op == ir.OpPhi {
+				return fmt.Errorf("%s: successor %s of a conditional branch has phi values", b, succ)
+			}
+		}
+		then, els := b.Succs[0], b.Succs[1]
+		g.emit(x86.CMP, g.location(b.Control), x86.Imm(0))
+		switch {
+		case then == next:
+			g.emitJump(x86.JE, g.labels[els])
+		case els == next:
+			g.emitJump(x86.JNE, g.labels[then])
+		default:
+			g.emitJump(x86.JNE, g.labels[then])
+			g.emitJump(x86.JMP, g.labels[els])
+		}
+
+	case ir.BlockReturn:
+		g.emitMove(x86.RAX, g.location(b.Control))
+		g.emitReturn(g.frame)
+
+	case ir.BlockExit:
+		g.emitSys(SYS_EXIT, g.location(b.Control))
+
+	default:
+		return fmt.Errorf("%s is not terminated", b)
+	}
+	return nil
+}
+
+func (g *irGenerator) value(v *ir.Value) {
+	var dst x86.Operand
+	if v.Op.HasResult() {
+		dst = g.location(v)
+	}
+
+	switch v.Op {
+	case ir.OpConst:
+		g.emitMove(dst, x86.Imm(v.AuxInt))
+
+	case ir.OpParam, ir.OpPhi:
+		// set by the parallel moves at the start of the function and the
+		// end of the predecessors
+
+	case ir.OpAdd, ir.OpSub, ir.OpMul:
+		g.emitMove(x86.RAX, g.location(v.Args[0]))
+		g.emit(irArithmetic[v.Op], x86.RAX, g.location(v.Args[1]))
+		g.emitMove(dst, x86.RAX)
+
+	case ir.OpDiv, ir.OpRem:
+		// signed division, the quotient is in rax and the remainder in rdx
+		g.emitMove(x86.RAX, g.location(v.Args[0]))
+		g.emit(x86.CQO)
+		g.emit(x86.IDIV, g.location(v.Args[1]))
+		if v.Op == ir.OpRem {
+			g.emitMove(dst, x86.RDX)
+		} else {
+			g.emitMove(dst, x86.RAX)
+		}
+
+	case ir.OpNeg:
+		g.emitMove(x86.RAX, g.location(v.Args[0]))
+		g.emit(x86.NEG, x86.RAX)
+		g.emitMove(dst, x86.RAX)
+
+	case ir.OpEq, ir.OpNe, ir.OpLt, ir.OpLe, ir.OpGt, ir.OpGe:
+		g.emitMove(x86.RAX, g.location(v.Args[0]))
+		g.emit(x86.CMP, x86.RAX, g.location(v.Args[1]))
+		g.emit(x86.Setcc(irConditions[v.Op]), x86.AL)
+		g.emit(x86.MOVZX, x86.EAX, x86.AL)
+		g.emitMove(dst, x86.RAX)
+
+	case ir.OpCall:
+		args := make([]x86.Operand, len(v.Args))
+		for i, arg := range v.Args {
+			args[i] = g.location(arg)
+		}
+		g.emitCallFunction(v.Aux, args...)
+		g.emitMove(dst, x86.RAX)
+
+	case ir.OpPrintInt:
+		g.emitMove(x86.RAX, g.location(v.Args[0]))
+		g.emitJump(x86.CALL, printIntLabel)
+		g.printIntUsed = true
+
+	case ir.OpPrintString:
+		label, ok := g.strings[v.Aux]
+		if !ok {
+			label = g.newLabel()
+			g.strings[v.Aux] = label
+			g.stringOrder = append(g.stringOrder, v.Aux)
+		}
+		g.emitLabelRIP(x86.LEA, label, x86.RSI, x86.Mem{RIP: true})
+		g.emitSys(SYS_WRITE, x86.Imm(1), x86.RSI, x86.Imm(len(v.Aux)))
+
+	case ir.OpLoad:
+		g.emitMove(x86.RAX, g.location(v.Args[0]))
+		g.emit(x86.MOV, x86.RAX, x86.Mem{Base: x86.RAX})
+		g.emitMove(dst, x86.RAX)
+
+	case ir.OpStore:
+		g.emitMove(x86.RAX, g.location(v.Args[0]))
+		g.emitMove(x86.RCX, g.location(v.Args[1]))
+		g.emit(x86.MOV, x86.Mem{Base: x86.RAX}, x86.RCX)
+
+	case ir.OpAlloc:
+		g.emitCallFunction(allocLabel, g.location(v.Args[0]))
+		g.emitMove(dst, x86.RAX)
+		g.runtimeUsed = true
+
+	case ir.OpFree:
+		g.emitCallFunction(freeLabel, g.location(v.Args[0]))
+		g.runtimeUsed = true
+
+	case ir.OpArgc, ir.OpArgv, ir.OpEnvp, ir.OpAuxv:
+		label := map[ir.Op]string{
+			ir.OpArgc: g.processStart.argc,
+			ir.OpArgv: g.processStart.argv,
+			ir.OpEnvp: g.processStart.envp,
+			ir.OpAuxv: g.processStart.auxv,
+		}[v.Op]
+		g.emitLabelRIP(x86.MOV, label, x86.RAX, x86.Mem{RIP: true})
+		g.emitMove(dst, x86.RAX)
+	}
+}
+
+// usesProcessStart reports whether a function of the program reads the
+// values of the entry prologue.
+func usesProcessStart(program *ir.Program) bool {
+	for _, f := range program.Funcs {
+		for _, b := range f.Blocks {
+			for _, v := range b.Values {
+				switch v.Op {
+				case ir.OpArgc, ir.OpArgv, ir.OpEnvp, ir.OpAuxv:
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package elf
+
+import (
+	"go-elf/ir"
+	"go-elf/lang"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCompileIR runs programs with fewer registers, so values are spilled
+// and the parallel moves of the phi values and arguments have to use the
+// stack slots.
+func TestCompileIR(t *testing.T) {
+	fib, err := os.ReadFile("testdata/fib.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	programs := []struct {
+		name     string
+		source   string
+		output   string
+		exitCode int
+	}{
+		{
+			name:     "fib",
+			source:   string(fib),
+			output:   "fib(0) = 0\nfib(1) = 1\nfib(2) = 1\nfib(3) = 2\nfib(4) = 3\nfib(5) = 5\nfib(6) = 8\nfib(7) = 13\nfib(8) = 21\nfib(9) = 34\n-42 0 -9223372036854775808\n",
+			exitCode: 55,
+		},
+		{
+			name: "rotate",
+			source: `func main() {
+	var a = 1; var b = 2; var c = 3; var i = 0;
+	while i < 5 {
+		var t = a; a = b; b = c; c = t;
+		i = i + 1;
+	}
+	print(a, " ", b, " ", c, "\n");
+	return a * 100 + b * 10 + c;
+}`,
+			output:   "3 1 2\n",
+			exitCode: 312 % 256,
+		},
+		{
+			name: "arguments",
+			source: `func f(a, b, c, d, e, g) { return a - b * 2 + c * 3 - d * 4 + e * 5 - g * 6; }
+func p(a, b, c, d, e, g) { return f(g, a, b, c, d, e) + f(b, c, a, e, g, d); }
+func main() { print(p(1, 2, 3, 4, 5, 6), "\n"); return 0; }`,
+			output: "-27\n",
+		},
+		{
+			name: "live values",
+			source: `func main() {
+	var a = 1; var b = a + 1; var c = b + 1; var d = c + 1;
+	var e = d + 1; var f = e + 1; var g = f + 1; var h = g + 1;
+	print(a + b + c + d + e + f + g + h, " ", a * h - b * g + c * f - d * e, " ", h / c, " ", h % c, "\n");
+	return a + h;
+}`,
+			output:   "36 -8 2 2\n",
+			exitCode: 9,
+		},
+	}
+	configs := []struct {
+		name      string
+		registers []int
+	}{
+		{"all registers", irRegisters.Registers},
+		{"3 registers", []int{6, 7, 3}},
+		{"callee saved register", []int{3}},
+		{"caller saved register", []int{6}},
+	}
+
+	tempDir := t.TempDir()
+	for _, config := range configs {
+		registers := ir.RegisterConfig{Registers: config.registers, CallerSaved: irRegisters.CallerSaved}
+		for _, test := range programs {
+			for _, opt := range []Optimization{{}, {Peephole: true}, {Fold: true, Peephole: true}} {
+				name := config.name + "/" + test.name
+				if opt.Fold {
+					name += "/fold"
+				}
+				if opt.Peephole {
+					name += "/peephole"
+				}
+				t.Run(name, func(t *testing.T) {
+					program, err := lang.Parse(test.source)
+					if err != nil {
+						t.Fatal(err)
+					}
+					p, err := lang.BuildIR(program)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var virtualAddress uint64 = 0x401000
+					w, _, err := compileIR(virtualAddress, p, registers, opt)
+					if err != nil {
+						t.Fatal(err)
+					}
+					elfBinary, err := Write(virtualAddress, w.EntryPoint, w.Code)
+					if err != nil {
+						t.Fatal(err)
+					}
+					outputPath := filepath.Join(tempDir, "output.elf")
+					err = os.WriteFile(outputPath, elfBinary, 0755)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					output, exitCode := execute(t, outputPath, "")
+					if exitCode != test.exitCode {
+						t.Errorf("expected exit code %d got %d", test.exitCode, exitCode)
+					}
+					if string(output) != test.output {
+						t.Errorf("expected output %q got %q", test.output, output)
+					}
+				})
+			}
+		}
+	}
+}
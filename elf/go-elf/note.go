@@ -0,0 +1,100 @@
+package elf
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+)
+
+const (
+	buildIDSize = sha1.Size
+
+	// GNU_PROPERTY_X86_FEATURE_1_AND is the property type which holds the
+	// X86Feature bits all linked objects support.
+	gnuPropertyX86Feature1And = 0xc0000002
+)
+
+// NoteHeader is the header of an entry in a note section (SHT_NOTE) or
+// segment (PT_NOTE). It is followed by the name of the owner (e.g. "GNU")
+// and the descriptor, both padded to the alignment of the note section.
+type NoteHeader struct {
+	NameSize uint32
+	DescSize uint32
+	Type     NoteType
+}
+
+// X86Feature are the bits of the GNU_PROPERTY_X86_FEATURE_1_AND property which
+// mark the executable as compatible with the control-flow enforcement
+// technology (CET) of the processor.
+type X86Feature uint32
+
+const (
+	X86_FEATURE_1_IBT   X86Feature = 0x1 // Indirect branch tracking, every indirect branch target starts with endbr64
+	X86_FEATURE_1_SHSTK X86Feature = 0x2 // Shadow stack, return addresses are only modified by call and ret
+)
+
+// note returns the content of a note with the owner name and descriptor
+// padded to align.
+func note(name string, noteType NoteType, desc []byte, align uint64) []byte {
+	nameBytes := append([]byte(name), 0)
+	data := encode(NoteHeader{
+		NameSize: uint32(len(nameBytes)),
+		DescSize: uint32(len(desc)),
+		Type:     noteType,
+	})
+	data = append(data, nameBytes...)
+	data = append(data, make([]byte, alignUp(uint64(len(data)), align)-uint64(len(data)))...)
+	data = append(data, desc...)
+	data = append(data, make([]byte, alignUp(uint64(len(data)), align)-uint64(len(data)))...)
+	return data
+}
+
+// addBuildIDNote adds a .note.gnu.build-id section. The build ID is the SHA-1
+// hash over the whole file with the build ID set to zero, which is
+// calculated when the file is written.
+func (img *image) addBuildIDNote() *section {
+	return img.addSection(".note.gnu.build-id", SHT_NOTE, SHF_ALLOC, 4, note("GNU", NT_GNU_BUILD_ID, make([]byte, buildIDSize), 4))
+}
+
+// addPropertyNote adds a .note.gnu.property section with the x86 features.
+func (img *image) addPropertyNote(features X86Feature) *section {
+	property := binary.LittleEndian.AppendUint32(nil, gnuPropertyX86Feature1And)
+	property = binary.LittleEndian.AppendUint32(property, 4)
+	property = binary.LittleEndian.AppendUint32(property, uint32(features))
+	property = append(property, make([]byte, 4)...) // pad to 8 bytes
+	return img.addSection(".note.gnu.property", SHT_NOTE, SHF_ALLOC, 8, note("GNU", NT_GNU_PROPERTY_TYPE_0, property, 8))
+}
+
+// gnuProgramHeaders returns a PT_NOTE program header for each note section,
+// as notes with different alignments can't share a segment, PT_GNU_PROPERTY
+// for the property note and PT_GNU_STACK which marks the stack as not
+// executable. Before the layout is calculated only the number of program
+// headers is valid.
+func (img *image) gnuProgramHeaders() []ProgramHeader64 {
+	programHeaders := []ProgramHeader64{}
+	for _, s := range img.sections {
+		if s.header.Type != SHT_NOTE {
+			continue
+		}
+		programHeader := ProgramHeader64{
+			Type:            PT_NOTE,
+			Flags:           PF_R,
+			Offset:          s.header.Offset,
+			VirtualAddress:  s.header.Address,
+			PhysicalAddress: s.header.Address,
+			FileSize:        s.header.Size,
+			MemorySize:      s.header.Size,
+			Align:           s.header.AddressAlign,
+		}
+		programHeaders = append(programHeaders, programHeader)
+		if s.name == ".note.gnu.property" {
+			programHeader.Type = PT_GNU_PROPERTY
+			programHeaders = append(programHeaders, programHeader)
+		}
+	}
+	programHeaders = append(programHeaders, ProgramHeader64{
+		Type:  PT_GNU_STACK,
+		Flags: PF_R | PF_W,
+		Align: 16,
+	})
+	return programHeaders
+}
@@ -0,0 +1,200 @@
+package elf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOptimizeOperations(t *testing.T) {
+	const counter, buffer = 0x402000, 0x402010
+	tests := []struct {
+		name     string
+		ops      []operation
+		expected []operation
+	}{
+		{
+			name: "exit with the counter",
+			ops: []operation{
+				{kind: opWrite, fd: 1, addr: buffer, count: 13},
+				{kind: opIncrement, addr: counter},
+				{kind: opIncrement, addr: counter},
+				{kind: opExit, addr: counter},
+			},
+			expected: []operation{
+				{kind: opWrite, fd: 1, addr: buffer, count: 13},
+				{kind: opExitCode, code: 35},
+			},
+		},
+		{
+			name: "counter written",
+			ops: []operation{
+				{kind: opIncrement, addr: counter},
+				{kind: opWrite, fd: 1, addr: counter - 2, count: 3},
+				{kind: opIncrement, addr: counter},
+				{kind: opExit, addr: counter},
+			},
+			expected: []operation{
+				{kind: opIncrement, addr: counter},
+				{kind: opWrite, fd: 1, addr: counter - 2, count: 3},
+				{kind: opExitCode, code: 35},
+			},
+		},
+		{
+			name: "unknown counter",
+			ops: []operation{
+				{kind: opIncrement, addr: buffer},
+				{kind: opExit, addr: buffer},
+				{kind: opWrite, fd: 1, addr: buffer, count: 4},
+			},
+			expected: []operation{
+				{kind: opIncrement, addr: buffer},
+				{kind: opExit, addr: buffer},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := optimizeOperations(test.ops, map[uint64]int32{counter: 33})
+			if len(ops) != len(test.expected) {
+				t.Fatalf("expected %v got %v", test.expected, ops)
+			}
+			for i := range ops {
+				if ops[i] != test.expected[i] {
+					t.Errorf("expected %v got %v", test.expected, ops)
+					break
+				}
+			}
+		})
+	}
+}
+
+// TestOptimize checks that the programs behave the same at -O0 and -O1.
+func TestOptimize(t *testing.T) {
+	var virtualAddress uint64 = 0x401000
+	o1 := Optimization{Fold: true, Peephole: true}
+
+	for _, machine := range []Machine{EM_X86_64, EM_AARCH64, EM_RISCV} {
+		t.Run(machine.String(), func(t *testing.T) {
+			runOptimized := func(opt Optimization) ([]byte, int, int) {
+				entryPoint, code, _, err := CompileOptimized(machine, virtualAddress, opt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				w := &Writer{
+					VirtualAddress: virtualAddress,
+					EntryPoint:     entryPoint,
+					Code:           code,
+					WritableCode:   true,
+					Machine:        machine,
+				}
+				buf := &bytes.Buffer{}
+				if _, err := w.WriteTo(buf); err != nil {
+					t.Fatal(err)
+				}
+				if machine == EM_X86_64 {
+					outputPath := filepath.Join(t.TempDir(), "output.elf")
+					if err := os.WriteFile(outputPath, buf.Bytes(), 0755); err != nil {
+						t.Fatal(err)
+					}
+					output, exitCode := execute(t, outputPath, "")
+					return output, exitCode, len(code)
+				}
+				output, exitCode := run(t, machine, buf.Bytes())
+				return output, exitCode, len(code)
+			}
+
+			output, exitCode, size := runOptimized(Optimization{})
+			optimizedOutput, optimizedExitCode, optimizedSize := runOptimized(o1)
+			if optimizedExitCode != exitCode || exitCode != 34 {
+				t.Errorf("expected exit code %d got %d", exitCode, optimizedExitCode)
+			}
+			if !bytes.Equal(optimizedOutput, output) {
+				t.Errorf("expected output %q got %q", output, optimizedOutput)
+			}
+			if optimizedSize >= size {
+				t.Errorf("expected less than %d bytes got %d", size, optimizedSize)
+			}
+		})
+	}
+
+	fib, err := os.ReadFile("testdata/fib.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	programs := []struct {
+		name   string
+		source string
+	}{
+		{"fib", string(fib)},
+		{"constant conditions", `func main() {
+	var a = 6 * 7;
+	if a == 42 { print("then\n"); } else { print("else\n"); }
+	if a < 0 || !(a % 2 == 0) { return 1; }
+	while 0 { print("never\n"); }
+	var i = 0;
+	while i < 3 && a > 40 { print(i, "\n"); i = i + 1; }
+	return a + i;
+}`},
+		{"dead assignments", `func f(x, y) {
+	var unused = x * y;
+	unused = x / 3;
+	var z = y;
+	z = 10;
+	return x + z;
+}
+func main() {
+	var a = f(5, 1000);
+	a = f(a, 0);
+	print(a, "\n");
+	return a;
+}`},
+		{"division by zero", `func main() {
+	var zero = 5 - 5;
+	print("before\n");
+	var unused = 7 / zero;
+	print("after\n");
+	return 3;
+}`},
+		{"smallest integer by -1", `func main() {
+	var x = -9223372036854775807 - 1;
+	var y = x % -1;
+	print("unreachable\n");
+	return 4;
+}`},
+	}
+	tempDir := t.TempDir()
+	for _, program := range programs {
+		t.Run(program.name, func(t *testing.T) {
+			runOptimized := func(opt Optimization) ([]byte, int, int) {
+				entryPoint, code, _, err := CompileSourceOptimized(virtualAddress, program.source, opt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				elfBinary, err := Write(virtualAddress, entryPoint, code)
+				if err != nil {
+					t.Fatal(err)
+				}
+				outputPath := filepath.Join(tempDir, program.name+".elf")
+				if err := os.WriteFile(outputPath, elfBinary, 0755); err != nil {
+					t.Fatal(err)
+				}
+				output, exitCode := execute(t, outputPath, tempDir)
+				return output, exitCode, len(code)
+			}
+
+			output, exitCode, size := runOptimized(Optimization{})
+			optimizedOutput, optimizedExitCode, optimizedSize := runOptimized(o1)
+			if optimizedExitCode != exitCode {
+				t.Errorf("expected exit code %d got %d", exitCode, optimizedExitCode)
+			}
+			if string(optimizedOutput) != string(output) {
+				t.Errorf("expected output %q got %q", output, optimizedOutput)
+			}
+			if optimizedSize >= size {
+				t.Errorf("expected less than %d bytes got %d", size, optimizedSize)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package elf
+
+import (
+	"fmt"
+	"go-elf/x86"
+	"math"
+)
+
+// peephole optimizes the x86-64 instructions of the Compiler while they are
+// emitted by emit. It keeps a window of the last instructions, which are at
+// the end of buf, and rewrites them whenever an instruction is added:
+//
+//   - shorter encodings: mov $imm32, %r64 becomes mov $imm32, %r32 if the
+//     value is zero extended, cmp $0, %r becomes test %r, %r and mov $0, %r
+//     becomes xor %r32, %r32 once a later instruction overwrites the flags
+//   - redundant loads: a load from the address of a preceding store gets
+//     the stored register instead
+//   - dead moves: a move to a register which is overwritten before it is
+//     read is removed, and a load, an arithmetic instruction and a store to
+//     the same address whose register is dead become a single instruction
+//     with a memory operand, e.g. add $1, counter
+//
+// The window only contains instructions of one basic block. It is cleared
+// by labels, functions, sections and anything else appended to buf, like
+// jumps, calls and data, and by instructions whose effects on registers,
+// flags and memory are not known to the optimizer.
+type peephole struct {
+	position int // of the window in buf
+	window   []peepholeInst
+	stats    PeepholeStats
+}
+
+// peepholeInst is an instruction in the window of the peephole optimizer.
+type peepholeInst struct {
+	op       x86.Op
+	operands []x86.Operand
+	code     []byte
+
+	// forwarded is the address of the load which forwardStore replaced by
+	// the move
+	forwarded *x86.Mem
+}
+
+// peepholeWindow is the maximum number of instructions in the window.
+const peepholeWindow = 32
+
+// PeepholeStats reports the size of the instructions emitted by the
+// Compiler before and after the peephole optimizer. Jumps, calls and
+// instructions with RIP relative addresses are not included.
+type PeepholeStats struct {
+	Before int // bytes
+	After  int // bytes
+}
+
+func (s PeepholeStats) String() string {
+	if s.Before == 0 {
+		return "0 bytes"
+	}
+	saved := s.Before - s.After
+	return fmt.Sprintf("%d bytes -> %d bytes (-%d bytes, %.1f%%)", s.Before, s.After, saved, 100*float64(saved)/float64(s.Before))
+}
+
+// enablePeephole turns on the peephole optimizer of emit.
+func (c *Compiler) enablePeephole() {
+	c.peephole = &peephole{}
+}
+
+// peepholeStats returns the sizes of the instructions which went through
+// the peephole optimizer.
+func (c *Compiler) peepholeStats() PeepholeStats {
+	if c.peephole == nil {
+		return PeepholeStats{}
+	}
+	return c.peephole.stats
+}
+
+// peepholeBarrier clears the window of the peephole optimizer, so that the
+// current position in buf stays the same.
+func (c *Compiler) peepholeBarrier() {
+	if c.peephole != nil {
+		c.peephole.window = nil
+	}
+}
+
+// emitPeephole appends the instruction through the window of the peephole
+// optimizer.
+func (c *Compiler) emitPeephole(op x86.Op, operands []x86.Operand) {
+	p := c.peephole
+	code, err := x86.Encode(op, operands...)
+	if err != nil {
+		panic(err)
+	}
+	p.stats.Before += len(code)
+	if p.position+p.size() != len(c.buf) {
+		// something was appended without emit since the last instruction
+		p.window = nil
+	}
+	if len(p.window) == 0 {
+		p.position = len(c.buf)
+	}
+
+	in := peepholeInst{op: op, operands: operands, code: code}
+	if _, ok := effectsOf(in); !ok {
+		c.buf = append(c.buf, code...)
+		p.stats.After += len(code)
+		p.window = nil
+		return
+	}
+
+	before := p.size() + len(code)
+	p.window = append(p.window, in)
+	changed := false
+	for p.rewrite() {
+		changed = true
+	}
+	if changed {
+		c.buf = c.buf[:p.position]
+		for i := range p.window {
+			in := &p.window[i]
+			in.code, err = x86.Encode(in.op, in.operands...)
+			if err != nil {
+				panic(err)
+			}
+			c.buf = append(c.buf, in.code...)
+		}
+	} else {
+		c.buf = append(c.buf, code...)
+	}
+	p.stats.After += p.size() - (before - len(code))
+
+	if len(p.window) > peepholeWindow {
+		p.position += len(p.window[0].code)
+		p.window = p.window[1:]
+	}
+}
+
+// size returns the length of the instructions in the window.
+func (p *peephole) size() int {
+	size := 0
+	for _, in := range p.window {
+		size += len(in.code)
+	}
+	return size
+}
+
+// rewrite applies the first rule which changes the window, which happens
+// when the last instruction was added or changed, and reports whether it
+// did so.
+func (p *peephole) rewrite() bool {
+	if len(p.window) == 0 {
+		return false
+	}
+	last := len(p.window) - 1
+	if p.shorten(last) {
+		return true
+	}
+	if p.zeroWithXor(last) {
+		return true
+	}
+	if p.forwardStore(last) {
+		return true
+	}
+	return p.removeDead(last)
+}
+
+// shorten replaces the instruction i by one with a shorter encoding and the
+// same effects.
+func (p *peephole) shorten(i int) bool {
+	in := &p.window[i]
+	if len(in.operands) != 2 {
+		return false
+	}
+	reg, ok := in.operands[0].(x86.Register)
+	if !ok {
+		return false
+	}
+	imm, ok := in.operands[1].(x86.Imm)
+	if !ok {
+		return false
+	}
+	switch {
+	case in.op == x86.MOV && reg.Size() == 64 && imm >= 0 && imm <= math.MaxUint32:
+		// writing the 32 bit register clears the upper half
+		in.operands = []x86.Operand{x86.Reg(reg.Num(), 32), imm}
+		return true
+	case in.op == x86.CMP && imm == 0:
+		in.op = x86.TEST
+		in.operands = []x86.Operand{reg, reg}
+		return true
+	}
+	return false
+}
+
+// zeroWithXor replaces mov $0, %r in front of instruction i with
+// xor %r32, %r32 if i overwrites the flags, which xor changes, before they
+// are read.
+func (p *peephole) zeroWithXor(i int) bool {
+	e, _ := effectsOf(p.window[i])
+	if !e.writeFlags || e.readFlags {
+		return false
+	}
+	changed := false
+	for j := i - 1; j >= 0; j-- {
+		in := &p.window[j]
+		e, _ := effectsOf(*in)
+		if e.readFlags || e.writeFlags {
+			break
+		}
+		if in.op != x86.MOV {
+			continue
+		}
+		reg, ok := in.operands[0].(x86.Register)
+		if ok && reg.Size() >= 32 && in.operands[1] == x86.Imm(0) {
+			reg32 := x86.Reg(reg.Num(), 32)
+			in.op = x86.XOR
+			in.operands = []x86.Operand{reg32, reg32}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// forwardStore replaces the load i from the address of a preceding store
+// by a move from the stored register, if neither the register, the memory
+// nor the registers of the address are written in between.
+func (p *peephole) forwardStore(i int) bool {
+	dst, addr, ok := p.window[i].load()
+	if !ok {
+		return false
+	}
+	address := registerMask(addr)
+	var written uint16
+	for j := i - 1; j >= 0; j-- {
+		if src, store, ok := p.window[j].store(); ok && sameAddress(store, addr) && src.Size() == dst.Size() {
+			if written&registerMask(src) != 0 {
+				return false
+			}
+			if src == dst && dst.Size() != 32 {
+				// a load into a 32 bit register clears the upper half
+				p.window = append(p.window[:i], p.window[i+1:]...)
+			} else {
+				p.window[i] = peepholeInst{op: x86.MOV, operands: []x86.Operand{dst, src}, forwarded: &addr}
+			}
+			return true
+		}
+		e, _ := effectsOf(p.window[j])
+		if e.writesMem || e.writes&address != 0 {
+			return false
+		}
+		written |= e.writes
+	}
+	return false
+}
+
+// removeDead removes the last instruction which writes the register that
+// instruction i overwrites without reading it. If it is a store, which
+// follows an arithmetic instruction and a load of the same address, the
+// three instructions are replaced by the arithmetic instruction with the
+// memory operand.
+func (p *peephole) removeDead(i int) bool {
+	e, _ := effectsOf(p.window[i])
+	dead := e.fullWrites &^ e.reads
+	if dead == 0 {
+		return false
+	}
+	for num := range 16 {
+		if dead&(1<<num) == 0 || num == x86.RSP.Num() {
+			continue
+		}
+		j := p.lastAccess(i, num)
+		if j < 0 {
+			continue
+		}
+		if p.window[j].pureDefinition(num) {
+			p.window = append(p.window[:j], p.window[j+1:]...)
+			return true
+		}
+		if fused, ok := p.fused(j, num); ok {
+			p.window = append(append(p.window[:j-2], fused), p.window[j+1:]...)
+			return true
+		}
+		// the move of forwardStore reads the register, the load is
+		// restored if the store in front of it can be fused instead
+		if forwarded := p.window[j].forwarded; forwarded != nil {
+			k := p.lastAccess(j, num)
+			if fused, ok := p.fused(k, num); ok {
+				p.window[j] = peepholeInst{op: x86.MOV, operands: []x86.Operand{p.window[j].operands[0], *forwarded}}
+				p.window = append(append(p.window[:k-2], fused), p.window[k+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lastAccess returns the index of the last instruction in front of i which
+// reads or writes the register num, or -1.
+func (p *peephole) lastAccess(i int, num int) int {
+	for j := i - 1; j >= 0; j-- {
+		e, _ := effectsOf(p.window[j])
+		if (e.reads|e.writes)&(1<<num) != 0 {
+			return j
+		}
+	}
+	return -1
+}
+
+// fused returns op src, addr for mov addr, %r; op src, %r; mov %r, addr
+// ending with the store at index i.
+func (p *peephole) fused(i int, num int) (peepholeInst, bool) {
+	if i < 2 {
+		return peepholeInst{}, false
+	}
+	reg, addr, ok := p.window[i].store()
+	if !ok || reg.Num() != num || reg.Size() < 32 {
+		return peepholeInst{}, false
+	}
+	loaded, load, ok := p.window[i-2].load()
+	if !ok || loaded != reg || !sameAddress(load, addr) || registerMask(addr)&registerMask(reg) != 0 {
+		return peepholeInst{}, false
+	}
+	arith := p.window[i-1]
+	switch arith.op {
+	case x86.ADD, x86.SUB, x86.AND, x86.OR, x86.XOR:
+	default:
+		return peepholeInst{}, false
+	}
+	if arith.operands[0] != reg {
+		return peepholeInst{}, false
+	}
+	switch src := arith.operands[1].(type) {
+	case x86.Imm:
+	case x86.Register:
+		if src.Num() == num {
+			return peepholeInst{}, false
+		}
+	default:
+		return peepholeInst{}, false
+	}
+	addr.Size = reg.Size()
+	return peepholeInst{op: arith.op, operands: []x86.Operand{addr, arith.operands[1]}}, true
+}
+
+// load returns the register and the address of mov addr, %r.
+func (in peepholeInst) load() (x86.Register, x86.Mem, bool) {
+	if in.op != x86.MOV {
+		return x86.Register{}, x86.Mem{}, false
+	}
+	reg, ok1 := in.operands[0].(x86.Register)
+	mem, ok2 := in.operands[1].(x86.Mem)
+	return reg, mem, ok1 && ok2
+}
+
+// store returns the register and the address of mov %r, addr.
+func (in peepholeInst) store() (x86.Register, x86.Mem, bool) {
+	if in.op != x86.MOV {
+		return x86.Register{}, x86.Mem{}, false
+	}
+	mem, ok1 := in.operands[0].(x86.Mem)
+	reg, ok2 := in.operands[1].(x86.Register)
+	return reg, mem, ok1 && ok2
+}
+
+// pureDefinition reports whether the only effect of the instruction is to
+// overwrite the register num, so it can be removed if the register is
+// dead.
+func (in peepholeInst) pureDefinition(num int) bool {
+	switch in.op {
+	case x86.MOV, x86.LEA, x86.MOVZX, x86.MOVSX, x86.MOVSXD:
+	default:
+		return false
+	}
+	reg, ok := in.operands[0].(x86.Register)
+	return ok && reg.Num() == num && reg.Size() >= 32
+}
+
+// sameAddress reports whether a and b access the same memory, ignoring the
+// size of the access.
+func sameAddress(a, b x86.Mem) bool {
+	a.Size, b.Size = 0, 0
+	return a == b && !a.RIP
+}
+
+// registerMask returns the registers of the operand as bit mask of their
+// numbers.
+func registerMask(operand x86.Operand) uint16 {
+	switch operand := operand.(type) {
+	case x86.Register:
+		return 1 << operand.Num()
+	case x86.Mem:
+		var mask uint16
+		if operand.Base.Size() != 0 {
+			mask |= 1 << operand.Base.Num()
+		}
+		if operand.Index.Size() != 0 {
+			mask |= 1 << operand.Index.Num()
+		}
+		return mask
+	}
+	return 0
+}
+
+// effects are the registers, flags and memory accessed by an instruction.
+// The registers are bit masks of their numbers.
+type effects struct {
+	reads      uint16
+	writes     uint16
+	fullWrites uint16 // writes of the whole register, i.e. 32 or 64 bits
+	readFlags  bool
+	writeFlags bool
+	writesMem  bool
+}
+
+// effectsOf returns the effects of the instruction if it is one the
+// peephole optimizer knows.
+func effectsOf(in peepholeInst) (effects, bool) {
+	var e effects
+	for _, operand := range in.operands {
+		if mem, ok := operand.(x86.Mem); ok {
+			e.reads |= registerMask(mem)
+		}
+	}
+	if len(in.operands) == 0 {
+		return e, false
+	}
+	dst := in.operands[0]
+	write := func(readDst bool) {
+		switch dst := dst.(type) {
+		case x86.Register:
+			e.writes |= registerMask(dst)
+			if dst.Size() >= 32 {
+				e.fullWrites |= registerMask(dst)
+			} else {
+				// the rest of the register is kept
+				readDst = true
+			}
+			if readDst {
+				e.reads |= registerMask(dst)
+			}
+		case x86.Mem:
+			e.writesMem = true
+		}
+	}
+	readSrc := func() {
+		if len(in.operands) == 2 {
+			if reg, ok := in.operands[1].(x86.Register); ok {
+				e.reads |= registerMask(reg)
+			}
+		}
+	}
+
+	setcc := in.op >= x86.SETO && in.op <= x86.SETG
+	cmovcc := in.op >= x86.CMOVO && in.op <= x86.CMOVG
+
+	switch {
+	case in.op == x86.MOV || in.op == x86.LEA || in.op == x86.MOVZX || in.op == x86.MOVSX || in.op == x86.MOVSXD:
+		write(false)
+		readSrc()
+	case in.op == x86.ADD || in.op == x86.SUB || in.op == x86.AND || in.op == x86.OR || in.op == x86.XOR:
+		// xor %r, %r is zero independent of the register
+		zero := in.op == x86.XOR && len(in.operands) == 2 && in.operands[0] == in.operands[1]
+		write(!zero)
+		if !zero {
+			readSrc()
+		}
+		e.writeFlags = true
+	case in.op == x86.CMP || in.op == x86.TEST:
+		e.reads |= registerMask(dst)
+		readSrc()
+		e.writeFlags = true
+	case in.op == x86.NEG:
+		write(true)
+		e.writeFlags = true
+	case in.op == x86.NOT:
+		write(true)
+	case in.op == x86.INC || in.op == x86.DEC:
+		// the carry flag is kept
+		write(true)
+		e.readFlags, e.writeFlags = true, true
+	case setcc:
+		write(false)
+		e.readFlags = true
+	case cmovcc:
+		// the destination is kept if the condition is false
+		write(true)
+		readSrc()
+		e.readFlags = true
+	default:
+		return e, false
+	}
+	return e, true
+}
@@ -0,0 +1,295 @@
+package elf
+
+import (
+	"go-elf/x86"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// disassemble returns the instructions of code in Intel syntax with single
+// spaces.
+func disassemble(t *testing.T, addr uint64, code []byte) []string {
+	t.Helper()
+	lines := []string{}
+	for pos := 0; pos < len(code); {
+		inst, err := x86.Decode(code[pos:])
+		if err != nil {
+			t.Fatalf("0x%x: %s", addr+uint64(pos), err)
+		}
+		lines = append(lines, strings.Join(strings.Fields(x86.IntelSyntax(inst, addr+uint64(pos), nil)), " "))
+		pos += inst.Len
+	}
+	return lines
+}
+
+func TestPeephole(t *testing.T) {
+	slot := x86.Mem{Base: x86.RBP, Disp: -8}
+	tests := []struct {
+		name     string
+		emit     func(c *Compiler)
+		expected []string
+	}{
+		{
+			name: "zero extended immediate",
+			emit: func(c *Compiler) {
+				c.emitMovRegImm64(6, 0x401000)
+				c.emitMovRegImm32(7, 0xffffffff) // sign extended
+				c.emit(x86.MOV, x86.R8, x86.Imm(1))
+			},
+			expected: []string{"mov esi,0x401000", "mov rdi,0xffffffffffffffff", "mov r8d,0x1"},
+		},
+		{
+			name: "compare with zero",
+			emit: func(c *Compiler) {
+				c.emit(x86.CMP, x86.RSI, x86.Imm(0))
+				c.emit(x86.CMP, x86.Mem{Base: x86.RSI, Size: 8}, x86.Imm(0))
+			},
+			expected: []string{"test rsi,rsi", "cmp BYTE PTR [rsi],0x0"},
+		},
+		{
+			name: "zero with xor",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, x86.RCX, x86.Imm(0))
+				c.emit(x86.MOV, x86.R9, x86.Imm(0))
+				c.emit(x86.ADD, x86.RDX, x86.RSI)
+			},
+			expected: []string{"xor ecx,ecx", "xor r9d,r9d", "add rdx,rsi"},
+		},
+		{
+			name: "zero before a flag reader",
+			emit: func(c *Compiler) {
+				c.emit(x86.CMP, x86.RDX, x86.RSI)
+				c.emit(x86.MOV, x86.ECX, x86.Imm(0))
+				c.emit(x86.SETL, x86.CL)
+				c.emit(x86.ADD, x86.RDX, x86.RCX)
+			},
+			expected: []string{"cmp rdx,rsi", "mov ecx,0x0", "setl cl", "add rdx,rcx"},
+		},
+		{
+			name: "zero at the end of the block",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, x86.RDI, x86.Imm(0))
+				c.emitSyscall()
+			},
+			expected: []string{"mov edi,0x0", "syscall"},
+		},
+		{
+			name: "forward store",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, slot, x86.RAX)
+				c.emit(x86.ADD, x86.RDX, x86.RAX)
+				c.emit(x86.MOV, x86.RCX, slot)
+				c.emit(x86.MOV, x86.RAX, slot)
+				c.emit(x86.ADD, x86.RCX, x86.RAX)
+			},
+			expected: []string{"mov QWORD PTR [rbp-0x8],rax", "add rdx,rax", "mov rcx,rax", "add rcx,rax"},
+		},
+		{
+			name: "store in between",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, slot, x86.RAX)
+				c.emit(x86.MOV, x86.Mem{Base: x86.RBX}, x86.RCX)
+				c.emit(x86.MOV, x86.RDX, slot)
+				c.emit(x86.ADD, x86.RDX, x86.RCX)
+			},
+			expected: []string{"mov QWORD PTR [rbp-0x8],rax", "mov QWORD PTR [rbx],rcx", "mov rdx,QWORD PTR [rbp-0x8]", "add rdx,rcx"},
+		},
+		{
+			name: "stored register overwritten",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, slot, x86.RAX)
+				c.emit(x86.ADD, x86.RAX, x86.RCX)
+				c.emit(x86.MOV, x86.RDX, slot)
+				c.emit(x86.ADD, x86.RDX, x86.RAX)
+			},
+			expected: []string{"mov QWORD PTR [rbp-0x8],rax", "add rax,rcx", "mov rdx,QWORD PTR [rbp-0x8]", "add rdx,rax"},
+		},
+		{
+			name: "dead move",
+			emit: func(c *Compiler) {
+				c.emit(x86.LEA, x86.RCX, x86.Mem{Base: x86.RAX, Disp: 8})
+				c.emit(x86.MOV, x86.RDX, x86.RCX)
+				c.emit(x86.MOV, x86.RDX, x86.Imm(1)) // removes the move from rcx
+				c.emit(x86.MOV, x86.RCX, x86.Imm(2)) // which read the result of lea
+			},
+			expected: []string{"mov edx,0x1", "mov ecx,0x2"},
+		},
+		{
+			name: "move read in between",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, x86.RCX, x86.RAX)
+				c.emit(x86.ADD, x86.RDX, x86.RCX)
+				c.emit(x86.MOV, x86.RCX, x86.RSI)
+				c.emit(x86.MOV, x86.CL, x86.DL) // partial write
+			},
+			expected: []string{"mov rcx,rax", "add rdx,rcx", "mov rcx,rsi", "mov cl,dl"},
+		},
+		{
+			name: "increment counter",
+			emit: func(c *Compiler) {
+				c.emitIncrementCounter(0x402000)
+				c.emitMovRegImm32(0, 60)
+			},
+			expected: []string{"add DWORD PTR ds:0x402000,0x1", "mov eax,0x3c"},
+		},
+		{
+			name: "increment counter and load it",
+			emit: func(c *Compiler) {
+				c.emitIncrementCounter(0x402000)
+				c.emitExit(0x402000)
+			},
+			expected: []string{"add DWORD PTR ds:0x402000,0x1", "mov edi,DWORD PTR ds:0x402000", "mov eax,0x3c", "syscall"},
+		},
+		{
+			name: "increment thread local",
+			emit: func(c *Compiler) {
+				c.emitIncrementThreadLocal(-8, 2)
+				c.emitMovRegImm32(0, 60)
+			},
+			expected: []string{"add DWORD PTR fs:0xfffffffffffffff8,0x2", "mov eax,0x3c"},
+		},
+		{
+			name: "increment with live register",
+			emit: func(c *Compiler) {
+				c.emitIncrementCounter(0x402000)
+				c.emit(x86.MOV, x86.EDI, x86.EAX)
+				c.emit(x86.ADD, x86.EAX, x86.Imm(1))
+			},
+			expected: []string{"mov eax,DWORD PTR ds:0x402000", "add eax,0x1", "mov DWORD PTR ds:0x402000,eax", "mov edi,eax", "add eax,0x1"},
+		},
+		{
+			name: "label",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, x86.RCX, x86.RAX)
+				c.label("next")
+				c.emit(x86.MOV, x86.RCX, x86.RDX)
+			},
+			expected: []string{"mov rcx,rax", "mov rcx,rdx"},
+		},
+		{
+			name: "unknown instruction",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, x86.RDX, x86.RAX)
+				c.emit(x86.CQO)
+				c.emit(x86.MOV, x86.RDX, x86.RCX)
+			},
+			expected: []string{"mov rdx,rax", "cqo", "mov rdx,rcx"},
+		},
+		{
+			name: "implicit operands",
+			emit: func(c *Compiler) {
+				c.emit(x86.MOV, x86.RCX, x86.RAX)
+				c.emit(x86.REP_MOVSB)
+				c.emit(x86.MOV, x86.RCX, x86.RDX)
+			},
+			expected: []string{"mov rcx,rax", "rep movs BYTE PTR es:[rdi],BYTE PTR ds:[rsi]", "mov rcx,rdx"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var addr uint64 = 0x401000
+			unoptimized := &Compiler{startAddr: addr}
+			test.emit(unoptimized)
+
+			c := &Compiler{startAddr: addr}
+			c.enablePeephole()
+			test.emit(c)
+			if err := c.resolve(); err != nil {
+				t.Fatal(err)
+			}
+
+			lines := disassemble(t, addr, c.buf)
+			if strings.Join(lines, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("expected\n\t%s\ngot\n\t%s", strings.Join(test.expected, "\n\t"), strings.Join(lines, "\n\t"))
+			}
+			stats := c.peepholeStats()
+			if stats.After-stats.Before != len(c.buf)-len(unoptimized.buf) {
+				t.Errorf("expected size %d -> %d got %s", len(unoptimized.buf), len(c.buf), stats)
+			}
+		})
+	}
+}
+
+// TestPeepholeCompile checks that the program of Compile and the programs
+// of the source language behave the same with the peephole optimizer, but
+// are smaller.
+func TestPeepholeCompile(t *testing.T) {
+	tempDir := t.TempDir()
+	var virtualAddress uint64 = 0x401000
+
+	run := func(t *testing.T, name string, entryPoint uint64, code []byte) ([]byte, int) {
+		t.Helper()
+		elfBinary, err := Write(virtualAddress, entryPoint, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputPath := filepath.Join(tempDir, name)
+		err = os.WriteFile(outputPath, elfBinary, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return execute(t, outputPath, tempDir)
+	}
+
+	t.Run("compile", func(t *testing.T) {
+		entryPoint, code, _, err := CompileOptimized(EM_X86_64, virtualAddress, Optimization{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		optimizedEntryPoint, optimized, stats, err := CompileOptimized(EM_X86_64, virtualAddress, Optimization{Peephole: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.After >= stats.Before || len(code)-len(optimized) != stats.Before-stats.After {
+			t.Errorf("expected %d bytes less got %s", len(code)-len(optimized), stats)
+		}
+
+		output, exitCode := run(t, "compile.elf", entryPoint, code)
+		optimizedOutput, optimizedExitCode := run(t, "compile-peephole.elf", optimizedEntryPoint, optimized)
+		if optimizedExitCode != exitCode || exitCode != 34 {
+			t.Errorf("expected exit code %d got %d", exitCode, optimizedExitCode)
+		}
+		if string(optimizedOutput) != string(output) {
+			t.Errorf("expected output %q got %q", output, optimizedOutput)
+		}
+	})
+
+	files, err := filepath.Glob("testdata/*.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no source files in testdata")
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		t.Run(name, func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entryPoint, code, _, err := CompileSourceOptimized(virtualAddress, string(source), Optimization{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			optimizedEntryPoint, optimized, stats, err := CompileSourceOptimized(virtualAddress, string(source), Optimization{Peephole: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(optimized) >= len(code) || stats.After >= stats.Before {
+				t.Errorf("expected less than %d bytes got %d, %s", len(code), len(optimized), stats)
+			}
+
+			output, exitCode := run(t, name+".elf", entryPoint, code)
+			optimizedOutput, optimizedExitCode := run(t, name+"-peephole.elf", optimizedEntryPoint, optimized)
+			if optimizedExitCode != exitCode {
+				t.Errorf("expected exit code %d got %d", exitCode, optimizedExitCode)
+			}
+			if string(optimizedOutput) != string(output) {
+				t.Errorf("expected output %q got %q", output, optimizedOutput)
+			}
+		})
+	}
+}
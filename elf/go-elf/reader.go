@@ -100,7 +100,7 @@ func (er *Reader) readSymbols() ([]Symbol64, error) {
 }
 
 func (er *Reader) readDynSymbols() ([]Symbol64, error) {
-	sectionName := ".dyntab"
+	sectionName := ".dynsym"
 	index, ok := er.sectionIndexByName(sectionName)
 	if !ok {
 		return nil, fmt.Errorf("section %s not found", sectionName)
@@ -130,3 +130,97 @@ func (er *Reader) readSymbolTable(sectionHeaderIndex int) ([]Symbol64, error) {
 	}
 	return symbols, err
 }
+
+func (er *Reader) readDynamic(sectionHeaderIndex int) ([]Dyn64, error) {
+	if sectionHeaderIndex > (len(er.SectionHeaders) - 1) {
+		return nil, fmt.Errorf("section header index too high")
+	}
+
+	sectionHeader := er.SectionHeaders[sectionHeaderIndex]
+
+	if sectionHeader.Type != SHT_DYNAMIC {
+		return nil, fmt.Errorf("section header index %d is not a dynamic section", sectionHeaderIndex)
+	}
+
+	entries := make([]Dyn64, sectionHeader.Size/dynSize)
+
+	data := bytes.NewBuffer(er.Data[sectionHeader.Offset : sectionHeader.Offset+sectionHeader.Size])
+
+	err := binary.Read(data, binary.LittleEndian, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (er *Reader) readRelocations(sectionHeaderIndex int) ([]Rela64, error) {
+	if sectionHeaderIndex > (len(er.SectionHeaders) - 1) {
+		return nil, fmt.Errorf("section header index too high")
+	}
+
+	sectionHeader := er.SectionHeaders[sectionHeaderIndex]
+
+	if sectionHeader.Type != SHT_RELA {
+		return nil, fmt.Errorf("section header index %d is not a relocation section", sectionHeaderIndex)
+	}
+
+	relocations := make([]Rela64, sectionHeader.Size/relaSize)
+
+	data := bytes.NewBuffer(er.Data[sectionHeader.Offset : sectionHeader.Offset+sectionHeader.Size])
+
+	err := binary.Read(data, binary.LittleEndian, &relocations)
+	if err != nil {
+		return nil, err
+	}
+	return relocations, nil
+}
+
+// Note is an entry of a note section.
+type Note struct {
+	Name string
+	Type NoteType
+	Desc []byte
+}
+
+func (er *Reader) readNotes(sectionHeaderIndex int) ([]Note, error) {
+	if sectionHeaderIndex > (len(er.SectionHeaders) - 1) {
+		return nil, fmt.Errorf("section header index too high")
+	}
+
+	sectionHeader := er.SectionHeaders[sectionHeaderIndex]
+
+	if sectionHeader.Type != SHT_NOTE {
+		return nil, fmt.Errorf("section header index %d is not a note section", sectionHeaderIndex)
+	}
+
+	if sectionHeader.Offset+sectionHeader.Size > uint64(len(er.Data)) {
+		return nil, fmt.Errorf("note section out of bounds")
+	}
+
+	align := max(sectionHeader.AddressAlign, 4)
+	data := er.Data[sectionHeader.Offset : sectionHeader.Offset+sectionHeader.Size]
+	notes := []Note{}
+	for len(data) > 0 {
+		header := NoteHeader{}
+		err := binary.Read(bytes.NewBuffer(data), binary.LittleEndian, &header)
+		if err != nil {
+			return nil, err
+		}
+
+		nameStart := uint64(binary.Size(header))
+		descStart := alignUp(nameStart+uint64(header.NameSize), align)
+		end := alignUp(descStart+uint64(header.DescSize), align)
+		if descStart+uint64(header.DescSize) > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid note size")
+		}
+
+		name, _, _ := bytes.Cut(data[nameStart:nameStart+uint64(header.NameSize)], []byte{0x0})
+		notes = append(notes, Note{
+			Name: string(name),
+			Type: header.Type,
+			Desc: data[descStart : descStart+uint64(header.DescSize)],
+		})
+		data = data[min(end, uint64(len(data))):]
+	}
+	return notes, nil
+}
@@ -11,13 +11,14 @@ import (
 	"testing"
 )
 
-func compile(cCode []byte, outputFile string) error {
+func compile(cCode []byte, outputFile string, flags ...string) error {
 	compiler, ok := os.LookupEnv("CC")
 	if !ok {
 		compiler = "gcc"
 	}
 
-	cmd := exec.Command(compiler, "-no-pie", "-x", "c", "-", "-o", outputFile)
+	args := append([]string{"-no-pie"}, flags...)
+	cmd := exec.Command(compiler, append(args, "-x", "c", "-", "-o", outputFile)...)
 	cmd.Stdin = bytes.NewBuffer(cCode)
 
 	_, err := cmd.Output()
@@ -0,0 +1,157 @@
+package elf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// riscvCompiler is the backend for 64-bit RISC-V (RV64GC). Only the base
+// integer instructions are used, which are all 4 bytes long; the compressed
+// instructions of the C extension are not generated. Addresses of data are
+// calculated relative to the program counter with auipc and addi.
+//
+// Linux system calls take the number in a7 and the arguments in a0-a5 and
+// are invoked with ecall.
+type riscvCompiler struct {
+	*Compiler
+}
+
+// RISC-V system call numbers (asm-generic/unistd.h)
+const (
+	riscvSysWrite = 64
+	riscvSysExit  = 93
+)
+
+// RISC-V registers by their ABI names
+const (
+	riscvZero = 0
+	riscvRA   = 1
+	riscvSP   = 2
+	riscvA0   = 10
+	riscvA1   = 11
+	riscvA2   = 12
+	riscvA7   = 17
+)
+
+// RISC-V major opcodes
+const (
+	riscvOpLoad   = 0x03
+	riscvOpImm    = 0x13
+	riscvOpAUIPC  = 0x17
+	riscvOpImm32  = 0x1b
+	riscvOpStore  = 0x23
+	riscvOp       = 0x33
+	riscvOpLUI    = 0x37
+	riscvOpBranch = 0x63
+	riscvOpJAL    = 0x6f
+	riscvOpSystem = 0x73
+)
+
+// The instruction formats of the base integer instruction set. The
+// immediates are split up differently in each format, so that the sign bit
+// is always bit 31 and the register fields stay at the same position.
+//
+//	     31          25 24   20 19   15 14  12 11          7 6      0
+//	R    funct7        | rs2   | rs1   |funct3| rd          | opcode
+//	I    imm[11:0]             | rs1   |funct3| rd          | opcode
+//	S    imm[11:5]     | rs2   | rs1   |funct3| imm[4:0]    | opcode
+//	B    imm[12|10:5]  | rs2   | rs1   |funct3| imm[4:1|11] | opcode
+//	U    imm[31:12]                           | rd          | opcode
+//	J    imm[20|10:1|11|19:12]                | rd          | opcode
+
+func riscvR(opcode, rd, funct3, rs1, rs2, funct7 uint32) uint32 {
+	return funct7<<25 | rs2<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func riscvI(opcode, rd, funct3, rs1 uint32, imm int32) uint32 {
+	return uint32(imm)&0xfff<<20 | rs1<<15 | funct3<<12 | rd<<7 | opcode
+}
+
+func riscvS(opcode, funct3, rs1, rs2 uint32, imm int32) uint32 {
+	i := uint32(imm)
+	return (i>>5)&0x7f<<25 | rs2<<20 | rs1<<15 | funct3<<12 | i&0x1f<<7 | opcode
+}
+
+func riscvB(opcode, funct3, rs1, rs2 uint32, imm int32) uint32 {
+	i := uint32(imm)
+	return (i>>12)&0x1<<31 | (i>>5)&0x3f<<25 | rs2<<20 | rs1<<15 | funct3<<12 | (i>>1)&0xf<<8 | (i>>11)&0x1<<7 | opcode
+}
+
+// riscvU takes the upper 20 bits of the immediate as value.
+func riscvU(opcode, rd uint32, imm int32) uint32 {
+	return uint32(imm)&0xfffff<<12 | rd<<7 | opcode
+}
+
+func riscvJ(opcode, rd uint32, imm int32) uint32 {
+	i := uint32(imm)
+	return (i>>20)&0x1<<31 | (i>>1)&0x3ff<<21 | (i>>11)&0x1<<20 | (i>>12)&0xff<<12 | rd<<7 | opcode
+}
+
+// riscvSplit splits a 32-bit value into the upper 20 bits for lui or auipc
+// and the lower 12 bits for a following addi. As the lower part is sign
+// extended, the upper part gets rounded up if bit 11 is set.
+func riscvSplit(value int64) (hi int32, lo int32) {
+	hi = int32((value + 0x800) >> 12)
+	lo = int32(value - int64(hi)<<12)
+	return hi, lo
+}
+
+func (c *riscvCompiler) emitInstruction(instruction uint32) {
+	c.buf = binary.LittleEndian.AppendUint32(c.buf, instruction)
+}
+
+// li rd, value
+func (c *riscvCompiler) emitLoadImmediate(rd uint32, value int32) {
+	if value >= -2048 && value < 2048 {
+		c.emitInstruction(riscvI(riscvOpImm, rd, 0, riscvZero, value)) // addi rd, zero, value
+		return
+	}
+	hi, lo := riscvSplit(int64(value))
+	c.emitInstruction(riscvU(riscvOpLUI, rd, hi))          // lui rd, hi
+	c.emitInstruction(riscvI(riscvOpImm32, rd, 0, rd, lo)) // addiw rd, rd, lo
+}
+
+// la rd, addr (within +-2GB of the instruction)
+func (c *riscvCompiler) emitLoadAddress(rd uint32, addr uint64) {
+	pc := c.startAddr + uint64(len(c.buf))
+	offset := int64(addr - pc)
+	if offset < -(1<<31) || offset >= 1<<31-0x800 {
+		panic(fmt.Sprintf("auipc offset %d out of range", offset))
+	}
+	hi, lo := riscvSplit(offset)
+	c.emitInstruction(riscvU(riscvOpAUIPC, rd, hi))      // auipc rd, hi
+	c.emitInstruction(riscvI(riscvOpImm, rd, 0, rd, lo)) // addi rd, rd, lo
+}
+
+// ecall
+func (c *riscvCompiler) emitEcall() {
+	c.emitInstruction(riscvI(riscvOpSystem, 0, 0, 0, 0))
+}
+
+func (c *riscvCompiler) emitWrite(fd int, bufAddr uint64, count int) {
+	c.emitLoadImmediate(riscvA7, riscvSysWrite) // a7 = syscall 64 (write)
+	c.emitLoadImmediate(riscvA0, int32(fd))     // a0 = fd
+	c.emitLoadAddress(riscvA1, bufAddr)         // a1 = buffer address
+	c.emitLoadImmediate(riscvA2, int32(count))  // a2 = count
+	c.emitEcall()
+}
+
+func (c *riscvCompiler) emitIncrementCounter(addr uint64) {
+	c.emitLoadAddress(riscvA1, addr)                                // a1 = counter address
+	c.emitInstruction(riscvI(riscvOpLoad, riscvA0, 2, riscvA1, 0))  // lw a0, 0(a1)
+	c.emitInstruction(riscvI(riscvOpImm32, riscvA0, 0, riscvA0, 1)) // addiw a0, a0, 1
+	c.emitInstruction(riscvS(riscvOpStore, 2, riscvA1, riscvA0, 0)) // sw a0, 0(a1)
+}
+
+func (c *riscvCompiler) emitExit(counterAddr uint64) {
+	c.emitLoadAddress(riscvA1, counterAddr)                        // a1 = counter address
+	c.emitInstruction(riscvI(riscvOpLoad, riscvA0, 2, riscvA1, 0)) // lw a0, 0(a1)
+	c.emitLoadImmediate(riscvA7, riscvSysExit)                     // a7 = syscall 93 (exit)
+	c.emitEcall()
+}
+
+func (c *riscvCompiler) emitExitCode(code int) {
+	c.emitLoadImmediate(riscvA0, int32(code))  // a0 = exit code
+	c.emitLoadImmediate(riscvA7, riscvSysExit) // a7 = syscall 93 (exit)
+	c.emitEcall()
+}
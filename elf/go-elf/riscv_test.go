@@ -0,0 +1,46 @@
+package elf
+
+import "testing"
+
+// The expected values are the encodings of GNU as for RV64GC with
+// compressed instructions disabled (-march=rv64g).
+func TestRISCVEncoding(t *testing.T) {
+	tests := []struct {
+		asm         string
+		instruction uint32
+		expected    uint32
+	}{
+		{"add a0, a1, a2", riscvR(riscvOp, riscvA0, 0, riscvA1, riscvA2, 0), 0x00c58533},
+		{"sub a0, a1, a2", riscvR(riscvOp, riscvA0, 0, riscvA1, riscvA2, 0x20), 0x40c58533},
+		{"li a7, 64", riscvI(riscvOpImm, riscvA7, 0, riscvZero, 64), 0x04000893},
+		{"addi sp, sp, -16", riscvI(riscvOpImm, riscvSP, 0, riscvSP, -16), 0xff010113},
+		{"addiw a0, a0, 1", riscvI(riscvOpImm32, riscvA0, 0, riscvA0, 1), 0x0015051b},
+		{"lw a0, 0(a1)", riscvI(riscvOpLoad, riscvA0, 2, riscvA1, 0), 0x0005a503},
+		{"sw a0, 0(a1)", riscvS(riscvOpStore, 2, riscvA1, riscvA0, 0), 0x00a5a023},
+		{"sd ra, -8(sp)", riscvS(riscvOpStore, 3, riscvSP, riscvRA, -8), 0xfe113c23},
+		{"beq a0, a1, .+8", riscvB(riscvOpBranch, 0, riscvA0, riscvA1, 8), 0x00b50463},
+		{"bnez a0, .-4", riscvB(riscvOpBranch, 1, riscvA0, riscvZero, -4), 0xfe051ee3},
+		{"lui a0, 0x12345", riscvU(riscvOpLUI, riscvA0, 0x12345), 0x12345537},
+		{"auipc a1, 0", riscvU(riscvOpAUIPC, riscvA1, 0), 0x00000597},
+		{"jal ra, .+8", riscvJ(riscvOpJAL, riscvRA, 8), 0x008000ef},
+		{"j .-4", riscvJ(riscvOpJAL, riscvZero, -4), 0xffdff06f},
+		{"ecall", riscvI(riscvOpSystem, 0, 0, 0, 0), 0x00000073},
+	}
+	for _, test := range tests {
+		if test.instruction != test.expected {
+			t.Errorf("%s: expected 0x%08x got 0x%08x", test.asm, test.expected, test.instruction)
+		}
+	}
+}
+
+func TestRISCVSplit(t *testing.T) {
+	for _, value := range []int64{0, 1, 0x7ff, 0x800, 0xfff, 0x1000, -1, -0x800, -0x801, 0x12345678, -0x12345678} {
+		hi, lo := riscvSplit(value)
+		if lo < -2048 || lo >= 2048 {
+			t.Errorf("0x%x: lower part %d out of range", value, lo)
+		}
+		if int64(hi)<<12+int64(lo) != value {
+			t.Errorf("0x%x: 0x%x<<12 + %d does not add up", value, hi, lo)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package elf
+
+import "go-elf/x86"
+
+// printIntLabel is the label of the routine which prints rax as decimal
+// number. Function names of the language of package lang can't start with a
+// dot, so it doesn't conflict with a function.
+const printIntLabel = ".print_int"
+
+// emitPrintInt emits the routine which writes rax as signed decimal number
+// to stdout. The digits are stored from the end of a buffer on the stack. It
+// overwrites the caller saved registers rsi, rdi, r8 and r9 in addition to
+// those of the syscall.
+func (c *Compiler) emitPrintInt() {
+	loop := c.newLabel()
+	positive := c.newLabel()
+	write := c.newLabel()
+
+	c.label(printIntLabel)
+	c.emit(x86.PUSH, x86.RBP)
+	c.emit(x86.MOV, x86.RBP, x86.RSP)
+	c.emit(x86.SUB, x86.RSP, x86.Imm(32))
+	c.emit(x86.MOV, x86.RSI, x86.RBP) // rsi = end of the buffer
+	c.emit(x86.MOV, x86.R9, x86.RAX)  // r9 = value with sign
+
+	// the absolute value of the smallest integer is 1<<63, which is
+	// correct as unsigned number
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JNS, positive)
+	c.emit(x86.NEG, x86.RAX)
+	c.label(positive)
+	c.emitMovRegImm32(8, 10) // r8 = 10
+
+	c.label(loop)
+	c.emit(x86.XOR, x86.EDX, x86.EDX)
+	c.emit(x86.DIV, x86.R8) // rax = rax / 10, rdx = rax % 10
+	c.emit(x86.ADD, x86.DL, x86.Imm('0'))
+	c.emit(x86.DEC, x86.RSI)
+	c.emit(x86.MOV, x86.Mem{Base: x86.RSI}, x86.DL)
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JNE, loop)
+
+	c.emit(x86.TEST, x86.R9, x86.R9)
+	c.emitJump(x86.JNS, write)
+	c.emit(x86.DEC, x86.RSI)
+	c.emit(x86.MOV, x86.Mem{Base: x86.RSI, Size: 8}, x86.Imm('-'))
+
+	c.label(write)
+	c.emit(x86.MOV, x86.RDX, x86.RBP)
+	c.emit(x86.SUB, x86.RDX, x86.RSI) // rdx = length
+	c.emitSys(SYS_WRITE, x86.Imm(1), x86.RSI, x86.RDX)
+	c.emit(x86.LEAVE)
+	c.emitRet()
+}
+
+// Labels of the functions of the heap allocator, which follow the System V
+// calling convention. They only overwrite caller saved registers.
+//
+//	void *runtime.alloc(size_t size): returns a block of at least size
+//	    bytes aligned to 16 bytes or NULL if no memory is available
+//	void runtime.free(void *ptr): releases a block returned by alloc
+const (
+	allocLabel = "runtime.alloc"
+	freeLabel  = "runtime.free"
+)
+
+const (
+	// heapHeaderSize is the size of the header in front of each block. It
+	// contains the size of the block including the header and the pointer
+	// to the next block of the free list.
+	heapHeaderSize = 16
+
+	// heapIncrement is the minimum size by which the heap is grown.
+	heapIncrement = 0x10000
+
+	// mmapThreshold is the size of a block from which on it is mapped by
+	// itself with mmap and unmapped by free.
+	mmapThreshold = 0x20000
+)
+
+// emitRuntime appends the heap allocator in its own section .runtime. Small
+// blocks are taken from the heap, which is grown with brk. Freed small
+// blocks are put on a free list and reused by alloc if they are large
+// enough (first fit). The variables of the allocator are in .bss.
+func (c *Compiler) emitRuntime() {
+	const (
+		heapNext = "runtime.heap_next" // next free address of the heap
+		heapEnd  = "runtime.heap_end"  // end of the heap, 0 if not initialized
+		freeList = "runtime.free_list" // first free block
+	)
+	c.beginSection(".runtime")
+
+	// rdi = size of the block
+	c.beginFunction(allocLabel)
+	c.label(allocLabel)
+	search := c.newLabel()
+	found := c.newLabel()
+	bump := c.newLabel()
+	grow := c.newLabel()
+	initialized := c.newLabel()
+	mapped := c.newLabel()
+	fail := c.newLabel()
+
+	c.emit(x86.ADD, x86.RDI, x86.Imm(heapHeaderSize+15))
+	c.emit(x86.AND, x86.RDI, x86.Imm(-16))
+	c.emit(x86.CMP, x86.RDI, x86.Imm(mmapThreshold))
+	c.emitJump(x86.JAE, mapped)
+
+	// first fit in the free list, rdx = address of the link to the block
+	c.emitLabelRIP(x86.LEA, freeList, x86.RDX, x86.Mem{RIP: true})
+	c.label(search)
+	c.emit(x86.MOV, x86.RAX, x86.Mem{Base: x86.RDX})
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JE, bump)
+	c.emit(x86.CMP, x86.Mem{Base: x86.RAX}, x86.RDI)
+	c.emitJump(x86.JAE, found)
+	c.emit(x86.LEA, x86.RDX, x86.Mem{Base: x86.RAX, Disp: 8})
+	c.emitJump(x86.JMP, search)
+	c.label(found)
+	c.emit(x86.MOV, x86.RCX, x86.Mem{Base: x86.RAX, Disp: 8})
+	c.emit(x86.MOV, x86.Mem{Base: x86.RDX}, x86.RCX) // unlink
+	c.emit(x86.ADD, x86.RAX, x86.Imm(heapHeaderSize))
+	c.emitRet()
+
+	// take the block from the end of the heap
+	c.label(bump)
+	c.emitLabelRIP(x86.MOV, heapNext, x86.RAX, x86.Mem{RIP: true})
+	c.emit(x86.LEA, x86.RCX, x86.Mem{Base: x86.RAX, Index: x86.RDI, Scale: 1})
+	c.emitLabelRIP(x86.CMP, heapEnd, x86.RCX, x86.Mem{RIP: true})
+	c.emitJump(x86.JA, grow)
+	c.emitLabelRIP(x86.MOV, heapNext, x86.Mem{RIP: true}, x86.RCX)
+	c.emit(x86.MOV, x86.Mem{Base: x86.RAX}, x86.RDI)
+	c.emit(x86.ADD, x86.RAX, x86.Imm(heapHeaderSize))
+	c.emitRet()
+
+	// grow the heap by the size of the block and the increment, r8 = size
+	c.label(grow)
+	c.emit(x86.MOV, x86.R8, x86.RDI)
+	c.emitLabelRIP(x86.CMP, heapEnd, x86.Mem{RIP: true, Size: 64}, x86.Imm(0))
+	c.emitJump(x86.JNE, initialized)
+	c.emitSys(SYS_BRK, x86.Imm(0)) // current end of the data segment
+	c.emitLabelRIP(x86.MOV, heapEnd, x86.Mem{RIP: true}, x86.RAX)
+	c.emit(x86.ADD, x86.RAX, x86.Imm(15))
+	c.emit(x86.AND, x86.RAX, x86.Imm(-16))
+	c.emitLabelRIP(x86.MOV, heapNext, x86.Mem{RIP: true}, x86.RAX)
+	c.label(initialized)
+	c.emitLabelRIP(x86.MOV, heapNext, x86.RDI, x86.Mem{RIP: true})
+	c.emit(x86.LEA, x86.RDI, x86.Mem{Base: x86.RDI, Index: x86.R8, Scale: 1, Disp: heapIncrement})
+	c.emitSys(SYS_BRK, x86.RDI)
+	c.emit(x86.CMP, x86.RAX, x86.RDI) // brk returns the old end on failure
+	c.emitJump(x86.JB, fail)
+	c.emitLabelRIP(x86.MOV, heapEnd, x86.Mem{RIP: true}, x86.RAX)
+	c.emit(x86.MOV, x86.RDI, x86.R8)
+	c.emitJump(x86.JMP, bump)
+
+	// large blocks get their own mapping
+	c.label(mapped)
+	c.emitSys(SYS_MMAP, x86.Imm(0), x86.RDI, x86.Imm(PROT_READ|PROT_WRITE), x86.Imm(MAP_PRIVATE|MAP_ANONYMOUS), x86.Imm(-1), x86.Imm(0))
+	c.emit(x86.CMP, x86.RAX, x86.Imm(-4096)) // error numbers are -4095 to -1
+	c.emitJump(x86.JA, fail)
+	c.emit(x86.MOV, x86.Mem{Base: x86.RAX}, x86.RSI) // length of the mapping
+	c.emit(x86.ADD, x86.RAX, x86.Imm(heapHeaderSize))
+	c.emitRet()
+
+	c.label(fail)
+	c.emit(x86.XOR, x86.EAX, x86.EAX)
+	c.emitRet()
+	c.endFunction()
+
+	// rdi = pointer to the block
+	c.beginFunction(freeLabel)
+	c.label(freeLabel)
+	null := c.newLabel()
+	unmap := c.newLabel()
+	c.emit(x86.TEST, x86.RDI, x86.RDI)
+	c.emitJump(x86.JE, null)
+	c.emit(x86.SUB, x86.RDI, x86.Imm(heapHeaderSize))
+	c.emit(x86.MOV, x86.RSI, x86.Mem{Base: x86.RDI}) // size
+	c.emit(x86.CMP, x86.RSI, x86.Imm(mmapThreshold))
+	c.emitJump(x86.JAE, unmap)
+	c.emitLabelRIP(x86.MOV, freeList, x86.RAX, x86.Mem{RIP: true})
+	c.emit(x86.MOV, x86.Mem{Base: x86.RDI, Disp: 8}, x86.RAX)
+	c.emitLabelRIP(x86.MOV, freeList, x86.Mem{RIP: true}, x86.RDI)
+	c.label(null)
+	c.emitRet()
+	c.label(unmap)
+	c.emitSys(SYS_MUNMAP, x86.RDI, x86.RSI)
+	c.emitRet()
+	c.endFunction()
+
+	bss := c.dataBuilder().BSS
+	bss.Align(8)
+	for _, name := range []string{heapNext, heapEnd, freeList} {
+		bss.Label(name)
+		bss.Reserve(8)
+	}
+}
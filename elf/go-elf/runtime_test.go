@@ -0,0 +1,175 @@
+package elf
+
+import (
+	"go-elf/x86"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRuntimeAlloc runs a program which allocates and fills a large mapped
+// buffer and many small blocks from the heap, and writes their checksums.
+// It frees a small block and checks that the next allocation reuses it.
+func TestRuntimeAlloc(t *testing.T) {
+	c := &Compiler{
+		startAddr: 0x401000,
+		buf:       make([]byte, 0),
+	}
+	newline := c.addString("\n")
+	entryPoint := c.startAddr + uint64(len(c.buf))
+
+	const (
+		large  = 1 << 20
+		blocks = 2000
+		size   = 200
+	)
+	printLine := func(value x86.Operand) {
+		c.emit(x86.MOV, x86.RAX, value)
+		c.emitJump(x86.CALL, printIntLabel)
+		c.emitWrite(1, newline, 1)
+	}
+	fail := c.newLabel()
+
+	// rbx = large buffer, byte i = i & 0xff
+	fill := c.newLabel()
+	sum := c.newLabel()
+	c.emitCallFunction(allocLabel, x86.Imm(large))
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JE, fail)
+	c.emit(x86.MOV, x86.RBX, x86.RAX)
+	c.emit(x86.XOR, x86.R12D, x86.R12D)
+	c.label(fill)
+	c.emit(x86.MOV, x86.Mem{Base: x86.RBX, Index: x86.R12, Scale: 1}, x86.R12B)
+	c.emit(x86.INC, x86.R12)
+	c.emit(x86.CMP, x86.R12, x86.Imm(large))
+	c.emitJump(x86.JB, fill)
+	c.emit(x86.XOR, x86.R12D, x86.R12D)
+	c.emit(x86.XOR, x86.R13D, x86.R13D)
+	c.label(sum)
+	c.emit(x86.MOVZX, x86.EAX, x86.Mem{Base: x86.RBX, Index: x86.R12, Scale: 1, Size: 8})
+	c.emit(x86.ADD, x86.R13, x86.RAX)
+	c.emit(x86.INC, x86.R12)
+	c.emit(x86.CMP, x86.R12, x86.Imm(large))
+	c.emitJump(x86.JB, sum)
+	printLine(x86.R13)
+
+	// r14 = array of small blocks, the bytes of block i are i & 0xff
+	next := c.newLabel()
+	fillBlock := c.newLabel()
+	sumBlocks := c.newLabel()
+	c.emitCallFunction(allocLabel, x86.Imm(8*blocks))
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JE, fail)
+	c.emit(x86.MOV, x86.R14, x86.RAX)
+	c.emit(x86.XOR, x86.R12D, x86.R12D)
+	c.label(next)
+	c.emitCallFunction(allocLabel, x86.Imm(size))
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JE, fail)
+	c.emit(x86.MOV, x86.Mem{Base: x86.R14, Index: x86.R12, Scale: 8}, x86.RAX)
+	c.emit(x86.XOR, x86.ECX, x86.ECX)
+	c.label(fillBlock)
+	c.emit(x86.MOV, x86.Mem{Base: x86.RAX, Index: x86.RCX, Scale: 1}, x86.R12B)
+	c.emit(x86.INC, x86.RCX)
+	c.emit(x86.CMP, x86.RCX, x86.Imm(size))
+	c.emitJump(x86.JB, fillBlock)
+	c.emit(x86.INC, x86.R12)
+	c.emit(x86.CMP, x86.R12, x86.Imm(blocks))
+	c.emitJump(x86.JB, next)
+
+	// the first and last byte of all blocks, which overlap if the
+	// allocator hands out a block twice
+	c.emit(x86.XOR, x86.R12D, x86.R12D)
+	c.emit(x86.XOR, x86.R13D, x86.R13D)
+	c.label(sumBlocks)
+	c.emit(x86.MOV, x86.RDI, x86.Mem{Base: x86.R14, Index: x86.R12, Scale: 8})
+	c.emit(x86.MOVZX, x86.EAX, x86.Mem{Base: x86.RDI, Size: 8})
+	c.emit(x86.ADD, x86.R13, x86.RAX)
+	c.emit(x86.MOVZX, x86.EAX, x86.Mem{Base: x86.RDI, Disp: size - 1, Size: 8})
+	c.emit(x86.ADD, x86.R13, x86.RAX)
+	c.emit(x86.INC, x86.R12)
+	c.emit(x86.CMP, x86.R12, x86.Imm(blocks))
+	c.emitJump(x86.JB, sumBlocks)
+	printLine(x86.R13)
+
+	// r15 = freed block, which is reused by the next allocation
+	c.emit(x86.MOV, x86.R15, x86.Mem{Base: x86.R14, Disp: 8 * 5})
+	c.emitCallFunction(freeLabel, x86.R15)
+	c.emitCallFunction(allocLabel, x86.Imm(size))
+	c.emit(x86.CMP, x86.RAX, x86.R15)
+	c.emit(x86.SETE, x86.AL)
+	c.emit(x86.MOVZX, x86.EAX, x86.AL)
+	printLine(x86.RAX)
+
+	c.emitCallFunction(freeLabel, x86.RBX)
+	c.emitCallFunction(freeLabel, x86.R14)
+	c.emitSys(SYS_EXIT, x86.Imm(0))
+	c.label(fail)
+	c.emitSys(SYS_EXIT, x86.Imm(1))
+
+	c.emitPrintInt()
+	c.emitRuntime()
+
+	outputPath := filepath.Join(t.TempDir(), "alloc.elf")
+	writeProgram(t, c, entryPoint, outputPath)
+
+	output, exitCode := execute(t, outputPath, t.TempDir())
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 got %d", exitCode)
+	}
+	// 4096 times the sum of 0 to 255 and twice the sum of i & 0xff
+	expected := "133693440\n500016\n1\n"
+	if string(output) != expected {
+		t.Errorf("expected output %q got %q", expected, output)
+	}
+
+	// the allocator is in its own section with a symbol for each function,
+	// its variables are in .bss
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Reader{File: file, Data: data}
+	index, ok := r.sectionIndexByName(".runtime")
+	if !ok {
+		t.Fatal("section .runtime not found")
+	}
+	bss, ok := r.sectionIndexByName(".bss")
+	if !ok {
+		t.Fatal("section .bss not found")
+	}
+	symtab, ok := r.sectionIndexByName(".symtab")
+	if !ok {
+		t.Fatal("section .symtab not found")
+	}
+	symbols, err := r.readSymbolTable(symtab)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, symbol := range symbols[1:] {
+		name, err := r.readString(int(r.SectionHeaders[symtab].Link), int(symbol.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, sectionName := index, ".runtime"
+		if symbol.SymbolType() == STT_OBJECT {
+			expected, sectionName = bss, ".bss"
+		}
+		section := r.SectionHeaders[expected]
+		inside := symbol.Value >= section.Address && symbol.Value+symbol.Size <= section.Address+section.Size
+		if int(symbol.SectionHeaderIndex) != expected || !inside {
+			t.Errorf("symbol %s at 0x%x is not in section %s", name, symbol.Value, sectionName)
+		}
+		found[name] = true
+	}
+	for _, name := range []string{allocLabel, freeLabel, "runtime.heap_next", "runtime.heap_end", "runtime.free_list"} {
+		if !found[name] {
+			t.Errorf("symbol %s not found", name)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package elf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Function is a function defined in the generated code.
+type Function struct {
+	Name    string
+	Address uint64
+	Size    uint64
+}
+
+// SharedObject is the layout of a shared library (ET_DYN) which exports
+// functions of the generated code. The file is mapped at an arbitrary base
+// address chosen by the dynamic linker, so the code has to be position
+// independent and all addresses are relative to the beginning of the file.
+//
+// The headers and the tables of the dynamic linker are read only. The
+// dynamic section starts on a new page in a writable segment, because the
+// dynamic linker writes to it while relocating the shared object, and is
+// read only after relocation (PT_GNU_RELRO). The code starts on the next
+// page in a readable and executable segment:
+//
+//	ELF header, program headers
+//	.note.gnu.build-id
+//	.gnu.hash  GNU hash table used to look up the exports
+//	.dynsym    exported symbols
+//	.dynstr    names of exported symbols, needed libraries and DT_SONAME
+//	.dynamic   information for the dynamic linker (PT_DYNAMIC)
+//	.text      code
+//	.rodata    Data, like that of the Writer
+//	.data      Data
+//	.bss       Data
+type SharedObject struct {
+	// Data is placed after the code if set, see Writer.Data.
+	Data *DataBuilder
+
+	// exports in the order of the dynamic symbol table
+	exports []string
+
+	img     *image
+	dynsym  *section
+	dynstr  *stringTable
+	dynamic *section
+	text    *section
+
+	// program headers after the PT_LOADs
+	lastProgramHeaders []ProgramHeader64
+}
+
+// NewSharedObject returns the layout of a shared object with the name soname
+// (DT_SONAME) which exports the functions named in exports.
+func NewSharedObject(soname string, needed []string, exports []string) *SharedObject {
+	s := &SharedObject{
+		img:    newImage(ET_DYN, EM_X86_64),
+		dynstr: newStringTable(),
+	}
+
+	dynamics := []Dyn64{}
+	for _, lib := range needed {
+		dynamics = append(dynamics, Dyn64{Tag: DT_NEEDED, Value: uint64(s.dynstr.add(lib))})
+	}
+	dynamics = append(dynamics, Dyn64{Tag: DT_SONAME, Value: uint64(s.dynstr.add(soname))})
+
+	gnuHash, sortedExports := gnuHashTable(exports)
+	s.exports = sortedExports
+	for _, name := range s.exports {
+		s.dynstr.add(name)
+	}
+
+	img := s.img
+	img.addBuildIDNote()
+	hash := img.addSection(".gnu.hash", SHT_GNU_HASH, SHF_ALLOC, 8, gnuHash)
+	s.dynsym = img.addSection(".dynsym", SHT_DYNSYM, SHF_ALLOC, 8, make([]byte, uint64(len(exports)+1)*symbolSize))
+	s.dynsym.header.EntSize = symbolSize
+	s.dynsym.header.Info = 1 // index of the first non-local symbol
+	dynstrSection := img.addSection(".dynstr", SHT_STRTAB, SHF_ALLOC, 1, s.dynstr.bytes())
+
+	dynamicCount := len(dynamics) + 6
+	s.dynamic = img.addSection(".dynamic", SHT_DYNAMIC, SHF_ALLOC|SHF_WRITE, 8, make([]byte, uint64(dynamicCount)*dynSize))
+	s.dynamic.header.EntSize = dynSize
+
+	s.text = img.addSection(".text", SHT_PROGBITS, SHF_ALLOC|SHF_EXECINSTR, 16, nil)
+	s.text.alignment = 0x1000
+
+	hash.header.Link = img.sectionIndex(s.dynsym)
+	s.dynsym.header.Link = img.sectionIndex(dynstrSection)
+	s.dynamic.header.Link = img.sectionIndex(dynstrSection)
+
+	// PT_LOAD..., PT_DYNAMIC, PT_GNU_RELRO, PT_NOTE, PT_GNU_STACK
+	img.alignSegments()
+	img.layout(2 + len(img.segments()) + maxDataSegments + len(img.gnuProgramHeaders()))
+	img.setBase(0)
+
+	dynamics = append(dynamics,
+		Dyn64{Tag: DT_GNU_HASH, Value: hash.header.Address},
+		Dyn64{Tag: DT_STRTAB, Value: dynstrSection.header.Address},
+		Dyn64{Tag: DT_SYMTAB, Value: s.dynsym.header.Address},
+		Dyn64{Tag: DT_STRSZ, Value: dynstrSection.header.Size},
+		Dyn64{Tag: DT_SYMENT, Value: symbolSize},
+		Dyn64{Tag: DT_NULL},
+	)
+	s.dynamic.setData(encode(dynamics))
+
+	// the PT_LOADs depend on the code and the data and are set in Write
+	s.lastProgramHeaders = []ProgramHeader64{
+		{
+			Type:            PT_DYNAMIC,
+			Flags:           PF_R | PF_W,
+			Offset:          s.dynamic.header.Offset,
+			VirtualAddress:  s.dynamic.header.Address,
+			PhysicalAddress: s.dynamic.header.Address,
+			FileSize:        s.dynamic.header.Size,
+			MemorySize:      s.dynamic.header.Size,
+			Align:           8,
+		},
+		{
+			Type:       PT_GNU_RELRO,
+			Flags:      PF_R,
+			Offset:     0,
+			FileSize:   s.text.header.Offset,
+			MemorySize: s.text.header.Offset,
+			Align:      1,
+		},
+	}
+	s.lastProgramHeaders = append(s.lastProgramHeaders, img.gnuProgramHeaders()...)
+
+	return s
+}
+
+// CodeAddress returns the address of the code relative to the load address.
+func (s *SharedObject) CodeAddress() uint64 {
+	return s.text.header.Address
+}
+
+// Write returns the ELF file containing the code. Every export has to be
+// defined by one of the functions and may only be named once.
+func (s *SharedObject) Write(code []byte, functions []Function) ([]byte, error) {
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no code to write")
+	}
+	img := s.img
+
+	symbols := NewSymbolTable64()
+	exported := map[string]bool{}
+	for _, name := range s.exports {
+		if exported[name] {
+			return nil, fmt.Errorf("function %s is exported more than once", name)
+		}
+		exported[name] = true
+		index := -1
+		for i, f := range functions {
+			if f.Name == name {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("exported function %s is not defined", name)
+		}
+		symbols = append(symbols, Symbol64{
+			Name:               s.dynstr.add(name),
+			Info:               NewSymbolInfo(STB_GLOBAL, STT_FUNC),
+			SectionHeaderIndex: uint16(img.sectionIndex(s.text)),
+			Value:              functions[index].Address,
+			Size:               functions[index].Size,
+		})
+	}
+	s.dynsym.setData(encode(symbols))
+
+	img.setLastData(s.text, code)
+	if s.Data != nil {
+		img.addDataAfter(s.text, s.Data)
+	}
+
+	img.setProgramHeaders(nil, s.lastProgramHeaders)
+
+	return img.bytes()
+}
+
+// gnuHashTable generates the content of a .gnu.hash section for the given
+// symbol names. The dynamic symbols have to be ordered by their hash bucket,
+// so the names are returned in the order in which they have to be placed in
+// the symbol table after the null symbol.
+//
+//	nbuckets, symoffset, bloomSize, bloomShift uint32
+//	bloom   [bloomSize]uint64
+//	buckets [nbuckets]uint32   index of the first symbol in the bucket
+//	chain   [symbols]uint32    hash with the lowest bit marking the end of a bucket
+func gnuHashTable(names []string) ([]byte, []string) {
+	const (
+		symbolOffset = 1 // the null symbol is not part of the table
+		bloomShift   = 6
+	)
+	nbuckets := uint32(max(len(names), 1))
+	bloomSize := uint32(1)
+
+	sorted := append([]string{}, names...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return gnuHash(sorted[i])%nbuckets < gnuHash(sorted[j])%nbuckets
+	})
+
+	bloom := make([]uint64, bloomSize)
+	buckets := make([]uint32, nbuckets)
+	chain := make([]uint32, len(sorted))
+	for i, name := range sorted {
+		h := gnuHash(name)
+		bloom[(h/64)%bloomSize] |= 1<<(h%64) | 1<<((h>>bloomShift)%64)
+
+		bucket := h % nbuckets
+		if buckets[bucket] == 0 {
+			buckets[bucket] = uint32(i + symbolOffset)
+		}
+
+		chain[i] = h &^ 1
+		if i == len(sorted)-1 || gnuHash(sorted[i+1])%nbuckets != bucket {
+			chain[i] |= 1
+		}
+	}
+
+	data := binary.LittleEndian.AppendUint32(nil, nbuckets)
+	data = binary.LittleEndian.AppendUint32(data, symbolOffset)
+	data = binary.LittleEndian.AppendUint32(data, bloomSize)
+	data = binary.LittleEndian.AppendUint32(data, bloomShift)
+	data = append(data, encode(bloom)...)
+	data = append(data, encode(buckets)...)
+	data = append(data, encode(chain)...)
+	return data, sorted
+}
+
+func gnuHash(name string) uint32 {
+	h := uint32(5381)
+	for _, c := range []byte(name) {
+		h = h*33 + uint32(c)
+	}
+	return h
+}
@@ -0,0 +1,52 @@
+package elf
+
+import (
+	"fmt"
+	"go-elf/lang"
+)
+
+// CompileSource compiles a program of the language of package lang to
+// x86-64 machine code for a statically linked executable. The code starts
+// at startAddr with the entry point, which calls main and exits with its
+// return value. The string constants follow the code.
+//
+// The program is translated to the SSA form of package ir, whose values get
+// registers by linear scan register allocation. Arguments are passed in the
+// registers of the System V ABI and spilled values are stored in the stack
+// frame below rbp.
+//
+// Programs which use the heap or the arguments of the process need the
+// sections of CompileSourceExecutable.
+func CompileSource(startAddr uint64, source string) (entryPoint uint64, code []byte, err error) {
+	entryPoint, code, _, err = CompileSourceOptimized(startAddr, source, Optimization{})
+	return entryPoint, code, err
+}
+
+// CompileSourceOptimized is like CompileSource with the optimizations of
+// opt. It returns the size of the instructions before and after the
+// peephole optimizer.
+func CompileSourceOptimized(startAddr uint64, source string, opt Optimization) (entryPoint uint64, code []byte, stats PeepholeStats, err error) {
+	w, stats, err := CompileSourceExecutable(startAddr, source, opt)
+	if err != nil {
+		return 0, nil, PeepholeStats{}, err
+	}
+	if w.Data != nil {
+		return 0, nil, PeepholeStats{}, fmt.Errorf("the program needs the data sections of CompileSourceExecutable")
+	}
+	return w.EntryPoint, w.Code, stats, nil
+}
+
+// CompileSourceExecutable is like CompileSourceOptimized, but returns the
+// executable with the variables of the heap allocator in its data sections
+// and a symbol for each function.
+func CompileSourceExecutable(startAddr uint64, source string, opt Optimization) (*Writer, PeepholeStats, error) {
+	program, err := lang.Parse(source)
+	if err != nil {
+		return nil, PeepholeStats{}, err
+	}
+	p, err := lang.BuildIR(program)
+	if err != nil {
+		return nil, PeepholeStats{}, err
+	}
+	return compileIR(startAddr, p, irRegisters, opt)
+}
@@ -0,0 +1,312 @@
+package elf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompileSource(t *testing.T) {
+	fib, err := os.ReadFile("testdata/fib.src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		output   string
+		exitCode int
+	}{
+		{
+			name:   "fib",
+			source: string(fib),
+			output: "fib(0) = 0\nfib(1) = 1\nfib(2) = 1\nfib(3) = 2\nfib(4) = 3\nfib(5) = 5\nfib(6) = 8\nfib(7) = 13\nfib(8) = 21\nfib(9) = 34\n" +
+				"-42 0 -9223372036854775808\n",
+			exitCode: 55,
+		},
+		{
+			name: "arithmetic",
+			source: `func main() {
+				print(7 + 3 * 4, " ", (7 + 3) * 4, " ", 17 / 5, " ", 17 % 5, " ", -17 / 5, " ", -17 % 5, "\n");
+				print(10 - 2 - 3, " ", -(2 - 5), " ", 0x7fffffffffff + 1, "\n");
+			}`,
+			output: "19 40 3 2 -3 -2\n5 3 140737488355328\n",
+		},
+		{
+			name: "comparisons",
+			source: `func main() {
+				print(1 < 2, 2 < 1, 1 <= 1, 2 > 1, 1 >= 2, 3 == 3, 3 != 3, !0, !5, "\n");
+				print(1 && 2, 1 && 0, 0 || 0, 0 || 7, -1 < 0, "\n");
+			}`,
+			output: "101101010\n10011\n",
+		},
+		{
+			name: "short circuit",
+			source: `func loud(x) {
+				print("loud ");
+				return x;
+			}
+			func main() {
+				if 0 && loud(1) { print("wrong"); }
+				if 1 || loud(1) { print("yes "); }
+				if loud(1) && loud(0) { print("wrong"); } else { print("no\n"); }
+			}`,
+			output: "yes loud loud no\n",
+		},
+		{
+			name: "scopes",
+			source: `func main() {
+				var x = 1;
+				if x {
+					var x = 2;
+					print(x);
+					x = 3;
+					print(x);
+				}
+				{
+					var y = x + 10;
+					print(y);
+				}
+				print(x, "\n");
+				return x;
+			}`,
+			output:   "23111\n",
+			exitCode: 1,
+		},
+		{
+			name: "else if",
+			source: `func sign(x) {
+				if x < 0 {
+					return -1;
+				} else if x == 0 {
+					return;
+				} else {
+					return 1;
+				}
+			}
+			func main() {
+				print(sign(-5), sign(0), sign(8), "\n");
+			}`,
+			output: "-101\n",
+		},
+		{
+			name: "arguments",
+			source: `func f(a, b, c, d, e, g) {
+				return a * 100000 + b * 10000 + c * 1000 + d * 100 + e * 10 + g;
+			}
+			func main() {
+				print(f(1, 2, 3, 4, 5, 6), " ", f(f(0, 0, 0, 0, 0, 1), 0, 0, 0, 0, f(0, 0, 0, 0, 0, 9)), "\n");
+			}`,
+			output: "123456 100009\n",
+		},
+		{
+			name: "exit",
+			source: `func main() {
+				var i = 0;
+				while 1 {
+					if i == 5 {
+						exit(i * 10);
+					}
+					i = i + 1;
+				}
+			}`,
+			exitCode: 50,
+		},
+	}
+
+	tempDir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputPath := filepath.Join(tempDir, "output.elf")
+			compileSourceFile(t, test.source, outputPath)
+			output, exitCode := execute(t, outputPath, "")
+			if exitCode != test.exitCode {
+				t.Errorf("expected exit code %d got %d", test.exitCode, exitCode)
+			}
+			if string(output) != test.output {
+				t.Errorf("expected output %q got %q", test.output, output)
+			}
+		})
+	}
+}
+
+// TestCompileSourceHeap runs a program which builds a linked list on the
+// heap through the executable of CompileSourceExecutable.
+func TestCompileSourceHeap(t *testing.T) {
+	source := `// list returns a linked list of the numbers 1 to n, each node is
+// the value followed by the address of the next node
+func list(n) {
+	var head = 0;
+	while n > 0 {
+		var node = alloc(16);
+		store(node, n);
+		store(node + 8, head);
+		head = node;
+		n = n - 1;
+	}
+	return head;
+}
+
+func sum(node) {
+	var s = 0;
+	while node != 0 {
+		s = s + load(node);
+		var next = load(node + 8);
+		free(node);
+		node = next;
+	}
+	return s;
+}
+
+func main() {
+	print(sum(list(1000)), "\n");
+
+	// freed blocks are reused
+	var a = alloc(100);
+	free(a);
+	print(alloc(100) == a, "\n");
+
+	// large blocks are mapped
+	var big = alloc(1000000);
+	store(big + 999992, 7);
+	var value = load(big + 999992);
+	free(big);
+	return value;
+}`
+	tempDir := t.TempDir()
+	for _, opt := range []Optimization{{}, {Fold: true, Peephole: true}} {
+		w, _, err := CompileSourceExecutable(0x401000, source, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if _, err := w.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+		outputPath := filepath.Join(tempDir, "heap.elf")
+		if err := os.WriteFile(outputPath, buf.Bytes(), 0755); err != nil {
+			t.Fatal(err)
+		}
+		output, exitCode := execute(t, outputPath, "")
+		if exitCode != 7 {
+			t.Errorf("%+v: expected exit code 7 got %d", opt, exitCode)
+		}
+		if string(output) != "500500\n1\n" {
+			t.Errorf("%+v: expected output %q got %q", opt, "500500\n1\n", output)
+		}
+
+		file, err := Read(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &Reader{File: file, Data: buf.Bytes()}
+		for _, name := range []string{".runtime", ".bss"} {
+			if _, ok := r.sectionIndexByName(name); !ok {
+				t.Errorf("%+v: section %s not found", opt, name)
+			}
+		}
+	}
+
+	// without the heap the sections aren't needed
+	w, _, err := CompileSourceExecutable(0x401000, "func main() { return 1; }", Optimization{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Data != nil || len(w.Sections) != 0 {
+		t.Errorf("expected no data and sections got %v %v", w.Data, w.Sections)
+	}
+}
+
+// TestCompileSourceArgs runs a program which reads its arguments, the
+// environment and the auxiliary vector.
+func TestCompileSourceArgs(t *testing.T) {
+	source := `// count returns the number of pointers in front of the 0 at p
+func count(p) {
+	var n = 0;
+	while load(p + 8 * n) != 0 {
+		n = n + 1;
+	}
+	return n;
+}
+
+func main() {
+	print(argc(), " ", count(argv()), " ", count(envp()), "\n");
+
+	// the page size is the value of the entry of type AT_PAGESZ
+	var p = auxv();
+	while load(p) != 0 {
+		if load(p) == 6 {
+			print(load(p + 8), "\n");
+		}
+		p = p + 16;
+	}
+	return argc();
+}`
+	w, _, err := CompileSourceExecutable(0x401000, source, Optimization{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if _, err := w.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	outputPath := filepath.Join(t.TempDir(), "args.elf")
+	if err := os.WriteFile(outputPath, buf.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(outputPath, "first", "", "last")
+	cmd.Env = []string{"A=1", "B=2"}
+	output, err := cmd.Output()
+	exitErr := &exec.ExitError{}
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 4 {
+		t.Errorf("expected exit code 4 got %v", err)
+	}
+	expected := fmt.Sprintf("4 4 2\n%d\n", os.Getpagesize())
+	if string(output) != expected {
+		t.Errorf("expected output %q got %q", expected, output)
+	}
+}
+
+func TestCompileSourceErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{"func f() {}", "function main is not defined"},
+		{"func main() {}\nfunc main() {}", "2:1: function main already defined"},
+		{"func main(a) {}", "1:1: function main must not have parameters"},
+		{"func print() {}", "1:1: print is a builtin function"},
+		{"func f(a, b, c, d, e, f, g) {}", "1:1: function f has more than 6 parameters"},
+		{"func main() {\n  x = 1;\n}", "2:3: undefined variable x"},
+		{"func main() { return y + 1; }", "1:22: undefined variable y"},
+		{"func main() { var a = 1; var a = 2; }", "1:26: a already declared"},
+		{"func main() { var main = 1; }", "1:15: main is a function"},
+		{"func main() { f(); }", "1:15: undefined function f"},
+		{"func f(a) {} func main() { f(1, 2); }", "1:28: f expects 1 arguments, got 2"},
+		{"func main() { exit(); }", "1:15: exit expects 1 argument, got 0"},
+		{"func main() { var s = \"text\"; }", "1:23: string \"text\" is only allowed as argument of print"},
+		{"func main() { return 1 }", "1:24: expected ;, found }"},
+		{"func main() { store(0); }", "1:15: store expects 2 arguments, got 1"},
+		{"func main() { var p = alloc(); }", "1:23: alloc expects 1 argument, got 0"},
+		{"func main() { var x = free(0); }", "1:23: free(0) has no value"},
+		{"func free() {}", "1:1: free is a builtin function"},
+		{"func main() { return argc(1); }", "1:22: argc expects 0 arguments, got 1"},
+		{"func main() { free(alloc(8)); }", "the program needs the data sections of CompileSourceExecutable"},
+	}
+	for _, test := range tests {
+		_, _, err := CompileSource(0x401000, test.source)
+		if err == nil {
+			t.Errorf("%q: expected error %q", test.source, test.err)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%q: expected error %q got %q", test.source, test.err, err)
+		}
+	}
+}
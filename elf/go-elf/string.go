@@ -1,4 +1,4 @@
-// Code generated by "stringer -type FileType,Class,Data,ProgramHeaderFlag,ProgramHeaderType,SectionHeaderFlag,SectionHeaderType,SymbolType,SymbolBinding,SymbolVisibility -output string.go"; DO NOT EDIT.
+// Code generated by "stringer -type FileType,Class,Data,ProgramHeaderFlag,ProgramHeaderType,SectionHeaderFlag,SectionHeaderType,SymbolType,SymbolBinding,SymbolVisibility,Machine,OSABI,DynamicTag,RelocationType,NoteType -output string.go"; DO NOT EDIT.
 
 package elf
 
@@ -126,28 +126,45 @@ func _() {
 	_ = x[PT_NOTE-4]
 	_ = x[PT_SHLIB-5]
 	_ = x[PT_PHDR-6]
+	_ = x[PT_TLS-7]
+	_ = x[PT_LOOS-1610612736]
+	_ = x[PT_GNU_EH_FRAME-1685382480]
+	_ = x[PT_GNU_STACK-1685382481]
+	_ = x[PT_GNU_RELRO-1685382482]
+	_ = x[PT_GNU_PROPERTY-1685382483]
+	_ = x[PT_HIOS-1879048191]
 	_ = x[PT_LOPROC-1879048192]
 	_ = x[PT_HIPROC-2147483647]
 }
 
 const (
-	_ProgramHeaderType_name_0 = "PT_NULLPT_LOADPT_DYNAMICPT_INTERPPT_NOTEPT_SHLIBPT_PHDR"
-	_ProgramHeaderType_name_1 = "PT_LOPROC"
-	_ProgramHeaderType_name_2 = "PT_HIPROC"
+	_ProgramHeaderType_name_0 = "PT_NULLPT_LOADPT_DYNAMICPT_INTERPPT_NOTEPT_SHLIBPT_PHDRPT_TLS"
+	_ProgramHeaderType_name_1 = "PT_LOOS"
+	_ProgramHeaderType_name_2 = "PT_GNU_EH_FRAMEPT_GNU_STACKPT_GNU_RELROPT_GNU_PROPERTY"
+	_ProgramHeaderType_name_3 = "PT_HIOSPT_LOPROC"
+	_ProgramHeaderType_name_4 = "PT_HIPROC"
 )
 
 var (
-	_ProgramHeaderType_index_0 = [...]uint8{0, 7, 14, 24, 33, 40, 48, 55}
+	_ProgramHeaderType_index_0 = [...]uint8{0, 7, 14, 24, 33, 40, 48, 55, 61}
+	_ProgramHeaderType_index_2 = [...]uint8{0, 15, 27, 39, 54}
+	_ProgramHeaderType_index_3 = [...]uint8{0, 7, 16}
 )
 
 func (i ProgramHeaderType) String() string {
 	switch {
-	case i <= 6:
+	case i <= 7:
 		return _ProgramHeaderType_name_0[_ProgramHeaderType_index_0[i]:_ProgramHeaderType_index_0[i+1]]
-	case i == 1879048192:
+	case i == 1610612736:
 		return _ProgramHeaderType_name_1
+	case 1685382480 <= i && i <= 1685382483:
+		i -= 1685382480
+		return _ProgramHeaderType_name_2[_ProgramHeaderType_index_2[i]:_ProgramHeaderType_index_2[i+1]]
+	case 1879048191 <= i && i <= 1879048192:
+		i -= 1879048191
+		return _ProgramHeaderType_name_3[_ProgramHeaderType_index_3[i]:_ProgramHeaderType_index_3[i+1]]
 	case i == 2147483647:
-		return _ProgramHeaderType_name_2
+		return _ProgramHeaderType_name_4
 	default:
 		return "ProgramHeaderType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
@@ -219,6 +236,10 @@ func _() {
 	_ = x[SHT_GROUP-17]
 	_ = x[SHT_SYMTAB_SHNDX-18]
 	_ = x[SHT_LOOS-1610612736]
+	_ = x[SHT_GNU_HASH-1879048182]
+	_ = x[SHT_GNU_VERDEF-1879048189]
+	_ = x[SHT_GNU_VERNEED-1879048190]
+	_ = x[SHT_GNU_VERSYM-1879048191]
 	_ = x[SHT_HIOS-1879048191]
 	_ = x[SHT_LOPROC-1879048192]
 	_ = x[SHT_HIPROC-2147483647]
@@ -230,16 +251,17 @@ const (
 	_SectionHeaderType_name_0 = "SHT_NULLSHT_PROGBITSSHT_SYMTABSHT_STRTABSHT_RELASHT_HASHSHT_DYNAMICSHT_NOTESHT_NOBITSSHT_RELSHT_SHLIBSHT_DYNSYM"
 	_SectionHeaderType_name_1 = "SHT_INIT_ARRAYSHT_FINI_ARRAYSHT_PREINIT_ARRAYSHT_GROUPSHT_SYMTAB_SHNDX"
 	_SectionHeaderType_name_2 = "SHT_LOOS"
-	_SectionHeaderType_name_3 = "SHT_HIOSSHT_LOPROC"
-	_SectionHeaderType_name_4 = "SHT_HIPROCSHT_LOUSER"
-	_SectionHeaderType_name_5 = "SHT_HIUSER"
+	_SectionHeaderType_name_3 = "SHT_GNU_HASH"
+	_SectionHeaderType_name_4 = "SHT_GNU_VERDEFSHT_GNU_VERNEEDSHT_GNU_VERSYMSHT_LOPROC"
+	_SectionHeaderType_name_5 = "SHT_HIPROCSHT_LOUSER"
+	_SectionHeaderType_name_6 = "SHT_HIUSER"
 )
 
 var (
 	_SectionHeaderType_index_0 = [...]uint8{0, 8, 20, 30, 40, 48, 56, 67, 75, 85, 92, 101, 111}
 	_SectionHeaderType_index_1 = [...]uint8{0, 14, 28, 45, 54, 70}
-	_SectionHeaderType_index_3 = [...]uint8{0, 8, 18}
-	_SectionHeaderType_index_4 = [...]uint8{0, 10, 20}
+	_SectionHeaderType_index_4 = [...]uint8{0, 14, 29, 43, 53}
+	_SectionHeaderType_index_5 = [...]uint8{0, 10, 20}
 )
 
 func (i SectionHeaderType) String() string {
@@ -251,14 +273,16 @@ func (i SectionHeaderType) String() string {
 		return _SectionHeaderType_name_1[_SectionHeaderType_index_1[i]:_SectionHeaderType_index_1[i+1]]
 	case i == 1610612736:
 		return _SectionHeaderType_name_2
-	case 1879048191 <= i && i <= 1879048192:
-		i -= 1879048191
-		return _SectionHeaderType_name_3[_SectionHeaderType_index_3[i]:_SectionHeaderType_index_3[i+1]]
+	case i == 1879048182:
+		return _SectionHeaderType_name_3
+	case 1879048189 <= i && i <= 1879048192:
+		i -= 1879048189
+		return _SectionHeaderType_name_4[_SectionHeaderType_index_4[i]:_SectionHeaderType_index_4[i+1]]
 	case 2147483647 <= i && i <= 2147483648:
 		i -= 2147483647
-		return _SectionHeaderType_name_4[_SectionHeaderType_index_4[i]:_SectionHeaderType_index_4[i+1]]
+		return _SectionHeaderType_name_5[_SectionHeaderType_index_5[i]:_SectionHeaderType_index_5[i+1]]
 	case i == 4294967295:
-		return _SectionHeaderType_name_5
+		return _SectionHeaderType_name_6
 	default:
 		return "SectionHeaderType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
@@ -368,3 +392,589 @@ func (i SymbolVisibility) String() string {
 	}
 	return _SymbolVisibility_name[_SymbolVisibility_index[idx]:_SymbolVisibility_index[idx+1]]
 }
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[EM_NONE-0]
+	_ = x[EM_M32-1]
+	_ = x[EM_SPARC-2]
+	_ = x[EM_386-3]
+	_ = x[EM_68K-4]
+	_ = x[EM_88K-5]
+	_ = x[EM_860-7]
+	_ = x[EM_MIPS-8]
+	_ = x[EM_S370-9]
+	_ = x[EM_MIPS_RS3_LE-10]
+	_ = x[EM_PARISC-15]
+	_ = x[EM_VPP500-17]
+	_ = x[EM_SPARC32PLUS-18]
+	_ = x[EM_960-19]
+	_ = x[EM_PPC-20]
+	_ = x[EM_PPC64-21]
+	_ = x[EM_S390-22]
+	_ = x[EM_V800-36]
+	_ = x[EM_FR20-37]
+	_ = x[EM_RH32-38]
+	_ = x[EM_RCE-39]
+	_ = x[EM_ARM-40]
+	_ = x[EM_SH-42]
+	_ = x[EM_SPARCV9-43]
+	_ = x[EM_TRICORE-44]
+	_ = x[EM_ARC-45]
+	_ = x[EM_H8_300-46]
+	_ = x[EM_H8_300H-47]
+	_ = x[EM_H8S-48]
+	_ = x[EM_H8_500-49]
+	_ = x[EM_IA_64-50]
+	_ = x[EM_MIPS_X-51]
+	_ = x[EM_COLDFIRE-52]
+	_ = x[EM_68HC12-53]
+	_ = x[EM_MMA-54]
+	_ = x[EM_PCP-55]
+	_ = x[EM_NCPU-56]
+	_ = x[EM_NDR1-57]
+	_ = x[EM_STARCORE-58]
+	_ = x[EM_ME16-59]
+	_ = x[EM_ST100-60]
+	_ = x[EM_TINYJ-61]
+	_ = x[EM_X86_64-62]
+	_ = x[EM_PDSP-63]
+	_ = x[EM_PDP10-64]
+	_ = x[EM_PDP11-65]
+	_ = x[EM_FX66-66]
+	_ = x[EM_ST9PLUS-67]
+	_ = x[EM_ST7-68]
+	_ = x[EM_68HC16-69]
+	_ = x[EM_68HC11-70]
+	_ = x[EM_68HC08-71]
+	_ = x[EM_68HC05-72]
+	_ = x[EM_SVX-73]
+	_ = x[EM_ST19-74]
+	_ = x[EM_VAX-75]
+	_ = x[EM_CRIS-76]
+	_ = x[EM_JAVELIN-77]
+	_ = x[EM_FIREPATH-78]
+	_ = x[EM_ZSP-79]
+	_ = x[EM_MMIX-80]
+	_ = x[EM_HUANY-81]
+	_ = x[EM_PRISM-82]
+	_ = x[EM_AVR-83]
+	_ = x[EM_FR30-84]
+	_ = x[EM_D10V-85]
+	_ = x[EM_D30V-86]
+	_ = x[EM_V850-87]
+	_ = x[EM_M32R-88]
+	_ = x[EM_MN10300-89]
+	_ = x[EM_MN10200-90]
+	_ = x[EM_PJ-91]
+	_ = x[EM_OPENRISC-92]
+	_ = x[EM_ARC_COMPACT-93]
+	_ = x[EM_XTENSA-94]
+	_ = x[EM_VIDEOCORE-95]
+	_ = x[EM_TMM_GPP-96]
+	_ = x[EM_NS32K-97]
+	_ = x[EM_TPC-98]
+	_ = x[EM_SNP1K-99]
+	_ = x[EM_ST200-100]
+	_ = x[EM_IP2K-101]
+	_ = x[EM_MAX-102]
+	_ = x[EM_CR-103]
+	_ = x[EM_F2MC16-104]
+	_ = x[EM_MSP430-105]
+	_ = x[EM_BLACKFIN-106]
+	_ = x[EM_SE_C33-107]
+	_ = x[EM_SEP-108]
+	_ = x[EM_ARCA-109]
+	_ = x[EM_UNICORE-110]
+	_ = x[EM_EXCESS-111]
+	_ = x[EM_DXP-112]
+	_ = x[EM_ALTERA_NIOS2-113]
+	_ = x[EM_CRX-114]
+	_ = x[EM_XGATE-115]
+	_ = x[EM_C166-116]
+	_ = x[EM_M16C-117]
+	_ = x[EM_DSPIC30F-118]
+	_ = x[EM_CE-119]
+	_ = x[EM_M32C-120]
+	_ = x[EM_TSK3000-131]
+	_ = x[EM_RS08-132]
+	_ = x[EM_SHARC-133]
+	_ = x[EM_ECOG2-134]
+	_ = x[EM_SCORE7-135]
+	_ = x[EM_DSP24-136]
+	_ = x[EM_VIDEOCORE3-137]
+	_ = x[EM_LATTICEMICO32-138]
+	_ = x[EM_SE_C17-139]
+	_ = x[EM_TI_C6000-140]
+	_ = x[EM_TI_C2000-141]
+	_ = x[EM_TI_C5500-142]
+	_ = x[EM_TI_ARP32-143]
+	_ = x[EM_TI_PRU-144]
+	_ = x[EM_MMDSP_PLUS-160]
+	_ = x[EM_CYPRESS_M8C-161]
+	_ = x[EM_R32C-162]
+	_ = x[EM_TRIMEDIA-163]
+	_ = x[EM_QDSP6-164]
+	_ = x[EM_8051-165]
+	_ = x[EM_STXP7X-166]
+	_ = x[EM_NDS32-167]
+	_ = x[EM_ECOG1-168]
+	_ = x[EM_MAXQ30-169]
+	_ = x[EM_XIMO16-170]
+	_ = x[EM_MANIK-171]
+	_ = x[EM_CRAYNV2-172]
+	_ = x[EM_RX-173]
+	_ = x[EM_METAG-174]
+	_ = x[EM_MCST_ELBRUS-175]
+	_ = x[EM_ECOG16-176]
+	_ = x[EM_CR16-177]
+	_ = x[EM_ETPU-178]
+	_ = x[EM_SLE9X-179]
+	_ = x[EM_L10M-180]
+	_ = x[EM_K10M-181]
+	_ = x[EM_AARCH64-183]
+	_ = x[EM_AVR32-185]
+	_ = x[EM_STM8-186]
+	_ = x[EM_TILE64-187]
+	_ = x[EM_TILEPRO-188]
+	_ = x[EM_MICROBLAZE-189]
+	_ = x[EM_CUDA-190]
+	_ = x[EM_TILEGX-191]
+	_ = x[EM_CLOUDSHIELD-192]
+	_ = x[EM_COREA_1ST-193]
+	_ = x[EM_COREA_2ND-194]
+	_ = x[EM_ARC_COMPACT2-195]
+	_ = x[EM_OPEN8-196]
+	_ = x[EM_RL78-197]
+	_ = x[EM_VIDEOCORE5-198]
+	_ = x[EM_78KOR-199]
+	_ = x[EM_56800EX-200]
+	_ = x[EM_BA1-201]
+	_ = x[EM_BA2-202]
+	_ = x[EM_XCORE-203]
+	_ = x[EM_MCHP_PIC-204]
+	_ = x[EM_INTEL205-205]
+	_ = x[EM_INTEL206-206]
+	_ = x[EM_INTEL207-207]
+	_ = x[EM_INTEL208-208]
+	_ = x[EM_INTEL209-209]
+	_ = x[EM_KM32-210]
+	_ = x[EM_KMX32-211]
+	_ = x[EM_KMX16-212]
+	_ = x[EM_KMX8-213]
+	_ = x[EM_KVARC-214]
+	_ = x[EM_CDP-215]
+	_ = x[EM_COGE-216]
+	_ = x[EM_COOL-217]
+	_ = x[EM_NORC-218]
+	_ = x[EM_CSR_KALIMBA-219]
+	_ = x[EM_Z80-220]
+	_ = x[EM_VISIUM-221]
+	_ = x[EM_FT32-222]
+	_ = x[EM_MOXIE-223]
+	_ = x[EM_AMDGPU-224]
+	_ = x[EM_RISCV-243]
+	_ = x[EM_LANAI-244]
+	_ = x[EM_BPF-247]
+	_ = x[EM_CSKY-252]
+	_ = x[EM_LOONGARCH-258]
+	_ = x[EM_486-6]
+	_ = x[EM_ALPHA_STD-41]
+	_ = x[EM_ALPHA-36902]
+}
+
+const _Machine_name = "EM_NONEEM_M32EM_SPARCEM_386EM_68KEM_88KEM_486EM_860EM_MIPSEM_S370EM_MIPS_RS3_LEEM_PARISCEM_VPP500EM_SPARC32PLUSEM_960EM_PPCEM_PPC64EM_S390EM_V800EM_FR20EM_RH32EM_RCEEM_ARMEM_ALPHA_STDEM_SHEM_SPARCV9EM_TRICOREEM_ARCEM_H8_300EM_H8_300HEM_H8SEM_H8_500EM_IA_64EM_MIPS_XEM_COLDFIREEM_68HC12EM_MMAEM_PCPEM_NCPUEM_NDR1EM_STARCOREEM_ME16EM_ST100EM_TINYJEM_X86_64EM_PDSPEM_PDP10EM_PDP11EM_FX66EM_ST9PLUSEM_ST7EM_68HC16EM_68HC11EM_68HC08EM_68HC05EM_SVXEM_ST19EM_VAXEM_CRISEM_JAVELINEM_FIREPATHEM_ZSPEM_MMIXEM_HUANYEM_PRISMEM_AVREM_FR30EM_D10VEM_D30VEM_V850EM_M32REM_MN10300EM_MN10200EM_PJEM_OPENRISCEM_ARC_COMPACTEM_XTENSAEM_VIDEOCOREEM_TMM_GPPEM_NS32KEM_TPCEM_SNP1KEM_ST200EM_IP2KEM_MAXEM_CREM_F2MC16EM_MSP430EM_BLACKFINEM_SE_C33EM_SEPEM_ARCAEM_UNICOREEM_EXCESSEM_DXPEM_ALTERA_NIOS2EM_CRXEM_XGATEEM_C166EM_M16CEM_DSPIC30FEM_CEEM_M32CEM_TSK3000EM_RS08EM_SHARCEM_ECOG2EM_SCORE7EM_DSP24EM_VIDEOCORE3EM_LATTICEMICO32EM_SE_C17EM_TI_C6000EM_TI_C2000EM_TI_C5500EM_TI_ARP32EM_TI_PRUEM_MMDSP_PLUSEM_CYPRESS_M8CEM_R32CEM_TRIMEDIAEM_QDSP6EM_8051EM_STXP7XEM_NDS32EM_ECOG1EM_MAXQ30EM_XIMO16EM_MANIKEM_CRAYNV2EM_RXEM_METAGEM_MCST_ELBRUSEM_ECOG16EM_CR16EM_ETPUEM_SLE9XEM_L10MEM_K10MEM_AARCH64EM_AVR32EM_STM8EM_TILE64EM_TILEPROEM_MICROBLAZEEM_CUDAEM_TILEGXEM_CLOUDSHIELDEM_COREA_1STEM_COREA_2NDEM_ARC_COMPACT2EM_OPEN8EM_RL78EM_VIDEOCORE5EM_78KOREM_56800EXEM_BA1EM_BA2EM_XCOREEM_MCHP_PICEM_INTEL205EM_INTEL206EM_INTEL207EM_INTEL208EM_INTEL209EM_KM32EM_KMX32EM_KMX16EM_KMX8EM_KVARCEM_CDPEM_COGEEM_COOLEM_NORCEM_CSR_KALIMBAEM_Z80EM_VISIUMEM_FT32EM_MOXIEEM_AMDGPUEM_RISCVEM_LANAIEM_BPFEM_CSKYEM_LOONGARCHEM_ALPHA"
+
+var _Machine_map = map[Machine]string{
+	0:     _Machine_name[0:7],
+	1:     _Machine_name[7:13],
+	2:     _Machine_name[13:21],
+	3:     _Machine_name[21:27],
+	4:     _Machine_name[27:33],
+	5:     _Machine_name[33:39],
+	6:     _Machine_name[39:45],
+	7:     _Machine_name[45:51],
+	8:     _Machine_name[51:58],
+	9:     _Machine_name[58:65],
+	10:    _Machine_name[65:79],
+	15:    _Machine_name[79:88],
+	17:    _Machine_name[88:97],
+	18:    _Machine_name[97:111],
+	19:    _Machine_name[111:117],
+	20:    _Machine_name[117:123],
+	21:    _Machine_name[123:131],
+	22:    _Machine_name[131:138],
+	36:    _Machine_name[138:145],
+	37:    _Machine_name[145:152],
+	38:    _Machine_name[152:159],
+	39:    _Machine_name[159:165],
+	40:    _Machine_name[165:171],
+	41:    _Machine_name[171:183],
+	42:    _Machine_name[183:188],
+	43:    _Machine_name[188:198],
+	44:    _Machine_name[198:208],
+	45:    _Machine_name[208:214],
+	46:    _Machine_name[214:223],
+	47:    _Machine_name[223:233],
+	48:    _Machine_name[233:239],
+	49:    _Machine_name[239:248],
+	50:    _Machine_name[248:256],
+	51:    _Machine_name[256:265],
+	52:    _Machine_name[265:276],
+	53:    _Machine_name[276:285],
+	54:    _Machine_name[285:291],
+	55:    _Machine_name[291:297],
+	56:    _Machine_name[297:304],
+	57:    _Machine_name[304:311],
+	58:    _Machine_name[311:322],
+	59:    _Machine_name[322:329],
+	60:    _Machine_name[329:337],
+	61:    _Machine_name[337:345],
+	62:    _Machine_name[345:354],
+	63:    _Machine_name[354:361],
+	64:    _Machine_name[361:369],
+	65:    _Machine_name[369:377],
+	66:    _Machine_name[377:384],
+	67:    _Machine_name[384:394],
+	68:    _Machine_name[394:400],
+	69:    _Machine_name[400:409],
+	70:    _Machine_name[409:418],
+	71:    _Machine_name[418:427],
+	72:    _Machine_name[427:436],
+	73:    _Machine_name[436:442],
+	74:    _Machine_name[442:449],
+	75:    _Machine_name[449:455],
+	76:    _Machine_name[455:462],
+	77:    _Machine_name[462:472],
+	78:    _Machine_name[472:483],
+	79:    _Machine_name[483:489],
+	80:    _Machine_name[489:496],
+	81:    _Machine_name[496:504],
+	82:    _Machine_name[504:512],
+	83:    _Machine_name[512:518],
+	84:    _Machine_name[518:525],
+	85:    _Machine_name[525:532],
+	86:    _Machine_name[532:539],
+	87:    _Machine_name[539:546],
+	88:    _Machine_name[546:553],
+	89:    _Machine_name[553:563],
+	90:    _Machine_name[563:573],
+	91:    _Machine_name[573:578],
+	92:    _Machine_name[578:589],
+	93:    _Machine_name[589:603],
+	94:    _Machine_name[603:612],
+	95:    _Machine_name[612:624],
+	96:    _Machine_name[624:634],
+	97:    _Machine_name[634:642],
+	98:    _Machine_name[642:648],
+	99:    _Machine_name[648:656],
+	100:   _Machine_name[656:664],
+	101:   _Machine_name[664:671],
+	102:   _Machine_name[671:677],
+	103:   _Machine_name[677:682],
+	104:   _Machine_name[682:691],
+	105:   _Machine_name[691:700],
+	106:   _Machine_name[700:711],
+	107:   _Machine_name[711:720],
+	108:   _Machine_name[720:726],
+	109:   _Machine_name[726:733],
+	110:   _Machine_name[733:743],
+	111:   _Machine_name[743:752],
+	112:   _Machine_name[752:758],
+	113:   _Machine_name[758:773],
+	114:   _Machine_name[773:779],
+	115:   _Machine_name[779:787],
+	116:   _Machine_name[787:794],
+	117:   _Machine_name[794:801],
+	118:   _Machine_name[801:812],
+	119:   _Machine_name[812:817],
+	120:   _Machine_name[817:824],
+	131:   _Machine_name[824:834],
+	132:   _Machine_name[834:841],
+	133:   _Machine_name[841:849],
+	134:   _Machine_name[849:857],
+	135:   _Machine_name[857:866],
+	136:   _Machine_name[866:874],
+	137:   _Machine_name[874:887],
+	138:   _Machine_name[887:903],
+	139:   _Machine_name[903:912],
+	140:   _Machine_name[912:923],
+	141:   _Machine_name[923:934],
+	142:   _Machine_name[934:945],
+	143:   _Machine_name[945:956],
+	144:   _Machine_name[956:965],
+	160:   _Machine_name[965:978],
+	161:   _Machine_name[978:992],
+	162:   _Machine_name[992:999],
+	163:   _Machine_name[999:1010],
+	164:   _Machine_name[1010:1018],
+	165:   _Machine_name[1018:1025],
+	166:   _Machine_name[1025:1034],
+	167:   _Machine_name[1034:1042],
+	168:   _Machine_name[1042:1050],
+	169:   _Machine_name[1050:1059],
+	170:   _Machine_name[1059:1068],
+	171:   _Machine_name[1068:1076],
+	172:   _Machine_name[1076:1086],
+	173:   _Machine_name[1086:1091],
+	174:   _Machine_name[1091:1099],
+	175:   _Machine_name[1099:1113],
+	176:   _Machine_name[1113:1122],
+	177:   _Machine_name[1122:1129],
+	178:   _Machine_name[1129:1136],
+	179:   _Machine_name[1136:1144],
+	180:   _Machine_name[1144:1151],
+	181:   _Machine_name[1151:1158],
+	183:   _Machine_name[1158:1168],
+	185:   _Machine_name[1168:1176],
+	186:   _Machine_name[1176:1183],
+	187:   _Machine_name[1183:1192],
+	188:   _Machine_name[1192:1202],
+	189:   _Machine_name[1202:1215],
+	190:   _Machine_name[1215:1222],
+	191:   _Machine_name[1222:1231],
+	192:   _Machine_name[1231:1245],
+	193:   _Machine_name[1245:1257],
+	194:   _Machine_name[1257:1269],
+	195:   _Machine_name[1269:1284],
+	196:   _Machine_name[1284:1292],
+	197:   _Machine_name[1292:1299],
+	198:   _Machine_name[1299:1312],
+	199:   _Machine_name[1312:1320],
+	200:   _Machine_name[1320:1330],
+	201:   _Machine_name[1330:1336],
+	202:   _Machine_name[1336:1342],
+	203:   _Machine_name[1342:1350],
+	204:   _Machine_name[1350:1361],
+	205:   _Machine_name[1361:1372],
+	206:   _Machine_name[1372:1383],
+	207:   _Machine_name[1383:1394],
+	208:   _Machine_name[1394:1405],
+	209:   _Machine_name[1405:1416],
+	210:   _Machine_name[1416:1423],
+	211:   _Machine_name[1423:1431],
+	212:   _Machine_name[1431:1439],
+	213:   _Machine_name[1439:1446],
+	214:   _Machine_name[1446:1454],
+	215:   _Machine_name[1454:1460],
+	216:   _Machine_name[1460:1467],
+	217:   _Machine_name[1467:1474],
+	218:   _Machine_name[1474:1481],
+	219:   _Machine_name[1481:1495],
+	220:   _Machine_name[1495:1501],
+	221:   _Machine_name[1501:1510],
+	222:   _Machine_name[1510:1517],
+	223:   _Machine_name[1517:1525],
+	224:   _Machine_name[1525:1534],
+	243:   _Machine_name[1534:1542],
+	244:   _Machine_name[1542:1550],
+	247:   _Machine_name[1550:1556],
+	252:   _Machine_name[1556:1563],
+	258:   _Machine_name[1563:1575],
+	36902: _Machine_name[1575:1583],
+}
+
+func (i Machine) String() string {
+	if str, ok := _Machine_map[i]; ok {
+		return str
+	}
+	return "Machine(" + strconv.FormatInt(int64(i), 10) + ")"
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[ELFOSABI_NONE-0]
+	_ = x[ELFOSABI_HPUX-1]
+	_ = x[ELFOSABI_NETBSD-2]
+	_ = x[ELFOSABI_LINUX-3]
+	_ = x[ELFOSABI_HURD-4]
+	_ = x[ELFOSABI_86OPEN-5]
+	_ = x[ELFOSABI_SOLARIS-6]
+	_ = x[ELFOSABI_AIX-7]
+	_ = x[ELFOSABI_IRIX-8]
+	_ = x[ELFOSABI_FREEBSD-9]
+	_ = x[ELFOSABI_TRU64-10]
+	_ = x[ELFOSABI_MODESTO-11]
+	_ = x[ELFOSABI_OPENBSD-12]
+	_ = x[ELFOSABI_OPENVMS-13]
+	_ = x[ELFOSABI_NSK-14]
+	_ = x[ELFOSABI_AROS-15]
+	_ = x[ELFOSABI_FENIXOS-16]
+	_ = x[ELFOSABI_CLOUDABI-17]
+	_ = x[ELFOSABI_ARM-97]
+	_ = x[ELFOSABI_STANDALONE-255]
+}
+
+const (
+	_OSABI_name_0 = "ELFOSABI_NONEELFOSABI_HPUXELFOSABI_NETBSDELFOSABI_LINUXELFOSABI_HURDELFOSABI_86OPENELFOSABI_SOLARISELFOSABI_AIXELFOSABI_IRIXELFOSABI_FREEBSDELFOSABI_TRU64ELFOSABI_MODESTOELFOSABI_OPENBSDELFOSABI_OPENVMSELFOSABI_NSKELFOSABI_AROSELFOSABI_FENIXOSELFOSABI_CLOUDABI"
+	_OSABI_name_1 = "ELFOSABI_ARM"
+	_OSABI_name_2 = "ELFOSABI_STANDALONE"
+)
+
+var (
+	_OSABI_index_0 = [...]uint16{0, 13, 26, 41, 55, 68, 83, 99, 111, 124, 140, 154, 170, 186, 202, 214, 227, 243, 260}
+)
+
+func (i OSABI) String() string {
+	switch {
+	case i <= 17:
+		return _OSABI_name_0[_OSABI_index_0[i]:_OSABI_index_0[i+1]]
+	case i == 97:
+		return _OSABI_name_1
+	case i == 255:
+		return _OSABI_name_2
+	default:
+		return "OSABI(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[DT_NULL-0]
+	_ = x[DT_NEEDED-1]
+	_ = x[DT_PLTRELSZ-2]
+	_ = x[DT_PLTGOT-3]
+	_ = x[DT_HASH-4]
+	_ = x[DT_STRTAB-5]
+	_ = x[DT_SYMTAB-6]
+	_ = x[DT_RELA-7]
+	_ = x[DT_RELASZ-8]
+	_ = x[DT_RELAENT-9]
+	_ = x[DT_STRSZ-10]
+	_ = x[DT_SYMENT-11]
+	_ = x[DT_INIT-12]
+	_ = x[DT_FINI-13]
+	_ = x[DT_SONAME-14]
+	_ = x[DT_RPATH-15]
+	_ = x[DT_SYMBOLIC-16]
+	_ = x[DT_REL-17]
+	_ = x[DT_RELSZ-18]
+	_ = x[DT_RELENT-19]
+	_ = x[DT_PLTREL-20]
+	_ = x[DT_DEBUG-21]
+	_ = x[DT_TEXTREL-22]
+	_ = x[DT_JMPREL-23]
+	_ = x[DT_BIND_NOW-24]
+	_ = x[DT_INIT_ARRAY-25]
+	_ = x[DT_FINI_ARRAY-26]
+	_ = x[DT_INIT_ARRAYSZ-27]
+	_ = x[DT_FINI_ARRAYSZ-28]
+	_ = x[DT_RUNPATH-29]
+	_ = x[DT_FLAGS-30]
+	_ = x[DT_LOOS-1610612749]
+	_ = x[DT_GNU_HASH-1879047925]
+	_ = x[DT_VERSYM-1879048176]
+	_ = x[DT_RELACOUNT-1879048185]
+	_ = x[DT_RELCOUNT-1879048186]
+	_ = x[DT_FLAGS_1-1879048187]
+	_ = x[DT_VERDEF-1879048188]
+	_ = x[DT_VERDEFNUM-1879048189]
+	_ = x[DT_VERNEED-1879048190]
+	_ = x[DT_VERNEEDNUM-1879048191]
+	_ = x[DT_LOPROC-1879048192]
+	_ = x[DT_HIPROC-2147483647]
+}
+
+const (
+	_DynamicTag_name_0 = "DT_NULLDT_NEEDEDDT_PLTRELSZDT_PLTGOTDT_HASHDT_STRTABDT_SYMTABDT_RELADT_RELASZDT_RELAENTDT_STRSZDT_SYMENTDT_INITDT_FINIDT_SONAMEDT_RPATHDT_SYMBOLICDT_RELDT_RELSZDT_RELENTDT_PLTRELDT_DEBUGDT_TEXTRELDT_JMPRELDT_BIND_NOWDT_INIT_ARRAYDT_FINI_ARRAYDT_INIT_ARRAYSZDT_FINI_ARRAYSZDT_RUNPATHDT_FLAGS"
+	_DynamicTag_name_1 = "DT_LOOS"
+	_DynamicTag_name_2 = "DT_GNU_HASH"
+	_DynamicTag_name_3 = "DT_VERSYM"
+	_DynamicTag_name_4 = "DT_RELACOUNTDT_RELCOUNTDT_FLAGS_1DT_VERDEFDT_VERDEFNUMDT_VERNEEDDT_VERNEEDNUMDT_LOPROC"
+	_DynamicTag_name_5 = "DT_HIPROC"
+)
+
+var (
+	_DynamicTag_index_0 = [...]uint16{0, 7, 16, 27, 36, 43, 52, 61, 68, 77, 87, 95, 104, 111, 118, 127, 135, 146, 152, 160, 169, 178, 186, 196, 205, 216, 229, 242, 257, 272, 282, 290}
+	_DynamicTag_index_4 = [...]uint8{0, 12, 23, 33, 42, 54, 64, 77, 86}
+)
+
+func (i DynamicTag) String() string {
+	switch {
+	case 0 <= i && i <= 30:
+		return _DynamicTag_name_0[_DynamicTag_index_0[i]:_DynamicTag_index_0[i+1]]
+	case i == 1610612749:
+		return _DynamicTag_name_1
+	case i == 1879047925:
+		return _DynamicTag_name_2
+	case i == 1879048176:
+		return _DynamicTag_name_3
+	case 1879048185 <= i && i <= 1879048192:
+		i -= 1879048185
+		return _DynamicTag_name_4[_DynamicTag_index_4[i]:_DynamicTag_index_4[i+1]]
+	case i == 2147483647:
+		return _DynamicTag_name_5
+	default:
+		return "DynamicTag(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[R_X86_64_NONE-0]
+	_ = x[R_X86_64_64-1]
+	_ = x[R_X86_64_PC32-2]
+	_ = x[R_X86_64_GOT32-3]
+	_ = x[R_X86_64_PLT32-4]
+	_ = x[R_X86_64_COPY-5]
+	_ = x[R_X86_64_GLOB_DAT-6]
+	_ = x[R_X86_64_JUMP_SLOT-7]
+	_ = x[R_X86_64_RELATIVE-8]
+	_ = x[R_X86_64_GOTPCREL-9]
+	_ = x[R_X86_64_32-10]
+	_ = x[R_X86_64_32S-11]
+	_ = x[R_X86_64_16-12]
+	_ = x[R_X86_64_PC16-13]
+	_ = x[R_X86_64_8-14]
+	_ = x[R_X86_64_PC8-15]
+	_ = x[R_X86_64_DTPMOD64-16]
+	_ = x[R_X86_64_DTPOFF64-17]
+	_ = x[R_X86_64_TPOFF64-18]
+	_ = x[R_X86_64_IRELATIVE-37]
+}
+
+const (
+	_RelocationType_name_0 = "R_X86_64_NONER_X86_64_64R_X86_64_PC32R_X86_64_GOT32R_X86_64_PLT32R_X86_64_COPYR_X86_64_GLOB_DATR_X86_64_JUMP_SLOTR_X86_64_RELATIVER_X86_64_GOTPCRELR_X86_64_32R_X86_64_32SR_X86_64_16R_X86_64_PC16R_X86_64_8R_X86_64_PC8R_X86_64_DTPMOD64R_X86_64_DTPOFF64R_X86_64_TPOFF64"
+	_RelocationType_name_1 = "R_X86_64_IRELATIVE"
+)
+
+var (
+	_RelocationType_index_0 = [...]uint16{0, 13, 24, 37, 51, 65, 78, 95, 113, 130, 147, 158, 170, 181, 194, 204, 216, 233, 250, 266}
+)
+
+func (i RelocationType) String() string {
+	switch {
+	case i <= 18:
+		return _RelocationType_name_0[_RelocationType_index_0[i]:_RelocationType_index_0[i+1]]
+	case i == 37:
+		return _RelocationType_name_1
+	default:
+		return "RelocationType(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+}
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[NT_GNU_ABI_TAG-1]
+	_ = x[NT_GNU_HWCAP-2]
+	_ = x[NT_GNU_BUILD_ID-3]
+	_ = x[NT_GNU_GOLD_VERSION-4]
+	_ = x[NT_GNU_PROPERTY_TYPE_0-5]
+}
+
+const _NoteType_name = "NT_GNU_ABI_TAGNT_GNU_HWCAPNT_GNU_BUILD_IDNT_GNU_GOLD_VERSIONNT_GNU_PROPERTY_TYPE_0"
+
+var _NoteType_index = [...]uint8{0, 14, 26, 41, 60, 82}
+
+func (i NoteType) String() string {
+	idx := int(i) - 1
+	if i < 1 || idx >= len(_NoteType_index)-1 {
+		return "NoteType(" + strconv.FormatInt(int64(i), 10) + ")"
+	}
+	return _NoteType_name[_NoteType_index[idx]:_NoteType_index[idx+1]]
+}
@@ -0,0 +1,103 @@
+package elf
+
+//go:generate go tool stringer -type Syscall -output syscall_string.go
+
+import (
+	"fmt"
+	"go-elf/x86"
+)
+
+// Syscall is the number of a system call of Linux on x86-64, see
+// arch/x86/entry/syscalls/syscall_64.tbl of the kernel.
+type Syscall uint32
+
+const (
+	SYS_READ       Syscall = 0
+	SYS_WRITE      Syscall = 1
+	SYS_OPEN       Syscall = 2
+	SYS_CLOSE      Syscall = 3
+	SYS_LSEEK      Syscall = 8
+	SYS_MMAP       Syscall = 9
+	SYS_MPROTECT   Syscall = 10
+	SYS_MUNMAP     Syscall = 11
+	SYS_BRK        Syscall = 12
+	SYS_NANOSLEEP  Syscall = 35
+	SYS_GETPID     Syscall = 39
+	SYS_CLONE      Syscall = 56
+	SYS_EXIT       Syscall = 60
+	SYS_KILL       Syscall = 62
+	SYS_ARCH_PRCTL Syscall = 158
+	SYS_GETTID     Syscall = 186
+	SYS_EXIT_GROUP Syscall = 231
+	SYS_OPENAT     Syscall = 257
+)
+
+// syscallParams are the names of the parameters of the system calls, as in
+// their man pages.
+var syscallParams = map[Syscall][]string{
+	SYS_READ:       {"fd", "buf", "count"},
+	SYS_WRITE:      {"fd", "buf", "count"},
+	SYS_OPEN:       {"pathname", "flags", "mode"},
+	SYS_CLOSE:      {"fd"},
+	SYS_LSEEK:      {"fd", "offset", "whence"},
+	SYS_MMAP:       {"addr", "length", "prot", "flags", "fd", "offset"},
+	SYS_MPROTECT:   {"addr", "length", "prot"},
+	SYS_MUNMAP:     {"addr", "length"},
+	SYS_BRK:        {"addr"},
+	SYS_NANOSLEEP:  {"req", "rem"},
+	SYS_GETPID:     {},
+	SYS_CLONE:      {"flags", "stack", "parent_tid", "child_tid", "tls"},
+	SYS_EXIT:       {"status"},
+	SYS_KILL:       {"pid", "sig"},
+	SYS_ARCH_PRCTL: {"code", "addr"},
+	SYS_GETTID:     {},
+	SYS_EXIT_GROUP: {"status"},
+	SYS_OPENAT:     {"dirfd", "pathname", "flags", "mode"},
+}
+
+// Flags and constants of the arguments of the system calls.
+const (
+	O_RDONLY = 0x0
+	O_WRONLY = 0x1
+	O_RDWR   = 0x2
+	O_CREAT  = 0x40
+	O_TRUNC  = 0x200
+	O_APPEND = 0x400
+
+	AT_FDCWD = -100
+
+	PROT_NONE  = 0x0
+	PROT_READ  = 0x1
+	PROT_WRITE = 0x2
+	PROT_EXEC  = 0x4
+
+	MAP_SHARED    = 0x1
+	MAP_PRIVATE   = 0x2
+	MAP_FIXED     = 0x10
+	MAP_ANONYMOUS = 0x20
+)
+
+// syscallRegisters are the registers of the arguments of a system call. The
+// fourth argument is passed in r10 instead of rcx, which is overwritten by
+// the syscall instruction with the return address.
+var syscallRegisters = []x86.Register{x86.RDI, x86.RSI, x86.RDX, x86.R10, x86.R8, x86.R9}
+
+// emitSys appends the system call nr with the arguments, which are moved to
+// the argument registers. The result is returned in rax, errors as negative
+// error numbers. The syscall instruction overwrites rcx and r11.
+func (c *Compiler) emitSys(nr Syscall, args ...x86.Operand) {
+	params, ok := syscallParams[nr]
+	if !ok {
+		panic(fmt.Sprintf("unknown system call %s", nr))
+	}
+	if len(args) != len(params) {
+		panic(fmt.Sprintf("system call %s expects %d arguments, got %d", nr, len(params), len(args)))
+	}
+	moves := make([]move, len(args))
+	for i, arg := range args {
+		moves[i] = move{dst: syscallRegisters[i], src: arg}
+	}
+	c.emitParallelMove(moves)
+	c.emitMovRegImm32(0, uint32(nr)) // rax = system call number
+	c.emitSyscall()
+}
@@ -0,0 +1,59 @@
+// Code generated by "stringer -type Syscall -output syscall_string.go"; DO NOT EDIT.
+
+package elf
+
+import "strconv"
+
+func _() {
+	// An "invalid array index" compiler error signifies that the constant values have changed.
+	// Re-run the stringer command to generate them again.
+	var x [1]struct{}
+	_ = x[SYS_READ-0]
+	_ = x[SYS_WRITE-1]
+	_ = x[SYS_OPEN-2]
+	_ = x[SYS_CLOSE-3]
+	_ = x[SYS_LSEEK-8]
+	_ = x[SYS_MMAP-9]
+	_ = x[SYS_MPROTECT-10]
+	_ = x[SYS_MUNMAP-11]
+	_ = x[SYS_BRK-12]
+	_ = x[SYS_NANOSLEEP-35]
+	_ = x[SYS_GETPID-39]
+	_ = x[SYS_CLONE-56]
+	_ = x[SYS_EXIT-60]
+	_ = x[SYS_KILL-62]
+	_ = x[SYS_ARCH_PRCTL-158]
+	_ = x[SYS_GETTID-186]
+	_ = x[SYS_EXIT_GROUP-231]
+	_ = x[SYS_OPENAT-257]
+}
+
+const _Syscall_name = "SYS_READSYS_WRITESYS_OPENSYS_CLOSESYS_LSEEKSYS_MMAPSYS_MPROTECTSYS_MUNMAPSYS_BRKSYS_NANOSLEEPSYS_GETPIDSYS_CLONESYS_EXITSYS_KILLSYS_ARCH_PRCTLSYS_GETTIDSYS_EXIT_GROUPSYS_OPENAT"
+
+var _Syscall_map = map[Syscall]string{
+	0:   _Syscall_name[0:8],
+	1:   _Syscall_name[8:17],
+	2:   _Syscall_name[17:25],
+	3:   _Syscall_name[25:34],
+	8:   _Syscall_name[34:43],
+	9:   _Syscall_name[43:51],
+	10:  _Syscall_name[51:63],
+	11:  _Syscall_name[63:73],
+	12:  _Syscall_name[73:80],
+	35:  _Syscall_name[80:93],
+	39:  _Syscall_name[93:103],
+	56:  _Syscall_name[103:112],
+	60:  _Syscall_name[112:120],
+	62:  _Syscall_name[120:128],
+	158: _Syscall_name[128:142],
+	186: _Syscall_name[142:152],
+	231: _Syscall_name[152:166],
+	257: _Syscall_name[166:176],
+}
+
+func (i Syscall) String() string {
+	if str, ok := _Syscall_map[i]; ok {
+		return str
+	}
+	return "Syscall(" + strconv.FormatInt(int64(i), 10) + ")"
+}
@@ -0,0 +1,150 @@
+package elf
+
+import (
+	"go-elf/x86"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSyscallNumbers(t *testing.T) {
+	expected := map[Syscall]uintptr{
+		SYS_READ:       syscall.SYS_READ,
+		SYS_WRITE:      syscall.SYS_WRITE,
+		SYS_OPEN:       syscall.SYS_OPEN,
+		SYS_CLOSE:      syscall.SYS_CLOSE,
+		SYS_LSEEK:      syscall.SYS_LSEEK,
+		SYS_MMAP:       syscall.SYS_MMAP,
+		SYS_MPROTECT:   syscall.SYS_MPROTECT,
+		SYS_MUNMAP:     syscall.SYS_MUNMAP,
+		SYS_BRK:        syscall.SYS_BRK,
+		SYS_NANOSLEEP:  syscall.SYS_NANOSLEEP,
+		SYS_GETPID:     syscall.SYS_GETPID,
+		SYS_CLONE:      syscall.SYS_CLONE,
+		SYS_EXIT:       syscall.SYS_EXIT,
+		SYS_KILL:       syscall.SYS_KILL,
+		SYS_ARCH_PRCTL: syscall.SYS_ARCH_PRCTL,
+		SYS_GETTID:     syscall.SYS_GETTID,
+		SYS_EXIT_GROUP: syscall.SYS_EXIT_GROUP,
+		SYS_OPENAT:     syscall.SYS_OPENAT,
+	}
+	if len(expected) != len(syscallParams) {
+		t.Errorf("expected %d system calls got %d", len(expected), len(syscallParams))
+	}
+	for nr, number := range expected {
+		if uintptr(nr) != number {
+			t.Errorf("%s: expected number %d got %d", nr, number, nr)
+		}
+		if _, ok := syscallParams[nr]; !ok {
+			t.Errorf("%s: parameters are missing", nr)
+		}
+	}
+	if SYS_EXIT_GROUP.String() != "SYS_EXIT_GROUP" {
+		t.Errorf("expected name SYS_EXIT_GROUP got %s", SYS_EXIT_GROUP)
+	}
+}
+
+// TestSyscallRead writes the content of input.txt to stdout like
+// asm/read.s. The exit code is the negative error number if a system call
+// fails.
+func TestSyscallRead(t *testing.T) {
+	c := &Compiler{
+		startAddr: 0x401000,
+		buf:       make([]byte, 0),
+	}
+	pathAddr := c.addString("input.txt")
+	entryPoint := c.startAddr + uint64(len(c.buf))
+
+	const bufferSize = 512
+	f := c.beginFrame("_start", bufferSize/8)
+	buffer := f.local(bufferSize/8 - 1) // lowest address
+	loop := c.newLabel()
+	exit := c.newLabel()
+
+	c.emitSys(SYS_OPENAT, x86.Imm(AT_FDCWD), x86.Imm(pathAddr), x86.Imm(O_RDONLY), x86.Imm(0))
+	c.emit(x86.MOV, x86.RBX, x86.RAX) // rbx = file descriptor
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JS, exit)
+
+	c.label(loop)
+	c.emit(x86.LEA, x86.R12, buffer)
+	c.emitSys(SYS_READ, x86.RBX, x86.R12, x86.Imm(bufferSize))
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JS, exit)
+	c.emitJump(x86.JE, exit) // end of file, rax = 0
+	c.emitSys(SYS_WRITE, x86.Imm(1), x86.R12, x86.RAX)
+	c.emit(x86.TEST, x86.RAX, x86.RAX)
+	c.emitJump(x86.JNS, loop)
+
+	c.label(exit)
+	c.emitSys(SYS_EXIT, x86.RAX)
+	c.endFrame()
+
+	tempDir := t.TempDir()
+	outputPath := filepath.Join(tempDir, "read.elf")
+	writeProgram(t, c, entryPoint, outputPath)
+
+	// larger than the buffer, so it is read in several parts
+	input := ""
+	for i := range 100 {
+		input += string(rune('a'+i%26)) + "0123456789\n"
+	}
+	err := os.WriteFile(filepath.Join(tempDir, "input.txt"), []byte(input), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, exitCode := execute(t, outputPath, tempDir)
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 got %d", exitCode)
+	}
+	if string(output) != input {
+		t.Errorf("expected output %q got %q", input, output)
+	}
+
+	// -ENOENT as exit code
+	output, exitCode = execute(t, outputPath, t.TempDir())
+	if exitCode != 256-int(syscall.ENOENT) {
+		t.Errorf("expected exit code %d got %d", 256-int(syscall.ENOENT), exitCode)
+	}
+	if len(output) != 0 {
+		t.Errorf("expected no output got %q", output)
+	}
+}
+
+// TestSyscallSleep calls a function which sleeps with nanosleep like
+// asm/sleep.s.
+func TestSyscallSleep(t *testing.T) {
+	c := &Compiler{
+		startAddr: 0x401000,
+		buf:       make([]byte, 0),
+	}
+	entryPoint := c.startAddr
+
+	const duration = 200 * time.Millisecond
+	c.emitCallFunction("sleep", x86.Imm(0), x86.Imm(duration.Nanoseconds()))
+	c.emitSys(SYS_EXIT, x86.RAX) // result of nanosleep
+
+	// sleep(seconds, nanoseconds) with a struct timespec on the stack
+	f := c.beginFrame("sleep", 2)
+	c.emit(x86.MOV, f.local(1), f.param(0)) // tv_sec
+	c.emit(x86.MOV, f.local(0), f.param(1)) // tv_nsec
+	c.emit(x86.LEA, x86.RDI, f.local(1))
+	c.emitSys(SYS_NANOSLEEP, x86.RDI, x86.Imm(0))
+	c.emitReturn(f)
+	c.endFrame()
+
+	outputPath := filepath.Join(t.TempDir(), "sleep.elf")
+	writeProgram(t, c, entryPoint, outputPath)
+
+	start := time.Now()
+	_, exitCode := execute(t, outputPath, "")
+	elapsed := time.Since(start)
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0 got %d", exitCode)
+	}
+	if elapsed < duration {
+		t.Errorf("expected to sleep %s, but the program ran %s", duration, elapsed)
+	}
+}
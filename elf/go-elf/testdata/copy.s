@@ -0,0 +1,28 @@
+.section .text
+.globl _start
+
+hello_msg:
+    .ascii "Hello\n"
+
+_start:
+    # copy hello_msg to buffer
+    lea hello_msg(%rip), %rsi
+    lea buffer(%rip), %rdi
+    mov $6, %rcx
+    rep movsb
+
+    # write(1, buffer, 6)
+    mov $1, %rax
+    mov $1, %rdi
+    lea buffer(%rip), %rsi
+    mov $6, %rdx
+    syscall
+
+    # exit(0)
+    mov $60, %rax
+    xor %rdi, %rdi
+    syscall
+
+.bss
+buffer:
+    .skip 6
@@ -0,0 +1,30 @@
+#include <dlfcn.h>
+#include <stdio.h>
+
+int main(int argc, char **argv) {
+  if (argc < 2) {
+    fprintf(stderr, "usage: %s SHARED-OBJECT\n", argv[0]);
+    return 1;
+  }
+
+  void *handle = dlopen(argv[1], RTLD_NOW);
+  if (handle == NULL) {
+    fprintf(stderr, "%s\n", dlerror());
+    return 1;
+  }
+
+  int (*answer)(void) = dlsym(handle, "answer");
+  int (*increment)(void) = dlsym(handle, "increment");
+  const char *(*greeting)(void) = dlsym(handle, "greeting");
+  if (answer == NULL || increment == NULL || greeting == NULL) {
+    fprintf(stderr, "%s\n", dlerror());
+    return 1;
+  }
+
+  printf("%s\n", greeting());
+  printf("answer: %d\n", answer());
+  printf("increment: %d\n", increment());
+  printf("increment: %d\n", increment());
+
+  return dlclose(handle);
+}
@@ -0,0 +1,85 @@
+#include <dlfcn.h>
+#include <stdint.h>
+#include <stdio.h>
+
+static int calls = 0;
+static int aligned = 1;
+
+// step is called by fold of the shared object
+static long step(long acc, long i) {
+  calls++;
+  // rbp is 16 byte aligned if rsp was 16 byte aligned before the call
+  if ((uintptr_t)__builtin_frame_address(0) % 16 != 0) {
+    aligned = 0;
+  }
+  return acc * 2 + i;
+}
+
+// affine is called by apply of the shared object
+static double affine(double a, long n, double b) { return a * n + b; }
+
+// preserved calls f(a, b) with known values in the callee saved registers
+// and returns whether f preserved them.
+static int preserved(void *f, long a, long b, long *result) {
+  long check;
+  __asm__ volatile("mov $1, %%rbx\n\t"
+                   "mov $2, %%r12\n\t"
+                   "mov $3, %%r13\n\t"
+                   "mov $4, %%r14\n\t"
+                   "mov %%rsp, %%r15\n\t"
+                   "sub $128, %%rsp\n\t" // skip the red zone
+                   "and $-16, %%rsp\n\t"
+                   "call *%[f]\n\t"
+                   "mov %%r15, %%rsp\n\t"
+                   "imul $10, %%rbx, %%rdx\n\t"
+                   "add %%r12, %%rdx\n\t"
+                   "imul $10, %%rdx, %%rdx\n\t"
+                   "add %%r13, %%rdx\n\t"
+                   "imul $10, %%rdx, %%rdx\n\t"
+                   "add %%r14, %%rdx\n\t"
+                   : "=a"(*result), "=d"(check), "+D"(a), "+S"(b)
+                   : [f] "r"(f)
+                   : "rbx", "rcx", "r8", "r9", "r10", "r11", "r12", "r13",
+                     "r14", "r15", "memory", "cc");
+  return check == 1234;
+}
+
+int main(int argc, char **argv) {
+  if (argc < 2) {
+    fprintf(stderr, "usage: %s SHARED-OBJECT\n", argv[0]);
+    return 1;
+  }
+
+  void *handle = dlopen(argv[1], RTLD_NOW);
+  if (handle == NULL) {
+    fprintf(stderr, "%s\n", dlerror());
+    return 1;
+  }
+
+  long (*weighted)(long, long, long, long, long, long) = dlsym(handle, "weighted");
+  long (*factorial)(long) = dlsym(handle, "factorial");
+  long (*fold)(long (*)(long, long), long) = dlsym(handle, "fold");
+  double (*mix)(long, double, long, double) = dlsym(handle, "mix");
+  double (*apply)(double (*)(double, long, double), long, double) = dlsym(handle, "apply");
+  if (weighted == NULL || factorial == NULL || fold == NULL || mix == NULL || apply == NULL) {
+    fprintf(stderr, "%s\n", dlerror());
+    return 1;
+  }
+
+  printf("weighted: %ld\n", weighted(1, 2, 3, 4, 5, 6));
+  printf("factorial(10): %ld\n", factorial(10));
+  printf("factorial(20): %ld\n", factorial(20));
+  long sum = fold(step, 4);
+  printf("fold: %ld calls: %d aligned: %d\n", sum, calls, aligned);
+
+  long result;
+  int ok = preserved(fold, (long)step, 5, &result);
+  printf("fold: %ld preserved: %d\n", result, ok);
+  ok = preserved(factorial, 5, 0, &result);
+  printf("factorial(5): %ld preserved: %d\n", result, ok);
+
+  printf("mix: %g\n", mix(3, 1.5, 2, 0.25));
+  printf("apply: %g\n", apply(affine, 3, 1.25));
+
+  return dlclose(handle);
+}
@@ -0,0 +1,168 @@
+package elf
+
+import "fmt"
+
+// ThreadLocalStorage is the template of the thread local storage (TLS). Each
+// thread gets its own copy of the template: the initialized part (.tdata)
+// followed by the zero initialized part (.tbss).
+//
+// On x86-64 the TLS block of the executable is placed directly below the
+// thread pointer (%fs), so variables are accessed with negative offsets
+// relative to %fs (local-exec model). The thread pointer points to the thread
+// control block whose first word has to point to itself.
+//
+//	             |<------- block size ------->|
+//	             | .tdata | .tbss | (padding) | TCB
+//	             ^                            ^
+//	          block                     thread pointer
+type ThreadLocalStorage struct {
+	Data    []byte
+	BSSSize uint64
+	Align   uint64
+}
+
+// AddInt32 appends an initialized thread local variable to .tdata and
+// returns its offset in the template. Must not be called after AddBSS.
+func (t *ThreadLocalStorage) AddInt32(value int32) uint64 {
+	if t.BSSSize != 0 {
+		panic("initialized thread local variable added after .tbss")
+	}
+	t.Align = max(t.Align, 4)
+	offset := alignUp(uint64(len(t.Data)), 4)
+	for uint64(len(t.Data)) < offset {
+		t.Data = append(t.Data, 0)
+	}
+	t.Data = append(t.Data, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+	return offset
+}
+
+// AddBSS reserves size zero initialized bytes in .tbss and returns their
+// offset in the template.
+func (t *ThreadLocalStorage) AddBSS(size uint64, align uint64) uint64 {
+	t.Align = max(t.Align, align)
+	offset := alignUp(uint64(len(t.Data))+t.BSSSize, align)
+	t.BSSSize = offset + size - uint64(len(t.Data))
+	return offset
+}
+
+// MemorySize returns the size of the template in memory.
+func (t *ThreadLocalStorage) MemorySize() uint64 {
+	return uint64(len(t.Data)) + t.BSSSize
+}
+
+// BlockSize returns the distance between the start of the TLS block and the
+// thread pointer.
+func (t *ThreadLocalStorage) BlockSize() uint64 {
+	return alignUp(t.MemorySize(), max(t.Align, 1))
+}
+
+// ThreadPointerOffset returns the offset relative to the thread pointer
+// (%fs) of a variable at offset in the template.
+func (t *ThreadLocalStorage) ThreadPointerOffset(offset uint64) int32 {
+	return int32(int64(offset) - int64(t.BlockSize()))
+}
+
+// StaticExecutable is the layout of a statically linked executable with
+// thread local storage. The TLS template is placed in front of the code, so
+// its address is known before the code gets generated.
+//
+// The headers are read only, the TLS template is readable and writable and
+// the code is readable and executable, each mapped by its own PT_LOAD:
+//
+//	ELF header, program headers
+//	.note.gnu.build-id
+//	.tdata     initialized part of the TLS template
+//	.tbss      zero initialized part of the TLS template, occupies no space
+//	.text      code, starts at virtualAddress
+//	.rodata    Data, like that of the Writer
+//	.data      Data
+//	.bss       Data
+type StaticExecutable struct {
+	// Data is placed after the code if set, see Writer.Data.
+	Data *DataBuilder
+
+	virtualAddress uint64
+	tls            ThreadLocalStorage
+
+	img   *image
+	tdata *section
+	text  *section
+
+	// program headers after the PT_LOADs
+	lastProgramHeaders []ProgramHeader64
+}
+
+// NewStaticExecutable returns the layout of an executable whose code starts
+// at virtualAddress, which has to be page aligned.
+func NewStaticExecutable(virtualAddress uint64, tls ThreadLocalStorage) *StaticExecutable {
+	e := &StaticExecutable{
+		virtualAddress: virtualAddress,
+		tls:            tls,
+		img:            newImage(ET_EXEC, EM_X86_64),
+	}
+
+	img := e.img
+	align := max(tls.Align, 1)
+	img.addBuildIDNote()
+	e.tdata = img.addSection(".tdata", SHT_PROGBITS, SHF_ALLOC|SHF_WRITE|SHF_TLS, align, tls.Data)
+	tbss := img.addSection(".tbss", SHT_NOBITS, SHF_ALLOC|SHF_WRITE|SHF_TLS, align, nil)
+	tbss.header.Size = tls.BSSSize
+	e.text = img.addSection(".text", SHT_PROGBITS, SHF_ALLOC|SHF_EXECINSTR, 16, nil)
+	e.text.alignment = 0x1000
+
+	// PT_LOAD..., PT_TLS, PT_NOTE, PT_GNU_STACK
+	img.alignSegments()
+	img.layout(1 + len(img.segments()) + maxDataSegments + len(img.gnuProgramHeaders()))
+	base := virtualAddress - e.text.header.Offset
+	img.setBase(base)
+
+	// the PT_LOADs depend on the code and the data and are set in Write
+	e.lastProgramHeaders = []ProgramHeader64{
+		{
+			Type:            PT_TLS,
+			Flags:           PF_R,
+			Offset:          e.tdata.header.Offset,
+			VirtualAddress:  e.tdata.header.Address,
+			PhysicalAddress: e.tdata.header.Address,
+			FileSize:        uint64(len(tls.Data)),
+			MemorySize:      tls.MemorySize(),
+			Align:           align,
+		},
+	}
+	e.lastProgramHeaders = append(e.lastProgramHeaders, img.gnuProgramHeaders()...)
+
+	return e
+}
+
+// CodeAddress returns the address where the code starts.
+func (e *StaticExecutable) CodeAddress() uint64 {
+	return e.virtualAddress
+}
+
+// TLS returns the TLS template of the executable.
+func (e *StaticExecutable) TLS() *ThreadLocalStorage {
+	return &e.tls
+}
+
+// TLSAddress returns the address of the initialized part of the TLS template
+// which has to be copied into the TLS block of each thread.
+func (e *StaticExecutable) TLSAddress() uint64 {
+	return e.tdata.header.Address
+}
+
+// Write returns the ELF file with the code placed at the code address.
+func (e *StaticExecutable) Write(entryPoint uint64, code []byte) ([]byte, error) {
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no code to write")
+	}
+	img := e.img
+	img.setLastData(e.text, code)
+	if e.Data != nil {
+		img.addDataAfter(e.text, e.Data)
+	}
+
+	img.setProgramHeaders(nil, e.lastProgramHeaders)
+	img.header.Entry = entryPoint
+
+	return img.bytes()
+}
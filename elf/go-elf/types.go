@@ -1,6 +1,6 @@
 package elf
 
-//go:generate go tool stringer -type FileType,Class,Data,ProgramHeaderFlag,ProgramHeaderType,SectionHeaderFlag,SectionHeaderType,SymbolType,SymbolBinding,SymbolVisibility -output string.go
+//go:generate go tool stringer -type FileType,Class,Data,ProgramHeaderFlag,ProgramHeaderType,SectionHeaderFlag,SectionHeaderType,SymbolType,SymbolBinding,SymbolVisibility,Machine,OSABI,DynamicTag,RelocationType,NoteType -output string.go
 
 // File combines the various information a ELF file could contain. But this
 // struct can't be read using binary.Read as only the header is guaranteed be
@@ -20,17 +20,43 @@ type ELFIdentifier struct {
 	Class      Class // 32bit or 64bit
 	Data       Data  // little or big endian
 	Version    byte  // always 1
-	OSABI      byte  // operating system, linux 0x03
+	OSABI      OSABI // operating system, linux 0x03
 	ABIVersion byte  //
 	Padding    [7]byte
 }
 
+// OSABI identifies the operating system and ABI extensions of the file.
+type OSABI byte
+
+const (
+	ELFOSABI_NONE       OSABI = 0   // UNIX System V ABI
+	ELFOSABI_HPUX       OSABI = 1   // HP-UX operating system
+	ELFOSABI_NETBSD     OSABI = 2   // NetBSD
+	ELFOSABI_LINUX      OSABI = 3   // Linux
+	ELFOSABI_HURD       OSABI = 4   // Hurd
+	ELFOSABI_86OPEN     OSABI = 5   // 86Open common IA32 ABI
+	ELFOSABI_SOLARIS    OSABI = 6   // Solaris
+	ELFOSABI_AIX        OSABI = 7   // AIX
+	ELFOSABI_IRIX       OSABI = 8   // IRIX
+	ELFOSABI_FREEBSD    OSABI = 9   // FreeBSD
+	ELFOSABI_TRU64      OSABI = 10  // TRU64 UNIX
+	ELFOSABI_MODESTO    OSABI = 11  // Novell Modesto
+	ELFOSABI_OPENBSD    OSABI = 12  // OpenBSD
+	ELFOSABI_OPENVMS    OSABI = 13  // Open VMS
+	ELFOSABI_NSK        OSABI = 14  // HP Non-Stop Kernel
+	ELFOSABI_AROS       OSABI = 15  // Amiga Research OS
+	ELFOSABI_FENIXOS    OSABI = 16  // The FenixOS highly scalable multi-core OS
+	ELFOSABI_CLOUDABI   OSABI = 17  // Nuxi CloudABI
+	ELFOSABI_ARM        OSABI = 97  // ARM
+	ELFOSABI_STANDALONE OSABI = 255 // Standalone (embedded) application
+)
+
 type Header64 struct {
 	ELFIdentifier
 	Type    FileType
-	Machine uint16 // Machine specifies ISA (e.g. 0x03 for x86)
-	Version uint32 // Version is always set to 1
-	Entry   uint64 // Entry point for executable files or zero for relocatable files, shared objects and the like
+	Machine Machine // Machine specifies ISA (e.g. 0x03 for x86)
+	Version uint32  // Version is always set to 1
+	Entry   uint64  // Entry point for executable files or zero for relocatable files, shared objects and the like
 
 	ProgramHeaderOffset uint64 // where in the file do the program headers start
 	SectionHeaderOffset uint64 // where in the file do the section headers start
@@ -58,6 +84,251 @@ const (
 	ET_HIPROC FileType = 0xFFFF
 )
 
+// Machine specifies the instruction set architecture (e_machine).
+type Machine uint16
+
+const (
+	EM_NONE          Machine = 0   // No machine
+	EM_M32           Machine = 1   // AT&T WE32100
+	EM_SPARC         Machine = 2   // Sun SPARC
+	EM_386           Machine = 3   // Intel i386
+	EM_68K           Machine = 4   // Motorola 68000
+	EM_88K           Machine = 5   // Motorola 88000
+	EM_860           Machine = 7   // Intel i860
+	EM_MIPS          Machine = 8   // MIPS R3000 Big-Endian only
+	EM_S370          Machine = 9   // IBM System/370
+	EM_MIPS_RS3_LE   Machine = 10  // MIPS R3000 Little-Endian
+	EM_PARISC        Machine = 15  // HP PA-RISC
+	EM_VPP500        Machine = 17  // Fujitsu VPP500
+	EM_SPARC32PLUS   Machine = 18  // SPARC v8plus
+	EM_960           Machine = 19  // Intel 80960
+	EM_PPC           Machine = 20  // PowerPC 32-bit
+	EM_PPC64         Machine = 21  // PowerPC 64-bit
+	EM_S390          Machine = 22  // IBM System/390
+	EM_V800          Machine = 36  // NEC V800
+	EM_FR20          Machine = 37  // Fujitsu FR20
+	EM_RH32          Machine = 38  // TRW RH-32
+	EM_RCE           Machine = 39  // Motorola RCE
+	EM_ARM           Machine = 40  // ARM
+	EM_SH            Machine = 42  // Hitachi SH
+	EM_SPARCV9       Machine = 43  // SPARC v9 64-bit
+	EM_TRICORE       Machine = 44  // Siemens TriCore embedded processor
+	EM_ARC           Machine = 45  // Argonaut RISC Core
+	EM_H8_300        Machine = 46  // Hitachi H8/300
+	EM_H8_300H       Machine = 47  // Hitachi H8/300H
+	EM_H8S           Machine = 48  // Hitachi H8S
+	EM_H8_500        Machine = 49  // Hitachi H8/500
+	EM_IA_64         Machine = 50  // Intel IA-64 Processor
+	EM_MIPS_X        Machine = 51  // Stanford MIPS-X
+	EM_COLDFIRE      Machine = 52  // Motorola ColdFire
+	EM_68HC12        Machine = 53  // Motorola M68HC12
+	EM_MMA           Machine = 54  // Fujitsu MMA
+	EM_PCP           Machine = 55  // Siemens PCP
+	EM_NCPU          Machine = 56  // Sony nCPU
+	EM_NDR1          Machine = 57  // Denso NDR1 microprocessor
+	EM_STARCORE      Machine = 58  // Motorola Star*Core processor
+	EM_ME16          Machine = 59  // Toyota ME16 processor
+	EM_ST100         Machine = 60  // STMicroelectronics ST100 processor
+	EM_TINYJ         Machine = 61  // Advanced Logic Corp. TinyJ processor
+	EM_X86_64        Machine = 62  // Advanced Micro Devices x86-64
+	EM_PDSP          Machine = 63  // Sony DSP Processor
+	EM_PDP10         Machine = 64  // Digital Equipment Corp. PDP-10
+	EM_PDP11         Machine = 65  // Digital Equipment Corp. PDP-11
+	EM_FX66          Machine = 66  // Siemens FX66 microcontroller
+	EM_ST9PLUS       Machine = 67  // STMicroelectronics ST9+ 8/16 bit microcontroller
+	EM_ST7           Machine = 68  // STMicroelectronics ST7 8-bit microcontroller
+	EM_68HC16        Machine = 69  // Motorola MC68HC16 Microcontroller
+	EM_68HC11        Machine = 70  // Motorola MC68HC11 Microcontroller
+	EM_68HC08        Machine = 71  // Motorola MC68HC08 Microcontroller
+	EM_68HC05        Machine = 72  // Motorola MC68HC05 Microcontroller
+	EM_SVX           Machine = 73  // Silicon Graphics SVx
+	EM_ST19          Machine = 74  // STMicroelectronics ST19 8-bit microcontroller
+	EM_VAX           Machine = 75  // Digital VAX
+	EM_CRIS          Machine = 76  // Axis Communications 32-bit embedded processor
+	EM_JAVELIN       Machine = 77  // Infineon Technologies 32-bit embedded processor
+	EM_FIREPATH      Machine = 78  // Element 14 64-bit DSP Processor
+	EM_ZSP           Machine = 79  // LSI Logic 16-bit DSP Processor
+	EM_MMIX          Machine = 80  // Donald Knuth's educational 64-bit processor
+	EM_HUANY         Machine = 81  // Harvard University machine-independent object files
+	EM_PRISM         Machine = 82  // SiTera Prism
+	EM_AVR           Machine = 83  // Atmel AVR 8-bit microcontroller
+	EM_FR30          Machine = 84  // Fujitsu FR30
+	EM_D10V          Machine = 85  // Mitsubishi D10V
+	EM_D30V          Machine = 86  // Mitsubishi D30V
+	EM_V850          Machine = 87  // NEC v850
+	EM_M32R          Machine = 88  // Mitsubishi M32R
+	EM_MN10300       Machine = 89  // Matsushita MN10300
+	EM_MN10200       Machine = 90  // Matsushita MN10200
+	EM_PJ            Machine = 91  // picoJava
+	EM_OPENRISC      Machine = 92  // OpenRISC 32-bit embedded processor
+	EM_ARC_COMPACT   Machine = 93  // ARC International ARCompact processor (old spelling/synonym: EM_ARC_A5)
+	EM_XTENSA        Machine = 94  // Tensilica Xtensa Architecture
+	EM_VIDEOCORE     Machine = 95  // Alphamosaic VideoCore processor
+	EM_TMM_GPP       Machine = 96  // Thompson Multimedia General Purpose Processor
+	EM_NS32K         Machine = 97  // National Semiconductor 32000 series
+	EM_TPC           Machine = 98  // Tenor Network TPC processor
+	EM_SNP1K         Machine = 99  // Trebia SNP 1000 processor
+	EM_ST200         Machine = 100 // STMicroelectronics (www.st.com) ST200 microcontroller
+	EM_IP2K          Machine = 101 // Ubicom IP2xxx microcontroller family
+	EM_MAX           Machine = 102 // MAX Processor
+	EM_CR            Machine = 103 // National Semiconductor CompactRISC microprocessor
+	EM_F2MC16        Machine = 104 // Fujitsu F2MC16
+	EM_MSP430        Machine = 105 // Texas Instruments embedded microcontroller msp430
+	EM_BLACKFIN      Machine = 106 // Analog Devices Blackfin (DSP) processor
+	EM_SE_C33        Machine = 107 // S1C33 Family of Seiko Epson processors
+	EM_SEP           Machine = 108 // Sharp embedded microprocessor
+	EM_ARCA          Machine = 109 // Arca RISC Microprocessor
+	EM_UNICORE       Machine = 110 // Microprocessor series from PKU-Unity Ltd. and MPRC of Peking University
+	EM_EXCESS        Machine = 111 // eXcess: 16/32/64-bit configurable embedded CPU
+	EM_DXP           Machine = 112 // Icera Semiconductor Inc. Deep Execution Processor
+	EM_ALTERA_NIOS2  Machine = 113 // Altera Nios II soft-core processor
+	EM_CRX           Machine = 114 // National Semiconductor CompactRISC CRX microprocessor
+	EM_XGATE         Machine = 115 // Motorola XGATE embedded processor
+	EM_C166          Machine = 116 // Infineon C16x/XC16x processor
+	EM_M16C          Machine = 117 // Renesas M16C series microprocessors
+	EM_DSPIC30F      Machine = 118 // Microchip Technology dsPIC30F Digital Signal Controller
+	EM_CE            Machine = 119 // Freescale Communication Engine RISC core
+	EM_M32C          Machine = 120 // Renesas M32C series microprocessors
+	EM_TSK3000       Machine = 131 // Altium TSK3000 core
+	EM_RS08          Machine = 132 // Freescale RS08 embedded processor
+	EM_SHARC         Machine = 133 // Analog Devices SHARC family of 32-bit DSP processors
+	EM_ECOG2         Machine = 134 // Cyan Technology eCOG2 microprocessor
+	EM_SCORE7        Machine = 135 // Sunplus S+core7 RISC processor
+	EM_DSP24         Machine = 136 // New Japan Radio (NJR) 24-bit DSP Processor
+	EM_VIDEOCORE3    Machine = 137 // Broadcom VideoCore III processor
+	EM_LATTICEMICO32 Machine = 138 // RISC processor for Lattice FPGA architecture
+	EM_SE_C17        Machine = 139 // Seiko Epson C17 family
+	EM_TI_C6000      Machine = 140 // The Texas Instruments TMS320C6000 DSP family
+	EM_TI_C2000      Machine = 141 // The Texas Instruments TMS320C2000 DSP family
+	EM_TI_C5500      Machine = 142 // The Texas Instruments TMS320C55x DSP family
+	EM_TI_ARP32      Machine = 143 // Texas Instruments Application Specific RISC Processor, 32bit fetch
+	EM_TI_PRU        Machine = 144 // Texas Instruments Programmable Realtime Unit
+	EM_MMDSP_PLUS    Machine = 160 // STMicroelectronics 64bit VLIW Data Signal Processor
+	EM_CYPRESS_M8C   Machine = 161 // Cypress M8C microprocessor
+	EM_R32C          Machine = 162 // Renesas R32C series microprocessors
+	EM_TRIMEDIA      Machine = 163 // NXP Semiconductors TriMedia architecture family
+	EM_QDSP6         Machine = 164 // QUALCOMM DSP6 Processor
+	EM_8051          Machine = 165 // Intel 8051 and variants
+	EM_STXP7X        Machine = 166 // STMicroelectronics STxP7x family of configurable and extensible RISC processors
+	EM_NDS32         Machine = 167 // Andes Technology compact code size embedded RISC processor family
+	EM_ECOG1         Machine = 168 // Cyan Technology eCOG1X family
+	EM_MAXQ30        Machine = 169 // Dallas Semiconductor MAXQ30 Core Micro-controllers
+	EM_XIMO16        Machine = 170 // New Japan Radio (NJR) 16-bit DSP Processor
+	EM_MANIK         Machine = 171 // M2000 Reconfigurable RISC Microprocessor
+	EM_CRAYNV2       Machine = 172 // Cray Inc. NV2 vector architecture
+	EM_RX            Machine = 173 // Renesas RX family
+	EM_METAG         Machine = 174 // Imagination Technologies META processor architecture
+	EM_MCST_ELBRUS   Machine = 175 // MCST Elbrus general purpose hardware architecture
+	EM_ECOG16        Machine = 176 // Cyan Technology eCOG16 family
+	EM_CR16          Machine = 177 // National Semiconductor CompactRISC CR16 16-bit microprocessor
+	EM_ETPU          Machine = 178 // Freescale Extended Time Processing Unit
+	EM_SLE9X         Machine = 179 // Infineon Technologies SLE9X core
+	EM_L10M          Machine = 180 // Intel L10M
+	EM_K10M          Machine = 181 // Intel K10M
+	EM_AARCH64       Machine = 183 // ARM 64-bit Architecture (AArch64)
+	EM_AVR32         Machine = 185 // Atmel Corporation 32-bit microprocessor family
+	EM_STM8          Machine = 186 // STMicroeletronics STM8 8-bit microcontroller
+	EM_TILE64        Machine = 187 // Tilera TILE64 multicore architecture family
+	EM_TILEPRO       Machine = 188 // Tilera TILEPro multicore architecture family
+	EM_MICROBLAZE    Machine = 189 // Xilinx MicroBlaze 32-bit RISC soft processor core
+	EM_CUDA          Machine = 190 // NVIDIA CUDA architecture
+	EM_TILEGX        Machine = 191 // Tilera TILE-Gx multicore architecture family
+	EM_CLOUDSHIELD   Machine = 192 // CloudShield architecture family
+	EM_COREA_1ST     Machine = 193 // KIPO-KAIST Core-A 1st generation processor family
+	EM_COREA_2ND     Machine = 194 // KIPO-KAIST Core-A 2nd generation processor family
+	EM_ARC_COMPACT2  Machine = 195 // Synopsys ARCompact V2
+	EM_OPEN8         Machine = 196 // Open8 8-bit RISC soft processor core
+	EM_RL78          Machine = 197 // Renesas RL78 family
+	EM_VIDEOCORE5    Machine = 198 // Broadcom VideoCore V processor
+	EM_78KOR         Machine = 199 // Renesas 78KOR family
+	EM_56800EX       Machine = 200 // Freescale 56800EX Digital Signal Controller (DSC)
+	EM_BA1           Machine = 201 // Beyond BA1 CPU architecture
+	EM_BA2           Machine = 202 // Beyond BA2 CPU architecture
+	EM_XCORE         Machine = 203 // XMOS xCORE processor family
+	EM_MCHP_PIC      Machine = 204 // Microchip 8-bit PIC(r) family
+	EM_INTEL205      Machine = 205 // Reserved by Intel
+	EM_INTEL206      Machine = 206 // Reserved by Intel
+	EM_INTEL207      Machine = 207 // Reserved by Intel
+	EM_INTEL208      Machine = 208 // Reserved by Intel
+	EM_INTEL209      Machine = 209 // Reserved by Intel
+	EM_KM32          Machine = 210 // KM211 KM32 32-bit processor
+	EM_KMX32         Machine = 211 // KM211 KMX32 32-bit processor
+	EM_KMX16         Machine = 212 // KM211 KMX16 16-bit processor
+	EM_KMX8          Machine = 213 // KM211 KMX8 8-bit processor
+	EM_KVARC         Machine = 214 // KM211 KVARC processor
+	EM_CDP           Machine = 215 // Paneve CDP architecture family
+	EM_COGE          Machine = 216 // Cognitive Smart Memory Processor
+	EM_COOL          Machine = 217 // Bluechip Systems CoolEngine
+	EM_NORC          Machine = 218 // Nanoradio Optimized RISC
+	EM_CSR_KALIMBA   Machine = 219 // CSR Kalimba architecture family
+	EM_Z80           Machine = 220 // Zilog Z80
+	EM_VISIUM        Machine = 221 // Controls and Data Services VISIUMcore processor
+	EM_FT32          Machine = 222 // FTDI Chip FT32 high performance 32-bit RISC architecture
+	EM_MOXIE         Machine = 223 // Moxie processor family
+	EM_AMDGPU        Machine = 224 // AMD GPU architecture
+	EM_RISCV         Machine = 243 // RISC-V
+	EM_LANAI         Machine = 244 // Lanai 32-bit processor
+	EM_BPF           Machine = 247 // Linux BPF – in-kernel virtual machine
+	EM_CSKY          Machine = 252 // C-SKY
+	EM_LOONGARCH     Machine = 258 // LoongArch
+
+	// Non-standard or deprecated
+	EM_486       Machine = 6      // Intel i486
+	EM_ALPHA_STD Machine = 41     // Digital Alpha (standard value)
+	EM_ALPHA     Machine = 0x9026 // Alpha (written in the absence of an ABI)
+)
+
+// RISC-V specific flags of the ELF header (e_flags). The float ABI defines
+// in which registers floating point arguments are passed.
+const (
+	EF_RISCV_RVC              uint32 = 0x0001 // Compressed instructions are used
+	EF_RISCV_FLOAT_ABI        uint32 = 0x0006 // Mask of the float ABI
+	EF_RISCV_FLOAT_ABI_SOFT   uint32 = 0x0000 // lp64, no floating point registers
+	EF_RISCV_FLOAT_ABI_SINGLE uint32 = 0x0002 // lp64f, F extension
+	EF_RISCV_FLOAT_ABI_DOUBLE uint32 = 0x0004 // lp64d, D extension (e.g. RV64GC)
+	EF_RISCV_FLOAT_ABI_QUAD   uint32 = 0x0006 // lp64q, Q extension
+	EF_RISCV_RVE              uint32 = 0x0008 // Embedded ABI with 16 registers
+	EF_RISCV_TSO              uint32 = 0x0010 // Total store ordering
+)
+
+// ARM specific flags of the ELF header (e_flags). The upper byte holds the
+// version of the embedded ABI (EABI).
+const (
+	EF_ARM_EABIMASK       uint32 = 0xff000000 // Mask of the EABI version
+	EF_ARM_EABI_UNKNOWN   uint32 = 0x00000000
+	EF_ARM_EABI_VER1      uint32 = 0x01000000
+	EF_ARM_EABI_VER2      uint32 = 0x02000000
+	EF_ARM_EABI_VER3      uint32 = 0x03000000
+	EF_ARM_EABI_VER4      uint32 = 0x04000000
+	EF_ARM_EABI_VER5      uint32 = 0x05000000
+	EF_ARM_BE8            uint32 = 0x00800000 // Byte invariant big endian code (BE-8)
+	EF_ARM_ABI_FLOAT_HARD uint32 = 0x00000400 // Floating point arguments in VFP registers
+	EF_ARM_ABI_FLOAT_SOFT uint32 = 0x00000200 // Floating point arguments in integer registers
+)
+
+// MIPS specific flags of the ELF header (e_flags). The upper four bits hold
+// the architecture level.
+const (
+	EF_MIPS_NOREORDER uint32 = 0x00000001 // .noreorder directive was used
+	EF_MIPS_PIC       uint32 = 0x00000002 // Contains position independent code
+	EF_MIPS_CPIC      uint32 = 0x00000004 // Uses PIC calling sequence
+	EF_MIPS_ABI2      uint32 = 0x00000020 // N32 ABI
+	EF_MIPS_32BITMODE uint32 = 0x00000100 // 64-bit code using 32-bit registers
+	EF_MIPS_NAN2008   uint32 = 0x00000400 // IEEE 754-2008 NaN encoding
+	EF_MIPS_ARCH      uint32 = 0xf0000000 // Mask of the architecture level
+	EF_MIPS_ARCH_1    uint32 = 0x00000000
+	EF_MIPS_ARCH_2    uint32 = 0x10000000
+	EF_MIPS_ARCH_3    uint32 = 0x20000000
+	EF_MIPS_ARCH_4    uint32 = 0x30000000
+	EF_MIPS_ARCH_5    uint32 = 0x40000000
+	EF_MIPS_ARCH_32   uint32 = 0x50000000
+	EF_MIPS_ARCH_64   uint32 = 0x60000000
+	EF_MIPS_ARCH_32R2 uint32 = 0x70000000
+	EF_MIPS_ARCH_64R2 uint32 = 0x80000000
+	EF_MIPS_ARCH_32R6 uint32 = 0x90000000
+	EF_MIPS_ARCH_64R6 uint32 = 0xa0000000
+)
+
 type Class byte
 
 const (
@@ -103,6 +374,14 @@ const (
 	PT_NOTE
 	PT_SHLIB
 	PT_PHDR
+	PT_TLS // thread local storage template
+
+	PT_LOOS         ProgramHeaderType = 0x60000000
+	PT_GNU_EH_FRAME ProgramHeaderType = 0x6474e550 // location of .eh_frame_hdr for stack unwinding
+	PT_GNU_STACK    ProgramHeaderType = 0x6474e551 // permissions of the stack, executable if missing on some kernels
+	PT_GNU_RELRO    ProgramHeaderType = 0x6474e552 // read-only after relocation by the dynamic linker
+	PT_GNU_PROPERTY ProgramHeaderType = 0x6474e553 // location of .note.gnu.property
+	PT_HIOS         ProgramHeaderType = 0x6fffffff
 
 	PT_LOPROC ProgramHeaderType = 0x70000000
 	PT_HIPROC ProgramHeaderType = 0x7fffffff
@@ -234,6 +513,10 @@ const (
 	SHT_GROUP         SectionHeaderType = 17
 	SHT_SYMTAB_SHNDX  SectionHeaderType = 18
 	SHT_LOOS          SectionHeaderType = 0x60000000
+	SHT_GNU_HASH      SectionHeaderType = 0x6ffffff6 // GNU style symbol hash table
+	SHT_GNU_VERDEF    SectionHeaderType = 0x6ffffffd // Symbol versions provided
+	SHT_GNU_VERNEED   SectionHeaderType = 0x6ffffffe // Symbol versions required
+	SHT_GNU_VERSYM    SectionHeaderType = 0x6fffffff // Symbol version table
 	SHT_HIOS          SectionHeaderType = 0x6fffffff
 	SHT_LOPROC        SectionHeaderType = 0x70000000
 	SHT_HIPROC        SectionHeaderType = 0x7fffffff
@@ -386,3 +669,132 @@ const (
 	GRP_MASKOS   SectionGroupFlag = 0x0ff00000 // OS-specific semantics
 	GRP_MASKPROC SectionGroupFlag = 0xf0000000 // Processor-specific semantics
 )
+
+// Dyn64 is an entry of the dynamic section (.dynamic). The dynamic linker
+// reads these entries through the PT_DYNAMIC program header to find the
+// symbol table, string table, relocations and the needed libraries.
+type Dyn64 struct {
+	// Tag controls the interpretation of Value. See DynamicTag.
+	Tag DynamicTag
+
+	// Value is either an integer (e.g. DT_STRSZ), a virtual address (e.g.
+	// DT_SYMTAB) or an offset into the dynamic string table (e.g.
+	// DT_NEEDED).
+	Value uint64
+}
+
+type DynamicTag int64
+
+const (
+	DT_NULL         DynamicTag = 0          // Marks end of dynamic section
+	DT_NEEDED       DynamicTag = 1          // Name of needed library (offset in DT_STRTAB)
+	DT_PLTRELSZ     DynamicTag = 2          // Size in bytes of PLT relocations
+	DT_PLTGOT       DynamicTag = 3          // Address of the GOT used by the PLT
+	DT_HASH         DynamicTag = 4          // Address of symbol hash table
+	DT_STRTAB       DynamicTag = 5          // Address of string table
+	DT_SYMTAB       DynamicTag = 6          // Address of symbol table
+	DT_RELA         DynamicTag = 7          // Address of Rela relocations
+	DT_RELASZ       DynamicTag = 8          // Total size of Rela relocations
+	DT_RELAENT      DynamicTag = 9          // Size of one Rela relocation
+	DT_STRSZ        DynamicTag = 10         // Size of string table
+	DT_SYMENT       DynamicTag = 11         // Size of one symbol table entry
+	DT_INIT         DynamicTag = 12         // Address of init function
+	DT_FINI         DynamicTag = 13         // Address of termination function
+	DT_SONAME       DynamicTag = 14         // Name of shared object (offset in DT_STRTAB)
+	DT_RPATH        DynamicTag = 15         // Library search path (deprecated)
+	DT_SYMBOLIC     DynamicTag = 16         // Start symbol search here
+	DT_REL          DynamicTag = 17         // Address of Rel relocations
+	DT_RELSZ        DynamicTag = 18         // Total size of Rel relocations
+	DT_RELENT       DynamicTag = 19         // Size of one Rel relocation
+	DT_PLTREL       DynamicTag = 20         // Type of relocations in the PLT (DT_REL or DT_RELA)
+	DT_DEBUG        DynamicTag = 21         // For debugging, filled in by the dynamic linker
+	DT_TEXTREL      DynamicTag = 22         // Relocations might modify a non-writable segment
+	DT_JMPREL       DynamicTag = 23         // Address of PLT relocations
+	DT_BIND_NOW     DynamicTag = 24         // Process all relocations before execution
+	DT_INIT_ARRAY   DynamicTag = 25         // Array with addresses of init functions
+	DT_FINI_ARRAY   DynamicTag = 26         // Array with addresses of fini functions
+	DT_INIT_ARRAYSZ DynamicTag = 27         // Size in bytes of DT_INIT_ARRAY
+	DT_FINI_ARRAYSZ DynamicTag = 28         // Size in bytes of DT_FINI_ARRAY
+	DT_RUNPATH      DynamicTag = 29         // Library search path
+	DT_FLAGS        DynamicTag = 30         // Flags for the object being loaded
+	DT_LOOS         DynamicTag = 0x6000000d // OS-specific semantics
+	DT_GNU_HASH     DynamicTag = 0x6ffffef5 // Address of GNU style hash table
+	DT_VERSYM       DynamicTag = 0x6ffffff0 // Address of symbol version table
+	DT_RELACOUNT    DynamicTag = 0x6ffffff9 // Number of relative Rela relocations
+	DT_RELCOUNT     DynamicTag = 0x6ffffffa // Number of relative Rel relocations
+	DT_FLAGS_1      DynamicTag = 0x6ffffffb // State flags, see DF_1_* in the GNU extensions
+	DT_VERDEF       DynamicTag = 0x6ffffffc // Address of version definition table
+	DT_VERDEFNUM    DynamicTag = 0x6ffffffd // Number of version definitions
+	DT_VERNEED      DynamicTag = 0x6ffffffe // Address of table with needed versions
+	DT_VERNEEDNUM   DynamicTag = 0x6fffffff // Number of needed versions
+	DT_LOPROC       DynamicTag = 0x70000000 // Processor-specific semantics
+	DT_HIPROC       DynamicTag = 0x7fffffff // Processor-specific semantics
+)
+
+// Rela64 is a relocation entry with an explicit addend. The dynamic linker
+// uses them for example to fill the global offset table (GOT) with the
+// addresses of functions from shared libraries.
+type Rela64 struct {
+	// Offset is the virtual address of the storage unit affected by the
+	// relocation (executables and shared objects).
+	Offset uint64
+
+	// Info holds the symbol table index (upper 32 bits) and the relocation
+	// type (lower 32 bits). See NewRelocationInfo.
+	Info uint64
+
+	// Addend is added to the computed value.
+	Addend int64
+}
+
+func (r Rela64) SymbolIndex() uint32 {
+	return uint32(r.Info >> 32)
+}
+
+func (r Rela64) RelocationType() RelocationType {
+	return RelocationType(r.Info & 0xffffffff)
+}
+
+// NewRelocationInfo combines symbol index and relocation type into Info
+func NewRelocationInfo(symbolIndex uint32, relocationType RelocationType) uint64 {
+	return uint64(symbolIndex)<<32 | uint64(relocationType)
+}
+
+// RelocationType specifies how a relocation is computed. The values are
+// processor specific, these are the ones of the AMD64 supplement.
+type RelocationType uint32
+
+const (
+	R_X86_64_NONE      RelocationType = 0  // No relocation
+	R_X86_64_64        RelocationType = 1  // S + A
+	R_X86_64_PC32      RelocationType = 2  // S + A - P
+	R_X86_64_GOT32     RelocationType = 3  // G + A
+	R_X86_64_PLT32     RelocationType = 4  // L + A - P
+	R_X86_64_COPY      RelocationType = 5  // Copy symbol at runtime
+	R_X86_64_GLOB_DAT  RelocationType = 6  // S, set GOT entry to data address
+	R_X86_64_JUMP_SLOT RelocationType = 7  // S, set GOT entry to code address
+	R_X86_64_RELATIVE  RelocationType = 8  // B + A
+	R_X86_64_GOTPCREL  RelocationType = 9  // G + GOT + A - P
+	R_X86_64_32        RelocationType = 10 // S + A, zero extended
+	R_X86_64_32S       RelocationType = 11 // S + A, sign extended
+	R_X86_64_16        RelocationType = 12 // S + A
+	R_X86_64_PC16      RelocationType = 13 // S + A - P
+	R_X86_64_8         RelocationType = 14 // S + A
+	R_X86_64_PC8       RelocationType = 15 // S + A - P
+	R_X86_64_DTPMOD64  RelocationType = 16 // ID of module containing symbol
+	R_X86_64_DTPOFF64  RelocationType = 17 // Offset in TLS block
+	R_X86_64_TPOFF64   RelocationType = 18 // Offset in initial TLS block
+	R_X86_64_IRELATIVE RelocationType = 37 // Indirect (B + A)()
+)
+
+// NoteType is the type of a note, its meaning depends on the owner name of
+// the note. These are the types of notes owned by "GNU".
+type NoteType uint32
+
+const (
+	NT_GNU_ABI_TAG         NoteType = 1 // ABI information, e.g. minimal kernel version
+	NT_GNU_HWCAP           NoteType = 2 // Hardware capabilities
+	NT_GNU_BUILD_ID        NoteType = 3 // Unique build ID
+	NT_GNU_GOLD_VERSION    NoteType = 4 // Version of the gold linker
+	NT_GNU_PROPERTY_TYPE_0 NoteType = 5 // Program properties, e.g. x86 features
+)
@@ -0,0 +1,202 @@
+package elf
+
+import (
+	"fmt"
+	"io"
+)
+
+const pageSize = 0x1000
+
+// Writer writes a statically linked executable. The code is placed on its
+// own page after the headers and the notes:
+//
+//	ELF header, program headers
+//	.note.gnu.property  only if Features is set
+//	.note.gnu.build-id
+//	.text               code and data, starts at VirtualAddress
+//	...                 Sections, e.g. .runtime
+//	.rodata             Data, on its own page
+//	.data               Data, on its own page
+//	.bss                Data, zero filled without space in the file
+//	.symtab, .strtab    only if there are Symbols or labels of Data
+//
+// Each group of sections with the same permissions is mapped by its own
+// PT_LOAD: the headers and notes are read only, the code is readable and
+// executable, .rodata is read only and .data and .bss are readable and
+// writable.
+type Writer struct {
+	// VirtualAddress is the address where the code gets loaded. It has to
+	// be page aligned and leave room for the headers in front of it.
+	VirtualAddress uint64
+
+	// EntryPoint is the address of the first instruction.
+	EntryPoint uint64
+
+	// Code contains the code and data as generated by the Compiler.
+	Code []byte
+
+	// WritableCode maps Code writable in addition, for code which keeps its
+	// variables in Code like that of Compile and Assemble. Variables of
+	// Data don't need it.
+	WritableCode bool
+
+	// Machine is the instruction set of the code, EM_X86_64 if not set.
+	Machine Machine
+
+	// Flags are the processor specific flags of the ELF header, e.g. the
+	// float ABI for EM_RISCV.
+	Flags uint32
+
+	// Features marks the executable with x86 features in a GNU property
+	// note if not zero.
+	Features X86Feature
+
+	// Sections split Code into named sections. A section starts at its
+	// address and ends at the next section or the end of Code. The code in
+	// front of the first section is .text.
+	Sections []CodeSection
+
+	// Symbols are the functions which are written to the symbol table.
+	Symbols []Function
+
+	// Data is placed after the code if set. Its addresses are those
+	// returned by DataBuilder.Address for the end of Code.
+	Data *DataBuilder
+}
+
+// CodeSection is a named part of the code of a Writer.
+type CodeSection struct {
+	Name    string
+	Address uint64
+}
+
+// WriteTo writes the executable to out. The input is validated before
+// anything is written.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	if len(w.Code) == 0 {
+		return 0, fmt.Errorf("no code to write")
+	}
+	if w.EntryPoint < w.VirtualAddress || w.EntryPoint >= w.VirtualAddress+uint64(len(w.Code)) {
+		return 0, fmt.Errorf("entry point 0x%x is outside of the code at 0x%x-0x%x", w.EntryPoint, w.VirtualAddress, w.VirtualAddress+uint64(len(w.Code)))
+	}
+
+	machine := w.Machine
+	if machine == EM_NONE {
+		machine = EM_X86_64
+	}
+	if w.Features != 0 && machine != EM_X86_64 {
+		return 0, fmt.Errorf("x86 features are not supported for machine %s", machine)
+	}
+
+	img := newImage(ET_EXEC, machine)
+	img.header.Flags = w.Flags
+	if w.Features != 0 {
+		img.addPropertyNote(w.Features)
+	}
+	img.addBuildIDNote()
+
+	// the Compiler puts code and data into the same buffer, the sections
+	// follow each other without padding
+	end := w.VirtualAddress + uint64(len(w.Code))
+	bounds := []uint64{w.VirtualAddress}
+	for _, s := range w.Sections {
+		if s.Address <= bounds[len(bounds)-1] || s.Address >= end {
+			return 0, fmt.Errorf("section %s at 0x%x is not after the previous section and inside the code at 0x%x-0x%x", s.Name, s.Address, bounds[len(bounds)-1], end)
+		}
+		bounds = append(bounds, s.Address)
+	}
+	bounds = append(bounds, end)
+
+	codeFlags := SHF_ALLOC | SHF_EXECINSTR
+	if w.WritableCode {
+		codeFlags |= SHF_WRITE
+	}
+	sections := []*section{}
+	for i := range len(bounds) - 1 {
+		name, align := ".text", uint64(16)
+		if i > 0 {
+			name, align = w.Sections[i-1].Name, 1
+		}
+		data := w.Code[bounds[i]-w.VirtualAddress : bounds[i+1]-w.VirtualAddress]
+		sections = append(sections, img.addSection(name, SHT_PROGBITS, codeFlags, align, data))
+	}
+	text := sections[0]
+	text.alignment = pageSize
+
+	type dataSymbol struct {
+		section *section
+		address uint64
+		label   dataLabel
+	}
+	dataSymbols := []dataSymbol{}
+	if w.Data != nil {
+		addresses := w.Data.layout(end)
+		for i, s := range w.Data.sections() {
+			if !s.used() {
+				continue
+			}
+			data := newDataSection(w.Data, s)
+			img.sections = append(img.sections, data)
+			for _, l := range s.labels {
+				if !isLocalLabel(l.name) {
+					dataSymbols = append(dataSymbols, dataSymbol{data, addresses[i] + l.offset, l})
+				}
+			}
+		}
+	}
+
+	if len(w.Symbols) > 0 || len(dataSymbols) > 0 {
+		strtab := newStringTable()
+		symbols := NewSymbolTable64()
+		for _, f := range w.Symbols {
+			if f.Address < w.VirtualAddress || f.Address+f.Size > end {
+				return 0, fmt.Errorf("symbol %s at 0x%x is outside of the code at 0x%x-0x%x", f.Name, f.Address, w.VirtualAddress, end)
+			}
+			// the section which contains the symbol
+			index := 0
+			for i, s := range w.Sections {
+				if f.Address >= s.Address {
+					index = i + 1
+				}
+			}
+			symbols = append(symbols, Symbol64{
+				Name:               strtab.add(f.Name),
+				Info:               NewSymbolInfo(STB_GLOBAL, STT_FUNC),
+				SectionHeaderIndex: uint16(img.sectionIndex(sections[index])),
+				Value:              f.Address,
+				Size:               f.Size,
+			})
+		}
+		for _, d := range dataSymbols {
+			symbols = append(symbols, Symbol64{
+				Name:               strtab.add(d.label.name),
+				Info:               NewSymbolInfo(STB_GLOBAL, STT_OBJECT),
+				SectionHeaderIndex: uint16(img.sectionIndex(d.section)),
+				Value:              d.address,
+				Size:               d.label.size,
+			})
+		}
+		symtab := img.addSection(".symtab", SHT_SYMTAB, 0, 8, encode(symbols))
+		symtab.header.EntSize = symbolSize
+		symtab.header.Info = 1 // index of the first non-local symbol
+		strtabSection := img.addSection(".strtab", SHT_STRTAB, 0, 1, strtab.bytes())
+		symtab.header.Link = img.sectionIndex(strtabSection)
+	}
+
+	// PT_LOAD..., PT_NOTE..., PT_GNU_STACK
+	img.alignSegments()
+	img.layout(len(img.segments()) + len(img.gnuProgramHeaders()))
+	if w.VirtualAddress%pageSize != text.header.Offset%pageSize {
+		return 0, fmt.Errorf("virtual address 0x%x is not congruent to the file offset 0x%x of the code modulo the page size 0x%x", w.VirtualAddress, text.header.Offset, pageSize)
+	}
+	if w.VirtualAddress < text.header.Offset {
+		return 0, fmt.Errorf("virtual address 0x%x is too low, the headers require 0x%x bytes in front of the code", w.VirtualAddress, text.header.Offset)
+	}
+	base := w.VirtualAddress - text.header.Offset
+	img.setBase(base)
+
+	img.setProgramHeaders(nil, img.gnuProgramHeaders())
+	img.header.Entry = w.EntryPoint
+
+	return img.writeTo(out)
+}
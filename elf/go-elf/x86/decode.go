@@ -0,0 +1,781 @@
+package x86
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Inst is a decoded instruction.
+type Inst struct {
+	Op Op
+	// Operands in Intel order like the operands of Encode. Memory operands
+	// have the size of the access, except for lea.
+	Operands []Operand
+	Len      int // length in bytes
+}
+
+// ErrTruncated is returned by Decode if the code ends within an
+// instruction.
+var ErrTruncated = errors.New("truncated instruction")
+
+// Decode decodes the instruction at the start of code. It supports the
+// instructions of Encode, which covers most of the integer instructions
+// generated by compilers in 64-bit mode, the scalar SSE2 instructions and
+// the moves of xmm registers.
+func Decode(code []byte) (Inst, error) {
+	d := &decoder{code: code}
+	inst, err := d.decode()
+	if err != nil {
+		return Inst{}, err
+	}
+	inst.Len = d.pos
+	return inst, nil
+}
+
+// decoder holds the state of the instruction which is decoded.
+type decoder struct {
+	code []byte
+	pos  int
+
+	// prefixes
+	rex     byte
+	size16  bool
+	rep     bool
+	repne   bool // mandatory prefix of SSE instructions only
+	segment Segment
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.code) {
+		return 0, ErrTruncated
+	}
+	b := d.code[d.pos]
+	d.pos++
+	return b, nil
+}
+
+// immediate reads a sign extended immediate of size bits (8, 16, 32 or 64).
+func (d *decoder) immediate(size int) (Imm, error) {
+	n := size / 8
+	if d.pos+n > len(d.code) {
+		return 0, ErrTruncated
+	}
+	b := d.code[d.pos : d.pos+n]
+	d.pos += n
+	switch size {
+	case 8:
+		return Imm(int8(b[0])), nil
+	case 16:
+		return Imm(int16(binary.LittleEndian.Uint16(b))), nil
+	case 32:
+		return Imm(int32(binary.LittleEndian.Uint32(b))), nil
+	default:
+		return Imm(binary.LittleEndian.Uint64(b)), nil
+	}
+}
+
+// immediateSize returns the size of the immediate for the operand size,
+// 64 bit operands use sign extended 32 bit immediates.
+func immediateSize(size int) int {
+	if size == 64 {
+		return 32
+	}
+	return size
+}
+
+// operandSize returns the operand size selected by REX.W and the 0x66
+// prefix.
+func (d *decoder) operandSize() int {
+	switch {
+	case d.rex&0x08 != 0:
+		return 64
+	case d.size16:
+		return 16
+	default:
+		return 32
+	}
+}
+
+// register returns the general purpose register num of size. Without REX
+// the numbers 4-7 of 8 bit registers select ah, ch, dh and bh.
+func (d *decoder) register(num byte, size int) (Register, error) {
+	if size == 8 && d.rex == 0 && num >= 4 && num < 8 {
+		return Register{}, fmt.Errorf("high byte registers are not supported")
+	}
+	return Register{num, size}, nil
+}
+
+// modRM decodes the ModRM byte and returns the number of the register in
+// ModRM.reg and the operand of ModRM.rm with size bits. The reg number is
+// the opcode extension for instructions without register operand.
+func (d *decoder) modRM(size int) (reg byte, rm Operand, err error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, nil, err
+	}
+	mod := b >> 6
+	reg = (b>>3)&7 | (d.rex&0x04)<<1
+	rmNum := b&7 | (d.rex&0x01)<<3
+
+	if mod == 3 {
+		r, err := d.register(rmNum, size)
+		return reg, r, err
+	}
+
+	mem := Mem{Size: size, Segment: d.segment}
+	switch {
+	case b&7 == 4:
+		sib, err := d.byte()
+		if err != nil {
+			return 0, nil, err
+		}
+		index := (sib>>3)&7 | (d.rex&0x02)<<2
+		if index != 4 {
+			mem.Index = Register{index, 64}
+			mem.Scale = 1 << (sib >> 6)
+		}
+		if sib&7 == 5 && mod == 0 {
+			// no base
+			mod = 2
+		} else {
+			mem.Base = Register{sib&7 | (d.rex&0x01)<<3, 64}
+		}
+	case b&7 == 5 && mod == 0:
+		mem.RIP = true
+		mod = 2
+	default:
+		mem.Base = Register{rmNum, 64}
+	}
+
+	switch mod {
+	case 1:
+		disp, err := d.immediate(8)
+		if err != nil {
+			return 0, nil, err
+		}
+		mem.Disp = int32(disp)
+	case 2:
+		disp, err := d.immediate(32)
+		if err != nil {
+			return 0, nil, err
+		}
+		mem.Disp = int32(disp)
+	}
+	return reg, mem, nil
+}
+
+// regRM decodes the ModRM byte of an instruction with a register and an
+// r/m operand of the same size.
+func (d *decoder) regRM(size int) (Register, Operand, error) {
+	num, rm, err := d.modRM(size)
+	if err != nil {
+		return Register{}, nil, err
+	}
+	reg, err := d.register(num, size)
+	return reg, rm, err
+}
+
+// opcodeRegister returns the register in the lower three bits of the
+// opcode, extended by REX.B.
+func (d *decoder) opcodeRegister(opcode byte, size int) (Register, error) {
+	return d.register(opcode&7|(d.rex&0x01)<<3, size)
+}
+
+// prefix records b if it is a legacy prefix and reports whether it is one.
+func (d *decoder) prefix(b byte) bool {
+	switch b {
+	case 0x66:
+		d.size16 = true
+	case 0xf3:
+		d.rep = true
+	case 0xf2:
+		d.repne = true
+	case 0x64, 0x65:
+		d.segment = Segment(b)
+	case 0x2e, 0x3e:
+		// cs and ds segment prefixes have no effect in 64-bit mode
+	default:
+		return false
+	}
+	return true
+}
+
+func (d *decoder) decode() (Inst, error) {
+	opcode, err := d.byte()
+	for err == nil && d.prefix(opcode) {
+		opcode, err = d.byte()
+	}
+	if err == nil && opcode&0xf0 == 0x40 {
+		// REX has to be the last prefix
+		d.rex = opcode
+		opcode, err = d.byte()
+	}
+	if err != nil {
+		return Inst{}, err
+	}
+	size := d.operandSize()
+
+	inst := func(op Op, operands ...Operand) (Inst, error) {
+		return Inst{Op: op, Operands: operands}, nil
+	}
+
+	// arithmetic with the operand forms in the lower three bits
+	if opcode < 0x40 && opcode&7 < 6 {
+		op, ok := aluOps[opcode>>3]
+		if !ok {
+			return Inst{}, fmt.Errorf("unknown opcode 0x%02x", opcode)
+		}
+		return d.decodeForms(op, opcode&7, size)
+	}
+
+	switch {
+	case opcode >= 0x50 && opcode <= 0x5f:
+		reg, err := d.opcodeRegister(opcode, 64)
+		if err != nil {
+			return Inst{}, err
+		}
+		if opcode >= 0x58 {
+			return inst(POP, reg)
+		}
+		return inst(PUSH, reg)
+
+	case opcode >= 0x70 && opcode <= 0x7f:
+		rel, err := d.immediate(8)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(Jcc(Condition(opcode-0x70)), Rel8(rel))
+
+	case opcode >= 0x91 && opcode <= 0x97:
+		reg, err := d.opcodeRegister(opcode, size)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(XCHG, reg, Register{0, size})
+
+	case opcode == 0x90:
+		switch {
+		case d.rex&0x01 != 0:
+			return inst(XCHG, Register{8, size}, Register{0, size})
+		case d.rep:
+			return inst(PAUSE)
+		case d.size16:
+			return inst(XCHG, AX, AX)
+		}
+		return inst(NOP)
+
+	case opcode >= 0xb0 && opcode <= 0xbf:
+		if opcode < 0xb8 {
+			size = 8
+		}
+		reg, err := d.opcodeRegister(opcode, size)
+		if err != nil {
+			return Inst{}, err
+		}
+		imm, err := d.immediate(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(MOV, reg, imm)
+	}
+
+	switch opcode {
+	case 0x0f:
+		return d.decode0F(size)
+
+	case 0x63:
+		if size != 64 {
+			return Inst{}, fmt.Errorf("movsxd without REX.W is not supported")
+		}
+		num, rm, err := d.modRM(32)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(MOVSXD, Register{num, 64}, rm)
+
+	case 0x68, 0x6a:
+		immSize := 32
+		if opcode == 0x6a {
+			immSize = 8
+		}
+		imm, err := d.immediate(immSize)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(PUSH, imm)
+
+	case 0x69, 0x6b:
+		reg, rm, err := d.regRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		immSize := immediateSize(size)
+		if opcode == 0x6b {
+			immSize = 8
+		}
+		imm, err := d.immediate(immSize)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(IMUL, reg, rm, imm)
+
+	case 0x80, 0x81, 0x83:
+		if opcode == 0x80 {
+			size = 8
+		}
+		ext, rm, err := d.modRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		op, ok := aluOps[ext&7]
+		if !ok {
+			return Inst{}, fmt.Errorf("unknown opcode 0x%02x /%d", opcode, ext&7)
+		}
+		immSize := immediateSize(size)
+		if opcode == 0x83 {
+			immSize = 8
+		}
+		imm, err := d.immediate(immSize)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(op, rm, imm)
+
+	case 0x84, 0x85, 0x86, 0x87, 0x88, 0x89, 0x8a, 0x8b:
+		op := map[byte]Op{0x84: TEST, 0x86: XCHG, 0x88: MOV, 0x8a: MOV}[opcode&^1]
+		if opcode&1 == 0 {
+			size = 8
+		}
+		reg, rm, err := d.regRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		if opcode >= 0x8a {
+			return inst(op, reg, rm)
+		}
+		return inst(op, rm, reg)
+
+	case 0x8d:
+		reg, rm, err := d.regRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		mem, ok := rm.(Mem)
+		if !ok {
+			return Inst{}, fmt.Errorf("lea with register operand")
+		}
+		mem.Size = 0
+		return inst(LEA, reg, mem)
+
+	case 0x8f:
+		ext, rm, err := d.modRM(64)
+		if err != nil {
+			return Inst{}, err
+		}
+		if ext&7 != 0 {
+			return Inst{}, fmt.Errorf("unknown opcode 0x8f /%d", ext&7)
+		}
+		return inst(POP, rm)
+
+	case 0x98, 0x99:
+		switch {
+		case opcode == 0x99 && size == 64:
+			return inst(CQO)
+		case opcode == 0x99 && size == 32:
+			return inst(CDQ)
+		case opcode == 0x98 && size == 64:
+			return inst(CDQE)
+		}
+		return Inst{}, fmt.Errorf("unknown opcode 0x%02x with operand size %d", opcode, size)
+
+	case 0xa4:
+		if d.rep {
+			return inst(REP_MOVSB)
+		}
+		return inst(MOVSB)
+
+	case 0xa8, 0xa9:
+		if opcode == 0xa8 {
+			size = 8
+		}
+		imm, err := d.immediate(immediateSize(size))
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(TEST, Register{0, size}, imm)
+
+	case 0xc0, 0xc1, 0xd0, 0xd1, 0xd2, 0xd3:
+		if opcode&1 == 0 {
+			size = 8
+		}
+		ext, rm, err := d.modRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		op, ok := shiftOps[ext&7]
+		if !ok {
+			return Inst{}, fmt.Errorf("unknown opcode 0x%02x /%d", opcode, ext&7)
+		}
+		switch opcode &^ 1 {
+		case 0xc0:
+			count, err := d.immediate(8)
+			if err != nil {
+				return Inst{}, err
+			}
+			return inst(op, rm, Imm(uint8(count)))
+		case 0xd0:
+			return inst(op, rm, Imm(1))
+		default:
+			return inst(op, rm, CL)
+		}
+
+	case 0xc3:
+		return inst(RET)
+
+	case 0xc6, 0xc7:
+		if opcode == 0xc6 {
+			size = 8
+		}
+		ext, rm, err := d.modRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		if ext&7 != 0 {
+			return Inst{}, fmt.Errorf("unknown opcode 0x%02x /%d", opcode, ext&7)
+		}
+		imm, err := d.immediate(immediateSize(size))
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(MOV, rm, imm)
+
+	case 0xc8:
+		frameSize, err := d.immediate(16)
+		if err != nil {
+			return Inst{}, err
+		}
+		level, err := d.immediate(8)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(ENTER, Imm(uint16(frameSize)), Imm(uint8(level)))
+
+	case 0xc9:
+		return inst(LEAVE)
+
+	case 0xcc:
+		return inst(INT3)
+
+	case 0xe8, 0xe9:
+		rel, err := d.immediate(32)
+		if err != nil {
+			return Inst{}, err
+		}
+		if opcode == 0xe8 {
+			return inst(CALL, Rel(rel))
+		}
+		return inst(JMP, Rel(rel))
+
+	case 0xeb:
+		rel, err := d.immediate(8)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(JMP, Rel8(rel))
+
+	case 0xf4:
+		return inst(HLT)
+
+	case 0xf6, 0xf7:
+		if opcode == 0xf6 {
+			size = 8
+		}
+		ext, rm, err := d.modRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		if ext&7 == 0 {
+			imm, err := d.immediate(immediateSize(size))
+			if err != nil {
+				return Inst{}, err
+			}
+			return inst(TEST, rm, imm)
+		}
+		op, ok := map[byte]Op{2: NOT, 3: NEG, 4: MUL, 5: IMUL, 6: DIV, 7: IDIV}[ext&7]
+		if !ok {
+			return Inst{}, fmt.Errorf("unknown opcode 0x%02x /%d", opcode, ext&7)
+		}
+		return inst(op, rm)
+
+	case 0xfe, 0xff:
+		if opcode == 0xfe {
+			size = 8
+		}
+		ext, err := d.peekModRM()
+		if err != nil {
+			return Inst{}, err
+		}
+		switch {
+		case ext == 0 || ext == 1:
+			_, rm, err := d.modRM(size)
+			if err != nil {
+				return Inst{}, err
+			}
+			return inst(map[byte]Op{0: INC, 1: DEC}[ext], rm)
+		case opcode == 0xff && (ext == 2 || ext == 4 || ext == 6):
+			// call, jmp and push always use 64 bit operands
+			_, rm, err := d.modRM(64)
+			if err != nil {
+				return Inst{}, err
+			}
+			return inst(map[byte]Op{2: CALL, 4: JMP, 6: PUSH}[ext], rm)
+		}
+		return Inst{}, fmt.Errorf("unknown opcode 0x%02x /%d", opcode, ext)
+	}
+	return Inst{}, fmt.Errorf("unknown opcode 0x%02x", opcode)
+}
+
+// decode0F decodes the two byte opcodes starting with 0x0f.
+func (d *decoder) decode0F(size int) (Inst, error) {
+	opcode, err := d.byte()
+	if err != nil {
+		return Inst{}, err
+	}
+	inst := func(op Op, operands ...Operand) (Inst, error) {
+		return Inst{Op: op, Operands: operands}, nil
+	}
+
+	if d.ssePrefix() == 0x66 && (opcode == 0x6e || opcode == 0x7e) {
+		return d.decodeMovGeneral(opcode)
+	}
+	if op, ok := sseOps[sseOpcode{d.ssePrefix(), opcode}]; ok {
+		return d.decodeSSE(op, false)
+	}
+	if op, ok := sseStores[sseOpcode{d.ssePrefix(), opcode}]; ok {
+		return d.decodeSSE(op, true)
+	}
+
+	switch {
+	case opcode >= 0x40 && opcode <= 0x4f:
+		reg, rm, err := d.regRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(Cmovcc(Condition(opcode-0x40)), reg, rm)
+
+	case opcode >= 0x80 && opcode <= 0x8f:
+		rel, err := d.immediate(32)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(Jcc(Condition(opcode-0x80)), Rel(rel))
+
+	case opcode >= 0x90 && opcode <= 0x9f:
+		_, rm, err := d.modRM(8)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(Setcc(Condition(opcode-0x90)), rm)
+	}
+
+	switch opcode {
+	case 0x05:
+		return inst(SYSCALL)
+	case 0x0b:
+		return inst(UD2)
+	case 0x1e:
+		b, err := d.byte()
+		if err != nil {
+			return Inst{}, err
+		}
+		if b == 0xfa && d.rep {
+			return inst(ENDBR64)
+		}
+	case 0x1f:
+		ext, rm, err := d.modRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		if ext&7 == 0 {
+			return inst(NOP, rm)
+		}
+	case 0xa3:
+		reg, rm, err := d.regRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(BT, rm, reg)
+	case 0xba:
+		ext, rm, err := d.modRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		if ext&7 == 4 {
+			offset, err := d.immediate(8)
+			if err != nil {
+				return Inst{}, err
+			}
+			return inst(BT, rm, Imm(uint8(offset)))
+		}
+	case 0xaf:
+		reg, rm, err := d.regRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		return inst(IMUL, reg, rm)
+	case 0xb6, 0xb7, 0xbe, 0xbf:
+		srcSize := 8
+		if opcode&1 != 0 {
+			srcSize = 16
+		}
+		num, rm, err := d.modRM(srcSize)
+		if err != nil {
+			return Inst{}, err
+		}
+		reg, err := d.register(num, size)
+		if err != nil {
+			return Inst{}, err
+		}
+		if opcode < 0xbe {
+			return inst(MOVZX, reg, rm)
+		}
+		return inst(MOVSX, reg, rm)
+	}
+	return Inst{}, fmt.Errorf("unknown opcode 0x0f 0x%02x", opcode)
+}
+
+// sseOpcode is the mandatory prefix and the opcode after 0x0f of an SSE
+// instruction.
+type sseOpcode struct {
+	prefix byte
+	opcode byte
+}
+
+// sseOps are the instructions of sseInstructions by their opcode and
+// sseStores the moves by the opcode with a memory destination.
+var sseOps, sseStores = func() (map[sseOpcode]Op, map[sseOpcode]Op) {
+	ops, stores := map[sseOpcode]Op{}, map[sseOpcode]Op{}
+	for op, sse := range sseInstructions {
+		ops[sseOpcode{sse.prefix, sse.opcode}] = op
+		if sse.store == 0 {
+			continue
+		}
+		if op == MOVQ {
+			sse.prefix = 0x66
+		}
+		stores[sseOpcode{sse.prefix, sse.store}] = op
+	}
+	return ops, stores
+}()
+
+// ssePrefix returns the prefix which selects the SSE instruction, 0xf2 and
+// 0xf3 take precedence over 0x66.
+func (d *decoder) ssePrefix() byte {
+	switch {
+	case d.repne:
+		return 0xf2
+	case d.rep:
+		return 0xf3
+	case d.size16:
+		return 0x66
+	}
+	return 0
+}
+
+// decodeSSE decodes the SSE instruction op. store selects the form of the
+// moves with a memory destination.
+func (d *decoder) decodeSSE(op Op, store bool) (Inst, error) {
+	size := 32
+	if d.rex&0x08 != 0 {
+		size = 64
+	}
+	if op == CVTSI2SD {
+		num, rm, err := d.modRM(size)
+		if err != nil {
+			return Inst{}, err
+		}
+		return Inst{Op: op, Operands: []Operand{Register{num, 128}, rm}}, nil
+	}
+
+	num, rm, err := d.modRM(sseInstructions[op].memSize)
+	if err != nil {
+		return Inst{}, err
+	}
+	if reg, ok := rm.(Register); ok {
+		rm = Register{reg.num, 128}
+	}
+	reg := Register{num, 128}
+	switch {
+	case op == CVTTSD2SI:
+		reg.size = size
+	case store:
+		return Inst{Op: op, Operands: []Operand{rm, reg}}, nil
+	}
+	return Inst{Op: op, Operands: []Operand{reg, rm}}, nil
+}
+
+// decodeMovGeneral decodes movd and movq between an xmm register and a
+// general purpose register or memory operand, REX.W selects movq.
+func (d *decoder) decodeMovGeneral(opcode byte) (Inst, error) {
+	op, size := MOVD, 32
+	if d.rex&0x08 != 0 {
+		op, size = MOVQ, 64
+	}
+	num, rm, err := d.modRM(size)
+	if err != nil {
+		return Inst{}, err
+	}
+	reg := Register{num, 128}
+	if opcode == 0x7e {
+		return Inst{Op: op, Operands: []Operand{rm, reg}}, nil
+	}
+	return Inst{Op: op, Operands: []Operand{reg, rm}}, nil
+}
+
+// peekModRM returns the opcode extension of the ModRM byte without
+// consuming it.
+func (d *decoder) peekModRM() (byte, error) {
+	if d.pos >= len(d.code) {
+		return 0, ErrTruncated
+	}
+	return (d.code[d.pos] >> 3) & 7, nil
+}
+
+// decodeForms decodes the six forms of the arithmetic instructions:
+//
+//	0: r/m8, r8    1: r/m, r    2: r8, r/m8    3: r, r/m    4: al, imm8    5: eax, imm32
+func (d *decoder) decodeForms(op Op, form byte, size int) (Inst, error) {
+	if form%2 == 0 {
+		size = 8
+	}
+	if form >= 4 {
+		imm, err := d.immediate(immediateSize(size))
+		if err != nil {
+			return Inst{}, err
+		}
+		return Inst{Op: op, Operands: []Operand{Register{0, size}, imm}}, nil
+	}
+	reg, rm, err := d.regRM(size)
+	if err != nil {
+		return Inst{}, err
+	}
+	if form >= 2 {
+		return Inst{Op: op, Operands: []Operand{reg, rm}}, nil
+	}
+	return Inst{Op: op, Operands: []Operand{rm, reg}}, nil
+}
+
+// aluOps and shiftOps are the instructions by their opcode extension.
+var (
+	aluOps   = invert(aluExtensions)
+	shiftOps = invert(shiftExtensions)
+)
+
+func invert[K, V comparable](m map[K]V) map[V]K {
+	inverted := make(map[V]K, len(m))
+	for k, v := range m {
+		inverted[v] = k
+	}
+	return inverted
+}
@@ -0,0 +1,184 @@
+package x86
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	for _, test := range encodeTests {
+		code, err := Encode(test.op, test.operands...)
+		if err != nil {
+			t.Fatalf("%s: %s", test.att, err)
+		}
+		inst, err := Decode(append(code, 0xcc))
+		if err != nil {
+			t.Errorf("%s: % x: %s", test.att, code, err)
+			continue
+		}
+		if inst.Len != len(code) {
+			t.Errorf("%s: expected length %d got %d", test.att, len(code), inst.Len)
+		}
+		if inst.Op != test.op {
+			t.Errorf("%s: expected %s got %s", test.att, test.op, inst.Op)
+		}
+		encoded, err := Encode(inst.Op, inst.Operands...)
+		if err != nil {
+			t.Errorf("%s: %v: %s", test.att, inst.Operands, err)
+			continue
+		}
+		if !bytes.Equal(encoded, code) {
+			t.Errorf("%s: expected % x got % x from %v", test.att, code, encoded, inst.Operands)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		code []byte
+		err  string
+	}{
+		{[]byte{}, "truncated instruction"},
+		{[]byte{0x48}, "truncated instruction"},
+		{[]byte{0x48, 0x8b}, "truncated instruction"},
+		{[]byte{0x48, 0x8b, 0x04}, "truncated instruction"},
+		{[]byte{0xe8, 0x00, 0x00}, "truncated instruction"},
+		{[]byte{0x10, 0xc0}, "unknown opcode 0x10"},
+		{[]byte{0x0f, 0xff}, "unknown opcode 0x0f 0xff"},
+		{[]byte{0xff, 0xf8}, "unknown opcode 0xff /7"},
+		{[]byte{0x88, 0xe0}, "high byte registers are not supported"},
+		{[]byte{0x8d, 0xc0}, "lea with register operand"},
+	}
+	for _, test := range tests {
+		_, err := Decode(test.code)
+		if err == nil {
+			t.Errorf("% x: expected error %q", test.code, test.err)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("% x: expected error %q got %q", test.code, test.err, err)
+		}
+	}
+	if _, err := Decode([]byte{0x0f}); !errors.Is(err, ErrTruncated) {
+		t.Errorf("expected ErrTruncated got %v", err)
+	}
+}
+
+// objdump disassembles code at address with objdump and returns the
+// instructions without addresses and machine code.
+func objdump(t *testing.T, code []byte, address uint64, options ...string) []string {
+	t.Helper()
+	objdumpPath, err := exec.LookPath("objdump")
+	if err != nil {
+		t.Skip("objdump not found")
+	}
+	path := filepath.Join(t.TempDir(), "code.bin")
+	err = os.WriteFile(path, code, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := append([]string{"-D", "-w", "-b", "binary", "-m", "i386:x86-64", fmt.Sprintf("--adjust-vma=0x%x", address)}, options...)
+	out, err := exec.Command(objdumpPath, append(args, path)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("objdump failed: %s: %s", err, out)
+	}
+
+	instructions := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		// address:\tmachine code\tinstruction
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		instructions = append(instructions, strings.TrimSpace(fields[2]))
+	}
+	return instructions
+}
+
+func TestSyntax(t *testing.T) {
+	var (
+		address uint64 = 0x401000
+		code    []byte
+	)
+	for _, test := range encodeTests {
+		encoded, err := Encode(test.op, test.operands...)
+		if err != nil {
+			t.Fatalf("%s: %s", test.att, err)
+		}
+		code = append(code, encoded...)
+	}
+
+	for _, syntax := range []struct {
+		name    string
+		format  func(Inst, uint64, SymbolLookup) string
+		options []string
+	}{
+		{"att", ATTSyntax, nil},
+		{"intel", IntelSyntax, []string{"-M", "intel"}},
+	} {
+		t.Run(syntax.name, func(t *testing.T) {
+			expected := objdump(t, code, address, syntax.options...)
+			pc := address
+			i := 0
+			for rest := code; len(rest) > 0; i++ {
+				inst, err := Decode(rest)
+				if err != nil {
+					t.Fatalf("0x%x: %s", pc, err)
+				}
+				got := syntax.format(inst, pc, nil)
+				if i >= len(expected) {
+					t.Fatalf("0x%x: objdump output too short", pc)
+				}
+				if got != expected[i] {
+					t.Errorf("0x%x: expected %q got %q", pc, expected[i], got)
+				}
+				pc += uint64(inst.Len)
+				rest = rest[inst.Len:]
+			}
+			if i != len(expected) {
+				t.Errorf("expected %d instructions got %d", len(expected), i)
+			}
+		})
+	}
+}
+
+func TestSyntaxSymbols(t *testing.T) {
+	symbol := func(addr uint64) (string, uint64) {
+		if addr >= 0x401000 && addr < 0x401100 {
+			return "main", 0x401000
+		}
+		return "", 0
+	}
+	tests := []struct {
+		code  []byte
+		att   string
+		intel string
+	}{
+		{[]byte{0xe8, 0xfb, 0xff, 0xff, 0xff}, "call   401000 <main>", "call   401000 <main>"},
+		{[]byte{0xeb, 0x10}, "jmp    401012 <main+0x12>", "jmp    401012 <main+0x12>"},
+		{[]byte{0x75, 0x7e}, "jne    0x401180", "jne    0x401180"},
+		{[]byte{0x48, 0x8d, 0x35, 0x10, 0x00, 0x00, 0x00}, "lea    0x10(%rip),%rsi        # 401017 <main+0x17>", "lea    rsi,[rip+0x10]        # 401017 <main+0x17>"},
+	}
+	for _, test := range tests {
+		inst, err := Decode(test.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc := uint64(0x401000)
+		if test.code[0] == 0x75 {
+			pc = 0x401100
+		}
+		if got := ATTSyntax(inst, pc, symbol); got != test.att {
+			t.Errorf("expected %q got %q", test.att, got)
+		}
+		if got := IntelSyntax(inst, pc, symbol); got != test.intel {
+			t.Errorf("expected %q got %q", test.intel, got)
+		}
+	}
+}
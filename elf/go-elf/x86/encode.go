@@ -0,0 +1,984 @@
+package x86
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Encode returns the machine code of the instruction op with the operands
+// in Intel order (destination first). The shortest encoding is chosen where
+// the GNU assembler does the same, e.g. an 8-bit immediate for add $1, %rax.
+func Encode(op Op, operands ...Operand) ([]byte, error) {
+	code, err := encode(op, operands)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	return code, nil
+}
+
+// aluExtensions are the opcode extensions (/digit) of the arithmetic
+// instructions with an immediate. The opcodes with register operands are
+// extension*8 + 0-5.
+var aluExtensions = map[Op]byte{
+	ADD: 0,
+	OR:  1,
+	AND: 4,
+	SUB: 5,
+	XOR: 6,
+	CMP: 7,
+}
+
+// unaryExtensions are the opcode extensions of the instructions with a
+// single r/m operand, which use the opcodes 0xfe/0xff or 0xf6/0xf7.
+var unaryExtensions = map[Op]struct {
+	opcode byte
+	ext    byte
+}{
+	INC:  {0xff, 0},
+	DEC:  {0xff, 1},
+	NOT:  {0xf7, 2},
+	NEG:  {0xf7, 3},
+	MUL:  {0xf7, 4},
+	IDIV: {0xf7, 7},
+	DIV:  {0xf7, 6},
+}
+
+// sseInstructions are the mandatory prefix, the opcode after 0x0f and the
+// size of a memory operand of the SSE instructions. The prefix 0xf2
+// selects double precision and 0xf3 single precision. The source of
+// cvtsi2sd is an integer of the size of the operand. The moves have a
+// second opcode for a memory destination, movq with the prefix 0x66.
+var sseInstructions = map[Op]struct {
+	prefix  byte
+	opcode  byte
+	memSize int
+	store   byte
+}{
+	MOVSD:      {0xf2, 0x10, 64, 0x11},
+	MOVSS:      {0xf3, 0x10, 32, 0x11},
+	ADDSD:      {0xf2, 0x58, 64, 0},
+	MULSD:      {0xf2, 0x59, 64, 0},
+	SUBSD:      {0xf2, 0x5c, 64, 0},
+	DIVSD:      {0xf2, 0x5e, 64, 0},
+	CVTSI2SD:   {0xf2, 0x2a, 0, 0},
+	CVTTSD2SI:  {0xf2, 0x2c, 64, 0},
+	COMISD:     {0x66, 0x2f, 64, 0},
+	MOVAPS:     {0, 0x28, 128, 0x29},
+	MOVUPS:     {0, 0x10, 128, 0x11},
+	MOVDQA:     {0x66, 0x6f, 128, 0x7f},
+	MOVQ:       {0xf3, 0x7e, 64, 0xd6},
+	PXOR:       {0x66, 0xef, 128, 0},
+	PUNPCKLQDQ: {0x66, 0x6c, 128, 0},
+}
+
+var shiftExtensions = map[Op]byte{
+	SHL: 4,
+	SHR: 5,
+	SAR: 7,
+}
+
+func encode(op Op, operands []Operand) ([]byte, error) {
+	count := func(n int) error {
+		if len(operands) != n {
+			return fmt.Errorf("expected %d operands, got %d", n, len(operands))
+		}
+		return nil
+	}
+
+	if cond, ok := op.Condition(); ok {
+		if err := count(1); err != nil {
+			return nil, err
+		}
+		switch target := operands[0].(type) {
+		case Rel:
+			return binary.LittleEndian.AppendUint32([]byte{0x0f, 0x80 + byte(cond)}, uint32(target)), nil
+		case Rel8:
+			return []byte{0x70 + byte(cond), byte(target)}, nil
+		default:
+			return nil, fmt.Errorf("invalid operand %T", target)
+		}
+	}
+
+	switch op {
+	case ADD, OR, AND, SUB, XOR, CMP:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		return encodeALU(aluExtensions[op], operands[0], operands[1])
+
+	case MOV:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		return encodeMov(operands[0], operands[1])
+
+	case LEA:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		dst, ok := operands[0].(Register)
+		src, ok2 := operands[1].(Mem)
+		if !ok || !ok2 || dst.size == 8 {
+			return nil, fmt.Errorf("expected a 16, 32 or 64 bit register and a memory operand")
+		}
+		return instruction{size: dst.size, opcode: []byte{0x8d}, reg: dst, rm: src}.encode()
+
+	case PUSH, POP:
+		if err := count(1); err != nil {
+			return nil, err
+		}
+		switch operand := operands[0].(type) {
+		case Register:
+			if operand.size != 64 {
+				return nil, fmt.Errorf("only 64 bit registers are supported")
+			}
+			opcode := byte(0x50)
+			if op == POP {
+				opcode = 0x58
+			}
+			return withREXB(operand, opcode+operand.num&7), nil
+		case Mem:
+			if operand.Size != 0 && operand.Size != 64 {
+				return nil, fmt.Errorf("only 64 bit memory operands are supported")
+			}
+			if op == POP {
+				return instruction{opcode: []byte{0x8f}, ext: 0, rm: operand}.encode()
+			}
+			return instruction{opcode: []byte{0xff}, ext: 6, rm: operand}.encode()
+		case Imm:
+			if op == POP {
+				return nil, fmt.Errorf("invalid operand %T", operand)
+			}
+			if fitsInt8(int64(operand)) {
+				return []byte{0x6a, byte(operand)}, nil
+			}
+			if !fitsInt32(int64(operand)) {
+				return nil, fmt.Errorf("immediate %d out of range", operand)
+			}
+			return binary.LittleEndian.AppendUint32([]byte{0x68}, uint32(operand)), nil
+		default:
+			return nil, fmt.Errorf("invalid operand %T", operand)
+		}
+
+	case CALL, JMP:
+		if err := count(1); err != nil {
+			return nil, err
+		}
+		ext := byte(2)
+		if op == JMP {
+			ext = 4
+		}
+		switch target := operands[0].(type) {
+		case Rel:
+			opcode := byte(0xe8)
+			if op == JMP {
+				opcode = 0xe9
+			}
+			return binary.LittleEndian.AppendUint32([]byte{opcode}, uint32(target)), nil
+		case Rel8:
+			if op == CALL {
+				return nil, fmt.Errorf("call has no 8 bit displacement")
+			}
+			return []byte{0xeb, byte(target)}, nil
+		case Register:
+			if target.size != 64 {
+				return nil, fmt.Errorf("only 64 bit registers are supported")
+			}
+			// the operand size is 64 bit by default, no REX.W
+			return instruction{opcode: []byte{0xff}, ext: ext, rm: target}.encode()
+		case Mem:
+			return instruction{opcode: []byte{0xff}, ext: ext, rm: target}.encode()
+		default:
+			return nil, fmt.Errorf("invalid operand %T", target)
+		}
+
+	case IMUL:
+		return encodeImul(operands)
+
+	case INC, DEC, NOT, NEG, MUL, DIV, IDIV:
+		if err := count(1); err != nil {
+			return nil, err
+		}
+		size, err := operandSize(operands[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		unary := unaryExtensions[op]
+		return instruction{size: size, opcode: []byte{byteOpcode(size, unary.opcode)}, ext: unary.ext, rm: operands[0]}.encode()
+
+	case TEST:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		return encodeTEST(operands[0], operands[1])
+
+	case ENTER:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		size, ok := operands[0].(Imm)
+		level, ok2 := operands[1].(Imm)
+		if !ok || !ok2 || size < 0 || size > math.MaxUint16 || level < 0 || level > math.MaxUint8 {
+			return nil, fmt.Errorf("expected a 16 bit and an 8 bit immediate")
+		}
+		return append(binary.LittleEndian.AppendUint16([]byte{0xc8}, uint16(size)), byte(level)), nil
+
+	case SHL, SHR, SAR:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		return encodeShift(shiftExtensions[op], operands[0], operands[1])
+
+	case MOVZX, MOVSX, MOVSXD:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		return encodeExtend(op, operands[0], operands[1])
+
+	case XCHG:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		return encodeXchg(operands[0], operands[1])
+
+	case NOP:
+		if len(operands) == 0 {
+			return []byte{0x90}, nil
+		}
+		// multi-byte nop used for padding
+		if err := count(1); err != nil {
+			return nil, err
+		}
+		size, err := operandSize(operands[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		if size != 16 && size != 32 {
+			return nil, fmt.Errorf("expected a 16 or 32 bit operand")
+		}
+		return instruction{size: size, opcode: []byte{0x0f, 0x1f}, ext: 0, rm: operands[0]}.encode()
+
+	case MOVSD, MOVSS, ADDSD, SUBSD, MULSD, DIVSD, CVTSI2SD, CVTTSD2SI, COMISD,
+		MOVAPS, MOVUPS, MOVDQA, MOVD, MOVQ, PXOR, PUNPCKLQDQ:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		return encodeSSE(op, operands[0], operands[1])
+
+	case BT:
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		return encodeBT(operands[0], operands[1])
+
+	case RET, CQO, CDQ, CDQE, SYSCALL, LEAVE, MOVSB, REP_MOVSB, HLT, INT3, UD2, PAUSE, ENDBR64:
+		if err := count(0); err != nil {
+			return nil, err
+		}
+		return map[Op][]byte{
+			RET:       {0xc3},
+			CQO:       {0x48, 0x99},
+			CDQ:       {0x99},
+			CDQE:      {0x48, 0x98},
+			SYSCALL:   {0x0f, 0x05},
+			LEAVE:     {0xc9},
+			MOVSB:     {0xa4},
+			REP_MOVSB: {0xf3, 0xa4},
+			HLT:       {0xf4},
+			INT3:      {0xcc},
+			UD2:       {0x0f, 0x0b},
+			PAUSE:     {0xf3, 0x90},
+			ENDBR64:   {0xf3, 0x0f, 0x1e, 0xfa},
+		}[op], nil
+	}
+
+	cond, _ := conditionOf(op)
+	switch {
+	case op >= SETO && op <= SETG:
+		// setcc r/m8
+		if err := count(1); err != nil {
+			return nil, err
+		}
+		size, err := operandSize(operands[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		if size != 8 {
+			return nil, fmt.Errorf("expected an 8 bit operand")
+		}
+		return instruction{opcode: []byte{0x0f, 0x90 + byte(cond)}, ext: 0, rm: operands[0]}.encode()
+
+	case op >= CMOVO && op <= CMOVG:
+		// cmovcc reg, r/m
+		if err := count(2); err != nil {
+			return nil, err
+		}
+		dst, ok := operands[0].(Register)
+		if !ok || dst.size == 8 {
+			return nil, fmt.Errorf("destination has to be a 16, 32 or 64 bit register")
+		}
+		size, err := operandSize(dst, operands[1])
+		if err != nil {
+			return nil, err
+		}
+		return instruction{size: size, opcode: []byte{0x0f, 0x40 + byte(cond)}, reg: dst, rm: operands[1]}.encode()
+
+	default:
+		return nil, fmt.Errorf("unknown instruction")
+	}
+}
+
+// encodeALU encodes add, or, and, sub, xor and cmp.
+func encodeALU(ext byte, dst Operand, src Operand) ([]byte, error) {
+	size, err := operandSize(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	base := ext * 8
+
+	switch src := src.(type) {
+	case Register:
+		// op r/m, reg
+		return instruction{size: size, opcode: []byte{byteOpcode(size, base+1)}, reg: src, rm: dst}.encode()
+
+	case Mem:
+		// op reg, r/m
+		reg, ok := dst.(Register)
+		if !ok {
+			return nil, fmt.Errorf("invalid operands: two memory operands")
+		}
+		return instruction{size: size, opcode: []byte{byteOpcode(size, base+3)}, reg: reg, rm: src}.encode()
+
+	case Imm:
+		value, err := immediate(int64(src), size)
+		if err != nil {
+			return nil, err
+		}
+		if size == 8 {
+			if reg, ok := dst.(Register); ok && reg.num == 0 {
+				return []byte{base + 4, byte(value)}, nil
+			}
+			return instruction{size: size, opcode: []byte{0x80}, ext: ext, rm: dst, imm: []byte{byte(value)}}.encode()
+		}
+		if fitsInt8(value) {
+			return instruction{size: size, opcode: []byte{0x83}, ext: ext, rm: dst, imm: []byte{byte(value)}}.encode()
+		}
+		imm := immediateBytes(value, size)
+		if reg, ok := dst.(Register); ok && reg.num == 0 {
+			// short form for the accumulator
+			return instruction{size: size, opcode: []byte{base + 5}, imm: imm}.encode()
+		}
+		return instruction{size: size, opcode: []byte{0x81}, ext: ext, rm: dst, imm: imm}.encode()
+
+	default:
+		return nil, fmt.Errorf("invalid operand %T", src)
+	}
+}
+
+func encodeMov(dst Operand, src Operand) ([]byte, error) {
+	size, err := operandSize(dst, src)
+	if err != nil {
+		return nil, err
+	}
+
+	switch src := src.(type) {
+	case Register:
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0x89)}, reg: src, rm: dst}.encode()
+
+	case Mem:
+		reg, ok := dst.(Register)
+		if !ok {
+			return nil, fmt.Errorf("invalid operands: two memory operands")
+		}
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0x8b)}, reg: reg, rm: src}.encode()
+
+	case Imm:
+		reg, isReg := dst.(Register)
+		if size == 64 {
+			if fitsInt32(int64(src)) {
+				// sign extended 32 bit immediate
+				return instruction{size: size, opcode: []byte{0xc7}, ext: 0, rm: dst, imm: immediateBytes(int64(src), 32)}.encode()
+			}
+			if !isReg {
+				return nil, fmt.Errorf("immediate %d out of range", src)
+			}
+			// movabs
+			return instruction{size: size, opcode: []byte{0xb8 + reg.num&7}, rmInOpcode: reg, imm: binary.LittleEndian.AppendUint64(nil, uint64(src))}.encode()
+		}
+		value, err := immediate(int64(src), size)
+		if err != nil {
+			return nil, err
+		}
+		imm := immediateBytes(value, size)
+		if isReg {
+			opcode := byte(0xb8)
+			if size == 8 {
+				opcode = 0xb0
+			}
+			return instruction{size: size, opcode: []byte{opcode + reg.num&7}, rmInOpcode: reg, imm: imm}.encode()
+		}
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0xc7)}, ext: 0, rm: dst, imm: imm}.encode()
+
+	default:
+		return nil, fmt.Errorf("invalid operand %T", src)
+	}
+}
+
+// encodeImul encodes the one operand form (rdx:rax = rax * r/m), the two
+// operand form (reg = reg * r/m) and the three operand form with an
+// immediate (reg = r/m * imm). The form imul $imm, reg is the three operand
+// form with reg as both operands.
+func encodeImul(operands []Operand) ([]byte, error) {
+	switch len(operands) {
+	case 1:
+		size, err := operandSize(operands[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0xf7)}, ext: 5, rm: operands[0]}.encode()
+
+	case 2:
+		if imm, ok := operands[1].(Imm); ok {
+			return encodeImul([]Operand{operands[0], operands[0], imm})
+		}
+		dst, ok := operands[0].(Register)
+		if !ok || dst.size == 8 {
+			return nil, fmt.Errorf("destination has to be a 16, 32 or 64 bit register")
+		}
+		size, err := operandSize(dst, operands[1])
+		if err != nil {
+			return nil, err
+		}
+		return instruction{size: size, opcode: []byte{0x0f, 0xaf}, reg: dst, rm: operands[1]}.encode()
+
+	case 3:
+		dst, ok := operands[0].(Register)
+		if !ok || dst.size == 8 {
+			return nil, fmt.Errorf("destination has to be a 16, 32 or 64 bit register")
+		}
+		size, err := operandSize(dst, operands[1])
+		if err != nil {
+			return nil, err
+		}
+		imm, ok := operands[2].(Imm)
+		if !ok {
+			return nil, fmt.Errorf("third operand has to be an immediate")
+		}
+		value, err := immediate(int64(imm), size)
+		if err != nil {
+			return nil, err
+		}
+		if fitsInt8(value) {
+			return instruction{size: size, opcode: []byte{0x6b}, reg: dst, rm: operands[1], imm: []byte{byte(value)}}.encode()
+		}
+		return instruction{size: size, opcode: []byte{0x69}, reg: dst, rm: operands[1], imm: immediateBytes(value, size)}.encode()
+
+	default:
+		return nil, fmt.Errorf("expected 1 to 3 operands, got %d", len(operands))
+	}
+}
+
+// encodeTEST encodes test, which sets the flags of dst & src.
+func encodeTEST(dst Operand, src Operand) ([]byte, error) {
+	size, err := operandSize(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	switch src := src.(type) {
+	case Register:
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0x85)}, reg: src, rm: dst}.encode()
+	case Imm:
+		value, err := immediate(int64(src), size)
+		if err != nil {
+			return nil, err
+		}
+		imm := immediateBytes(value, size)
+		if reg, ok := dst.(Register); ok && reg.num == 0 {
+			// short form for the accumulator
+			return instruction{size: size, opcode: []byte{byteOpcode(size, 0xa9)}, imm: imm}.encode()
+		}
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0xf7)}, ext: 0, rm: dst, imm: imm}.encode()
+	default:
+		return nil, fmt.Errorf("invalid operand %T", src)
+	}
+}
+
+// encodeExtend encodes movzx and movsx, which zero or sign extend an 8 or
+// 16 bit source, and movsxd, which sign extends a 32 bit source to 64 bit.
+func encodeExtend(op Op, dst Operand, src Operand) ([]byte, error) {
+	reg, ok := dst.(Register)
+	if !ok || reg.size == 8 {
+		return nil, fmt.Errorf("destination has to be a 16, 32 or 64 bit register")
+	}
+	srcSize, err := operandSize(src, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if op == MOVSXD {
+		if reg.size != 64 || srcSize != 32 {
+			return nil, fmt.Errorf("expected a 64 bit destination and a 32 bit source")
+		}
+		return instruction{size: 64, opcode: []byte{0x63}, reg: reg, rm: src}.encode()
+	}
+
+	opcode := byte(0xb6)
+	if op == MOVSX {
+		opcode = 0xbe
+	}
+	switch {
+	case srcSize == 16 && reg.size > 16:
+		opcode++
+	case srcSize != 8:
+		return nil, fmt.Errorf("source has to be smaller than the destination")
+	}
+	return instruction{size: reg.size, opcode: []byte{0x0f, opcode}, reg: reg, rm: src}.encode()
+}
+
+// encodeXchg encodes xchg, which uses the short form 0x90+r if one operand
+// is the accumulator. The short form of xchg %eax, %eax would be nop, which
+// does not clear the upper half of rax.
+func encodeXchg(dst Operand, src Operand) ([]byte, error) {
+	size, err := operandSize(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	dstReg, dstIsReg := dst.(Register)
+	srcReg, srcIsReg := src.(Register)
+	if dstIsReg && srcIsReg && size != 8 {
+		other := Register{}
+		switch {
+		case srcReg.num == 0:
+			other = dstReg
+		case dstReg.num == 0:
+			other = srcReg
+		}
+		if other.size != 0 && !(size == 32 && other.num == 0) {
+			return instruction{size: size, opcode: []byte{0x90 + other.num&7}, rmInOpcode: other}.encode()
+		}
+	}
+	switch {
+	case srcIsReg:
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0x87)}, reg: srcReg, rm: dst}.encode()
+	case dstIsReg:
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0x87)}, reg: dstReg, rm: src}.encode()
+	default:
+		return nil, fmt.Errorf("invalid operands: two memory operands")
+	}
+}
+
+// encodeShift encodes shl, shr and sar by an immediate or by cl.
+func encodeShift(ext byte, dst Operand, count Operand) ([]byte, error) {
+	size, err := operandSize(dst, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch count := count.(type) {
+	case Imm:
+		if count < 0 || count > 255 {
+			return nil, fmt.Errorf("shift count %d out of range", count)
+		}
+		if count == 1 {
+			return instruction{size: size, opcode: []byte{byteOpcode(size, 0xd1)}, ext: ext, rm: dst}.encode()
+		}
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0xc1)}, ext: ext, rm: dst, imm: []byte{byte(count)}}.encode()
+	case Register:
+		if count != CL {
+			return nil, fmt.Errorf("shift count has to be an immediate or cl")
+		}
+		return instruction{size: size, opcode: []byte{byteOpcode(size, 0xd3)}, ext: ext, rm: dst}.encode()
+	default:
+		return nil, fmt.Errorf("invalid operand %T", count)
+	}
+}
+
+// encodeSSE encodes the SSE instructions, whose operands are an xmm
+// register and an xmm register or memory operand, except for the integer
+// operand of the conversions and of movd and movq. The moves store with a
+// memory destination.
+func encodeSSE(op Op, dst Operand, src Operand) ([]byte, error) {
+	sse := sseInstructions[op]
+	opcode := sse.opcode
+	xmmOrMem := func(operand Operand, size int) error {
+		if isXMM(operand) {
+			return nil
+		}
+		mem, ok := operand.(Mem)
+		if !ok {
+			return fmt.Errorf("expected an xmm register or memory operand")
+		}
+		if mem.Size != 0 && mem.Size != size {
+			return fmt.Errorf("expected a %d bit memory operand", size)
+		}
+		return nil
+	}
+
+	switch {
+	case op == CVTSI2SD:
+		reg, ok := dst.(Register)
+		if !ok || !reg.IsXMM() {
+			return nil, fmt.Errorf("destination has to be an xmm register")
+		}
+		size, err := operandSize(src, nil)
+		if err != nil {
+			return nil, err
+		}
+		if size != 32 && size != 64 {
+			return nil, fmt.Errorf("source has to be a 32 or 64 bit integer")
+		}
+		return instruction{size: size, prefix: sse.prefix, opcode: []byte{0x0f, opcode}, reg: reg, rm: src}.encode()
+
+	case op == CVTTSD2SI:
+		reg, ok := dst.(Register)
+		if !ok || reg.size != 32 && reg.size != 64 {
+			return nil, fmt.Errorf("destination has to be a 32 or 64 bit register")
+		}
+		if err := xmmOrMem(src, sse.memSize); err != nil {
+			return nil, err
+		}
+		return instruction{size: reg.size, prefix: sse.prefix, opcode: []byte{0x0f, opcode}, reg: reg, rm: src}.encode()
+
+	case op == MOVD || op == MOVQ && (isGeneral(dst) || isGeneral(src)):
+		return encodeMovGeneral(op, dst, src)
+
+	case sse.store != 0 && !isXMM(dst):
+		// store: movsd m64, xmm
+		dst, src = src, dst
+		opcode = sse.store
+		if op == MOVQ {
+			sse.prefix = 0x66
+		}
+	}
+
+	reg, ok := dst.(Register)
+	if !ok || !reg.IsXMM() {
+		return nil, fmt.Errorf("expected an xmm register")
+	}
+	if err := xmmOrMem(src, sse.memSize); err != nil {
+		return nil, err
+	}
+	return instruction{prefix: sse.prefix, opcode: []byte{0x0f, opcode}, reg: reg, rm: src}.encode()
+}
+
+// isXMM reports whether operand is an xmm register.
+func isXMM(operand Operand) bool {
+	reg, ok := operand.(Register)
+	return ok && reg.IsXMM()
+}
+
+// isGeneral reports whether operand is a general purpose register.
+func isGeneral(operand Operand) bool {
+	reg, ok := operand.(Register)
+	return ok && !reg.IsXMM()
+}
+
+// encodeMovGeneral encodes movd and movq between an xmm register and a
+// general purpose register or memory operand of 32 or 64 bit.
+func encodeMovGeneral(op Op, dst Operand, src Operand) ([]byte, error) {
+	size := 32
+	if op == MOVQ {
+		size = 64
+	}
+	opcode := byte(0x6e)
+	reg, ok := dst.(Register)
+	if !ok || !reg.IsXMM() {
+		// store: movd r/m32, xmm
+		dst, src = src, dst
+		opcode = 0x7e
+		reg, ok = dst.(Register)
+		if !ok || !reg.IsXMM() {
+			return nil, fmt.Errorf("expected an xmm register")
+		}
+	}
+	if isXMM(src) || sizeOf(src) != 0 && sizeOf(src) != size {
+		return nil, fmt.Errorf("expected a %d bit register or memory operand", size)
+	}
+	if size == 32 {
+		// no REX.W
+		size = 0
+	}
+	return instruction{size: size, prefix: 0x66, opcode: []byte{0x0f, opcode}, reg: reg, rm: src}.encode()
+}
+
+// encodeBT encodes bt r/m, reg and bt r/m, imm8.
+func encodeBT(dst Operand, src Operand) ([]byte, error) {
+	if imm, ok := src.(Imm); ok {
+		size, err := operandSize(dst, nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 8 {
+			return nil, fmt.Errorf("expected a 16, 32 or 64 bit operand")
+		}
+		if imm < 0 || imm > math.MaxUint8 {
+			return nil, fmt.Errorf("bit offset %d out of range", imm)
+		}
+		return instruction{size: size, opcode: []byte{0x0f, 0xba}, ext: 4, rm: dst, imm: []byte{byte(imm)}}.encode()
+	}
+	reg, ok := src.(Register)
+	if !ok || reg.size == 8 || reg.IsXMM() {
+		return nil, fmt.Errorf("bit offset has to be an immediate or a 16, 32 or 64 bit register")
+	}
+	size, err := operandSize(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	return instruction{size: size, opcode: []byte{0x0f, 0xa3}, reg: reg, rm: dst}.encode()
+}
+
+// operandSize returns the size of the operation from the register operands
+// or the size of the memory operand.
+func operandSize(dst Operand, src Operand) (int, error) {
+	size := 0
+	for _, operand := range []Operand{dst, src} {
+		current := 0
+		switch operand := operand.(type) {
+		case Register:
+			if operand.IsXMM() {
+				return 0, fmt.Errorf("invalid operand %s", operand)
+			}
+			current = operand.size
+		case Mem:
+			current = operand.Size
+		}
+		if current == 0 {
+			continue
+		}
+		if size != 0 && size != current {
+			return 0, fmt.Errorf("operand size mismatch: %d and %d bit", size, current)
+		}
+		size = current
+	}
+	if size == 0 {
+		return 0, fmt.Errorf("operand size missing")
+	}
+	return size, nil
+}
+
+// byteOpcode returns the opcode for 8 bit operands, which is one less than
+// the opcode for 16, 32 and 64 bit operands.
+func byteOpcode(size int, opcode byte) byte {
+	if size == 8 {
+		return opcode - 1
+	}
+	return opcode
+}
+
+// immediate checks that value fits into size bits, either signed or
+// unsigned, and returns it sign extended from size bits.
+func immediate(value int64, size int) (int64, error) {
+	if size == 64 {
+		if !fitsInt32(value) {
+			return 0, fmt.Errorf("immediate %d out of range", value)
+		}
+		return value, nil
+	}
+	if value < -(1<<(size-1)) || value >= 1<<size {
+		return 0, fmt.Errorf("immediate %d out of range for %d bit operand", value, size)
+	}
+	shift := 64 - size
+	return value << shift >> shift, nil
+}
+
+// immediateBytes returns the immediate with 1, 2 or 4 bytes. 64 bit operands
+// use sign extended 32 bit immediates.
+func immediateBytes(value int64, size int) []byte {
+	switch size {
+	case 8:
+		return []byte{byte(value)}
+	case 16:
+		return binary.LittleEndian.AppendUint16(nil, uint16(value))
+	default:
+		return binary.LittleEndian.AppendUint32(nil, uint32(value))
+	}
+}
+
+func fitsInt8(value int64) bool {
+	return value >= math.MinInt8 && value <= math.MaxInt8
+}
+
+func fitsInt32(value int64) bool {
+	return value >= math.MinInt32 && value <= math.MaxInt32
+}
+
+// withREXB returns the opcode which contains the lower three bits of the
+// register number, prefixed with REX.B for r8-r15.
+func withREXB(reg Register, opcode byte) []byte {
+	if reg.num >= 8 {
+		return []byte{0x41, opcode}
+	}
+	return []byte{opcode}
+}
+
+// instruction is an instruction with an optional ModRM byte.
+type instruction struct {
+	size   int  // operand size in bits, selects the 0x66 prefix and REX.W
+	prefix byte // mandatory prefix of SSE instructions, 0 for none
+	opcode []byte
+
+	// ModRM.reg is either the register reg or the opcode extension ext
+	reg Register
+	ext byte
+	// ModRM.rm is a Register or Mem, no ModRM byte if nil
+	rm Operand
+
+	// register encoded in the lower three bits of the opcode
+	rmInOpcode Register
+
+	imm []byte
+}
+
+func (in instruction) encode() ([]byte, error) {
+	code := []byte{}
+	if mem, ok := in.rm.(Mem); ok && mem.Segment != 0 {
+		code = append(code, byte(mem.Segment))
+	}
+	if in.size == 16 {
+		code = append(code, 0x66)
+	}
+	if in.size == 128 {
+		return nil, fmt.Errorf("xmm registers are only supported by SSE instructions")
+	}
+	if in.prefix != 0 {
+		code = append(code, in.prefix)
+	}
+
+	rex := byte(0)
+	forceREX := false
+	if in.size == 64 {
+		rex |= 0x08 // W
+	}
+	needsREX := func(r Register) {
+		// spl, bpl, sil and dil instead of ah, ch, dh and bh
+		if r.size == 8 && r.num >= 4 && r.num < 8 {
+			forceREX = true
+		}
+	}
+
+	modRM := []byte{}
+	if in.rm != nil {
+		regField := in.ext
+		if in.reg.size != 0 {
+			regField = in.reg.num
+			needsREX(in.reg)
+		}
+		if regField >= 8 {
+			rex |= 0x04 // R
+		}
+
+		switch rm := in.rm.(type) {
+		case Register:
+			needsREX(rm)
+			if rm.num >= 8 {
+				rex |= 0x01 // B
+			}
+			modRM = append(modRM, 0xc0|(regField&7)<<3|rm.num&7)
+		case Mem:
+			encoded, rexXB, err := encodeMem(regField, rm)
+			if err != nil {
+				return nil, err
+			}
+			rex |= rexXB
+			modRM = encoded
+		default:
+			return nil, fmt.Errorf("invalid operand %T", rm)
+		}
+	}
+	if in.rmInOpcode.size != 0 {
+		needsREX(in.rmInOpcode)
+		if in.rmInOpcode.num >= 8 {
+			rex |= 0x01 // B
+		}
+	}
+
+	if rex != 0 || forceREX {
+		code = append(code, 0x40|rex)
+	}
+	code = append(code, in.opcode...)
+	code = append(code, modRM...)
+	code = append(code, in.imm...)
+	return code, nil
+}
+
+// encodeMem returns the ModRM byte, the SIB byte and the displacement of a
+// memory operand and the REX.X and REX.B bits.
+//
+//	ModRM: mod(2) reg(3) rm(3)    SIB: scale(2) index(3) base(3)
+//
+// rm 100 selects a SIB byte. mod 00 with rm 101 is RIP relative and a SIB
+// byte with base 101 and mod 00 has no base, both with a 32 bit
+// displacement.
+func encodeMem(regField byte, m Mem) (code []byte, rex byte, err error) {
+	reg := (regField & 7) << 3
+
+	if m.RIP {
+		if m.Base.size != 0 || m.Index.size != 0 {
+			return nil, 0, fmt.Errorf("RIP relative operand with base or index register")
+		}
+		return binary.LittleEndian.AppendUint32([]byte{0x05 | reg}, uint32(m.Disp)), 0, nil
+	}
+
+	for _, r := range []Register{m.Base, m.Index} {
+		if r.size != 0 && r.size != 64 {
+			return nil, 0, fmt.Errorf("address register %s is not a 64 bit register", r)
+		}
+	}
+	if m.Index == RSP {
+		return nil, 0, fmt.Errorf("rsp can't be used as index register")
+	}
+
+	scale := byte(0)
+	if m.Index.size != 0 {
+		switch m.Scale {
+		case 0, 1:
+			scale = 0
+		case 2:
+			scale = 1
+		case 4:
+			scale = 2
+		case 8:
+			scale = 3
+		default:
+			return nil, 0, fmt.Errorf("invalid scale %d", m.Scale)
+		}
+		if m.Index.num >= 8 {
+			rex |= 0x02 // X
+		}
+	}
+	index := byte(4) // no index
+	if m.Index.size != 0 {
+		index = m.Index.num & 7
+	}
+
+	if m.Base.size == 0 {
+		// no base, always a 32 bit displacement
+		code = []byte{0x04 | reg, scale<<6 | index<<3 | 5}
+		return binary.LittleEndian.AppendUint32(code, uint32(m.Disp)), rex, nil
+	}
+
+	if m.Base.num >= 8 {
+		rex |= 0x01 // B
+	}
+	base := m.Base.num & 7
+
+	var mod byte
+	var disp []byte
+	switch {
+	case m.Disp == 0 && base != 5: // rbp and r13 always need a displacement
+		mod = 0x00
+	case fitsInt8(int64(m.Disp)):
+		mod = 0x40
+		disp = []byte{byte(m.Disp)}
+	default:
+		mod = 0x80
+		disp = binary.LittleEndian.AppendUint32(nil, uint32(m.Disp))
+	}
+
+	if m.Index.size == 0 && base != 4 { // rsp and r12 always need a SIB byte
+		code = []byte{mod | reg | base}
+	} else {
+		code = []byte{mod | reg | 4, scale<<6 | index<<3 | base}
+	}
+	return append(code, disp...), rex, nil
+}
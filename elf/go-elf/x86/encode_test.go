@@ -0,0 +1,407 @@
+package x86
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type encodeTest struct {
+	att      string // the instruction in the syntax of the GNU assembler
+	op       Op
+	operands []Operand
+}
+
+var encodeTests = []encodeTest{
+	// mov
+	{"mov %rbx, %rax", MOV, []Operand{RAX, RBX}},
+	{"mov %r15, %r8", MOV, []Operand{R8, R15}},
+	{"mov %ecx, %r9d", MOV, []Operand{R9D, ECX}},
+	{"mov %si, %ax", MOV, []Operand{AX, SI}},
+	{"mov %dil, %al", MOV, []Operand{AL, DIL}},
+	{"mov %r10b, %cl", MOV, []Operand{CL, R10B}},
+	{"mov $1, %rax", MOV, []Operand{RAX, Imm(1)}},
+	{"mov $-1, %r12", MOV, []Operand{R12, Imm(-1)}},
+	{"mov $0x12345678, %eax", MOV, []Operand{EAX, Imm(0x12345678)}},
+	{"mov $0xffffffff, %r11d", MOV, []Operand{R11D, Imm(0xffffffff)}},
+	{"movabs $0x1122334455667788, %r13", MOV, []Operand{R13, Imm(0x1122334455667788)}},
+	{"movabs $0xffffffff, %rax", MOV, []Operand{RAX, Imm(0xffffffff)}},
+	{"mov $0x1234, %r8w", MOV, []Operand{R8W, Imm(0x1234)}},
+	{"mov $0x7f, %sil", MOV, []Operand{SIL, Imm(0x7f)}},
+	{"mov (%rax), %rbx", MOV, []Operand{RBX, Mem{Base: RAX}}},
+	{"mov %rbx, (%rax)", MOV, []Operand{Mem{Base: RAX}, RBX}},
+	{"mov 8(%rsp), %rdi", MOV, []Operand{RDI, Mem{Base: RSP, Disp: 8}}},
+	{"mov (%rbp), %eax", MOV, []Operand{EAX, Mem{Base: RBP}}},
+	{"mov (%r13), %eax", MOV, []Operand{EAX, Mem{Base: R13}}},
+	{"mov (%r12), %eax", MOV, []Operand{EAX, Mem{Base: R12}}},
+	{"mov -0x80(%rbp), %r14", MOV, []Operand{R14, Mem{Base: RBP, Disp: -0x80}}},
+	{"mov 0x1000(%rbx), %ecx", MOV, []Operand{ECX, Mem{Base: RBX, Disp: 0x1000}}},
+	{"mov (%rax,%rcx,8), %rdx", MOV, []Operand{RDX, Mem{Base: RAX, Index: RCX, Scale: 8}}},
+	{"mov 0x10(%r8,%r9,4), %r10", MOV, []Operand{R10, Mem{Base: R8, Index: R9, Scale: 4, Disp: 0x10}}},
+	{"mov (%rsp,%r12,2), %al", MOV, []Operand{AL, Mem{Base: RSP, Index: R12, Scale: 2}}},
+	{"mov 0x20(,%rsi,8), %rax", MOV, []Operand{RAX, Mem{Index: RSI, Scale: 8, Disp: 0x20}}},
+	{"mov 0x402000, %eax", MOV, []Operand{EAX, Mem{Disp: 0x402000}}},
+	{"mov %edi, 0x402000", MOV, []Operand{Mem{Disp: 0x402000}, EDI}},
+	{"mov 0x100(%rip), %eax", MOV, []Operand{EAX, Mem{RIP: true, Disp: 0x100}}},
+	{"mov %r9, -4(%rip)", MOV, []Operand{Mem{RIP: true, Disp: -4}, R9}},
+	{"movl $1, (%rax)", MOV, []Operand{Mem{Base: RAX, Size: 32}, Imm(1)}},
+	{"movq $-2, 8(%r15)", MOV, []Operand{Mem{Base: R15, Disp: 8, Size: 64}, Imm(-2)}},
+	{"movb $0xff, (%rdi)", MOV, []Operand{Mem{Base: RDI, Size: 8}, Imm(0xff)}},
+	{"movw $0x10, (%rdi)", MOV, []Operand{Mem{Base: RDI, Size: 16}, Imm(0x10)}},
+
+	// arithmetic
+	{"add %rbx, %rax", ADD, []Operand{RAX, RBX}},
+	{"add $1, %eax", ADD, []Operand{EAX, Imm(1)}},
+	{"add $1, %r8", ADD, []Operand{R8, Imm(1)}},
+	{"add $0x1000, %eax", ADD, []Operand{EAX, Imm(0x1000)}},
+	{"add $0x1000, %rcx", ADD, []Operand{RCX, Imm(0x1000)}},
+	{"add $0xffffffff, %eax", ADD, []Operand{EAX, Imm(0xffffffff)}},
+	{"add $5, %al", ADD, []Operand{AL, Imm(5)}},
+	{"add $5, %bl", ADD, []Operand{BL, Imm(5)}},
+	{"add $0x1000, %ax", ADD, []Operand{AX, Imm(0x1000)}},
+	{"add (%rsi), %r11", ADD, []Operand{R11, Mem{Base: RSI}}},
+	{"addl $1, 0x402000", ADD, []Operand{Mem{Disp: 0x402000, Size: 32}, Imm(1)}},
+	{"sub %r10, %rsp", SUB, []Operand{RSP, R10}},
+	{"sub $0x10, %rsp", SUB, []Operand{RSP, Imm(0x10)}},
+	{"sub $0x200, %rax", SUB, []Operand{RAX, Imm(0x200)}},
+	{"cmp %esi, %edi", CMP, []Operand{EDI, ESI}},
+	{"cmp $0, %r15", CMP, []Operand{R15, Imm(0)}},
+	{"cmpq $0x1000, 8(%rbp)", CMP, []Operand{Mem{Base: RBP, Disp: 8, Size: 64}, Imm(0x1000)}},
+	{"cmpb $0, (%rax)", CMP, []Operand{Mem{Base: RAX, Size: 8}, Imm(0)}},
+	{"and $0xf, %edx", AND, []Operand{EDX, Imm(0xf)}},
+	{"and %r8, %r9", AND, []Operand{R9, R8}},
+	{"or $0x100, %r12", OR, []Operand{R12, Imm(0x100)}},
+	{"or %al, %cl", OR, []Operand{CL, AL}},
+	{"xor %eax, %eax", XOR, []Operand{EAX, EAX}},
+	{"xor %r13, %r14", XOR, []Operand{R14, R13}},
+	{"xor (%rcx,%rdx,1), %eax", XOR, []Operand{EAX, Mem{Base: RCX, Index: RDX, Scale: 1}}},
+
+	// lea
+	{"lea 8(%rax), %rbx", LEA, []Operand{RBX, Mem{Base: RAX, Disp: 8}}},
+	{"lea (%rax,%rax,2), %ecx", LEA, []Operand{ECX, Mem{Base: RAX, Index: RAX, Scale: 2}}},
+	{"lea 0x10(%rip), %r8", LEA, []Operand{R8, Mem{RIP: true, Disp: 0x10}}},
+	{"lea -0x1000(%r13,%r14,8), %r15", LEA, []Operand{R15, Mem{Base: R13, Index: R14, Scale: 8, Disp: -0x1000}}},
+
+	// stack
+	{"push %rax", PUSH, []Operand{RAX}},
+	{"push %r12", PUSH, []Operand{R12}},
+	{"push $1", PUSH, []Operand{Imm(1)}},
+	{"push $0x1000", PUSH, []Operand{Imm(0x1000)}},
+	{"pushq 8(%rsp)", PUSH, []Operand{Mem{Base: RSP, Disp: 8}}},
+	{"pop %rbp", POP, []Operand{RBP}},
+	{"pop %r15", POP, []Operand{R15}},
+	{"popq (%rax)", POP, []Operand{Mem{Base: RAX}}},
+
+	// control flow
+	{"{disp32} call .+0x105", CALL, []Operand{Rel(0x100)}},
+	{"call *%rax", CALL, []Operand{RAX}},
+	{"call *%r11", CALL, []Operand{R11}},
+	{"call *0x10(%rbx)", CALL, []Operand{Mem{Base: RBX, Disp: 0x10}}},
+	{"ret", RET, nil},
+	{"{disp32} jmp .+0x205", JMP, []Operand{Rel(0x200)}},
+	{"{disp32} jmp .-0x10", JMP, []Operand{Rel(-0x15)}},
+	{"jmp .+0x12", JMP, []Operand{Rel8(0x10)}},
+	{"jmp .", JMP, []Operand{Rel8(-2)}},
+	{"jmp *%rcx", JMP, []Operand{RCX}},
+	{"jmp *(%rax,%rdi,8)", JMP, []Operand{Mem{Base: RAX, Index: RDI, Scale: 8}}},
+	{"{disp32} je .+0x106", JE, []Operand{Rel(0x100)}},
+	{"{disp32} jne .-0x20", JNE, []Operand{Rel(-0x26)}},
+	{"{disp32} jl .+6", JL, []Operand{Rel(0)}},
+	{"{disp32} jg .+6", JG, []Operand{Rel(0)}},
+	{"{disp32} jb .+6", JB, []Operand{Rel(0)}},
+	{"{disp32} jae .+6", JAE, []Operand{Rel(0)}},
+	{"jle .+0x10", JLE, []Operand{Rel8(0xe)}},
+	{"jo .-0x7e", JO, []Operand{Rel8(-0x80)}},
+
+	// multiplication and division
+	{"imul %rbx", IMUL, []Operand{RBX}},
+	{"imull (%rcx)", IMUL, []Operand{Mem{Base: RCX, Size: 32}}},
+	{"imul %rcx, %rax", IMUL, []Operand{RAX, RCX}},
+	{"imul %r8d, %r9d", IMUL, []Operand{R9D, R8D}},
+	{"imul 8(%rsp), %r10", IMUL, []Operand{R10, Mem{Base: RSP, Disp: 8}}},
+	{"imul $10, %rax, %rax", IMUL, []Operand{RAX, Imm(10)}},
+	{"imul $1000, %rbx, %rcx", IMUL, []Operand{RCX, RBX, Imm(1000)}},
+	{"imul $3, (%rdi), %edx", IMUL, []Operand{EDX, Mem{Base: RDI}, Imm(3)}},
+	{"idiv %rcx", IDIV, []Operand{RCX}},
+	{"idiv %r10d", IDIV, []Operand{R10D}},
+	{"idivq 0x10(%rbp)", IDIV, []Operand{Mem{Base: RBP, Disp: 0x10, Size: 64}}},
+	{"cqo", CQO, nil},
+	{"cltd", CDQ, nil},
+
+	// shifts
+	{"shl $1, %rax", SHL, []Operand{RAX, Imm(1)}},
+	{"shl $4, %r9", SHL, []Operand{R9, Imm(4)}},
+	{"shl %cl, %rdx", SHL, []Operand{RDX, CL}},
+	{"shr $3, %eax", SHR, []Operand{EAX, Imm(3)}},
+	{"shr %cl, %r11d", SHR, []Operand{R11D, CL}},
+	{"shrb $2, (%rsi)", SHR, []Operand{Mem{Base: RSI, Size: 8}, Imm(2)}},
+	{"sar $63, %rdi", SAR, []Operand{RDI, Imm(63)}},
+	{"sar $1, %bpl", SAR, []Operand{BPL, Imm(1)}},
+
+	{"syscall", SYSCALL, nil},
+	{"nop", NOP, nil},
+
+	// unary
+	{"inc %rdi", INC, []Operand{RDI}},
+	{"incq -8(%rbp)", INC, []Operand{Mem{Base: RBP, Disp: -8, Size: 64}}},
+	{"dec %r9d", DEC, []Operand{R9D}},
+	{"decb (%rax)", DEC, []Operand{Mem{Base: RAX, Size: 8}}},
+	{"neg %rax", NEG, []Operand{RAX}},
+	{"not %r12", NOT, []Operand{R12}},
+	{"mul %r8", MUL, []Operand{R8}},
+	{"div %ecx", DIV, []Operand{ECX}},
+	{"test %rax, %rax", TEST, []Operand{RAX, RAX}},
+	{"test %r8b, %dil", TEST, []Operand{DIL, R8B}},
+	{"test $1, %eax", TEST, []Operand{EAX, Imm(1)}},
+	{"test $1, %al", TEST, []Operand{AL, Imm(1)}},
+	{"test $0x10, %rbx", TEST, []Operand{RBX, Imm(0x10)}},
+	{"testl $0x100, (%rdi)", TEST, []Operand{Mem{Base: RDI, Size: 32}, Imm(0x100)}},
+
+	// stack frame
+	{"enter $32, $0", ENTER, []Operand{Imm(32), Imm(0)}},
+	{"enter $0x1000, $1", ENTER, []Operand{Imm(0x1000), Imm(1)}},
+	{"leave", LEAVE, nil},
+
+	// extension
+	{"movzbl %al, %eax", MOVZX, []Operand{EAX, AL}},
+	{"movzbq (%rdi), %rsi", MOVZX, []Operand{RSI, Mem{Base: RDI, Size: 8}}},
+	{"movzwl %r8w, %r9d", MOVZX, []Operand{R9D, R8W}},
+	{"movzbw %sil, %ax", MOVZX, []Operand{AX, SIL}},
+	{"movsbl -1(%rbp), %eax", MOVSX, []Operand{EAX, Mem{Base: RBP, Disp: -1, Size: 8}}},
+	{"movswq %cx, %rdx", MOVSX, []Operand{RDX, CX}},
+	{"movslq %eax, %rdx", MOVSXD, []Operand{RDX, EAX}},
+	{"movslq 8(%rsp), %r12", MOVSXD, []Operand{R12, Mem{Base: RSP, Disp: 8, Size: 32}}},
+	{"cltq", CDQE, nil},
+
+	// conditions
+	{"sete %al", SETE, []Operand{AL}},
+	{"setg %r9b", SETG, []Operand{R9B}},
+	{"setb (%rax)", SETB, []Operand{Mem{Base: RAX, Size: 8}}},
+	{"cmovne %rcx, %rax", CMOVNE, []Operand{RAX, RCX}},
+	{"cmovl 8(%rbp), %r10d", CMOVL, []Operand{R10D, Mem{Base: RBP, Disp: 8}}},
+
+	// exchange
+	{"xchg %rbx, %rax", XCHG, []Operand{RAX, RBX}},
+	{"xchg %eax, %r12d", XCHG, []Operand{R12D, EAX}},
+	{"xchg %eax, %eax", XCHG, []Operand{EAX, EAX}},
+	{"xchg %ax, %ax", XCHG, []Operand{AX, AX}},
+	{"xchg %rcx, %rdx", XCHG, []Operand{RDX, RCX}},
+	{"xchg %bl, %cl", XCHG, []Operand{CL, BL}},
+	{"xchg %rsi, (%rdi)", XCHG, []Operand{Mem{Base: RDI}, RSI}},
+
+	// segments
+	{"mov %fs:0x10, %eax", MOV, []Operand{EAX, Mem{Disp: 0x10, Segment: FS}}},
+	{"movq $0, %fs:-8(%rax)", MOV, []Operand{Mem{Base: RAX, Disp: -8, Size: 64, Segment: FS}, Imm(0)}},
+	{"mov %gs:(%rbx), %rcx", MOV, []Operand{RCX, Mem{Base: RBX, Segment: GS}}},
+
+	// scalar SSE2
+	{"movsd %xmm1, %xmm0", MOVSD, []Operand{XMM0, XMM1}},
+	{"movsd %xmm15, %xmm8", MOVSD, []Operand{XMM8, XMM15}},
+	{"movsd 8(%rbp), %xmm2", MOVSD, []Operand{XMM2, Mem{Base: RBP, Disp: 8}}},
+	{"movsd %xmm9, -0x10(%rsp)", MOVSD, []Operand{Mem{Base: RSP, Disp: -0x10, Size: 64}, XMM9}},
+	{"movsd 0x100(%rip), %xmm3", MOVSD, []Operand{XMM3, Mem{RIP: true, Disp: 0x100}}},
+	{"movss (%rax), %xmm4", MOVSS, []Operand{XMM4, Mem{Base: RAX, Size: 32}}},
+	{"movss %xmm12, (%r12)", MOVSS, []Operand{Mem{Base: R12}, XMM12}},
+	{"movss %xmm2, %xmm1", MOVSS, []Operand{XMM1, XMM2}},
+	{"addsd %xmm1, %xmm0", ADDSD, []Operand{XMM0, XMM1}},
+	{"addsd (%rax,%rcx,8), %xmm10", ADDSD, []Operand{XMM10, Mem{Base: RAX, Index: RCX, Scale: 8}}},
+	{"subsd %xmm11, %xmm7", SUBSD, []Operand{XMM7, XMM11}},
+	{"mulsd -8(%rbp), %xmm0", MULSD, []Operand{XMM0, Mem{Base: RBP, Disp: -8, Size: 64}}},
+	{"divsd %xmm3, %xmm2", DIVSD, []Operand{XMM2, XMM3}},
+	{"cvtsi2sd %rax, %xmm0", CVTSI2SD, []Operand{XMM0, RAX}},
+	{"cvtsi2sd %r9d, %xmm13", CVTSI2SD, []Operand{XMM13, R9D}},
+	{"cvtsi2sdq 8(%rsp), %xmm1", CVTSI2SD, []Operand{XMM1, Mem{Base: RSP, Disp: 8, Size: 64}}},
+	{"cvtsi2sdl (%rdi), %xmm1", CVTSI2SD, []Operand{XMM1, Mem{Base: RDI, Size: 32}}},
+	{"cvttsd2si %xmm0, %rax", CVTTSD2SI, []Operand{RAX, XMM0}},
+	{"cvttsd2si %xmm14, %ecx", CVTTSD2SI, []Operand{ECX, XMM14}},
+	{"cvttsd2si (%rsi), %r8", CVTTSD2SI, []Operand{R8, Mem{Base: RSI}}},
+	{"comisd %xmm1, %xmm0", COMISD, []Operand{XMM0, XMM1}},
+	{"comisd 0x10(%rip), %xmm9", COMISD, []Operand{XMM9, Mem{RIP: true, Disp: 0x10}}},
+	{"movsd %fs:8, %xmm0", MOVSD, []Operand{XMM0, Mem{Disp: 8, Segment: FS}}},
+
+	// moves of xmm registers and packed integers
+	{"movaps %xmm1, %xmm0", MOVAPS, []Operand{XMM0, XMM1}},
+	{"movaps %xmm0, (%rsp)", MOVAPS, []Operand{Mem{Base: RSP}, XMM0}},
+	{"movaps 0x10(%rsp), %xmm9", MOVAPS, []Operand{XMM9, Mem{Base: RSP, Disp: 0x10, Size: 128}}},
+	{"movups %xmm0, 8(%rsp)", MOVUPS, []Operand{Mem{Base: RSP, Disp: 8, Size: 128}, XMM0}},
+	{"movups (%rax), %xmm15", MOVUPS, []Operand{XMM15, Mem{Base: RAX}}},
+	{"movups %xmm3, %xmm2", MOVUPS, []Operand{XMM2, XMM3}},
+	{"movdqa 0x1d10(%rip), %xmm0", MOVDQA, []Operand{XMM0, Mem{RIP: true, Disp: 0x1d10}}},
+	{"movdqa %xmm1, (%rdi,%rcx,1)", MOVDQA, []Operand{Mem{Base: RDI, Index: RCX, Scale: 1}, XMM1}},
+	{"movdqa %xmm10, %xmm4", MOVDQA, []Operand{XMM4, XMM10}},
+	{"movq %rax, %xmm0", MOVQ, []Operand{XMM0, RAX}},
+	{"movq %xmm1, %r9", MOVQ, []Operand{R9, XMM1}},
+	{"movq %xmm1, %xmm0", MOVQ, []Operand{XMM0, XMM1}},
+	{"movq (%rax), %xmm8", MOVQ, []Operand{XMM8, Mem{Base: RAX, Size: 64}}},
+	{"movq %xmm2, -8(%rbp)", MOVQ, []Operand{Mem{Base: RBP, Disp: -8}, XMM2}},
+	{"movd %eax, %xmm1", MOVD, []Operand{XMM1, EAX}},
+	{"movd %xmm11, %r8d", MOVD, []Operand{R8D, XMM11}},
+	{"movd (%rsi), %xmm0", MOVD, []Operand{XMM0, Mem{Base: RSI}}},
+	{"pxor %xmm0, %xmm0", PXOR, []Operand{XMM0, XMM0}},
+	{"pxor 0x20(%rip), %xmm12", PXOR, []Operand{XMM12, Mem{RIP: true, Disp: 0x20}}},
+	{"punpcklqdq %xmm0, %xmm1", PUNPCKLQDQ, []Operand{XMM1, XMM0}},
+	{"punpcklqdq (%rdx), %xmm3", PUNPCKLQDQ, []Operand{XMM3, Mem{Base: RDX, Size: 128}}},
+
+	// bit test
+	{"bt %rax, %rdi", BT, []Operand{RDI, RAX}},
+	{"bt %ecx, %r8d", BT, []Operand{R8D, ECX}},
+	{"bt %dx, %ax", BT, []Operand{AX, DX}},
+	{"bt %r10, (%rbx)", BT, []Operand{Mem{Base: RBX}, R10}},
+	{"bt $63, %rax", BT, []Operand{RAX, Imm(63)}},
+	{"btl $5, 8(%rsp)", BT, []Operand{Mem{Base: RSP, Disp: 8, Size: 32}, Imm(5)}},
+
+	// padding and other
+	{"nopw 0(%rax,%rax,1)", NOP, []Operand{Mem{Base: RAX, Index: RAX, Scale: 1, Size: 16}}},
+	{"nopl 0x0(%rax)", NOP, []Operand{Mem{Base: RAX, Size: 32}}},
+	{"movsb", MOVSB, nil},
+	{"rep movsb", REP_MOVSB, nil},
+	{"hlt", HLT, nil},
+	{"int3", INT3, nil},
+	{"ud2", UD2, nil},
+	{"pause", PAUSE, nil},
+	{"endbr64", ENDBR64, nil},
+}
+
+// assemble assembles each instruction with the GNU assembler and returns the
+// machine code of each of them. Every instruction is placed into its own
+// section, so the code can be read from the object file.
+func assemble(t *testing.T, instructions []string) [][]byte {
+	t.Helper()
+	asPath, err := exec.LookPath("as")
+	if err != nil {
+		t.Skip("GNU assembler not found")
+	}
+
+	source := &strings.Builder{}
+	for i, instruction := range instructions {
+		fmt.Fprintf(source, ".section .text.%d,\"ax\"\n%s\n", i, instruction)
+	}
+
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "test.s")
+	objectPath := filepath.Join(dir, "test.o")
+	err = os.WriteFile(sourcePath, []byte(source.String()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(asPath, "--64", "-o", objectPath, sourcePath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("as failed: %s: %s", err, out)
+	}
+
+	f, err := elf.Open(objectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	codes := make([][]byte, len(instructions))
+	for i := range instructions {
+		s := f.Section(fmt.Sprintf(".text.%d", i))
+		if s == nil {
+			t.Fatalf("section of instruction %d not found", i)
+		}
+		codes[i], err = s.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return codes
+}
+
+func TestEncode(t *testing.T) {
+	instructions := []string{}
+	for _, test := range encodeTests {
+		instructions = append(instructions, test.att)
+	}
+	expected := assemble(t, instructions)
+
+	for i, test := range encodeTests {
+		code, err := Encode(test.op, test.operands...)
+		if err != nil {
+			t.Errorf("%s: %s", test.att, err)
+			continue
+		}
+		if !bytes.Equal(code, expected[i]) {
+			t.Errorf("%s: expected % x got % x", test.att, expected[i], code)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		op       Op
+		operands []Operand
+		err      string
+	}{
+		{MOV, []Operand{RAX, EBX}, "mov: operand size mismatch: 64 and 32 bit"},
+		{MOV, []Operand{Mem{Base: RAX}, Imm(1)}, "mov: operand size missing"},
+		{MOV, []Operand{Mem{Base: RAX}, Mem{Base: RBX, Size: 64}}, "mov: invalid operands: two memory operands"},
+		{MOV, []Operand{Mem{Base: RAX, Size: 64}, Imm(1 << 40)}, "mov: immediate 1099511627776 out of range"},
+		{ADD, []Operand{EAX, Imm(1 << 32)}, "add: immediate 4294967296 out of range for 32 bit operand"},
+		{ADD, []Operand{RAX}, "add: expected 2 operands, got 1"},
+		{MOV, []Operand{RAX, Mem{Base: EAX}}, "mov: address register eax is not a 64 bit register"},
+		{MOV, []Operand{RAX, Mem{Base: RAX, Index: RSP}}, "mov: rsp can't be used as index register"},
+		{MOV, []Operand{RAX, Mem{Base: RAX, Index: RCX, Scale: 3}}, "mov: invalid scale 3"},
+		{PUSH, []Operand{EAX}, "push: only 64 bit registers are supported"},
+		{SHL, []Operand{RAX, RCX}, "shl: shift count has to be an immediate or cl"},
+		{CALL, []Operand{Rel8(0)}, "call: call has no 8 bit displacement"},
+		{INC, []Operand{Mem{Base: RAX}}, "inc: operand size missing"},
+		{TEST, []Operand{RAX, EAX}, "test: operand size mismatch: 64 and 32 bit"},
+		{ENTER, []Operand{Imm(1 << 16), Imm(0)}, "enter: expected a 16 bit and an 8 bit immediate"},
+		{MOV, []Operand{RAX, XMM0}, "mov: invalid operand xmm0"},
+		{LEA, []Operand{XMM0, Mem{Base: RAX}}, "lea: xmm registers are only supported by SSE instructions"},
+		{MOVSD, []Operand{RAX, XMM0}, "movsd: expected an xmm register or memory operand"},
+		{MOVSD, []Operand{Mem{Base: RAX}, Mem{Base: RBX}}, "movsd: expected an xmm register"},
+		{ADDSD, []Operand{XMM0, Mem{Base: RAX, Size: 32}}, "addsd: expected a 64 bit memory operand"},
+		{ADDSD, []Operand{Mem{Base: RAX}, XMM0}, "addsd: expected an xmm register"},
+		{CVTSI2SD, []Operand{XMM0, Mem{Base: RAX}}, "cvtsi2sd: operand size missing"},
+		{CVTSI2SD, []Operand{XMM0, AX}, "cvtsi2sd: source has to be a 32 or 64 bit integer"},
+		{CVTSI2SD, []Operand{XMM0, XMM1}, "cvtsi2sd: invalid operand xmm1"},
+		{MOVAPS, []Operand{XMM0, Mem{Base: RAX, Size: 64}}, "movaps: expected a 128 bit memory operand"},
+		{MOVQ, []Operand{RAX, RBX}, "movq: expected an xmm register"},
+		{MOVQ, []Operand{XMM0, EAX}, "movq: expected a 64 bit register or memory operand"},
+		{MOVD, []Operand{XMM0, XMM1}, "movd: expected a 32 bit register or memory operand"},
+		{BT, []Operand{AL, Imm(1)}, "bt: expected a 16, 32 or 64 bit operand"},
+		{BT, []Operand{RAX, Imm(64 << 2)}, "bt: bit offset 256 out of range"},
+		{BT, []Operand{RAX, XMM0}, "bt: bit offset has to be an immediate or a 16, 32 or 64 bit register"},
+		{CVTTSD2SI, []Operand{XMM0, XMM1}, "cvttsd2si: destination has to be a 32 or 64 bit register"},
+	}
+	for _, test := range tests {
+		_, err := Encode(test.op, test.operands...)
+		if err == nil {
+			t.Errorf("%s %v: expected error %q", test.op, test.operands, test.err)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%s %v: expected error %q got %q", test.op, test.operands, test.err, err)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, reg := range []Register{RAX, R8D, SI, DIL, R15B, XMM0, XMM15} {
+		found, ok := LookupRegister(reg.String())
+		if !ok || found != reg {
+			t.Errorf("%s: got %v %t", reg, found, ok)
+		}
+	}
+	if _, ok := LookupRegister("ah"); ok {
+		t.Errorf("ah: unexpected register")
+	}
+
+	for op := MOV; op <= PUNPCKLQDQ; op++ {
+		found, ok := LookupOp(op.String())
+		if !ok || found != op {
+			t.Errorf("%s: got %v %t", op, found, ok)
+		}
+	}
+	if _, ok := LookupOp("movl"); ok {
+		t.Errorf("movl: unexpected op")
+	}
+}
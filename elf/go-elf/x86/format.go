@@ -0,0 +1,305 @@
+package x86
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SymbolLookup returns the name and the address of the symbol which
+// contains addr or an empty name if there is none.
+type SymbolLookup func(addr uint64) (name string, base uint64)
+
+// ATTSyntax returns the instruction in the AT&T syntax of the GNU tools as
+// printed by objdump. pc is the address of the instruction, which is needed
+// for the targets of jumps and RIP relative operands. symbol is used to
+// annotate these addresses and may be nil.
+func ATTSyntax(inst Inst, pc uint64, symbol SymbolLookup) string {
+	f := formatter{inst: inst, end: pc + uint64(inst.Len), symbol: symbol}
+	mnemonic := inst.Op.String()
+	operands := inst.Operands
+	size := f.operandSize()
+
+	switch inst.Op {
+	case MOV:
+		if f.isMovabs() {
+			mnemonic = "movabs"
+		}
+	case CQO:
+		mnemonic = "cqto"
+	case CDQ:
+		mnemonic = "cltd"
+	case CDQE:
+		mnemonic = "cltq"
+	case MOVZX, MOVSX:
+		mnemonic = mnemonic[:4] + attSuffix(sizeOf(operands[1])) + attSuffix(size)
+	case MOVSXD:
+		mnemonic = "movslq"
+	case CVTSI2SD:
+		// the size of the integer is only known from a register
+		if mem, ok := operands[1].(Mem); ok {
+			mnemonic += attSuffix(mem.Size)
+		}
+	case MOVSB:
+		return "movsb  %ds:(%rsi),%es:(%rdi)"
+	case REP_MOVSB:
+		return "rep movsb %ds:(%rsi),%es:(%rdi)"
+	case SHL, SHR, SAR:
+		if operands[1] == Imm(1) {
+			operands = operands[:1]
+		}
+	}
+
+	args := make([]string, len(operands))
+	hasRegister, hasMemory := false, false
+	for i, operand := range operands {
+		var arg string
+		switch operand := operand.(type) {
+		case Register:
+			arg = "%" + operand.String()
+			if operand != CL || i == 0 {
+				hasRegister = true
+			}
+		case Imm:
+			arg = "$" + f.immediate(operand)
+		case Mem:
+			hasMemory = true
+			arg = f.memATT(operand)
+		case Rel:
+			arg = f.target(int64(operand))
+		case Rel8:
+			arg = f.target(int64(operand))
+		}
+		if (inst.Op == CALL || inst.Op == JMP) && !isRelative(operand) {
+			arg = "*" + arg
+		}
+		// AT&T has the destination last, except for enter
+		if inst.Op == ENTER {
+			args[i] = arg
+		} else {
+			args[len(operands)-1-i] = arg
+		}
+	}
+	if hasMemory && !hasRegister && needsSuffix(inst.Op) {
+		mnemonic += attSuffix(size)
+	}
+	return f.join(mnemonic, args)
+}
+
+// IntelSyntax returns the instruction in the Intel syntax as printed by
+// objdump -M intel. See ATTSyntax for pc and symbol.
+func IntelSyntax(inst Inst, pc uint64, symbol SymbolLookup) string {
+	f := formatter{inst: inst, end: pc + uint64(inst.Len), symbol: symbol}
+	mnemonic := inst.Op.String()
+
+	switch inst.Op {
+	case MOV:
+		if f.isMovabs() {
+			mnemonic = "movabs"
+		}
+	case MOVSB:
+		return "movs   BYTE PTR es:[rdi],BYTE PTR ds:[rsi]"
+	case REP_MOVSB:
+		return "rep movs BYTE PTR es:[rdi],BYTE PTR ds:[rsi]"
+	}
+
+	args := make([]string, len(inst.Operands))
+	for i, operand := range inst.Operands {
+		switch operand := operand.(type) {
+		case Register:
+			args[i] = operand.String()
+		case Imm:
+			if i == 1 && operand == 1 && (inst.Op == SHL || inst.Op == SHR || inst.Op == SAR) {
+				args[i] = "1"
+				continue
+			}
+			args[i] = f.immediate(operand)
+		case Mem:
+			args[i] = f.memIntel(operand)
+		case Rel:
+			args[i] = f.target(int64(operand))
+		case Rel8:
+			args[i] = f.target(int64(operand))
+		}
+	}
+	return f.join(mnemonic, args)
+}
+
+// formatter holds the instruction which is formatted.
+type formatter struct {
+	inst    Inst
+	end     uint64 // address of the next instruction
+	symbol  SymbolLookup
+	comment string
+}
+
+func (f *formatter) join(mnemonic string, args []string) string {
+	if len(args) == 0 {
+		return mnemonic
+	}
+	return fmt.Sprintf("%-6s %s", mnemonic, strings.Join(args, ",")) + f.comment
+}
+
+// operandSize returns the size of the first operand, which is the size of
+// the operation for most instructions.
+func (f *formatter) operandSize() int {
+	if f.inst.Op == PUSH {
+		return 64
+	}
+	if len(f.inst.Operands) == 0 {
+		return 0
+	}
+	return sizeOf(f.inst.Operands[0])
+}
+
+// isMovabs reports whether the instruction is a mov with a 64 bit
+// immediate.
+func (f *formatter) isMovabs() bool {
+	imm, ok := f.inst.Operands[1].(Imm)
+	return ok && f.operandSize() == 64 && !fitsInt32(int64(imm))
+}
+
+// immediate returns the immediate in hex, negative values are shown in two's
+// complement of the operand size.
+func (f *formatter) immediate(imm Imm) string {
+	value := uint64(imm)
+	if size := f.operandSize(); size > 0 && size < 64 {
+		value &= 1<<size - 1
+	}
+	return fmt.Sprintf("0x%x", value)
+}
+
+// target returns the target address of a jump or call with the symbol if
+// there is one.
+func (f *formatter) target(displacement int64) string {
+	return f.address(f.end + uint64(displacement))
+}
+
+func (f *formatter) address(addr uint64) string {
+	if f.symbol != nil {
+		name, base := f.symbol(addr)
+		switch {
+		case name != "" && addr == base:
+			return fmt.Sprintf("%x <%s>", addr, name)
+		case name != "":
+			return fmt.Sprintf("%x <%s+0x%x>", addr, name, addr-base)
+		}
+	}
+	return fmt.Sprintf("0x%x", addr)
+}
+
+// displacement returns the displacement as signed hex number.
+func displacement(disp int32) string {
+	if disp < 0 {
+		return fmt.Sprintf("-0x%x", -int64(disp))
+	}
+	return fmt.Sprintf("0x%x", disp)
+}
+
+// hasDisplacement reports whether the encoding of the memory operand
+// contains a displacement, which objdump prints even if it is zero.
+func hasDisplacement(m Mem) bool {
+	return m.Disp != 0 || m.Base.size == 0 || m.Base.num&7 == 5
+}
+
+func (f *formatter) memATT(m Mem) string {
+	s := ""
+	if m.Segment != 0 {
+		s = "%" + m.Segment.String() + ":"
+	}
+	switch {
+	case m.RIP:
+		f.comment = "        # " + f.address(f.end+uint64(int64(m.Disp)))
+		return s + displacement(m.Disp) + "(%rip)"
+	case m.Base.size == 0 && m.Index.size == 0:
+		return s + fmt.Sprintf("0x%x", uint64(int64(m.Disp)))
+	}
+	if hasDisplacement(m) {
+		s += displacement(m.Disp)
+	}
+	s += "("
+	if m.Base.size != 0 {
+		s += "%" + m.Base.String()
+	}
+	if m.Index.size != 0 {
+		s += fmt.Sprintf(",%%%s,%d", m.Index, m.Scale)
+	}
+	return s + ")"
+}
+
+var ptrNames = map[int]string{
+	8:   "BYTE PTR ",
+	16:  "WORD PTR ",
+	32:  "DWORD PTR ",
+	64:  "QWORD PTR ",
+	128: "XMMWORD PTR ",
+}
+
+func (f *formatter) memIntel(m Mem) string {
+	s := ptrNames[m.Size]
+	if m.Segment != 0 {
+		s += m.Segment.String() + ":"
+	}
+	switch {
+	case m.RIP:
+		f.comment = "        # " + f.address(f.end+uint64(int64(m.Disp)))
+		// objdump shows negative RIP displacements in two's complement
+		return s + fmt.Sprintf("[rip+0x%x]", uint64(int64(m.Disp)))
+	case m.Base.size == 0 && m.Index.size == 0:
+		if m.Segment == 0 {
+			s += "ds:"
+		}
+		return s + fmt.Sprintf("0x%x", uint64(int64(m.Disp)))
+	}
+	parts := []string{}
+	if m.Base.size != 0 {
+		parts = append(parts, m.Base.String())
+	}
+	if m.Index.size != 0 {
+		parts = append(parts, fmt.Sprintf("%s*%d", m.Index, m.Scale))
+	}
+	s += "[" + strings.Join(parts, "+")
+	if hasDisplacement(m) {
+		s += signed(m.Disp)
+	}
+	return s + "]"
+}
+
+// signed returns the displacement with its sign for Intel syntax.
+func signed(disp int32) string {
+	if disp < 0 {
+		return displacement(disp)
+	}
+	return "+" + displacement(disp)
+}
+
+func sizeOf(operand Operand) int {
+	switch operand := operand.(type) {
+	case Register:
+		return operand.size
+	case Mem:
+		return operand.Size
+	}
+	return 0
+}
+
+func isRelative(operand Operand) bool {
+	switch operand.(type) {
+	case Rel, Rel8:
+		return true
+	}
+	return false
+}
+
+// needsSuffix reports whether the AT&T mnemonic gets a size suffix if there
+// is no register operand which determines the operand size.
+func needsSuffix(op Op) bool {
+	switch op {
+	case MOV, ADD, OR, AND, SUB, XOR, CMP, TEST, BT, INC, DEC, NOT, NEG, MUL, DIV, IDIV, IMUL, SHL, SHR, SAR, NOP:
+		return true
+	}
+	return false
+}
+
+func attSuffix(size int) string {
+	return map[int]string{8: "b", 16: "w", 32: "l", 64: "q"}[size]
+}
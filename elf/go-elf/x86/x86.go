@@ -0,0 +1,536 @@
+// Package x86 encodes x86-64 instructions.
+//
+// An instruction consists of up to four parts:
+//
+//	prefixes | REX | opcode | ModRM | SIB | displacement | immediate
+//
+// The REX prefix extends the register numbers to 4 bits (R for ModRM.reg, X
+// for SIB.index, B for ModRM.rm or SIB.base) and selects 64-bit operands (W).
+// The ModRM byte selects a register or a memory operand, which may need a
+// SIB byte (scale, index, base) and a displacement.
+package x86
+
+import "fmt"
+
+// Register is a general purpose register of a specific size or an SSE
+// register, whose size is 128 bits.
+type Register struct {
+	num  byte // number used in the encoding, 0-15
+	size int  // in bits, 0 for no register
+}
+
+var (
+	RAX = Register{0, 64}
+	RCX = Register{1, 64}
+	RDX = Register{2, 64}
+	RBX = Register{3, 64}
+	RSP = Register{4, 64}
+	RBP = Register{5, 64}
+	RSI = Register{6, 64}
+	RDI = Register{7, 64}
+	R8  = Register{8, 64}
+	R9  = Register{9, 64}
+	R10 = Register{10, 64}
+	R11 = Register{11, 64}
+	R12 = Register{12, 64}
+	R13 = Register{13, 64}
+	R14 = Register{14, 64}
+	R15 = Register{15, 64}
+
+	EAX  = Register{0, 32}
+	ECX  = Register{1, 32}
+	EDX  = Register{2, 32}
+	EBX  = Register{3, 32}
+	ESP  = Register{4, 32}
+	EBP  = Register{5, 32}
+	ESI  = Register{6, 32}
+	EDI  = Register{7, 32}
+	R8D  = Register{8, 32}
+	R9D  = Register{9, 32}
+	R10D = Register{10, 32}
+	R11D = Register{11, 32}
+	R12D = Register{12, 32}
+	R13D = Register{13, 32}
+	R14D = Register{14, 32}
+	R15D = Register{15, 32}
+
+	AX   = Register{0, 16}
+	CX   = Register{1, 16}
+	DX   = Register{2, 16}
+	BX   = Register{3, 16}
+	SP   = Register{4, 16}
+	BP   = Register{5, 16}
+	SI   = Register{6, 16}
+	DI   = Register{7, 16}
+	R8W  = Register{8, 16}
+	R9W  = Register{9, 16}
+	R10W = Register{10, 16}
+	R11W = Register{11, 16}
+	R12W = Register{12, 16}
+	R13W = Register{13, 16}
+	R14W = Register{14, 16}
+	R15W = Register{15, 16}
+
+	// SPL, BPL, SIL and DIL require a REX prefix, without it the numbers
+	// 4-7 select AH, CH, DH and BH, which are not supported.
+	AL   = Register{0, 8}
+	CL   = Register{1, 8}
+	DL   = Register{2, 8}
+	BL   = Register{3, 8}
+	SPL  = Register{4, 8}
+	BPL  = Register{5, 8}
+	SIL  = Register{6, 8}
+	DIL  = Register{7, 8}
+	R8B  = Register{8, 8}
+	R9B  = Register{9, 8}
+	R10B = Register{10, 8}
+	R11B = Register{11, 8}
+	R12B = Register{12, 8}
+	R13B = Register{13, 8}
+	R14B = Register{14, 8}
+	R15B = Register{15, 8}
+
+	// The SSE registers are only used by the SSE instructions, which
+	// access the lower 32 or 64 bits for scalar floating point values.
+	XMM0  = Register{0, 128}
+	XMM1  = Register{1, 128}
+	XMM2  = Register{2, 128}
+	XMM3  = Register{3, 128}
+	XMM4  = Register{4, 128}
+	XMM5  = Register{5, 128}
+	XMM6  = Register{6, 128}
+	XMM7  = Register{7, 128}
+	XMM8  = Register{8, 128}
+	XMM9  = Register{9, 128}
+	XMM10 = Register{10, 128}
+	XMM11 = Register{11, 128}
+	XMM12 = Register{12, 128}
+	XMM13 = Register{13, 128}
+	XMM14 = Register{14, 128}
+	XMM15 = Register{15, 128}
+)
+
+// Reg returns the register with the number num (0-15) and size in bits, 128
+// for the SSE registers.
+func Reg(num int, size int) Register {
+	if num < 0 || num > 15 {
+		panic(fmt.Sprintf("invalid register number %d", num))
+	}
+	switch size {
+	case 8, 16, 32, 64, 128:
+	default:
+		panic(fmt.Sprintf("invalid register size %d", size))
+	}
+	return Register{byte(num), size}
+}
+
+// Num returns the number of the register used in the encoding.
+func (r Register) Num() int {
+	return int(r.num)
+}
+
+// Size returns the size of the register in bits.
+func (r Register) Size() int {
+	return r.size
+}
+
+var registerNames = map[int][16]string{
+	64:  {"rax", "rcx", "rdx", "rbx", "rsp", "rbp", "rsi", "rdi", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"},
+	32:  {"eax", "ecx", "edx", "ebx", "esp", "ebp", "esi", "edi", "r8d", "r9d", "r10d", "r11d", "r12d", "r13d", "r14d", "r15d"},
+	16:  {"ax", "cx", "dx", "bx", "sp", "bp", "si", "di", "r8w", "r9w", "r10w", "r11w", "r12w", "r13w", "r14w", "r15w"},
+	8:   {"al", "cl", "dl", "bl", "spl", "bpl", "sil", "dil", "r8b", "r9b", "r10b", "r11b", "r12b", "r13b", "r14b", "r15b"},
+	128: {"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5", "xmm6", "xmm7", "xmm8", "xmm9", "xmm10", "xmm11", "xmm12", "xmm13", "xmm14", "xmm15"},
+}
+
+func (r Register) String() string {
+	names, ok := registerNames[r.size]
+	if !ok {
+		return "none"
+	}
+	return names[r.num]
+}
+
+// IsXMM reports whether r is an SSE register.
+func (r Register) IsXMM() bool {
+	return r.size == 128
+}
+
+// LookupRegister returns the register with the name, e.g. "rax", "r8d" or
+// "xmm1".
+func LookupRegister(name string) (Register, bool) {
+	for size, names := range registerNames {
+		for num, n := range names {
+			if n == name {
+				return Register{byte(num), size}, true
+			}
+		}
+	}
+	return Register{}, false
+}
+
+// Operand is a Register, Imm, Mem, Rel or Rel8.
+type Operand interface {
+	operand()
+}
+
+// Imm is an immediate value.
+type Imm int64
+
+// Mem is a memory operand which addresses Base + Index*Scale + Disp. Base
+// and Index are optional. If RIP is set, Disp is relative to the end of the
+// instruction and Base and Index must not be set.
+type Mem struct {
+	Base    Register
+	Index   Register
+	Scale   int // 1, 2, 4 or 8
+	Disp    int32
+	RIP     bool
+	Segment Segment // FS or GS for thread local storage, 0 otherwise
+
+	// Size of the operand in bits. It is only needed if it can't be
+	// derived from a register operand, e.g. for mov $1, (%rax).
+	Size int
+}
+
+// Segment is a segment register which is selected with a prefix. In 64-bit
+// mode only the base addresses of FS and GS are used.
+type Segment byte
+
+const (
+	FS Segment = 0x64
+	GS Segment = 0x65
+)
+
+func (s Segment) String() string {
+	switch s {
+	case FS:
+		return "fs"
+	case GS:
+		return "gs"
+	default:
+		return fmt.Sprintf("Segment(0x%x)", byte(s))
+	}
+}
+
+// Rel is the target of a jump or call as displacement relative to the end
+// of the instruction, encoded with 32 bits.
+type Rel int32
+
+// Rel8 is like Rel but encoded with 8 bits (short jump).
+type Rel8 int8
+
+func (Register) operand() {}
+func (Imm) operand()      {}
+func (Mem) operand()      {}
+func (Rel) operand()      {}
+func (Rel8) operand()     {}
+
+// Condition is the condition code of a conditional jump (Jcc), which is
+// part of the opcode.
+type Condition byte
+
+const (
+	CondO  Condition = 0x0 // overflow
+	CondNO Condition = 0x1 // not overflow
+	CondB  Condition = 0x2 // below (unsigned <), carry
+	CondAE Condition = 0x3 // above or equal (unsigned >=), not carry
+	CondE  Condition = 0x4 // equal, zero
+	CondNE Condition = 0x5 // not equal, not zero
+	CondBE Condition = 0x6 // below or equal (unsigned <=)
+	CondA  Condition = 0x7 // above (unsigned >)
+	CondS  Condition = 0x8 // sign
+	CondNS Condition = 0x9 // not sign
+	CondP  Condition = 0xa // parity
+	CondNP Condition = 0xb // not parity
+	CondL  Condition = 0xc // less (signed <)
+	CondGE Condition = 0xd // greater or equal (signed >=)
+	CondLE Condition = 0xe // less or equal (signed <=)
+	CondG  Condition = 0xf // greater (signed >)
+)
+
+var conditionNames = [16]string{"o", "no", "b", "ae", "e", "ne", "be", "a", "s", "ns", "p", "np", "l", "ge", "le", "g"}
+
+func (c Condition) String() string {
+	return conditionNames[c&0xf]
+}
+
+// Op is an instruction mnemonic.
+type Op int
+
+const (
+	MOV Op = iota
+	ADD
+	SUB
+	CMP
+	AND
+	OR
+	XOR
+	LEA
+	PUSH
+	POP
+	CALL
+	RET
+	JMP
+	JO // conditional jumps in the order of the condition codes
+	JNO
+	JB
+	JAE
+	JE
+	JNE
+	JBE
+	JA
+	JS
+	JNS
+	JP
+	JNP
+	JL
+	JGE
+	JLE
+	JG
+	IMUL
+	IDIV
+	SHL
+	SHR
+	SAR
+	CQO
+	CDQ
+	SYSCALL
+	NOP
+	INC
+	DEC
+	NEG
+	NOT
+	MUL
+	DIV
+	TEST
+	ENTER
+	LEAVE
+	MOVZX
+	MOVSX
+	MOVSXD
+	CDQE
+	XCHG
+	MOVSB
+	REP_MOVSB // movsb with the rep prefix, copies rcx bytes
+	HLT
+	INT3
+	UD2
+	PAUSE
+	ENDBR64
+	BT
+	SETO // set byte on condition in the order of the condition codes
+	SETNO
+	SETB
+	SETAE
+	SETE
+	SETNE
+	SETBE
+	SETA
+	SETS
+	SETNS
+	SETP
+	SETNP
+	SETL
+	SETGE
+	SETLE
+	SETG
+	CMOVO // conditional move in the order of the condition codes
+	CMOVNO
+	CMOVB
+	CMOVAE
+	CMOVE
+	CMOVNE
+	CMOVBE
+	CMOVA
+	CMOVS
+	CMOVNS
+	CMOVP
+	CMOVNP
+	CMOVL
+	CMOVGE
+	CMOVLE
+	CMOVG
+	MOVSD // scalar SSE2 instructions of double and single precision values
+	MOVSS
+	ADDSD
+	SUBSD
+	MULSD
+	DIVSD
+	CVTSI2SD
+	CVTTSD2SI
+	COMISD
+	MOVAPS // moves of 128 bit xmm registers and packed integer instructions
+	MOVUPS
+	MOVDQA
+	MOVD
+	MOVQ
+	PXOR
+	PUNPCKLQDQ
+)
+
+var opNames = [...]string{
+	MOV:       "mov",
+	ADD:       "add",
+	SUB:       "sub",
+	CMP:       "cmp",
+	AND:       "and",
+	OR:        "or",
+	XOR:       "xor",
+	LEA:       "lea",
+	PUSH:      "push",
+	POP:       "pop",
+	CALL:      "call",
+	RET:       "ret",
+	JMP:       "jmp",
+	JO:        "jo",
+	JNO:       "jno",
+	JB:        "jb",
+	JAE:       "jae",
+	JE:        "je",
+	JNE:       "jne",
+	JBE:       "jbe",
+	JA:        "ja",
+	JS:        "js",
+	JNS:       "jns",
+	JP:        "jp",
+	JNP:       "jnp",
+	JL:        "jl",
+	JGE:       "jge",
+	JLE:       "jle",
+	JG:        "jg",
+	IMUL:      "imul",
+	IDIV:      "idiv",
+	SHL:       "shl",
+	SHR:       "shr",
+	SAR:       "sar",
+	CQO:       "cqo",
+	CDQ:       "cdq",
+	SYSCALL:   "syscall",
+	NOP:       "nop",
+	INC:       "inc",
+	DEC:       "dec",
+	NEG:       "neg",
+	NOT:       "not",
+	MUL:       "mul",
+	DIV:       "div",
+	TEST:      "test",
+	ENTER:     "enter",
+	LEAVE:     "leave",
+	MOVZX:     "movzx",
+	MOVSX:     "movsx",
+	MOVSXD:    "movsxd",
+	CDQE:      "cdqe",
+	XCHG:      "xchg",
+	MOVSB:     "movsb",
+	REP_MOVSB: "rep movsb",
+	HLT:       "hlt",
+	INT3:      "int3",
+	UD2:       "ud2",
+	PAUSE:     "pause",
+	ENDBR64:   "endbr64",
+	BT:        "bt",
+	SETO:      "seto",
+	SETNO:     "setno",
+	SETB:      "setb",
+	SETAE:     "setae",
+	SETE:      "sete",
+	SETNE:     "setne",
+	SETBE:     "setbe",
+	SETA:      "seta",
+	SETS:      "sets",
+	SETNS:     "setns",
+	SETP:      "setp",
+	SETNP:     "setnp",
+	SETL:      "setl",
+	SETGE:     "setge",
+	SETLE:     "setle",
+	SETG:      "setg",
+	CMOVO:     "cmovo",
+	CMOVNO:    "cmovno",
+	CMOVB:     "cmovb",
+	CMOVAE:    "cmovae",
+	CMOVE:     "cmove",
+	CMOVNE:    "cmovne",
+	CMOVBE:    "cmovbe",
+	CMOVA:     "cmova",
+	CMOVS:     "cmovs",
+	CMOVNS:    "cmovns",
+	CMOVP:     "cmovp",
+	CMOVNP:    "cmovnp",
+	CMOVL:     "cmovl",
+	CMOVGE:    "cmovge",
+	CMOVLE:    "cmovle",
+	CMOVG:     "cmovg",
+
+	MOVSD:     "movsd",
+	MOVSS:     "movss",
+	ADDSD:     "addsd",
+	SUBSD:     "subsd",
+	MULSD:     "mulsd",
+	DIVSD:     "divsd",
+	CVTSI2SD:  "cvtsi2sd",
+	CVTTSD2SI: "cvttsd2si",
+	COMISD:    "comisd",
+
+	MOVAPS:     "movaps",
+	MOVUPS:     "movups",
+	MOVDQA:     "movdqa",
+	MOVD:       "movd",
+	MOVQ:       "movq",
+	PXOR:       "pxor",
+	PUNPCKLQDQ: "punpcklqdq",
+}
+
+func (op Op) String() string {
+	if op < 0 || int(op) >= len(opNames) {
+		return fmt.Sprintf("Op(%d)", int(op))
+	}
+	return opNames[op]
+}
+
+// LookupOp returns the Op with the mnemonic name, e.g. "mov" or "jne".
+func LookupOp(name string) (Op, bool) {
+	for op, n := range opNames {
+		if n == name {
+			return Op(op), true
+		}
+	}
+	return 0, false
+}
+
+// Jcc returns the conditional jump for the condition c.
+func Jcc(c Condition) Op {
+	return JO + Op(c&0xf)
+}
+
+// Condition returns the condition of a conditional jump.
+func (op Op) Condition() (Condition, bool) {
+	if op < JO || op > JG {
+		return 0, false
+	}
+	return Condition(op - JO), true
+}
+
+// Setcc returns the instruction which sets a byte to 1 if the condition c
+// is met and to 0 otherwise.
+func Setcc(c Condition) Op {
+	return SETO + Op(c&0xf)
+}
+
+// Cmovcc returns the instruction which moves if the condition c is met.
+func Cmovcc(c Condition) Op {
+	return CMOVO + Op(c&0xf)
+}
+
+// conditionOf returns the condition of a jcc, setcc or cmovcc.
+func conditionOf(op Op) (Condition, bool) {
+	switch {
+	case op >= JO && op <= JG:
+		return Condition(op - JO), true
+	case op >= SETO && op <= SETG:
+		return Condition(op - SETO), true
+	case op >= CMOVO && op <= CMOVG:
+		return Condition(op - CMOVO), true
+	}
+	return 0, false
+}